@@ -0,0 +1,127 @@
+package sqlcache
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyEWMAWeight is the weight given to the latest execution time.
+	latencyEWMAWeight = 0.2
+	// hitRatioEWMAWeight is the weight given to the latest lookup.
+	hitRatioEWMAWeight = 0.05
+	// maxTrendsTracked bounds the number of fingerprints whose trends are
+	// tracked. Queries of untracked fingerprints are judged by their
+	// latest execution time alone and their TTL isn't scaled.
+	maxTrendsTracked = 10000
+
+	defaultAdaptiveMinFactor = 0.5
+	defaultAdaptiveMaxFactor = 4
+)
+
+// AdaptiveTTL configures scaling of the @cache-ttl of queries by how
+// expensive they are to recompute and how often their results are hit.
+// The TTL is multiplied by
+//
+//	(average execution time / BaseLatency) × (2 × hit ratio)
+//
+// clamped to [MinFactor, MaxFactor], where both averages are moving
+// averages tracked by query fingerprint. A query taking BaseLatency to run
+// with half its lookups hitting the cache keeps its TTL.
+type AdaptiveTTL struct {
+	// BaseLatency is the execution time at which TTL isn't scaled for
+	// cost. This field is mandatory.
+	BaseLatency time.Duration
+	// MinFactor is the lower bound of the factor. Defaults to 0.5.
+	MinFactor float64
+	// MaxFactor is the upper bound of the factor. Defaults to 4. It must
+	// not be less than MinFactor.
+	MaxFactor float64
+}
+
+// queryTrend holds moving averages of a query's execution time and hit
+// ratio.
+type queryTrend struct {
+	latency  time.Duration
+	hitRatio float64
+}
+
+// trendTracker tracks trends of queries by fingerprint.
+type trendTracker struct {
+	mu     sync.Mutex
+	trends map[string]*queryTrend
+}
+
+// get returns the trend of the fingerprint, creating it if there's room.
+// It must be called with the lock held.
+func (t *trendTracker) get(fp string) *queryTrend {
+	if t.trends == nil {
+		t.trends = make(map[string]*queryTrend)
+	}
+	qt, ok := t.trends[fp]
+	if !ok {
+		if len(t.trends) >= maxTrendsTracked {
+			return nil
+		}
+		// assume an even hit ratio until lookups are observed
+		qt = &queryTrend{latency: -1, hitRatio: 0.5}
+		t.trends[fp] = qt
+	}
+
+	return qt
+}
+
+// observe adds an execution time sample for the fingerprint and returns
+// the updated average.
+func (t *trendTracker) observe(fp string, d time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	qt := t.get(fp)
+	if qt == nil {
+		return d
+	}
+	if qt.latency < 0 {
+		qt.latency = d
+	} else {
+		qt.latency += time.Duration(latencyEWMAWeight * float64(d-qt.latency))
+	}
+
+	return qt.latency
+}
+
+// lookup records a cache lookup of the fingerprint.
+func (t *trendTracker) lookup(fp string, hit bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	qt := t.get(fp)
+	if qt == nil {
+		return
+	}
+	x := 0.0
+	if hit {
+		x = 1
+	}
+	qt.hitRatio += hitRatioEWMAWeight * (x - qt.hitRatio)
+}
+
+// scaleTTL returns the TTL scaled as described by AdaptiveTTL.
+func (t *trendTracker) scaleTTL(fp string, ttl time.Duration, cfg *AdaptiveTTL) time.Duration {
+	t.mu.Lock()
+	qt, ok := t.trends[fp]
+	var trend queryTrend
+	if ok {
+		trend = *qt
+	}
+	t.mu.Unlock()
+	if !ok || trend.latency < 0 {
+		return ttl
+	}
+
+	factor := float64(trend.latency) / float64(cfg.BaseLatency) * 2 * trend.hitRatio
+	factor = math.Max(cfg.MinFactor, math.Min(cfg.MaxFactor, factor))
+
+	return time.Duration(float64(ttl) * factor)
+}
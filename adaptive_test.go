@@ -0,0 +1,145 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrendTracker(t *testing.T) {
+	assert := require.New(t)
+
+	var tt trendTracker
+	assert.Equal(10*time.Millisecond, tt.observe("f", 10*time.Millisecond))
+	assert.Equal(12*time.Millisecond, tt.observe("f", 20*time.Millisecond))
+	assert.Equal(time.Millisecond, tt.observe("g", time.Millisecond))
+}
+
+func TestScaleTTL(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := &AdaptiveTTL{
+		BaseLatency: 10 * time.Millisecond,
+		MinFactor:   0.5,
+		MaxFactor:   4,
+	}
+
+	var tt trendTracker
+	// unknown queries keep their TTL
+	assert.Equal(time.Minute, tt.scaleTTL("f", time.Minute, cfg))
+
+	tt.observe("f", 10*time.Millisecond)
+	assert.Equal(time.Minute, tt.scaleTTL("f", time.Minute, cfg))
+
+	tt.observe("g", 20*time.Millisecond)
+	assert.Equal(2*time.Minute, tt.scaleTTL("g", time.Minute, cfg))
+
+	// rarely hit
+	for n := 0; n < 100; n++ {
+		tt.lookup("g", false)
+	}
+	assert.Equal(30*time.Second, tt.scaleTTL("g", time.Minute, cfg))
+
+	// frequently hit and expensive
+	tt.observe("h", time.Second)
+	for n := 0; n < 100; n++ {
+		tt.lookup("h", true)
+	}
+	assert.Equal(4*time.Minute, tt.scaleTTL("h", time.Minute, cfg))
+}
+
+func TestMinQueryLatency(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Once()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:           mCacher,
+		MinQueryLatency: 20 * time.Millisecond,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	fast := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	runQuery(t, assert, qMock, db, fast, true)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipFastQuery])
+
+	slow := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age < ?`
+	qMock.ExpectQuery(slow).WithArgs(18).WillDelayFor(30 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John").AddRow("Lisa"))
+	runQuery(t, assert, qMock, db, slow, false)
+
+	s := ic.Stats()
+	assert.Equal(uint64(1), s.SkipReasons[SkipFastQuery])
+	assert.Equal(uint64(1), s.Sets)
+	assert.True(mCacher.AssertExpectations(t))
+}
+
+func TestAdaptiveTTL(t *testing.T) {
+	assert := require.New(t)
+
+	for _, a := range []*AdaptiveTTL{
+		{},
+		{BaseLatency: time.Second, MinFactor: 2, MaxFactor: 1},
+		// greater than the default MaxFactor
+		{BaseLatency: time.Second, MinFactor: 5},
+	} {
+		_, err := NewInterceptor(&Config{
+			Cache:       new(mocks.Cacher),
+			AdaptiveTTL: a,
+		})
+		assert.NotNil(err)
+	}
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	// cheap queries are cached for MinFactor × @cache-ttl
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(15*time.Second)).Return(nil).Once()
+
+	ic, err := NewInterceptor(&Config{
+		Cache:       mCacher,
+		AdaptiveTTL: &AdaptiveTTL{BaseLatency: time.Hour},
+	})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	runQuery(t, assert, qMock, db, query, true)
+	assert.True(mCacher.AssertExpectations(t))
+}
@@ -0,0 +1,176 @@
+package sqlcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const defaultAsyncSetQueueSize = 1024
+
+var (
+	errSetQueueFull   = errors.New("set queue is full")
+	errSetQueueClosed = errors.New("set queue is closed")
+)
+
+type setTask struct {
+	q    *queryInfo
+	item *cache.Item
+	ttl  time.Duration
+}
+
+// setQueue writes items to the cache backend asynchronously using a
+// bounded queue and a fixed number of workers.
+type setQueue struct {
+	i     *Interceptor
+	tasks chan setTask
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	pending int
+	// drained is closed when pending drops to zero.
+	drained chan struct{}
+}
+
+func newSetQueue(i *Interceptor, workers, size int) *setQueue {
+	if size <= 0 {
+		size = defaultAsyncSetQueueSize
+	}
+
+	sq := &setQueue{
+		i:     i,
+		tasks: make(chan setTask, size),
+	}
+	sq.wg.Add(workers)
+	for n := 0; n < workers; n++ {
+		go sq.work()
+	}
+
+	return sq
+}
+
+func (sq *setQueue) enqueue(t setTask) error {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if sq.closed {
+		return errSetQueueClosed
+	}
+
+	select {
+	case sq.tasks <- t:
+	default:
+		return errSetQueueFull
+	}
+
+	if sq.pending == 0 {
+		sq.drained = make(chan struct{})
+	}
+	sq.pending++
+
+	return nil
+}
+
+func (sq *setQueue) work() {
+	defer sq.wg.Done()
+
+	for t := range sq.tasks {
+		sq.i.writeCache(context.Background(), t.q, t.item, t.ttl)
+
+		sq.mu.Lock()
+		sq.pending--
+		if sq.pending == 0 {
+			close(sq.drained)
+		}
+		sq.mu.Unlock()
+	}
+}
+
+// flush waits for pending writes to complete.
+func (sq *setQueue) flush(ctx context.Context) error {
+	sq.mu.Lock()
+	if sq.pending == 0 {
+		sq.mu.Unlock()
+		return nil
+	}
+	drained := sq.drained
+	sq.mu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting writes and waits for workers to drain the queue.
+func (sq *setQueue) close(ctx context.Context) error {
+	sq.mu.Lock()
+	if !sq.closed {
+		sq.closed = true
+		close(sq.tasks)
+	}
+	sq.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sq.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush waits until all pending asynchronous writes to the cache backend
+// (see Config.AsyncSetWorkers) complete or ctx is done.
+func (i *Interceptor) Flush(ctx context.Context) error {
+	if i.setQueue == nil {
+		return nil
+	}
+
+	return i.setQueue.flush(ctx)
+}
+
+// Shutdown stops the asynchronous writers after draining pending writes to
+// the cache backend, and waits for queries verifying cache hits (see
+// Config.Shadow) and refreshing stale results (see Config.StaleBudget),
+// until they complete or ctx is done. Results of queries
+// run after Shutdown are written synchronously. See Close to also stop
+// using the cache backend.
+func (i *Interceptor) Shutdown(ctx context.Context) error {
+	if i.setQueue != nil {
+		if err := i.setQueue.close(ctx); err != nil {
+			return err
+		}
+	}
+	if i.shadow == nil && i.stale == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if i.shadow != nil {
+			i.shadow.wg.Wait()
+		}
+		if i.stale != nil {
+			i.stale.wg.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
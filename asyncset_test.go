@@ -0,0 +1,76 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncSet(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	started := make(chan error, 4)
+	release := make(chan struct{})
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).
+		Run(func(args mock.Arguments) {
+			started <- args.Get(0).(context.Context).Err()
+			<-release
+		}).Return(nil)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:             mCacher,
+		AsyncSetWorkers:   1,
+		AsyncSetQueueSize: 1,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	// the worker blocks on the first write
+	runQuery(t, assert, qMock, db, query, true)
+	assert.Nil(<-started) // detached from the query's context
+	// the second write is queued and the third overflows
+	runQuery(t, assert, qMock, db, query, true)
+	runQuery(t, assert, qMock, db, query, true)
+
+	s := ic.Stats()
+	assert.Equal(uint64(0), s.Sets)
+	assert.Equal(uint64(1), s.SkipReasons[SkipSetQueueFull])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, ic.Flush(ctx))
+
+	close(release)
+	assert.Nil(ic.Flush(context.Background()))
+	assert.Equal(uint64(2), ic.Stats().Sets)
+
+	// writes are synchronous after shutdown
+	assert.Nil(ic.Shutdown(context.Background()))
+	assert.Nil(ic.Shutdown(context.Background()))
+	runQuery(t, assert, qMock, db, query, true)
+	assert.Equal(uint64(3), ic.Stats().Sets)
+}
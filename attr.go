@@ -1,39 +1,129 @@
 package sqlcache
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 )
 
 var (
 	attrRegexp = regexp.MustCompile(`(@cache-ttl|@cache-max-rows) (\d+)`)
+	// sampleRateRegexp matches the optional @cache-sample-rate attribute,
+	// which overrides Config.SampleRate.
+	sampleRateRegexp = regexp.MustCompile(`@cache-sample-rate ([0-9.]+)`)
+	// perPrincipalRegexp matches the optional @cache-per-principal
+	// attribute, which scopes cache entries by Config.Principal.
+	perPrincipalRegexp = regexp.MustCompile(`@cache-per-principal\b`)
+)
+
+// ZeroTTLPolicy is what `@cache-ttl 0` means.
+type ZeroTTLPolicy int
+
+const (
+	// ZeroTTLSkip doesn't cache the results of queries with a TTL of zero,
+	// so that caching of a query can be turned off by its attributes.
+	ZeroTTLSkip ZeroTTLPolicy = iota
+	// ZeroTTLNoExpiry caches the results of queries with a TTL of zero
+	// until they're evicted by the backend.
+	ZeroTTLNoExpiry
 )
 
 type attributes struct {
-	ttl     int
+	ttl int
+	// maxRows of zero means the number of rows isn't limited.
 	maxRows int
+	// sampleRate is nil unless set by the query.
+	sampleRate *float64
+	// maxBytes, when set, limits the encoded size of results in addition
+	// to Config.MaxItemBytes.
+	maxBytes int
+	// auto is set for attributes of queries cached by Config.AutoCache.
+	auto bool
+	// mask and encrypt are the columns listed by @cache-mask and
+	// @cache-encrypt, lowercase.
+	mask, encrypt []string
+	// perPrincipal is set by @cache-per-principal.
+	perPrincipal bool
+	// fragmentBy is the column named by @cache-fragment-by or
+	// @cache-row-key, lowercase; rowKey is set for the latter.
+	fragmentBy string
+	rowKey     bool
+	// err is set when the attributes are present but can't be used.
+	err error
 }
 
+// getAttrs returns the attributes in the comments of the query, or nil if
+// it has none.
 func getAttrs(query string) *attributes {
-	matches := attrRegexp.FindAllStringSubmatch(query, 2)
-	if len(matches) != 2 {
-		return nil
-	}
-
-	var attrs attributes
-	for _, match := range matches {
-		if len(match) != 3 {
-			return nil
+	text, hintErr := attrText(query)
+	var (
+		attrs               attributes
+		seenTTL, seenMaxRow bool
+	)
+	for _, match := range attrRegexp.FindAllStringSubmatch(text, -1) {
+		n, err := strconv.Atoi(match[2])
+		if err != nil && attrs.err == nil {
+			attrs.err = fmt.Errorf("%s %s out of range", match[1], match[2])
 		}
 		switch match[1] {
 		case "@cache-ttl":
-			ttl, _ := strconv.Atoi(match[2])
-			attrs.ttl = ttl
+			if seenTTL && attrs.err == nil {
+				attrs.err = fmt.Errorf("@cache-ttl repeated")
+			}
+			seenTTL = true
+			attrs.ttl = n
 		case "@cache-max-rows":
-			maxRows, _ := strconv.Atoi(match[2])
-			attrs.maxRows = maxRows
+			if seenMaxRow && attrs.err == nil {
+				attrs.err = fmt.Errorf("@cache-max-rows repeated")
+			}
+			seenMaxRow = true
+			attrs.maxRows = n
+		}
+	}
+	if !seenTTL || !seenMaxRow {
+		if hintErr != nil {
+			return &attributes{err: hintErr}
 		}
+		return nil
+	}
+	if hintErr != nil && attrs.err == nil {
+		attrs.err = hintErr
 	}
 
+	for n, match := range sampleRateRegexp.FindAllStringSubmatch(text, -1) {
+		rate, err := strconv.ParseFloat(match[1], 64)
+		if attrs.err == nil {
+			switch {
+			case n > 0:
+				attrs.err = fmt.Errorf("@cache-sample-rate repeated")
+			case err != nil || rate < 0 || rate > 1:
+				attrs.err = fmt.Errorf("@cache-sample-rate %s not between 0 and 1", match[1])
+			}
+		}
+		attrs.sampleRate = &rate
+	}
+	attrs.mask, attrs.encrypt = getColumnAttrs(text)
+	attrs.perPrincipal = perPrincipalRegexp.MatchString(text)
+	fragmentBy, rowKey, err := getFragmentAttr(text)
+	if err != nil && attrs.err == nil {
+		attrs.err = err
+	}
+	attrs.fragmentBy, attrs.rowKey = fragmentBy, rowKey
+
 	return &attrs
 }
+
+// validate returns an error if the attributes can't be used under the
+// policy for zero TTLs.
+func (a *attributes) validate(zeroTTL ZeroTTLPolicy) error {
+	if a.err != nil {
+		return a.err
+	}
+	if a.ttl == 0 && a.maxRows == 0 && zeroTTL == ZeroTTLNoExpiry {
+		// an unbounded number of rows that never expire is more likely
+		// a mistake than intended
+		return fmt.Errorf("@cache-ttl 0 and @cache-max-rows 0 can't be combined")
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package sqlcache
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxAutoCacheDecisions bounds the number of queries whose eligibility
+// for AutoCache is remembered; others are checked on every call.
+const maxAutoCacheDecisions = 10000
+
+var (
+	// noStoreRegexp matches the @cache-no-store annotation, which opts a
+	// query out of AutoCache.
+	noStoreRegexp = regexp.MustCompile(`@cache-no-store\b`)
+	// lockingReadRegexp matches locking clauses of SELECT statements.
+	lockingReadRegexp = regexp.MustCompile(`(?i)\bfor\s+(?:update|share|no\s+key\s+update|key\s+share)\b|\block\s+in\s+share\s+mode\b`)
+	// sqlTokenRegexp splits a query, without comments, into string
+	// literals, names, which may be quoted and qualified, and single
+	// characters.
+	sqlTokenRegexp = regexp.MustCompile(`'(?:[^']|'')*'|` + sqlName + `(?:\s*\.\s*` + sqlName + `)*|\S`)
+)
+
+// sqlName matches a name, quoted or not.
+const sqlName = "(?:\"[^\"]*\"|`[^`]*`|[\\w$]+)"
+
+// fromClauseEnd lists the keywords that can follow a table, or its alias,
+// in a FROM clause.
+var fromClauseEnd = map[string]bool{
+	"WHERE": true, "GROUP": true, "ORDER": true, "LIMIT": true, "OFFSET": true,
+	"HAVING": true, "UNION": true, "INTERSECT": true, "EXCEPT": true, "ON": true,
+	"USING": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"CROSS": true, "NATURAL": true, "OUTER": true, "JOIN": true, "WINDOW": true,
+	"FOR": true, "FETCH": true, "LATERAL": true, "STRAIGHT_JOIN": true,
+}
+
+// AutoCache caches the results of SELECT statements without cache
+// attributes, for codebases where annotating every query isn't feasible.
+// As results of unannotated queries may be cached unintentionally, only
+// queries that pass all of its guardrails are cached, as if annotated with
+// `@cache-ttl` TTL and `@cache-max-rows` MaxRows:
+//
+//   - the statement is a SELECT without locking clauses such as FOR UPDATE,
+//     and doesn't call non-deterministic functions such as NOW()
+//   - it reads from at least one table and only from Tables
+//   - it doesn't match any of DenyPatterns
+//   - it isn't annotated with `@cache-no-store`
+//
+// Queries with cache attributes are cached as per their attributes.
+type AutoCache struct {
+	// Tables lists the tables whose queries may be cached. A name without
+	// a schema allows the table in any schema, while one with a schema
+	// only allows queries naming that schema. It's required.
+	Tables []string
+	// TTL is how long results are cached, in whole seconds. It's required.
+	TTL time.Duration
+	// MaxRows is the most rows of results cached. It's required.
+	MaxRows int
+	// MaxItemBytes, when set, is the largest encoded size of results
+	// cached, in addition to Config.MaxItemBytes.
+	MaxItemBytes int
+	// DenyPatterns are regular expressions of queries never cached.
+	DenyPatterns []string
+}
+
+// autoCacher decides which queries are cached by AutoCache.
+type autoCacher struct {
+	tables    map[string]bool
+	attrs     attributes
+	deny      []*regexp.Regexp
+	decisions sync.Map // query -> bool
+	n         int64
+}
+
+func newAutoCacher(a *AutoCache) (*autoCacher, error) {
+	if len(a.Tables) == 0 {
+		return nil, fmt.Errorf("AutoCache.Tables must be set")
+	}
+	if a.TTL < time.Second {
+		return nil, fmt.Errorf("AutoCache.TTL must be at least a second")
+	}
+	if a.MaxRows <= 0 {
+		return nil, fmt.Errorf("AutoCache.MaxRows must be positive")
+	}
+	if a.MaxItemBytes < 0 {
+		return nil, fmt.Errorf("AutoCache.MaxItemBytes must not be negative")
+	}
+
+	ac := &autoCacher{
+		tables: make(map[string]bool, len(a.Tables)),
+		attrs: attributes{
+			ttl:      int(a.TTL / time.Second),
+			maxRows:  a.MaxRows,
+			maxBytes: a.MaxItemBytes,
+			auto:     true,
+		},
+	}
+	for _, t := range a.Tables {
+		ac.tables[normalizeTableName(t)] = true
+	}
+	for _, p := range a.DenyPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AutoCache.DenyPatterns: %w", err)
+		}
+		ac.deny = append(ac.deny, re)
+	}
+
+	return ac, nil
+}
+
+// getAttrs returns the attributes of the query if it's to be cached,
+// analyzing it with analyze.
+func (ac *autoCacher) getAttrs(query string, analyze func(string) *ParsedQuery) *attributes {
+	if ac == nil {
+		return nil
+	}
+
+	ok, known := ac.decisions.Load(query)
+	if !known {
+		ok = ac.eligible(query, analyze(query))
+		if atomic.LoadInt64(&ac.n) < maxAutoCacheDecisions {
+			if _, loaded := ac.decisions.LoadOrStore(query, ok); !loaded {
+				atomic.AddInt64(&ac.n, 1)
+			}
+		}
+	}
+	if !ok.(bool) {
+		return nil
+	}
+	attrs := ac.attrs
+
+	return &attrs
+}
+
+func (ac *autoCacher) eligible(query string, pq *ParsedQuery) bool {
+	if text, _ := attrText(query); noStoreRegexp.MatchString(text) {
+		return false
+	}
+	if !pq.Read || pq.Locking || pq.NonDeterministic != "" || len(pq.Tables) == 0 {
+		return false
+	}
+	for _, re := range ac.deny {
+		if re.MatchString(query) {
+			return false
+		}
+	}
+
+	for _, t := range pq.Tables {
+		if !ac.tables[t] && !ac.tables[unqualifiedTableName(t)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// queryTables returns the names of the tables read by a query without
+// comments, normalized. The boolean returned is false when they can't be
+// determined.
+func queryTables(query string) ([]string, bool) {
+	const (
+		none = iota
+		table
+		alias
+	)
+	var (
+		tables []string
+		state  = none
+		inFrom bool // commas separate tables, as opposed to in JOINs
+	)
+	for _, tok := range sqlTokenRegexp.FindAllString(query, -1) {
+		upper := strings.ToUpper(tok)
+		switch {
+		case upper == "FROM" || upper == "JOIN":
+			state = table
+			inFrom = upper == "FROM"
+		case state == table:
+			if tok == "(" {
+				// tables of subqueries are found as the tokens are
+				// walked; derived tables can't be told apart
+				state = none
+				continue
+			}
+			if strings.HasPrefix(tok, "'") || !isSQLName(tok) {
+				return nil, false
+			}
+			tables = append(tables, normalizeTableName(tok))
+			state = alias
+		case state == alias:
+			switch {
+			case tok == "(":
+				// a table function
+				return nil, false
+			case tok == "," && inFrom:
+				state = table
+			case upper == "AS" || (isSQLName(tok) && !fromClauseEnd[upper]):
+			default:
+				state = none
+			}
+		}
+	}
+
+	return tables, true
+}
+
+func isSQLName(tok string) bool {
+	c := tok[0]
+	return c == '"' || c == '`' || c == '_' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// normalizeTableName lowercases the name and strips quotes and spaces.
+func normalizeTableName(name string) string {
+	return strings.ToLower(strings.NewReplacer(`"`, "", "`", "", " ", "", "\t", "", "\n", "").Replace(name))
+}
+
+func unqualifiedTableName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+
+	return name
+}
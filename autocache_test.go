@@ -0,0 +1,143 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryTables(t *testing.T) {
+	assert := require.New(t)
+
+	for query, want := range map[string][]string{
+		`SELECT name FROM users WHERE id = $1`:                                 {"users"},
+		`SELECT u.name FROM public."Users" AS u JOIN orders o ON o.uid = u.id`: {"public.users", "orders"},
+		"SELECT * FROM `shop`.`items` i, tags t, (SELECT id FROM x) d":         {"shop.items", "tags", "x"},
+		`SELECT * FROM a LEFT OUTER JOIN b USING (id) ORDER BY id`:             {"a", "b"},
+		`SELECT id FROM a WHERE id IN (SELECT aid FROM b) LIMIT 10`:            {"a", "b"},
+		`SELECT 1`: nil,
+	} {
+		tables, ok := queryTables(query)
+		assert.True(ok, query)
+		assert.Equal(want, tables, query)
+	}
+
+	for _, query := range []string{
+		`SELECT * FROM generate_series(1, 10)`,
+		`SELECT SUBSTRING(name FROM 1) FROM users`,
+	} {
+		_, ok := queryTables(query)
+		assert.False(ok, query)
+	}
+}
+
+func TestAutoCache(t *testing.T) {
+	assert := require.New(t)
+
+	for _, a := range []*AutoCache{
+		{TTL: time.Minute, MaxRows: 10},
+		{Tables: []string{"users"}, MaxRows: 10},
+		{Tables: []string{"users"}, TTL: time.Minute},
+		{Tables: []string{"users"}, TTL: time.Minute, MaxRows: 10, DenyPatterns: []string{"("}},
+	} {
+		_, err := NewInterceptor(&Config{Cache: &mapCacher{entries: make(map[string]cache.Entry)}, AutoCache: a})
+		assert.NotNil(err)
+	}
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{
+		Cache: mc,
+		AutoCache: &AutoCache{
+			Tables:       []string{"users", "public.orders"},
+			TTL:          time.Minute,
+			MaxRows:      2,
+			MaxItemBytes: 1000,
+			DenyPatterns: []string{`(?i)\bpassword\b`},
+		},
+	})
+	assert.Nil(err)
+
+	run := func(query string, n int) {
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: n, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	cached := []string{
+		`SELECT name FROM users WHERE id = 1`,
+		`SELECT o.id FROM users u JOIN public.orders o ON o.uid = u.id`,
+	}
+	for _, query := range cached {
+		run(query, 1)
+	}
+	assert.Len(mc.entries, len(cached))
+	for _, e := range mc.entries {
+		assert.Equal(time.Minute, e.TTL)
+	}
+
+	for _, query := range []string{
+		`SELECT name FROM users WHERE id = 2 -- @cache-no-store`,
+		`SELECT name FROM users u, accounts a`,
+		`SELECT name FROM other.orders`,
+		`SELECT id FROM orders`,
+		`SELECT password FROM users`,
+		`SELECT name FROM users FOR UPDATE`,
+		`SELECT NOW() FROM users`,
+		`SELECT 1`,
+		`UPDATE users SET name = 'x' RETURNING id`,
+		`WITH u AS (SELECT * FROM users) SELECT * FROM u`,
+	} {
+		run(query, 1)
+	}
+	assert.Len(mc.entries, len(cached))
+	assert.Equal(uint64(10), ic.Stats().SkipReasons[SkipNoAttributes])
+
+	run(`SELECT name FROM users WHERE id = 3`, 3)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipMaxRows])
+
+	// attributes take precedence
+	run(`-- @cache-ttl 5
+	     -- @cache-max-rows 0
+	     SELECT name FROM accounts`, 3)
+	assert.Len(mc.entries, len(cached)+1)
+
+	var auto int
+	for _, info := range ic.Fingerprints() {
+		if info.Policy.Auto {
+			auto++
+		}
+	}
+	assert.Equal(len(cached)+1, auto)
+}
+
+func TestAutoCacheMaxItemBytes(t *testing.T) {
+	assert := require.New(t)
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{
+		Cache:     mc,
+		AutoCache: &AutoCache{Tables: []string{"users"}, TTL: time.Minute, MaxRows: 100, MaxItemBytes: 10},
+	})
+	assert.Nil(err)
+
+	rows, err := ic.intercept(context.Background(), ic.prepare(`SELECT name FROM users`), nil, false, nil, func() (driver.Rows, error) {
+		return &seqRows{n: 50, cols: 1}, nil
+	})
+	assert.Nil(err)
+	dest := make([]driver.Value, 1)
+	for rows.Next(dest) == nil {
+	}
+	assert.Nil(rows.Close())
+	assert.Empty(mc.entries)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipMaxBytes])
+}
@@ -9,7 +9,28 @@ import (
 // Item represents a single item in cache and will contain the results of a
 // single SQL query.
 type Item struct {
+	// Hits counts the number of cache hits served from this item. It's only
+	// maintained when sqlcache.Config.CountHits is set, must be accessed
+	// atomically (hence the first field) and isn't persisted by backends
+	// that serialize items.
+	Hits uint64 `msgpack:"-"`
 	Cols []string
+	// CreatedAt is the time at which the query results were recorded.
+	CreatedAt time.Time
+	// Fingerprint identifies the query text the item holds results of.
+	Fingerprint string
+	// Digest identifies the query and args the item holds results of. It's
+	// only set when sqlcache.Config.VerifyDigest is set.
+	Digest []byte
+	// StaleAt is when the results stop being fresh, if before the item
+	// expires at the TTL it's set with. Backends expire items at their
+	// TTL, the hard TTL, and sqlcache treats items past StaleAt, the soft
+	// TTL, as misses that may still be served stale, such as by
+	// sqlcache.Config.StaleBudget. It's zero when the results are fresh
+	// until the item expires.
+	StaleAt time.Time
+	// Rows must remain the last field so that codecs can decode all other
+	// fields before streaming the rows.
 	Rows [][]driver.Value
 }
 
@@ -22,3 +43,141 @@ type Cacher interface {
 	// Set sets the item into cache with the given TTL.
 	Set(ctx context.Context, key string, item *Item, ttl time.Duration) error
 }
+
+// RowsReader reads the rows of a cached item incrementally.
+type RowsReader interface {
+	// Header returns the item with all fields except Rows populated.
+	Header() *Item
+	// Next reads the next row into dest. It must return io.EOF when there
+	// are no more rows.
+	Next(dest []driver.Value) error
+}
+
+// StreamGetter can optionally be implemented by a Cacher to allow rows of an
+// item to be decoded as they are read on a cache hit, instead of decoding the
+// entire item up front. This reduces peak memory and latency when callers
+// only read a few rows of large results.
+type StreamGetter interface {
+	// GetStream is the streaming equivalent of Cacher.Get.
+	GetStream(ctx context.Context, key string) (RowsReader, bool, error)
+}
+
+// FreshGetter can optionally be implemented by a Cacher whose Get returns
+// items that aren't shared with other callers, such as items decoded anew
+// on every Get. Byte slices in rows of items of other backends are copied
+// on cache hits so that callers modifying them can't corrupt the cache.
+type FreshGetter interface {
+	// FreshItems reports whether Get returns unshared items.
+	FreshItems() bool
+}
+
+// Codec serializes and deserializes items for backends that store them as
+// opaque bytes (such as redis).
+type Codec interface {
+	// Marshal encodes the item into bytes.
+	Marshal(item *Item) ([]byte, error)
+	// Unmarshal decodes bytes produced by Marshal into item.
+	Unmarshal(b []byte, item *Item) error
+}
+
+// StreamCodec can optionally be implemented by a Codec that is able to
+// decode rows incrementally.
+type StreamCodec interface {
+	// NewRowsReader returns a reader of the item encoded in b.
+	NewRowsReader(b []byte) (RowsReader, error)
+}
+
+// BackendStats contains statistics reported by a backend. Fields the
+// backend can't report are left as zero.
+type BackendStats struct {
+	// Entries is the number of items in the cache.
+	Entries uint64
+	// Bytes is the memory used by the cache.
+	Bytes uint64
+	// Evictions is the number of items evicted to make room for others.
+	Evictions uint64
+}
+
+// StatsReporter can optionally be implemented by a Cacher to report
+// statistics of the backend.
+type StatsReporter interface {
+	// BackendStats returns the current statistics of the backend.
+	BackendStats(ctx context.Context) (*BackendStats, error)
+}
+
+// Locker can optionally be implemented by a Cacher to let processes sharing
+// the backend coordinate so that only one of them runs a query on a cache
+// miss while the others wait for its results to be cached.
+type Locker interface {
+	// TryLock tries to acquire a lock on key which expires after ttl. The
+	// boolean returned is false when the lock is held by someone else.
+	// When acquired, the returned function must be called to release it.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(ctx context.Context) error, ok bool, err error)
+}
+
+// Pinger can optionally be implemented by a Cacher to check that the
+// backend responds, such as to probe it while it's bypassed after repeated
+// failures. Backends that don't implement it are probed with a Get.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Deleter can optionally be implemented by a Cacher to let the interceptor
+// remove entries, such as ones found to hold results of another query.
+type Deleter interface {
+	// Delete removes the item of key, if present.
+	Delete(ctx context.Context, key string) error
+}
+
+// Indexer can optionally be implemented by a Cacher that maintains an
+// index of keys by the fingerprint of the query whose results they hold,
+// as in Item.Fingerprint, so that the results of a query for all args can
+// be removed at once.
+type Indexer interface {
+	// DeleteFingerprint removes the items of the query with fingerprint
+	// and returns the number of items removed.
+	DeleteFingerprint(ctx context.Context, fingerprint string) (int, error)
+}
+
+// TTLReporter can optionally be implemented by a Cacher to report how long
+// items have left before they expire, such as to tell how stale they are.
+type TTLReporter interface {
+	// TTL returns the time left before the item of key expires, which is
+	// zero if it doesn't expire. The boolean returned is false when the
+	// item isn't present.
+	TTL(ctx context.Context, key string) (time.Duration, bool, error)
+}
+
+// Entry is an item along with its key and TTL, as written by
+// BatchCacher.SetMulti.
+type Entry struct {
+	Key  string
+	Item *Item
+	TTL  time.Duration
+}
+
+// BatchCacher can optionally be implemented by a Cacher to get and set
+// multiple items in a single round trip.
+type BatchCacher interface {
+	// GetMulti returns the items of keys, in the same order. Items that
+	// aren't present are nil.
+	GetMulti(ctx context.Context, keys []string) ([]*Item, error)
+	// SetMulti sets all entries into cache.
+	SetMulti(ctx context.Context, entries []Entry) error
+}
+
+// Closer can optionally be implemented by a Cacher to release resources,
+// such as connections, when sqlcache.Interceptor.Close is called.
+type Closer interface {
+	Close() error
+}
+
+// Ranger can optionally be implemented by a Cacher to enumerate its items,
+// such as to dump them with sqlcache.Dump.
+type Ranger interface {
+	// Range calls fn with every item, its key and the time left before it
+	// expires, which is zero if it doesn't expire. Items written or
+	// removed meanwhile may or may not be seen. Range stops at and returns
+	// the first error returned by fn.
+	Range(ctx context.Context, fn func(e Entry) error) error
+}
@@ -0,0 +1,462 @@
+// Package cachetest provides a conformance test suite for implementations
+// of cache.Cacher, so that third-party backends can check they behave as
+// sqlcache expects of the built-in ones.
+package cachetest
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Option configures RunConformance.
+type Option func(*config)
+
+type config struct {
+	wait       func(c cache.Cacher)
+	expiryWait time.Duration
+}
+
+// WithWait sets a function called after writes for backends that apply
+// them asynchronously, such as ristretto, to wait until they're visible.
+func WithWait(wait func(c cache.Cacher)) Option {
+	return func(cfg *config) {
+		cfg.wait = wait
+	}
+}
+
+// WithExpiryWait sets how long items may outlive their TTL before the
+// backend is considered to not expire them. It defaults to 2 seconds.
+func WithExpiryWait(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.expiryWait = d
+	}
+}
+
+// RunConformance runs the conformance tests as subtests of t. newCacher
+// must return an empty cacher for every subtest, not sharing items with
+// the cachers of other subtests, such as by using a distinct key prefix.
+// Optional interfaces of package cache implemented by the cacher, such as
+// cache.Deleter and cache.BatchCacher, are tested too; backends that
+// implement cache.Indexer must be returned with their index enabled.
+func RunConformance(t *testing.T, newCacher func(t *testing.T) cache.Cacher, opts ...Option) {
+	cfg := &config{
+		wait:       func(cache.Cacher) {},
+		expiryWait: 2 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, c cache.Cacher, cfg *config)
+	}{
+		{"GetMissing", testGetMissing},
+		{"SetGet", testSetGet},
+		{"Overwrite", testOverwrite},
+		{"Keys", testKeys},
+		{"Types", testTypes},
+		{"Empty", testEmpty},
+		{"LargeValues", testLargeValues},
+		{"TTL", testTTL},
+		{"Concurrency", testConcurrency},
+		{"Delete", testDelete},
+		{"Batch", testBatch},
+		{"Stream", testStream},
+		{"Range", testRange},
+		{"Index", testIndex},
+		{"TTLReporter", testTTLReporter},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newCacher(t), cfg)
+		})
+	}
+}
+
+func testItem(name string, rows int) *cache.Item {
+	item := &cache.Item{
+		Cols:        []string{"id", "name"},
+		CreatedAt:   time.Date(2021, 2, 3, 4, 5, 6, 7, time.UTC),
+		Fingerprint: "fingerprint:" + name,
+		Digest:      []byte("digest:" + name),
+	}
+	for r := 0; r < rows; r++ {
+		item.Rows = append(item.Rows, []driver.Value{int64(r), fmt.Sprintf("%s-%d", name, r)})
+	}
+
+	return item
+}
+
+// requireItem fails the test unless got holds the same results as want.
+// Times are compared by instant, and Hits, which backends needn't persist,
+// is ignored.
+func requireItem(t *testing.T, want, got *cache.Item) {
+	t.Helper()
+	assert := require.New(t)
+
+	assert.NotNil(got)
+	assert.Equal(want.Cols, got.Cols)
+	assert.True(want.CreatedAt.Equal(got.CreatedAt), "CreatedAt: want %v, got %v", want.CreatedAt, got.CreatedAt)
+	assert.Equal(want.Fingerprint, got.Fingerprint)
+	assert.Equal(want.Digest, got.Digest)
+	assert.Len(got.Rows, len(want.Rows))
+	for r, row := range want.Rows {
+		requireRow(t, row, got.Rows[r])
+	}
+}
+
+func requireRow(t *testing.T, want, got []driver.Value) {
+	t.Helper()
+	assert := require.New(t)
+
+	assert.Len(got, len(want))
+	for c, v := range want {
+		switch v := v.(type) {
+		case time.Time:
+			tm, ok := got[c].(time.Time)
+			assert.True(ok, "want time.Time, got %T", got[c])
+			assert.True(v.Equal(tm), "want %v, got %v", v, tm)
+		case []byte:
+			b, ok := got[c].([]byte)
+			assert.True(ok, "want []byte, got %T", got[c])
+			assert.True(bytes.Equal(v, b), "want %q, got %q", v, b)
+		default:
+			assert.Equal(v, got[c])
+		}
+	}
+}
+
+func requireGet(t *testing.T, c cache.Cacher, key string, want *cache.Item) {
+	t.Helper()
+
+	got, ok, err := c.Get(context.Background(), key)
+	require.Nil(t, err)
+	if want == nil {
+		require.False(t, ok, "key %q", key)
+		require.Nil(t, got)
+		return
+	}
+	require.True(t, ok, "key %q", key)
+	requireItem(t, want, got)
+}
+
+func testGetMissing(t *testing.T, c cache.Cacher, cfg *config) {
+	requireGet(t, c, "missing", nil)
+}
+
+func testSetGet(t *testing.T, c cache.Cacher, cfg *config) {
+	item := testItem("a", 3)
+	require.Nil(t, c.Set(context.Background(), "a", item, time.Minute))
+	cfg.wait(c)
+	requireGet(t, c, "a", item)
+	requireGet(t, c, "b", nil)
+}
+
+func testOverwrite(t *testing.T, c cache.Cacher, cfg *config) {
+	require.Nil(t, c.Set(context.Background(), "a", testItem("first", 3), time.Minute))
+	cfg.wait(c)
+	second := testItem("second", 1)
+	require.Nil(t, c.Set(context.Background(), "a", second, time.Minute))
+	cfg.wait(c)
+	requireGet(t, c, "a", second)
+}
+
+func testKeys(t *testing.T, c cache.Cacher, cfg *config) {
+	keys := []string{
+		"x",
+		"user:1",
+		"with space",
+		"glob*?[x]\\",
+		"ünïcödé",
+		strings.Repeat("k", 1000),
+	}
+	items := make(map[string]*cache.Item)
+	for n, key := range keys {
+		items[key] = testItem(fmt.Sprint(n), n+1)
+		require.Nil(t, c.Set(context.Background(), key, items[key], time.Minute))
+	}
+	cfg.wait(c)
+
+	for _, key := range keys {
+		requireGet(t, c, key, items[key])
+	}
+	requireGet(t, c, "glob", nil)
+	requireGet(t, c, "user:", nil)
+}
+
+func testTypes(t *testing.T, c cache.Cacher, cfg *config) {
+	item := &cache.Item{
+		Cols:      []string{"nil", "int64", "float64", "bool", "string", "bytes", "time"},
+		CreatedAt: time.Now(),
+		Rows: [][]driver.Value{
+			{nil, int64(0), float64(0), false, "", []byte{0}, time.Unix(0, 0)},
+			{nil, int64(math.MaxInt64), math.MaxFloat64, true, "ünïcödé", []byte("bytes"), time.Date(2021, 2, 3, 4, 5, 6, 7, time.FixedZone("X", 3600))},
+			{nil, int64(math.MinInt64), -math.SmallestNonzeroFloat64, true, "\x00", []byte{0xff, 0x00}, time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	require.Nil(t, c.Set(context.Background(), "types", item, time.Minute))
+	cfg.wait(c)
+	requireGet(t, c, "types", item)
+}
+
+func testEmpty(t *testing.T, c cache.Cacher, cfg *config) {
+	// results of queries returning no rows
+	item := testItem("empty", 0)
+	require.Nil(t, c.Set(context.Background(), "empty", item, time.Minute))
+	cfg.wait(c)
+	requireGet(t, c, "empty", item)
+}
+
+func testLargeValues(t *testing.T, c cache.Cacher, cfg *config) {
+	wide := &cache.Item{
+		Cols: []string{"blob"},
+		Rows: [][]driver.Value{{bytes.Repeat([]byte{'x'}, 1<<20)}},
+	}
+	long := testItem("long", 10000)
+	require.Nil(t, c.Set(context.Background(), "wide", wide, time.Minute))
+	require.Nil(t, c.Set(context.Background(), "long", long, time.Minute))
+	cfg.wait(c)
+	requireGet(t, c, "wide", wide)
+	requireGet(t, c, "long", long)
+}
+
+func testTTL(t *testing.T, c cache.Cacher, cfg *config) {
+	short := testItem("short", 1)
+	forever := testItem("forever", 1)
+	require.Nil(t, c.Set(context.Background(), "short", short, 100*time.Millisecond))
+	require.Nil(t, c.Set(context.Background(), "forever", forever, 0))
+	cfg.wait(c)
+	requireGet(t, c, "short", short)
+
+	require.Eventually(t, func() bool {
+		_, ok, err := c.Get(context.Background(), "short")
+		return err == nil && !ok
+	}, 100*time.Millisecond+cfg.expiryWait, 10*time.Millisecond, "item didn't expire")
+	// a TTL of zero means no expiry
+	requireGet(t, c, "forever", forever)
+}
+
+func testConcurrency(t *testing.T, c cache.Cacher, cfg *config) {
+	const (
+		workers = 8
+		ops     = 100
+		keys    = 10
+	)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, workers)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < ops; n++ {
+				key := fmt.Sprint("key", (w+n)%keys)
+				if n%2 == 0 {
+					if err := c.Set(context.Background(), key, testItem(key, 2), time.Minute); err != nil {
+						errs <- err
+						return
+					}
+					continue
+				}
+				item, ok, err := c.Get(context.Background(), key)
+				if err != nil {
+					errs <- err
+					return
+				}
+				// items are never torn between writers
+				if ok && (item.Fingerprint != "fingerprint:"+key || len(item.Rows) != 2) {
+					errs <- fmt.Errorf("key %q holds item %q with %d rows", key, item.Fingerprint, len(item.Rows))
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.Nil(t, err)
+	}
+
+	cfg.wait(c)
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprint("key", k)
+		requireGet(t, c, key, testItem(key, 2))
+	}
+}
+
+func testDelete(t *testing.T, c cache.Cacher, cfg *config) {
+	d, ok := cache.As[cache.Deleter](c)
+	if !ok {
+		t.Skip("cache.Deleter not implemented")
+	}
+
+	require.Nil(t, c.Set(context.Background(), "a", testItem("a", 1), time.Minute))
+	require.Nil(t, c.Set(context.Background(), "b", testItem("b", 1), time.Minute))
+	cfg.wait(c)
+	require.Nil(t, d.Delete(context.Background(), "a"))
+	cfg.wait(c)
+	requireGet(t, c, "a", nil)
+	requireGet(t, c, "b", testItem("b", 1))
+
+	// deleting a missing key isn't an error
+	require.Nil(t, d.Delete(context.Background(), "missing"))
+}
+
+func testBatch(t *testing.T, c cache.Cacher, cfg *config) {
+	b, ok := cache.As[cache.BatchCacher](c)
+	if !ok {
+		t.Skip("cache.BatchCacher not implemented")
+	}
+
+	require.Nil(t, b.SetMulti(context.Background(), []cache.Entry{
+		{Key: "a", Item: testItem("a", 1), TTL: time.Minute},
+		{Key: "b", Item: testItem("b", 2), TTL: time.Minute},
+	}))
+	cfg.wait(c)
+	requireGet(t, c, "a", testItem("a", 1))
+
+	items, err := b.GetMulti(context.Background(), []string{"b", "missing", "a"})
+	require.Nil(t, err)
+	require.Len(t, items, 3)
+	requireItem(t, testItem("b", 2), items[0])
+	require.Nil(t, items[1])
+	requireItem(t, testItem("a", 1), items[2])
+
+	items, err = b.GetMulti(context.Background(), nil)
+	require.Nil(t, err)
+	require.Empty(t, items)
+}
+
+func testStream(t *testing.T, c cache.Cacher, cfg *config) {
+	s, ok := cache.As[cache.StreamGetter](c)
+	if !ok {
+		t.Skip("cache.StreamGetter not implemented")
+	}
+
+	item := testItem("a", 5)
+	require.Nil(t, c.Set(context.Background(), "a", item, time.Minute))
+	cfg.wait(c)
+
+	_, ok, err := s.GetStream(context.Background(), "missing")
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	rr, ok, err := s.GetStream(context.Background(), "a")
+	require.Nil(t, err)
+	require.True(t, ok)
+	got := rr.Header()
+	require.Empty(t, got.Rows)
+	for {
+		dest := make([]driver.Value, len(got.Cols))
+		err := rr.Next(dest)
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		got.Rows = append(got.Rows, dest)
+	}
+	requireItem(t, item, got)
+}
+
+func testRange(t *testing.T, c cache.Cacher, cfg *config) {
+	r, ok := cache.As[cache.Ranger](c)
+	if !ok {
+		t.Skip("cache.Ranger not implemented")
+	}
+
+	require.Nil(t, c.Set(context.Background(), "a", testItem("a", 1), time.Minute))
+	require.Nil(t, c.Set(context.Background(), "b", testItem("b", 2), 0))
+	cfg.wait(c)
+
+	seen := make(map[string]cache.Entry)
+	var mu sync.Mutex
+	require.Nil(t, r.Range(context.Background(), func(e cache.Entry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[e.Key] = e
+		return nil
+	}))
+	require.Len(t, seen, 2)
+	requireItem(t, testItem("a", 1), seen["a"].Item)
+	require.True(t, seen["a"].TTL > 0 && seen["a"].TTL <= time.Minute, "TTL %v", seen["a"].TTL)
+	requireItem(t, testItem("b", 2), seen["b"].Item)
+	require.Zero(t, seen["b"].TTL)
+
+	// errors of fn stop ranging
+	stop := fmt.Errorf("stop")
+	err := r.Range(context.Background(), func(cache.Entry) error { return stop })
+	require.ErrorIs(t, err, stop)
+}
+
+func testIndex(t *testing.T, c cache.Cacher, cfg *config) {
+	x, ok := cache.As[cache.Indexer](c)
+	if !ok {
+		t.Skip("cache.Indexer not implemented")
+	}
+
+	item := func(fingerprint string) *cache.Item {
+		return &cache.Item{Cols: []string{"id"}, Fingerprint: fingerprint, Rows: [][]driver.Value{{int64(1)}}}
+	}
+	require.Nil(t, c.Set(context.Background(), "a1", item("a"), time.Minute))
+	require.Nil(t, c.Set(context.Background(), "a2", item("a"), 0))
+	require.Nil(t, c.Set(context.Background(), "b1", item("b"), time.Minute))
+	// keys overwritten with results of another query
+	require.Nil(t, c.Set(context.Background(), "x", item("a"), time.Minute))
+	cfg.wait(c)
+	require.Nil(t, c.Set(context.Background(), "x", item("b"), time.Minute))
+	cfg.wait(c)
+
+	n, err := x.DeleteFingerprint(context.Background(), "a")
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+	cfg.wait(c)
+	requireGet(t, c, "a1", nil)
+	requireGet(t, c, "a2", nil)
+	requireGet(t, c, "b1", item("b"))
+	requireGet(t, c, "x", item("b"))
+
+	n, err = x.DeleteFingerprint(context.Background(), "a")
+	require.Nil(t, err)
+	require.Zero(t, n)
+}
+
+func testTTLReporter(t *testing.T, c cache.Cacher, cfg *config) {
+	r, ok := cache.As[cache.TTLReporter](c)
+	if !ok {
+		t.Skip("cache.TTLReporter not implemented")
+	}
+
+	require.Nil(t, c.Set(context.Background(), "a", testItem("a", 1), time.Minute))
+	require.Nil(t, c.Set(context.Background(), "b", testItem("b", 2), 0))
+	cfg.wait(c)
+
+	ttl, ok, err := r.TTL(context.Background(), "a")
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.True(t, ttl > 0 && ttl <= time.Minute, "TTL %v", ttl)
+
+	ttl, ok, err = r.TTL(context.Background(), "b")
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Zero(t, ttl)
+
+	_, ok, err = r.TTL(context.Background(), "missing")
+	require.Nil(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,301 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrUnsupported is returned by the methods of optional interfaces of a
+// Cacher returned by Instrument when the Cacher it wraps doesn't implement
+// them. Use As to check for optional interfaces beforehand.
+var ErrUnsupported = errors.New("cache: operation not supported by the backend")
+
+// Op describes an operation performed by a Cacher returned by Instrument.
+type Op struct {
+	// Name is the name of the method called, such as "Get" or "SetMulti".
+	Name string
+	// Key is the key operated on, if the operation is on a single key.
+	Key string
+	// Duration is how long the operation took.
+	Duration time.Duration
+	// Err is the error returned by the operation, if any.
+	Err error
+	// Items and Rows are the number of items read or written and the
+	// number of rows they held. Rows of items read by GetStream are read
+	// after the operation and aren't counted.
+	Items int
+	Rows  int
+}
+
+// Hook is called by a Cacher returned by Instrument after every operation
+// with the context it was called with, or context.Background for Close.
+type Hook func(ctx context.Context, op *Op)
+
+// Unwrapper is implemented by Cachers wrapping another, such as those
+// returned by Instrument.
+type Unwrapper interface {
+	// Unwrap returns the wrapped Cacher.
+	Unwrap() Cacher
+}
+
+// As returns c as T, an optional interface such as Deleter, if c and every
+// Cacher it wraps implement it. Cachers returned by Instrument implement
+// all optional interfaces whether or not the Cacher they wrap does, so
+// checks for optional interfaces should use As rather than a type
+// assertion.
+func As[T any](c Cacher) (T, bool) {
+	t, ok := c.(T)
+	if !ok {
+		return t, false
+	}
+	for u, wraps := c.(Unwrapper); wraps; u, wraps = c.(Unwrapper) {
+		c = u.Unwrap()
+		if _, ok := c.(T); !ok {
+			var zero T
+			return zero, false
+		}
+	}
+
+	return t, true
+}
+
+// Instrument wraps c so that hooks are called with the latency, error and
+// size of every operation, so that backends needn't implement telemetry
+// themselves. The Cacher returned implements every optional interface of
+// this package, returning ErrUnsupported from the methods of those c
+// doesn't implement; see As.
+func Instrument(c Cacher, hooks ...Hook) Cacher {
+	return &instrumented{c: c, hooks: hooks}
+}
+
+type instrumented struct {
+	c     Cacher
+	hooks []Hook
+}
+
+// observe calls the hooks with op, which started at start.
+func (in *instrumented) observe(ctx context.Context, start time.Time, op *Op) {
+	op.Duration = time.Since(start)
+	for _, hook := range in.hooks {
+		hook(ctx, op)
+	}
+}
+
+func itemRows(item *Item) int {
+	if item == nil {
+		return 0
+	}
+
+	return len(item.Rows)
+}
+
+func (in *instrumented) Unwrap() Cacher {
+	return in.c
+}
+
+func (in *instrumented) Get(ctx context.Context, key string) (*Item, bool, error) {
+	start := time.Now()
+	item, ok, err := in.c.Get(ctx, key)
+	op := &Op{Name: "Get", Key: key, Err: err}
+	if ok {
+		op.Items, op.Rows = 1, itemRows(item)
+	}
+	in.observe(ctx, start, op)
+
+	return item, ok, err
+}
+
+func (in *instrumented) Set(ctx context.Context, key string, item *Item, ttl time.Duration) error {
+	start := time.Now()
+	err := in.c.Set(ctx, key, item, ttl)
+	in.observe(ctx, start, &Op{Name: "Set", Key: key, Err: err, Items: 1, Rows: itemRows(item)})
+
+	return err
+}
+
+func (in *instrumented) GetStream(ctx context.Context, key string) (RowsReader, bool, error) {
+	sg, ok := in.c.(StreamGetter)
+	if !ok {
+		return nil, false, ErrUnsupported
+	}
+
+	start := time.Now()
+	rr, ok, err := sg.GetStream(ctx, key)
+	op := &Op{Name: "GetStream", Key: key, Err: err}
+	if ok {
+		op.Items = 1
+	}
+	in.observe(ctx, start, op)
+
+	return rr, ok, err
+}
+
+func (in *instrumented) FreshItems() bool {
+	fg, ok := in.c.(FreshGetter)
+	return ok && fg.FreshItems()
+}
+
+// Codec returns the codec of the wrapped Cacher, if it has one, or nil.
+func (in *instrumented) Codec() Codec {
+	cp, ok := in.c.(interface{ Codec() Codec })
+	if !ok {
+		return nil
+	}
+
+	return cp.Codec()
+}
+
+func (in *instrumented) BackendStats(ctx context.Context) (*BackendStats, error) {
+	sr, ok := in.c.(StatsReporter)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	start := time.Now()
+	s, err := sr.BackendStats(ctx)
+	in.observe(ctx, start, &Op{Name: "BackendStats", Err: err})
+
+	return s, err
+}
+
+func (in *instrumented) TryLock(ctx context.Context, key string, ttl time.Duration) (func(ctx context.Context) error, bool, error) {
+	l, ok := in.c.(Locker)
+	if !ok {
+		return nil, false, ErrUnsupported
+	}
+
+	start := time.Now()
+	unlock, ok, err := l.TryLock(ctx, key, ttl)
+	in.observe(ctx, start, &Op{Name: "TryLock", Key: key, Err: err})
+	if !ok || unlock == nil {
+		return unlock, ok, err
+	}
+
+	return func(ctx context.Context) error {
+		start := time.Now()
+		err := unlock(ctx)
+		in.observe(ctx, start, &Op{Name: "Unlock", Key: key, Err: err})
+		return err
+	}, ok, err
+}
+
+func (in *instrumented) Ping(ctx context.Context) error {
+	p, ok := in.c.(Pinger)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	start := time.Now()
+	err := p.Ping(ctx)
+	in.observe(ctx, start, &Op{Name: "Ping", Err: err})
+
+	return err
+}
+
+func (in *instrumented) Delete(ctx context.Context, key string) error {
+	d, ok := in.c.(Deleter)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	start := time.Now()
+	err := d.Delete(ctx, key)
+	in.observe(ctx, start, &Op{Name: "Delete", Key: key, Err: err})
+
+	return err
+}
+
+func (in *instrumented) DeleteFingerprint(ctx context.Context, fingerprint string) (int, error) {
+	idx, ok := in.c.(Indexer)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+
+	start := time.Now()
+	n, err := idx.DeleteFingerprint(ctx, fingerprint)
+	in.observe(ctx, start, &Op{Name: "DeleteFingerprint", Err: err, Items: n})
+
+	return n, err
+}
+
+func (in *instrumented) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	r, ok := in.c.(TTLReporter)
+	if !ok {
+		return 0, false, ErrUnsupported
+	}
+
+	start := time.Now()
+	ttl, ok, err := r.TTL(ctx, key)
+	in.observe(ctx, start, &Op{Name: "TTL", Key: key, Err: err})
+
+	return ttl, ok, err
+}
+
+func (in *instrumented) GetMulti(ctx context.Context, keys []string) ([]*Item, error) {
+	bc, ok := in.c.(BatchCacher)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	start := time.Now()
+	items, err := bc.GetMulti(ctx, keys)
+	op := &Op{Name: "GetMulti", Err: err}
+	for _, item := range items {
+		if item != nil {
+			op.Items++
+			op.Rows += itemRows(item)
+		}
+	}
+	in.observe(ctx, start, op)
+
+	return items, err
+}
+
+func (in *instrumented) SetMulti(ctx context.Context, entries []Entry) error {
+	bc, ok := in.c.(BatchCacher)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	start := time.Now()
+	err := bc.SetMulti(ctx, entries)
+	op := &Op{Name: "SetMulti", Err: err, Items: len(entries)}
+	for _, e := range entries {
+		op.Rows += itemRows(e.Item)
+	}
+	in.observe(ctx, start, op)
+
+	return err
+}
+
+func (in *instrumented) Close() error {
+	c, ok := in.c.(Closer)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	start := time.Now()
+	err := c.Close()
+	in.observe(context.Background(), start, &Op{Name: "Close", Err: err})
+
+	return err
+}
+
+func (in *instrumented) Range(ctx context.Context, fn func(e Entry) error) error {
+	r, ok := in.c.(Ranger)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	start := time.Now()
+	op := &Op{Name: "Range"}
+	op.Err = r.Range(ctx, func(e Entry) error {
+		op.Items++
+		op.Rows += itemRows(e.Item)
+		return fn(e)
+	})
+	in.observe(ctx, start, op)
+
+	return op.Err
+}
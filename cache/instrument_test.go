@@ -0,0 +1,81 @@
+package cache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/cache/cachetest"
+	"github.com/prashanthpai/sqlcache/sqlcachetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// getSetCacher implements cache.Cacher only.
+type getSetCacher struct {
+	cache.Cacher
+}
+
+type opRecorder struct {
+	mu  sync.Mutex
+	ops []cache.Op
+}
+
+func (r *opRecorder) hook(ctx context.Context, op *cache.Op) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, *op)
+}
+
+func TestInstrumentConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cacher {
+		return cache.Instrument(sqlcachetest.NewCache(nil))
+	})
+}
+
+func TestInstrument(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	rec := &opRecorder{}
+	c := cache.Instrument(sqlcachetest.NewCache(nil), rec.hook)
+	item := &cache.Item{Cols: []string{"id"}, Rows: [][]driver.Value{{int64(1)}, {int64(2)}}}
+	assert.Nil(c.Set(ctx, "a", item, time.Minute))
+	_, ok, err := c.Get(ctx, "a")
+	assert.Nil(err)
+	assert.True(ok)
+	_, ok, err = c.Get(ctx, "b")
+	assert.Nil(err)
+	assert.False(ok)
+	bc, ok := cache.As[cache.BatchCacher](c)
+	assert.True(ok)
+	_, err = bc.GetMulti(ctx, []string{"a", "b"})
+	assert.Nil(err)
+
+	assert.Len(rec.ops, 4)
+	for n, want := range []cache.Op{
+		{Name: "Set", Key: "a", Items: 1, Rows: 2},
+		{Name: "Get", Key: "a", Items: 1, Rows: 2},
+		{Name: "Get", Key: "b"},
+		{Name: "GetMulti", Items: 1, Rows: 2},
+	} {
+		got := rec.ops[n]
+		assert.True(got.Duration > 0)
+		got.Duration = 0
+		assert.Equal(want, got)
+	}
+
+	// optional interfaces are only supported when the wrapped cacher
+	// implements them, however deeply wrapped
+	c = cache.Instrument(cache.Instrument(getSetCacher{sqlcachetest.NewCache(nil)}, rec.hook))
+	_, ok = c.(cache.Deleter)
+	assert.True(ok)
+	_, ok = cache.As[cache.Deleter](c)
+	assert.False(ok)
+	assert.ErrorIs(c.(cache.Deleter).Delete(ctx, "a"), cache.ErrUnsupported)
+	_, ok = cache.As[cache.Deleter](cache.Instrument(sqlcachetest.NewCache(nil)))
+	assert.True(ok)
+}
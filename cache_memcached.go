@@ -0,0 +1,517 @@
+package sqlcache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const defaultMemcachedMaxIdleConns = 8
+
+// Identifiers of codecs and compressions recorded in the client flags of
+// items, the codec in the low byte and the compression in the next one.
+// Items of codecs without an identifier are recorded with mcCodecCustom
+// and decoded with the codec of the backend.
+const (
+	mcCodecCustom   = 0
+	mcCodecMsgpack  = 1
+	mcCodecColumnar = 2
+
+	mcCompressNone  = 0
+	mcCompressFlate = 1
+)
+
+// mcMaxKeyLen is the longest key, before base64 encoding, sent as is;
+// longer ones are hashed to fit the 250 bytes memcached allows.
+const mcMaxKeyLen = 186
+
+// Memcached implements cache.Cacher interface to use memcached as backend.
+// It speaks the meta protocol of memcached 1.6 and later over connections
+// it pools itself. Every item is stored with client flags recording the
+// codec and compression it was written with, so that items written by
+// backends with different settings, such as during a rolling change of
+// codec, coexist and are decoded correctly.
+type Memcached struct {
+	addr        string
+	keyPrefix   string
+	codec       cache.Codec
+	compressMin int
+	dialer      net.Dialer
+
+	mu     sync.Mutex
+	idle   []*mcConn
+	max    int
+	closed bool
+}
+
+// MemcachedOption configures optional behaviour of the memcached backend.
+type MemcachedOption func(m *Memcached)
+
+// WithMemcachedCodec sets the codec used to serialize items written to
+// memcached. By default MsgpackCodec is used. Items are decoded with the
+// codec they were written with, except those of codecs other than
+// MsgpackCodec and ColumnarCodec, which are decoded with the codec set.
+func WithMemcachedCodec(codec cache.Codec) MemcachedOption {
+	return func(m *Memcached) {
+		m.codec = codec
+	}
+}
+
+// WithMemcachedCompression compresses items whose encoding is at least
+// minBytes long with DEFLATE, such as to fit large results in the 1MB
+// memcached stores per item by default. Compressed items are decompressed
+// whether or not compression is enabled.
+func WithMemcachedCompression(minBytes int) MemcachedOption {
+	return func(m *Memcached) {
+		m.compressMin = minBytes
+	}
+}
+
+// WithMemcachedMaxIdleConns sets how many idle connections are kept for
+// reuse. It defaults to 8.
+func WithMemcachedMaxIdleConns(n int) MemcachedOption {
+	return func(m *Memcached) {
+		m.max = n
+	}
+}
+
+// NewMemcached creates a new instance of memcached backend for the server
+// at addr. All keys created in memcached by sqlcache will start with
+// prefix. Connections are dialed when needed.
+func NewMemcached(addr, keyPrefix string, opts ...MemcachedOption) *Memcached {
+	m := &Memcached{
+		addr:      addr,
+		keyPrefix: keyPrefix,
+		codec:     MsgpackCodec{},
+		max:       defaultMemcachedMaxIdleConns,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Codec returns the codec used to serialize items written to memcached.
+func (m *Memcached) Codec() cache.Codec {
+	return m.codec
+}
+
+// Get gets a cache item from memcached. Returns pointer to the item, a
+// boolean which represents whether key exists or not and an error.
+func (m *Memcached) Get(ctx context.Context, key string) (*cache.Item, bool, error) {
+	var (
+		item *cache.Item
+		ok   bool
+	)
+	err := m.do(ctx, func(c *mcConn) error {
+		if err := c.command("mg", m.key(key), "b", "v", "f"); err != nil {
+			return err
+		}
+		status, flags, err := c.status()
+		if err != nil || status == "EN" {
+			return err
+		}
+		if status != "VA" || len(flags) == 0 {
+			return unexpectedReply(status, flags)
+		}
+		b, err := c.value(flags[0])
+		if err != nil {
+			return err
+		}
+		ok = true
+		item, err = m.decode(b, retFlag(flags[1:], 'f'))
+		return err
+	})
+
+	return item, ok, err
+}
+
+// Set sets the given item into memcached with provided TTL duration,
+// rounded up to whole seconds.
+func (m *Memcached) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
+	b, flags, err := m.encode(item)
+	if err != nil {
+		return err
+	}
+
+	return m.do(ctx, func(c *mcConn) error {
+		err := c.command("ms", m.key(key), strconv.Itoa(len(b)), "b",
+			"T"+strconv.FormatInt(mcTTL(ttl), 10), "F"+strconv.FormatUint(uint64(flags), 10))
+		if err == nil {
+			err = c.data(b)
+		}
+		if err != nil {
+			return err
+		}
+		status, ret, err := c.status()
+		if err != nil {
+			return err
+		}
+		if status != "HD" {
+			return unexpectedReply(status, ret)
+		}
+		return nil
+	})
+}
+
+// Delete implements cache.Deleter.
+func (m *Memcached) Delete(ctx context.Context, key string) error {
+	return m.do(ctx, func(c *mcConn) error {
+		if err := c.command("md", m.key(key), "b"); err != nil {
+			return err
+		}
+		status, ret, err := c.status()
+		if err != nil {
+			return err
+		}
+		if status != "HD" && status != "NF" {
+			return unexpectedReply(status, ret)
+		}
+		return nil
+	})
+}
+
+// TTL implements cache.TTLReporter. Memcached reports TTLs in whole
+// seconds; items with less than a second left are reported to have one.
+func (m *Memcached) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	var (
+		ttl time.Duration
+		ok  bool
+	)
+	err := m.do(ctx, func(c *mcConn) error {
+		if err := c.command("mg", m.key(key), "b", "t"); err != nil {
+			return err
+		}
+		status, ret, err := c.status()
+		if err != nil || status == "EN" {
+			return err
+		}
+		if status != "HD" {
+			return unexpectedReply(status, ret)
+		}
+		// t is -1 when the item doesn't expire
+		secs, err := strconv.ParseInt(retFlag(ret, 't'), 10, 64)
+		if err != nil {
+			return fmt.Errorf("sqlcache: bad memcached TTL: %w", err)
+		}
+		ok = true
+		switch {
+		case secs < 0:
+		case secs == 0:
+			ttl = time.Second
+		default:
+			ttl = time.Duration(secs) * time.Second
+		}
+		return nil
+	})
+
+	return ttl, ok, err
+}
+
+// Ping implements cache.Pinger using the meta no-op command.
+func (m *Memcached) Ping(ctx context.Context) error {
+	return m.do(ctx, func(c *mcConn) error {
+		if err := c.command("mn"); err != nil {
+			return err
+		}
+		status, ret, err := c.status()
+		if err != nil {
+			return err
+		}
+		if status != "MN" {
+			return unexpectedReply(status, ret)
+		}
+		return nil
+	})
+}
+
+// FreshItems implements cache.FreshGetter; items are decoded on every Get.
+func (m *Memcached) FreshItems() bool {
+	return true
+}
+
+// Close implements cache.Closer by closing idle connections. Connections
+// in use are closed once their commands complete.
+func (m *Memcached) Close() error {
+	m.mu.Lock()
+	idle := m.idle
+	m.idle, m.closed = nil, true
+	m.mu.Unlock()
+
+	var err error
+	for _, c := range idle {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// key returns the base64 encoding of the memcached key of key, which is
+// hashed when too long. Keys are sent base64 encoded so that they may hold
+// spaces and control characters.
+func (m *Memcached) key(key string) string {
+	key = m.keyPrefix + key
+	if len(key) > mcMaxKeyLen {
+		sum := sha256.Sum256([]byte(key))
+		key = "#" + string(sum[:])
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+// encode encodes item with the codec and, when large enough, compresses
+// it, returning the client flags recording how.
+func (m *Memcached) encode(item *cache.Item) ([]byte, uint32, error) {
+	b, err := m.codec.Marshal(item)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var codecID uint32 = mcCodecCustom
+	switch m.codec.(type) {
+	case MsgpackCodec:
+		codecID = mcCodecMsgpack
+	case ColumnarCodec:
+		codecID = mcCodecColumnar
+	}
+	if m.compressMin <= 0 || len(b) < m.compressMin {
+		return b, codecID, nil
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, 0, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	return buf.Bytes(), codecID | mcCompressFlate<<8, nil
+}
+
+// decode decodes an item stored with the client flags given.
+func (m *Memcached) decode(b []byte, flags string) (*cache.Item, error) {
+	f, err := strconv.ParseUint(flags, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("sqlcache: bad memcached flags %q", flags)
+	}
+
+	switch compress := f >> 8 & 0xff; compress {
+	case mcCompressNone:
+	case mcCompressFlate:
+		r := flate.NewReader(bytes.NewReader(b))
+		b, err = io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("sqlcache: decompressing memcached item failed: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("sqlcache: unknown memcached compression %d", compress)
+	}
+
+	var codec cache.Codec
+	switch id := f & 0xff; id {
+	case mcCodecCustom:
+		codec = m.codec
+	case mcCodecMsgpack:
+		codec = MsgpackCodec{}
+	case mcCodecColumnar:
+		codec = ColumnarCodec{}
+	default:
+		return nil, fmt.Errorf("sqlcache: unknown memcached codec %d", id)
+	}
+
+	var item cache.Item
+	if err := codec.Unmarshal(b, &item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// mcTTL returns the memcached expiry of ttl: seconds, or a unix time past
+// the 30 days memcached takes relative expiries up to.
+func mcTTL(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if secs > 30*24*60*60 {
+		return time.Now().Unix() + secs
+	}
+
+	return secs
+}
+
+// mcConn is a connection to memcached.
+type mcConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// do runs fn with a connection, which is reused unless fn fails for other
+// reasons than a server error reported by memcached, such as an item being
+// too large.
+func (m *Memcached) do(ctx context.Context, fn func(c *mcConn) error) error {
+	c, err := m.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := c.SetDeadline(deadline); err != nil {
+		c.Close()
+		return err
+	}
+
+	err = fn(c)
+	var re *memcachedError
+	if err != nil && !(errors.As(err, &re) && strings.HasPrefix(re.msg, "SERVER_ERROR")) {
+		c.Close()
+		return err
+	}
+	m.release(c)
+
+	return err
+}
+
+// conn returns an idle connection, or dials a new one.
+func (m *Memcached) conn(ctx context.Context) (*mcConn, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, errors.New("sqlcache: memcached backend closed")
+	}
+	if n := len(m.idle); n > 0 {
+		c := m.idle[n-1]
+		m.idle = m.idle[:n-1]
+		m.mu.Unlock()
+		return c, nil
+	}
+	m.mu.Unlock()
+
+	nc, err := m.dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+// release returns c to the idle connections, or closes it.
+func (m *Memcached) release(c *mcConn) {
+	m.mu.Lock()
+	if !m.closed && len(m.idle) < m.max {
+		m.idle = append(m.idle, c)
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Unlock()
+	c.Close()
+}
+
+// command writes a command line. It's flushed by data or status.
+func (c *mcConn) command(args ...string) error {
+	_, err := c.w.WriteString(strings.Join(args, " ") + "\r\n")
+	return err
+}
+
+// data writes the data block of a command.
+func (c *mcConn) data(b []byte) error {
+	if _, err := c.w.Write(b); err != nil {
+		return err
+	}
+	_, err := c.w.WriteString("\r\n")
+	return err
+}
+
+// status flushes the command and reads the status line of its reply,
+// returning the status code and its flags. Errors reported by memcached
+// are returned as *memcachedError.
+func (c *mcConn) status() (string, []string, error) {
+	if err := c.w.Flush(); err != nil {
+		return "", nil, err
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", nil, &memcachedError{msg: line}
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil, errors.New("sqlcache: empty memcached reply")
+	}
+
+	return fields[0], fields[1:], nil
+}
+
+// value reads the data block of a reply of size bytes.
+func (c *mcConn) value(size string) ([]byte, error) {
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("sqlcache: bad memcached value size %q", size)
+	}
+
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(b, []byte("\r\n")) {
+		return nil, errors.New("sqlcache: memcached value not terminated")
+	}
+
+	return b[:n], nil
+}
+
+// retFlag returns the value of the return flag named f.
+func retFlag(flags []string, f byte) string {
+	for _, flag := range flags {
+		if len(flag) > 0 && flag[0] == f {
+			return flag[1:]
+		}
+	}
+
+	return ""
+}
+
+// memcachedError is an error reported by memcached.
+type memcachedError struct {
+	msg string
+}
+
+func (e *memcachedError) Error() string {
+	return "sqlcache: memcached: " + e.msg
+}
+
+// unexpectedReply returns the error of a reply not expected for the
+// command, after which the connection is not reused.
+func unexpectedReply(status string, flags []string) error {
+	return fmt.Errorf("sqlcache: unexpected memcached reply %q", strings.Join(append([]string{status}, flags...), " "))
+}
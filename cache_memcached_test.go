@@ -0,0 +1,257 @@
+package sqlcache
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/cache/cachetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMemcached serves the subset of the memcached meta protocol used by
+// the backend, refusing items over 1MB like memcached does by default.
+type fakeMemcached struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	items map[string]fakeMemcachedItem
+}
+
+type fakeMemcachedItem struct {
+	data    []byte
+	flags   string
+	expires time.Time
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	f := &fakeMemcached{ln: ln, items: make(map[string]fakeMemcachedItem)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeMemcached) addr() string {
+	return f.ln.Addr().String()
+}
+
+func (f *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		if fields[0] == "ms" {
+			n, _ := strconv.Atoi(fields[2])
+			data := make([]byte, n+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			fields = append(fields[:2], fields[3:]...)
+			if n > 1<<20 {
+				fmt.Fprint(w, "SERVER_ERROR object too large for cache\r\n")
+				w.Flush()
+				continue
+			}
+			fields = append(fields, "D"+string(data[:n]))
+		}
+		if len(fields) == 1 {
+			fields = append(fields, "")
+		}
+		f.reply(w, fields[0], fields[1], fields[2:])
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeMemcached) reply(w io.Writer, cmd, key string, flags []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	item, ok := f.items[key]
+	if ok && !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(f.items, key)
+		ok = false
+	}
+
+	switch cmd {
+	case "mn":
+		fmt.Fprint(w, "MN\r\n")
+	case "mg":
+		if !ok {
+			fmt.Fprint(w, "EN\r\n")
+			return
+		}
+		status, ret := "HD", ""
+		for _, flag := range flags {
+			switch flag[0] {
+			case 'v':
+				status = "VA " + strconv.Itoa(len(item.data))
+			case 'f':
+				ret += " f" + item.flags
+			case 't':
+				ttl := -1
+				if !item.expires.IsZero() {
+					ttl = int(math.Ceil(time.Until(item.expires).Seconds()))
+				}
+				ret += " t" + strconv.Itoa(ttl)
+			}
+		}
+		fmt.Fprintf(w, "%s%s\r\n", status, ret)
+		if status != "HD" {
+			fmt.Fprintf(w, "%s\r\n", item.data)
+		}
+	case "ms":
+		item = fakeMemcachedItem{flags: "0"}
+		for _, flag := range flags {
+			switch flag[0] {
+			case 'T':
+				if secs, _ := strconv.Atoi(flag[1:]); secs > 0 {
+					item.expires = time.Now().Add(time.Duration(secs) * time.Second)
+				}
+			case 'F':
+				item.flags = flag[1:]
+			case 'D':
+				item.data = []byte(flag[1:])
+			}
+		}
+		f.items[key] = item
+		fmt.Fprint(w, "HD\r\n")
+	case "md":
+		delete(f.items, key)
+		if ok {
+			fmt.Fprint(w, "HD\r\n")
+		} else {
+			fmt.Fprint(w, "NF\r\n")
+		}
+	default:
+		fmt.Fprint(w, "ERROR\r\n")
+	}
+}
+
+func (f *fakeMemcached) flags(m *Memcached, key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.items[m.key(key)].flags
+}
+
+func TestMemcachedConformance(t *testing.T) {
+	f := newFakeMemcached(t)
+
+	for name, codec := range map[string]cache.Codec{"msgpack": MsgpackCodec{}, "columnar": ColumnarCodec{}} {
+		codec := codec
+		t.Run(name, func(t *testing.T) {
+			cachetest.RunConformance(t, func(t *testing.T) cache.Cacher {
+				m := NewMemcached(f.addr(), t.Name()+":", WithMemcachedCodec(codec), WithMemcachedCompression(1024))
+				t.Cleanup(func() { m.Close() })
+				return m
+			})
+		})
+	}
+}
+
+// TestMemcachedServerConformance runs against the memcached server at
+// $SQLCACHE_TEST_MEMCACHED_ADDR, whose items are left to expire.
+func TestMemcachedServerConformance(t *testing.T) {
+	addr := os.Getenv("SQLCACHE_TEST_MEMCACHED_ADDR")
+	if addr == "" {
+		t.Skip("SQLCACHE_TEST_MEMCACHED_ADDR not set")
+	}
+
+	run := time.Now().UnixNano()
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cacher {
+		m := NewMemcached(addr, fmt.Sprintf("sqlcache-test:%d:%s:", run, t.Name()), WithMemcachedCompression(1024))
+		t.Cleanup(func() { m.Close() })
+		return m
+	})
+}
+
+func TestMemcachedFlags(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	f := newFakeMemcached(t)
+	msgpack := NewMemcached(f.addr(), "sqc:")
+	defer msgpack.Close()
+	columnar := NewMemcached(f.addr(), "sqc:", WithMemcachedCodec(ColumnarCodec{}), WithMemcachedCompression(1))
+	defer columnar.Close()
+
+	item := &cache.Item{Cols: []string{"id"}, Rows: [][]driver.Value{{int64(1)}, {int64(2)}}}
+	assert.Nil(msgpack.Set(ctx, "a", item, time.Minute))
+	assert.Nil(columnar.Set(ctx, "b", item, time.Minute))
+	assert.Equal("1", f.flags(msgpack, "a"))
+	assert.Equal(strconv.Itoa(mcCodecColumnar|mcCompressFlate<<8), f.flags(msgpack, "b"))
+
+	// items written with different settings are read by either backend
+	for _, m := range []*Memcached{msgpack, columnar} {
+		for _, key := range []string{"a", "b"} {
+			got, ok, err := m.Get(ctx, key)
+			assert.Nil(err)
+			assert.True(ok)
+			assert.Equal(item.Rows, got.Rows)
+		}
+	}
+
+	// unknown identifiers are reported
+	f.mu.Lock()
+	f.items[msgpack.key("c")] = fakeMemcachedItem{data: []byte("x"), flags: "9"}
+	f.items[msgpack.key("d")] = fakeMemcachedItem{data: []byte("x"), flags: strconv.Itoa(9 << 8)}
+	f.mu.Unlock()
+	_, ok, err := msgpack.Get(ctx, "c")
+	assert.True(ok)
+	assert.ErrorContains(err, "unknown memcached codec 9")
+	_, _, err = msgpack.Get(ctx, "d")
+	assert.ErrorContains(err, "unknown memcached compression 9")
+
+	// items memcached refuses are reported, and the connection reused
+	large := &cache.Item{Cols: []string{"blob"}, Rows: [][]driver.Value{{make([]byte, 2<<20)}}}
+	err = msgpack.Set(ctx, "e", large, time.Minute)
+	assert.ErrorContains(err, "object too large")
+	assert.Len(msgpack.idle, 1)
+	assert.Nil(msgpack.Ping(ctx))
+	assert.Nil(columnar.Set(ctx, "e", large, time.Minute))
+
+	assert.Nil(msgpack.Close())
+	assert.NotNil(msgpack.Ping(ctx))
+}
+
+func TestMemcachedTTL(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(int64(0), mcTTL(0))
+	assert.Equal(int64(1), mcTTL(time.Millisecond))
+	assert.Equal(int64(60), mcTTL(time.Minute))
+	// memcached takes expiries past 30 days as unix times
+	assert.InDelta(time.Now().Add(31*24*time.Hour).Unix(), mcTTL(31*24*time.Hour), 2)
+}
@@ -2,10 +2,17 @@ package sqlcache
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
-	"github.com/vmihailenco/msgpack/v4"
 
 	"github.com/prashanthpai/sqlcache/cache"
 )
@@ -13,18 +20,64 @@ import (
 // Redis implements cache.Cacher interface to use redis as backend with
 // go-redis as the redis client library.
 type Redis struct {
-	c         redis.UniversalClient
+	c redis.UniversalClient
+	// rc is the client lookups are sent to; see WithReadClient.
+	rc        redis.UniversalClient
 	keyPrefix string
+	codec     cache.Codec
+	index     bool
+}
+
+// RedisOption configures optional behaviour of the redis backend.
+type RedisOption func(r *Redis)
+
+// WithCodec sets the codec used to serialize items stored in redis. By
+// default MsgpackCodec is used. Note that items written with one codec
+// can't be read back with another.
+func WithCodec(codec cache.Codec) RedisOption {
+	return func(r *Redis) {
+		r.codec = codec
+	}
+}
+
+// WithFingerprintIndex maintains an index of keys by query fingerprint, a
+// sorted set per query, so that the backend implements cache.Indexer. It
+// adds a script call to every write.
+func WithFingerprintIndex() RedisOption {
+	return func(r *Redis) {
+		r.index = true
+	}
+}
+
+// WithReadClient sends lookups (Get, GetStream, GetMulti and TTL) to c,
+// and everything else, writes and locks included, to the client the
+// backend is created with, so that read-heavy cache traffic can be served
+// by replicas. c can be, for Sentinel deployments, a client of replicas:
+//
+//	rc := redis.NewFailoverClusterClient(&redis.FailoverOptions{
+//		MasterName:     "mymaster",
+//		SentinelAddrs:  sentinels,
+//		RouteByLatency: true,
+//	})
+//
+// Cluster clients route lookups to replicas themselves when created with
+// ReadOnly, RouteByLatency or RouteRandomly, and need no read client.
+// Results written to the primary may not be seen by lookups until they're
+// replicated, so lookups right after writes may miss; Close closes c too.
+func WithReadClient(c redis.UniversalClient) RedisOption {
+	return func(r *Redis) {
+		r.rc = c
+	}
 }
 
 // Get gets a cache item from redis. Returns pointer to the item, a boolean
 // which represents whether key exists or not and an error.
 func (r *Redis) Get(ctx context.Context, key string) (*cache.Item, bool, error) {
-	b, err := r.c.Get(ctx, r.keyPrefix+key).Bytes()
+	b, err := r.rc.Get(ctx, r.keyPrefix+key).Bytes()
 	switch err {
 	case nil:
 		var item cache.Item
-		if err := msgpack.Unmarshal(b, &item); err != nil {
+		if err := r.codec.Unmarshal(b, &item); err != nil {
 			return nil, true, err
 		}
 		return &item, true, nil
@@ -35,22 +88,475 @@ func (r *Redis) Get(ctx context.Context, key string) (*cache.Item, bool, error)
 	}
 }
 
+// GetStream gets a cache item from redis and returns a reader of its rows.
+// When the configured codec implements cache.StreamCodec, rows are decoded
+// incrementally as they are read.
+func (r *Redis) GetStream(ctx context.Context, key string) (cache.RowsReader, bool, error) {
+	b, err := r.rc.Get(ctx, r.keyPrefix+key).Bytes()
+	switch err {
+	case nil:
+		if sc, ok := r.codec.(cache.StreamCodec); ok {
+			rr, err := sc.NewRowsReader(b)
+			if err != nil {
+				return nil, true, err
+			}
+			return rr, true, nil
+		}
+		var item cache.Item
+		if err := r.codec.Unmarshal(b, &item); err != nil {
+			return nil, true, err
+		}
+		return &itemReader{item: &item}, true, nil
+	case redis.Nil:
+		return nil, false, nil
+	default:
+		return nil, false, err
+	}
+}
+
 // Set sets the given item into redis with provided TTL duration.
 func (r *Redis) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
-	b, err := msgpack.Marshal(item)
+	b, err := r.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	if !r.index || item.Fingerprint == "" {
+		_, err = r.c.Set(ctx, r.keyPrefix+key, b, ttl).Result()
+		return err
+	}
+
+	pipe := r.c.Pipeline()
+	pipe.Set(ctx, r.keyPrefix+key, b, ttl)
+	r.addToIndex(ctx, pipe, key, item.Fingerprint, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// indexScript adds a key to the index of its fingerprint, scored by the
+// time it expires at in unix milliseconds, removes the keys that have
+// expired and has the index expire with its last key.
+const indexScript = `
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", "(" .. ARGV[3])
+local expiry = "+inf"
+if ARGV[2] ~= "0" then
+	expiry = ARGV[2]
+end
+redis.call("ZADD", KEYS[1], expiry, ARGV[1])
+local last = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")[2]
+if last == "inf" then
+	redis.call("PERSIST", KEYS[1])
+else
+	redis.call("PEXPIREAT", KEYS[1], last)
+end
+return 0`
+
+func (r *Redis) indexKey(fingerprint string) string {
+	return r.keyPrefix + "idx:" + fingerprint
+}
+
+// addToIndex adds key to the index of fingerprint with the commands of
+// pipe.
+func (r *Redis) addToIndex(ctx context.Context, pipe redis.Pipeliner, key, fingerprint string, ttl time.Duration) {
+	now := time.Now()
+	var expiry int64
+	if ttl > 0 {
+		expiry = now.Add(ttl).UnixMilli()
+	}
+	pipe.Eval(ctx, indexScript, []string{r.indexKey(fingerprint)}, r.keyPrefix+key, expiry, now.UnixMilli())
+}
+
+// DeleteFingerprint implements cache.Indexer when WithFingerprintIndex is
+// used. Keys of the query written meanwhile may not be deleted.
+func (r *Redis) DeleteFingerprint(ctx context.Context, fingerprint string) (int, error) {
+	if !r.index {
+		return 0, errors.New("sqlcache: fingerprint index not enabled; see WithFingerprintIndex")
+	}
+
+	idx := r.indexKey(fingerprint)
+	keys, err := r.c.ZRange(ctx, idx, 0, -1).Result()
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	pipe := r.c.Pipeline()
+	dels := make([]*redis.IntCmd, len(keys))
+	members := make([]interface{}, len(keys))
+	for n, key := range keys {
+		dels[n] = pipe.Del(ctx, key)
+		members[n] = key
+	}
+	// keys indexed meanwhile stay in the index
+	pipe.ZRem(ctx, idx, members...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for _, del := range dels {
+		deleted += int(del.Val())
+	}
+
+	return deleted, nil
+}
+
+// Ping implements cache.Pinger.
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.c.Ping(ctx).Err()
+}
+
+// Delete implements cache.Deleter.
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.c.Del(ctx, r.keyPrefix+key).Err()
+}
+
+// TTL implements cache.TTLReporter using PTTL.
+func (r *Redis) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	ttl, err := r.rc.PTTL(ctx, r.keyPrefix+key).Result()
 	if err != nil {
+		return 0, false, err
+	}
+
+	// PTTL is -2 when the key doesn't exist and -1 when it doesn't expire
+	switch ttl {
+	case -2:
+		return 0, false, nil
+	case -1:
+		return 0, true, nil
+	}
+
+	return ttl, true, nil
+}
+
+// DeletePrefix deletes all keys starting with prefix, after the key prefix
+// of the backend, and returns the number of keys deleted. Keys are found
+// using SCAN, on every master of a cluster, which can be slow on large
+// databases; keys written meanwhile may not be deleted.
+func (r *Redis) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	var deleted int64
+	match := globEscape(r.keyPrefix+prefix) + "*"
+	err := r.forEachMaster(ctx, func(ctx context.Context, c redis.UniversalClient) error {
+		n, err := deleteMatching(ctx, c, match)
+		atomic.AddInt64(&deleted, int64(n))
+		return err
+	})
+
+	return int(deleted), err
+}
+
+// forEachMaster calls fn with the client, or concurrently with the client
+// of every master of a cluster.
+func (r *Redis) forEachMaster(ctx context.Context, fn func(ctx context.Context, c redis.UniversalClient) error) error {
+	if cc, ok := r.c.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return fn(ctx, c)
+		})
+	}
+
+	return fn(ctx, r.c)
+}
+
+// Range implements cache.Ranger using SCAN, on every master of a cluster.
+// Locks of cache.Locker and the fingerprint index aren't items and are
+// skipped.
+func (r *Redis) Range(ctx context.Context, fn func(e cache.Entry) error) error {
+	var mu sync.Mutex // fn is called by one master at a time
+	lockPrefix := r.keyPrefix + "lock:"
+	indexPrefix := r.keyPrefix + "idx:"
+
+	return r.forEachMaster(ctx, func(ctx context.Context, c redis.UniversalClient) error {
+		var batch []string
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			pipe := c.Pipeline()
+			gets := make([]*redis.StringCmd, len(batch))
+			ttls := make([]*redis.DurationCmd, len(batch))
+			for n, key := range batch {
+				gets[n] = pipe.Get(ctx, key)
+				ttls[n] = pipe.PTTL(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for n, key := range batch {
+				b, err := gets[n].Bytes()
+				if err == redis.Nil {
+					continue // expired meanwhile
+				}
+				if err != nil {
+					return err
+				}
+				item := new(cache.Item)
+				if err := r.codec.Unmarshal(b, item); err != nil {
+					return fmt.Errorf("decoding %q failed: %w", key, err)
+				}
+				ttl := ttls[n].Val()
+				if ttl < 0 {
+					ttl = 0
+				}
+				e := cache.Entry{Key: strings.TrimPrefix(key, r.keyPrefix), Item: item, TTL: ttl}
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			batch = batch[:0]
+			return nil
+		}
+
+		iter := c.Scan(ctx, 0, globEscape(r.keyPrefix)+"*", 1000).Iterator()
+		for iter.Next(ctx) {
+			if key := iter.Val(); !strings.HasPrefix(key, lockPrefix) && !strings.HasPrefix(key, indexPrefix) {
+				batch = append(batch, key)
+			}
+			if len(batch) == 100 {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		return flush()
+	})
+}
+
+// deleteMatching deletes the keys matching the glob pattern in batches.
+// Keys are deleted with individual DEL commands so that the keys of a batch
+// needn't be in the same cluster slot.
+func deleteMatching(ctx context.Context, c redis.UniversalClient, match string) (int, error) {
+	var (
+		deleted int
+		batch   []string
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		pipe := c.Pipeline()
+		cmds := make([]*redis.IntCmd, len(batch))
+		for n, key := range batch {
+			cmds[n] = pipe.Del(ctx, key)
+		}
+		_, err := pipe.Exec(ctx)
+		for _, cmd := range cmds {
+			deleted += int(cmd.Val())
+		}
+		batch = batch[:0]
 		return err
 	}
 
-	_, err = r.c.Set(ctx, r.keyPrefix+key, b, ttl).Result()
+	iter := c.Scan(ctx, 0, match, 1000).Iterator()
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 1000 {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+
+	return deleted, flush()
+}
+
+// GetMulti implements cache.BatchCacher by pipelining GET commands rather
+// than using MGET, whose keys must all hash to the same slot of a cluster.
+func (r *Redis) GetMulti(ctx context.Context, keys []string) ([]*cache.Item, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pipe := r.rc.Pipeline()
+	gets := make([]*redis.StringCmd, len(keys))
+	for n, key := range keys {
+		gets[n] = pipe.Get(ctx, r.keyPrefix+key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	items := make([]*cache.Item, len(keys))
+	for n, get := range gets {
+		b, err := get.Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		item := new(cache.Item)
+		if err := r.codec.Unmarshal(b, item); err != nil {
+			return nil, fmt.Errorf("decoding %q failed: %w", keys[n], err)
+		}
+		items[n] = item
+	}
+
+	return items, nil
+}
+
+// SetMulti implements cache.BatchCacher by pipelining SET commands.
+func (r *Redis) SetMulti(ctx context.Context, entries []cache.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	pipe := r.c.Pipeline()
+	for _, e := range entries {
+		b, err := r.codec.Marshal(e.Item)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, r.keyPrefix+e.Key, b, e.TTL)
+		if r.index && e.Item.Fingerprint != "" {
+			r.addToIndex(ctx, pipe, e.Key, e.Item.Fingerprint, e.TTL)
+		}
+	}
+
+	_, err := pipe.Exec(ctx)
 	return err
 }
 
 // NewRedis creates a new instance of redis backend using go-redis client.
 // All keys created in redis by sqlcache will have start with prefix.
-func NewRedis(c redis.UniversalClient, keyPrefix string) *Redis {
-	return &Redis{
+func NewRedis(c redis.UniversalClient, keyPrefix string, opts ...RedisOption) *Redis {
+	r := &Redis{
 		c:         c,
 		keyPrefix: keyPrefix,
+		codec:     MsgpackCodec{},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.rc == nil {
+		r.rc = c
+	}
+
+	return r
+}
+
+// Codec returns the codec used to serialize items stored in redis.
+func (r *Redis) Codec() cache.Codec {
+	return r.codec
+}
+
+// Close implements cache.Closer by closing the redis clients, which mustn't
+// be used elsewhere afterwards.
+func (r *Redis) Close() error {
+	err := r.c.Close()
+	if r.rc != r.c {
+		if rerr := r.rc.Close(); err == nil {
+			err = rerr
+		}
+	}
+
+	return err
+}
+
+// FreshItems implements cache.FreshGetter; items are decoded on every Get.
+func (r *Redis) FreshItems() bool {
+	return true
+}
+
+// BackendStats implements cache.StatsReporter. Entries counts keys with
+// the key prefix using SCAN on every master of a cluster, which can be
+// slow on large databases; locks of cache.Locker and the fingerprint index
+// aren't counted. Bytes and Evictions are reported by INFO, summed over
+// masters, and apply to the whole redis server rather than just keys
+// created by sqlcache.
+func (r *Redis) BackendStats(ctx context.Context) (*cache.BackendStats, error) {
+	var entries, bytes, evictions uint64
+	lockPrefix := r.keyPrefix + "lock:"
+	indexPrefix := r.keyPrefix + "idx:"
+
+	err := r.forEachMaster(ctx, func(ctx context.Context, c redis.UniversalClient) error {
+		var n uint64
+		iter := c.Scan(ctx, 0, globEscape(r.keyPrefix)+"*", 1000).Iterator()
+		for iter.Next(ctx) {
+			if key := iter.Val(); !strings.HasPrefix(key, lockPrefix) && !strings.HasPrefix(key, indexPrefix) {
+				n++
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		atomic.AddUint64(&entries, n)
+
+		info, err := c.Info(ctx, "memory", "stats").Result()
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(info, "\n") {
+			k, v, ok := strings.Cut(strings.TrimSpace(line), ":")
+			if !ok {
+				continue
+			}
+			switch k {
+			case "used_memory":
+				n, _ := strconv.ParseUint(v, 10, 64)
+				atomic.AddUint64(&bytes, n)
+			case "evicted_keys":
+				n, _ := strconv.ParseUint(v, 10, 64)
+				atomic.AddUint64(&evictions, n)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cache.BackendStats{Entries: entries, Bytes: bytes, Evictions: evictions}, nil
+}
+
+// globEscape escapes characters that have special meaning in redis glob
+// patterns.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// unlockScript deletes the lock only if it's still held by the caller.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// TryLock implements cache.Locker using SET NX with a random token so that
+// only the holder of the lock can release it.
+func (r *Redis) TryLock(ctx context.Context, key string, ttl time.Duration) (func(ctx context.Context) error, bool, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, false, err
 	}
+	token := hex.EncodeToString(b)
+	lockKey := r.keyPrefix + "lock:" + key
+
+	ok, err := r.c.SetNX(ctx, lockKey, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	return func(ctx context.Context) error {
+		return unlockScript.Run(ctx, r.c, []string{lockKey}, token).Err()
+	}, true, nil
 }
@@ -0,0 +1,123 @@
+package sqlcache
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/cache/cachetest"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRedisConformance runs against the redis server at
+// $SQLCACHE_TEST_REDIS_ADDR, whose keys prefixed with "sqlcache-test" are
+// deleted.
+func TestRedisConformance(t *testing.T) {
+	addr := os.Getenv("SQLCACHE_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("SQLCACHE_TEST_REDIS_ADDR not set")
+	}
+
+	rc := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{addr}})
+	defer rc.Close()
+
+	for name, codec := range map[string]cache.Codec{"msgpack": MsgpackCodec{}, "columnar": ColumnarCodec{}} {
+		codec := codec
+		t.Run(name, func(t *testing.T) {
+			cachetest.RunConformance(t, func(t *testing.T) cache.Cacher {
+				r := NewRedis(rc, "sqlcache-test:"+t.Name()+":", WithCodec(codec), WithFingerprintIndex())
+				_, err := r.DeletePrefix(context.Background(), "")
+				require.Nil(t, err)
+				t.Cleanup(func() { _, _ = r.DeletePrefix(context.Background(), "") })
+				return r
+			})
+		})
+	}
+}
+
+// recordingHook answers commands without a server, recording their names.
+type recordingHook struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (h *recordingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *recordingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return h.answer(cmd)
+	}
+}
+
+func (h *recordingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		var err error
+		for _, cmd := range cmds {
+			if cerr := h.answer(cmd); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+}
+
+func (h *recordingHook) answer(cmd redis.Cmder) error {
+	h.mu.Lock()
+	h.cmds = append(h.cmds, cmd.Name())
+	h.mu.Unlock()
+
+	switch cmd := cmd.(type) {
+	case *redis.StatusCmd:
+		cmd.SetVal("OK")
+	case *redis.IntCmd:
+		cmd.SetVal(1)
+	case *redis.DurationCmd:
+		cmd.SetVal(-2)
+	case *redis.SliceCmd:
+		cmd.SetVal(make([]interface{}, len(cmd.Args())-1))
+	default:
+		cmd.SetErr(redis.Nil)
+		return redis.Nil
+	}
+	return nil
+}
+
+func TestRedisReadClient(t *testing.T) {
+	assert := require.New(t)
+
+	primary, replica := new(recordingHook), new(recordingHook)
+	pc := redis.NewClient(&redis.Options{Addr: "primary:6379"})
+	pc.AddHook(primary)
+	rc := redis.NewClient(&redis.Options{Addr: "replica:6379"})
+	rc.AddHook(replica)
+	r := NewRedis(pc, "sqc:", WithReadClient(rc))
+
+	ctx := context.Background()
+	_, ok, err := r.Get(ctx, "a")
+	assert.Nil(err)
+	assert.False(ok)
+	_, ok, err = r.GetStream(ctx, "a")
+	assert.Nil(err)
+	assert.False(ok)
+	items, err := r.GetMulti(ctx, []string{"a", "b"})
+	assert.Nil(err)
+	assert.Equal([]*cache.Item{nil, nil}, items)
+	_, ok, err = r.TTL(ctx, "a")
+	assert.Nil(err)
+	assert.False(ok)
+	assert.Nil(r.Set(ctx, "a", &cache.Item{Cols: []string{"n"}}, time.Minute))
+	assert.Nil(r.Delete(ctx, "a"))
+
+	// lookups go to the read client and everything else to the primary
+	assert.Equal([]string{"get", "get", "get", "get", "pttl"}, replica.cmds)
+	assert.Equal([]string{"set", "del"}, primary.cmds)
+	assert.Nil(r.Close())
+	assert.Equal(redis.ErrClosed, rc.Close())
+}
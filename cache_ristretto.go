@@ -2,6 +2,8 @@ package sqlcache
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,7 +15,60 @@ import (
 // Ristretto implements cache.Cacher interface to use ristretto as backend with
 // go-redis as the redis client library.
 type Ristretto struct {
-	c *ristretto.Cache
+	c           *ristretto.Cache
+	cost        func(item *cache.Item) int64
+	costIsBytes bool
+	index       *keyIndex
+	persist     *persister
+}
+
+// RistrettoOption configures optional behaviour of the ristretto backend.
+type RistrettoOption func(r *Ristretto)
+
+// WithByteSizeCost uses the estimated memory footprint of items in bytes
+// (see ItemSize) as their cost instead of the number of rows, so that
+// ristretto's MaxCost bounds the memory used by the cache.
+func WithByteSizeCost() RistrettoOption {
+	return func(r *Ristretto) {
+		r.cost = ItemSize
+		r.costIsBytes = true
+	}
+}
+
+// WithEncodedSizeCost uses the size of items encoded with the codec as
+// their cost, so that ristretto's MaxCost bounds the bytes the cache would
+// take if serialized. It's slower but more precise than WithByteSizeCost
+// for items with large values; items failing to encode are costed by
+// ItemSize.
+func WithEncodedSizeCost(codec cache.Codec) RistrettoOption {
+	return func(r *Ristretto) {
+		r.cost = func(item *cache.Item) int64 {
+			b, err := codec.Marshal(item)
+			if err != nil {
+				return ItemSize(item)
+			}
+			return int64(len(b))
+		}
+		r.costIsBytes = true
+	}
+}
+
+// WithCostFunc sets the function used to compute the cost of items.
+func WithCostFunc(cost func(item *cache.Item) int64) RistrettoOption {
+	return func(r *Ristretto) {
+		r.cost = cost
+		r.costIsBytes = false
+	}
+}
+
+// WithRistrettoFingerprintIndex maintains an index of keys by query
+// fingerprint so that the backend implements cache.Indexer. Keys evicted
+// by ristretto stay in the index until they expire or their query's items
+// are deleted.
+func WithRistrettoFingerprintIndex() RistrettoOption {
+	return func(r *Ristretto) {
+		r.index = newKeyIndex(time.Now)
+	}
 }
 
 // Get gets a cache item from ristretto. Returns pointer to the item, a boolean
@@ -34,17 +89,186 @@ func (r *Ristretto) Get(ctx context.Context, key string) (*cache.Item, bool, err
 
 // Set sets the given item into ristretto with provided TTL duration.
 func (r *Ristretto) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
-	// using # of rows as cost
-	_ = r.c.SetWithTTL(key, item, int64(len(item.Rows)), ttl)
+	if r.persist == nil {
+		r.set(key, item, ttl)
+		return nil
+	}
+
+	r.persist.admit(key, item, ttl, true, func() bool {
+		return r.set(key, item, ttl)
+	})
+	return nil
+}
+
+// set sets the item into ristretto without persisting it, and reports
+// whether ristretto accepted it.
+func (r *Ristretto) set(key string, item *cache.Item, ttl time.Duration) bool {
+	if !r.c.SetWithTTL(key, item, r.cost(item), ttl) {
+		return false
+	}
+	if r.index != nil {
+		r.index.add(key, item.Fingerprint, ttl)
+	}
+	return true
+}
+
+// Delete implements cache.Deleter.
+func (r *Ristretto) Delete(ctx context.Context, key string) error {
+	r.c.Del(key)
+	if r.index != nil {
+		r.index.remove(key)
+	}
+	if r.persist != nil {
+		r.persist.forget(key)
+	}
+	return nil
+}
+
+// TTL implements cache.TTLReporter.
+func (r *Ristretto) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	ttl, ok := r.c.GetTTL(key)
+
+	return ttl, ok, nil
+}
+
+// DeleteFingerprint implements cache.Indexer when
+// WithRistrettoFingerprintIndex is used. The number of items removed may
+// include items evicted by ristretto.
+func (r *Ristretto) DeleteFingerprint(ctx context.Context, fingerprint string) (int, error) {
+	if r.index == nil {
+		return 0, errors.New("sqlcache: fingerprint index not enabled; see WithRistrettoFingerprintIndex")
+	}
+
+	keys := r.index.take(fingerprint)
+	for _, key := range keys {
+		r.c.Del(key)
+		if r.persist != nil {
+			r.persist.forget(key)
+		}
+	}
+
+	return len(keys), nil
+}
+
+// GetMulti implements cache.BatchCacher.
+func (r *Ristretto) GetMulti(ctx context.Context, keys []string) ([]*cache.Item, error) {
+	items := make([]*cache.Item, len(keys))
+	for n, key := range keys {
+		item, _, err := r.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		items[n] = item
+	}
+
+	return items, nil
+}
+
+// SetMulti implements cache.BatchCacher.
+func (r *Ristretto) SetMulti(ctx context.Context, entries []cache.Entry) error {
+	for _, e := range entries {
+		if err := r.Set(ctx, e.Key, e.Item, e.TTL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BackendStats implements cache.StatsReporter using ristretto's metrics,
+// which are only collected when ristretto.Config.Metrics is set. Bytes is
+// only reported when WithByteSizeCost is used.
+func (r *Ristretto) BackendStats(ctx context.Context) (*cache.BackendStats, error) {
+	m := r.c.Metrics
+	s := &cache.BackendStats{
+		Entries:   sub(m.KeysAdded(), m.KeysEvicted()),
+		Evictions: m.KeysEvicted(),
+	}
+	if r.costIsBytes {
+		s.Bytes = sub(m.CostAdded(), m.CostEvicted())
+	}
+
+	return s, nil
+}
+
+// Close implements cache.Closer by stopping the goroutines of the ristretto
+// cache, after waiting for writes queued by WithPersistence to complete.
+// Items can't be set or found afterwards.
+func (r *Ristretto) Close() error {
+	if r.persist != nil {
+		r.persist.close()
+	}
+	r.c.Close()
 	return nil
 }
 
 // NewRistretto creates a new instance of ristretto backend wrapping the
 // provided *ristretto.Cache instance. While creating the ristretto
 // instance, please note that number of rows will be used as "cost"
-// (in ristretto's terminology) for each cache item.
-func NewRistretto(c *ristretto.Cache) *Ristretto {
-	return &Ristretto{
-		c: c,
+// (in ristretto's terminology) for each cache item unless WithByteSizeCost
+// or WithCostFunc is used.
+func NewRistretto(c *ristretto.Cache, opts ...RistrettoOption) *Ristretto {
+	r := &Ristretto{
+		c:    c,
+		cost: rowCount,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// rowCount costs items by number of rows. Empty results cost one, like a
+// row, so that they're bounded by MaxCost too.
+func rowCount(item *cache.Item) int64 {
+	if len(item.Rows) == 0 {
+		return 1
 	}
+	return int64(len(item.Rows))
+}
+
+// Approximate sizes, in bytes, of the parts of an item on 64-bit platforms.
+const (
+	sliceHeaderSize = 24
+	valueSize       = 16 // interface header
+	timeSize        = 24
+	wordSize        = 8
+)
+
+// ItemSize estimates the memory footprint of the item in bytes. Ten rows
+// of text can be thousands of times larger than ten rows of integers, so
+// this is a far better measure of cost than the number of rows.
+func ItemSize(item *cache.Item) int64 {
+	size := int64(sliceHeaderSize*2 + len(item.Fingerprint) + timeSize)
+	for _, col := range item.Cols {
+		size += int64(valueSize + len(col))
+	}
+
+	for _, row := range item.Rows {
+		size += rowSize(row)
+	}
+
+	return size
+}
+
+// rowSize estimates the memory footprint of the row in bytes.
+func rowSize(row []driver.Value) int64 {
+	size := int64(sliceHeaderSize + valueSize*len(row))
+	for _, v := range row {
+		switch v := v.(type) {
+		case nil, bool:
+		case string:
+			size += int64(len(v))
+		case []byte:
+			size += int64(sliceHeaderSize + len(v))
+		case time.Time:
+			size += timeSize
+		default:
+			size += wordSize
+		}
+	}
+
+	return size
 }
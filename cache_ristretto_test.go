@@ -0,0 +1,133 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/cache/cachetest"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestItemSize(t *testing.T) {
+	assert := require.New(t)
+
+	ints := &cache.Item{Cols: []string{"id"}}
+	texts := &cache.Item{Cols: []string{"id"}}
+	for n := 0; n < 10; n++ {
+		ints.Rows = append(ints.Rows, []driver.Value{int64(n)})
+		texts.Rows = append(texts.Rows, []driver.Value{strings.Repeat("x", 1000)})
+	}
+
+	assert.Greater(ItemSize(texts), int64(10000))
+	assert.Less(ItemSize(ints), int64(1000))
+	assert.Equal(rowCount(texts), rowCount(ints))
+	assert.Equal(int64(1), rowCount(&cache.Item{Cols: []string{"id"}}))
+}
+
+func TestRistrettoByteSizeCost(t *testing.T) {
+	assert := require.New(t)
+
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        100,
+		MaxCost:            1 << 20,
+		BufferItems:        64,
+		Metrics:            true,
+		IgnoreInternalCost: true,
+	})
+	assert.Nil(err)
+
+	r := NewRistretto(rc, WithByteSizeCost())
+	item := &cache.Item{
+		Cols: []string{"name"},
+		Rows: [][]driver.Value{{"John"}, {"Lisa"}},
+	}
+	assert.Nil(r.Set(context.Background(), "k", item, time.Minute))
+	rc.Wait()
+
+	s, err := r.BackendStats(context.Background())
+	assert.Nil(err)
+	assert.Equal(uint64(1), s.Entries)
+	assert.Equal(uint64(ItemSize(item)), s.Bytes)
+
+	// custom cost
+	r = NewRistretto(rc, WithCostFunc(func(*cache.Item) int64 { return 1 << 21 }))
+	assert.Nil(r.Set(context.Background(), "big", item, time.Minute))
+	rc.Wait()
+	_, ok, err := r.Get(context.Background(), "big")
+	assert.Nil(err)
+	assert.False(ok) // exceeds MaxCost
+}
+
+func TestRistrettoBatch(t *testing.T) {
+	assert := require.New(t)
+
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        100,
+		MaxCost:            100,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+	})
+	assert.Nil(err)
+
+	var r cache.BatchCacher = NewRistretto(rc)
+	a := &cache.Item{Cols: []string{"a"}}
+	b := &cache.Item{Cols: []string{"b"}}
+	assert.Nil(r.SetMulti(context.Background(), []cache.Entry{
+		{Key: "a", Item: a, TTL: time.Minute},
+		{Key: "b", Item: b, TTL: time.Minute},
+	}))
+	rc.Wait()
+
+	items, err := r.GetMulti(context.Background(), []string{"b", "missing", "a"})
+	assert.Nil(err)
+	assert.Equal([]*cache.Item{b, nil, a}, items)
+}
+
+func TestRistrettoEncodedSizeCost(t *testing.T) {
+	assert := require.New(t)
+
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        100,
+		MaxCost:            1 << 20,
+		BufferItems:        64,
+		Metrics:            true,
+		IgnoreInternalCost: true,
+	})
+	assert.Nil(err)
+
+	r := NewRistretto(rc, WithEncodedSizeCost(MsgpackCodec{}))
+	item := &cache.Item{
+		Cols: []string{"name"},
+		Rows: [][]driver.Value{{"John"}, {"Lisa"}},
+	}
+	assert.Nil(r.Set(context.Background(), "k", item, time.Minute))
+	rc.Wait()
+
+	b, err := MsgpackCodec{}.Marshal(item)
+	assert.Nil(err)
+	s, err := r.BackendStats(context.Background())
+	assert.Nil(err)
+	assert.Equal(uint64(len(b)), s.Bytes)
+}
+
+func TestRistrettoConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cacher {
+		rc, err := ristretto.NewCache(&ristretto.Config{
+			NumCounters:        1e4,
+			MaxCost:            1 << 30,
+			BufferItems:        64,
+			IgnoreInternalCost: true,
+		})
+		require.Nil(t, err)
+		t.Cleanup(rc.Close)
+		return NewRistretto(rc, WithRistrettoFingerprintIndex())
+	}, cachetest.WithWait(func(c cache.Cacher) {
+		c.(*Ristretto).c.Wait()
+	}))
+}
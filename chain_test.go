@@ -0,0 +1,161 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/ngrok/sqlmw"
+	"github.com/stretchr/testify/require"
+)
+
+// countingInterceptor is a middleware, as built with sqlmw by tracing and
+// hooks libraries, counting the queries it sees.
+type countingInterceptor struct {
+	sqlmw.NullInterceptor
+	queries int64
+}
+
+func (c *countingInterceptor) ConnQueryContext(ctx context.Context, conn driver.QueryerContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	atomic.AddInt64(&c.queries, 1)
+	return c.NullInterceptor.ConnQueryContext(ctx, conn, query, args)
+}
+
+func (c *countingInterceptor) StmtQueryContext(ctx context.Context, stmt driver.StmtQueryContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	atomic.AddInt64(&c.queries, 1)
+	return c.NullInterceptor.StmtQueryContext(ctx, stmt, query, args)
+}
+
+// taggingInterceptor is a middleware whose conns and stmts can't be used as
+// map keys.
+type taggingInterceptor struct {
+	sqlmw.NullInterceptor
+	tags []string
+}
+
+func TestMiddlewareChaining(t *testing.T) {
+	for _, outer := range []bool{true, false} {
+		t.Run(fmt.Sprintf("outer=%t", outer), func(t *testing.T) {
+			assert := require.New(t)
+
+			dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+			mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+			assert.Nil(err)
+			defer mockDB.Close()
+
+			mc := &mapCacher{entries: make(map[string]cache.Entry)}
+			ic, err := NewInterceptor(&Config{Cache: mc, DisableTxCaching: true})
+			assert.Nil(err)
+
+			mw := new(countingInterceptor)
+			d := sqlmw.Driver(ic.Driver(mockDB.Driver()), mw)
+			if outer {
+				d = ic.Driver(sqlmw.Driver(mockDB.Driver(), mw))
+			}
+			driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+			sql.Register(driverName, d)
+			db, err := sql.Open(driverName, dsn)
+			assert.Nil(err)
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			query := `-- @cache-ttl 30
+			          -- @cache-max-rows 10
+			          SELECT name FROM users WHERE age > ?`
+			run := func(q interface {
+				QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+			}) string {
+				rows, err := q.QueryContext(context.Background(), query, 18)
+				assert.Nil(err)
+				defer rows.Close()
+				types, err := rows.ColumnTypes()
+				assert.Nil(err)
+				for rows.Next() {
+				}
+				assert.Nil(rows.Err())
+				return types[0].DatabaseTypeName()
+			}
+
+			// the column types of the driver are seen on misses
+			qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnRows(
+				sqlmock.NewRowsWithColumnDefinition(sqlmock.NewColumn("name").OfType("VARCHAR", "")).AddRow("John"))
+			assert.Equal("VARCHAR", run(db))
+			run(db)
+			assert.Equal(uint64(1), ic.Stats().Hits)
+
+			// queries within transactions are neither served from nor
+			// written to cache
+			qMock.ExpectBegin()
+			qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Lisa"))
+			qMock.ExpectCommit()
+			tx, err := db.BeginTx(context.Background(), nil)
+			assert.Nil(err)
+			run(tx)
+			assert.Nil(tx.Commit())
+			assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipInTx])
+
+			// prepared statements are cached by their query
+			qMock.ExpectPrepare("SELECT name")
+			stmt, err := db.PrepareContext(context.Background(), query)
+			assert.Nil(err)
+			rows, err := stmt.QueryContext(context.Background(), 18)
+			assert.Nil(err)
+			assert.True(rows.Next())
+			assert.Nil(rows.Close())
+			assert.Nil(stmt.Close())
+			assert.Equal(uint64(2), ic.Stats().Hits)
+
+			assert.Nil(qMock.ExpectationsWereMet())
+			if outer {
+				// the middleware below sees only the queries run
+				assert.Equal(int64(2), atomic.LoadInt64(&mw.queries))
+			} else {
+				assert.Equal(int64(4), atomic.LoadInt64(&mw.queries))
+			}
+		})
+	}
+}
+
+func TestMiddlewareChainingUncomparable(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{Cache: mc, DisableTxCaching: true})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(sqlmw.Driver(mockDB.Driver(), taggingInterceptor{tags: []string{"a"}})))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	// conns that can't be tracked are assumed to be within a transaction
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users`
+	qMock.ExpectBegin()
+	qMock.ExpectQuery("SELECT name").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	qMock.ExpectCommit()
+	tx, err := db.BeginTx(context.Background(), nil)
+	assert.Nil(err)
+	rows, err := tx.QueryContext(context.Background(), query)
+	assert.Nil(err)
+	assert.True(rows.Next())
+	assert.Nil(rows.Close())
+	assert.Nil(tx.Commit())
+
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipInTx])
+	assert.Equal(0, len(mc.entries))
+	assert.Nil(qMock.ExpectationsWereMet())
+}
@@ -0,0 +1,106 @@
+package sqlcache
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs timers for the interceptor. Config.Clock
+// replaces the system clock so that time-dependent behaviour, such as item
+// ages, L1 expiry, rate limiting, per query stats, retry backoff and lock
+// polling, can be tested deterministically. Durations of queries and cache
+// operations are always measured with the system clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer returns a channel that receives the current time once d has
+	// elapsed, and a function that stops the timer, reporting whether it
+	// was stopped before firing.
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+// FakeClock is a Clock whose time only changes when advanced, for use in
+// tests. It's safe for concurrent use.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTimer returns a timer that fires once the clock is advanced by d.
+// Timers of zero or negative durations fire immediately.
+func (c *FakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t.ch, func() bool { return false }
+	}
+	c.timers[t] = struct{}{}
+
+	return t.ch, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		_, pending := c.timers[t]
+		delete(c.timers, t)
+		return pending
+	}
+}
+
+// Advance moves the clock forward by d and fires the timers due by then.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.at.After(c.now) {
+			t.ch <- c.now
+			delete(c.timers, t)
+		}
+	}
+}
+
+// Timers returns the number of timers yet to fire, so that tests can wait
+// for the code under test to start waiting before advancing the clock.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
@@ -0,0 +1,84 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	assert := require.New(t)
+
+	start := time.Unix(1700000000, 0)
+	c := NewFakeClock(start)
+	assert.Equal(start, c.Now())
+
+	fired, _ := c.NewTimer(time.Second)
+	stopped, stop := c.NewTimer(time.Second)
+	later, _ := c.NewTimer(time.Minute)
+	now, _ := c.NewTimer(0)
+	assert.Equal(start, <-now)
+	assert.Equal(3, c.Timers())
+
+	assert.True(stop())
+	assert.False(stop())
+	c.Advance(time.Second)
+	assert.Equal(start.Add(time.Second), c.Now())
+	assert.Equal(start.Add(time.Second), <-fired)
+	assert.Len(stopped, 0)
+	assert.Len(later, 0)
+	assert.Equal(1, c.Timers())
+
+	c.Advance(time.Hour)
+	assert.Equal(start.Add(time.Hour+time.Second), <-later)
+	assert.Equal(0, c.Timers())
+}
+
+func TestConfigClock(t *testing.T) {
+	assert := require.New(t)
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	item := &cache.Item{Cols: []string{"n"}, CreatedAt: clock.Now()}
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, errors.New("transient")).Once()
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(item, true, nil).Twice()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: mCacher,
+		Clock: clock,
+		Retry: &RetryPolicy{MaxRetries: 1, Backoff: time.Hour},
+	})
+
+	// the retry waits on the fake clock
+	done := make(chan error)
+	go func() {
+		_, err := ic.intercept(context.Background(), ic.prepare(`-- @cache-max-rows 10
+			-- @cache-ttl 30
+			SELECT 1`), nil, false, nil, func() (driver.Rows, error) {
+			return nil, errors.New("query run")
+		})
+		done <- err
+	}()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Hour)
+	assert.Nil(<-done)
+	assert.Equal(uint64(1), ic.Stats().Retries)
+
+	clock.Advance(time.Minute)
+	info, ok, err := ic.Inspect(context.Background(), `-- @cache-max-rows 10
+		-- @cache-ttl 30
+		SELECT 1`)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(time.Hour+time.Minute, info.Age)
+}
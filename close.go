@@ -0,0 +1,29 @@
+package sqlcache
+
+import (
+	"context"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// Close shuts the interceptor down for good. Queries started afterwards
+// bypass the cache as if it were disabled, pending writes are flushed and
+// background work is waited for as by Shutdown, and then the cache backend
+// is closed if it implements cache.Closer, even when ctx is done first.
+// Close returns the first error encountered; calling it again returns the
+// same error.
+func (i *Interceptor) Close(ctx context.Context) error {
+	i.closeOnce.Do(func() {
+		i.closed.Store(true)
+		i.health.close()
+		err := i.Shutdown(ctx)
+		if c, ok := cache.As[cache.Closer](i.cacher()); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+		i.closeErr = err
+	})
+
+	return i.closeErr
+}
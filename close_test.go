@@ -0,0 +1,74 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+type closingCacher struct {
+	mapCacher
+	closes int
+	err    error
+}
+
+func (c *closingCacher) Close() error {
+	c.closes++
+	return c.err
+}
+
+func TestClose(t *testing.T) {
+	assert := require.New(t)
+
+	errClose := errors.New("close failed")
+	cc := &closingCacher{mapCacher: mapCacher{entries: make(map[string]cache.Entry)}, err: errClose}
+	ic, err := NewInterceptor(&Config{
+		Cache:           cc,
+		AsyncSetWorkers: 1,
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+              -- @cache-max-rows 10
+              SELECT name FROM users WHERE id = ?`
+	run := func() {
+		args := []driver.NamedValue{{Ordinal: 1, Value: int64(1)}}
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	run()
+	assert.Equal(errClose, ic.Close(context.Background()))
+	// pending writes were flushed before closing the backend
+	assert.Len(cc.entries, 1)
+	assert.Equal(1, cc.closes)
+
+	assert.Equal(errClose, ic.Close(context.Background()))
+	assert.Equal(1, cc.closes)
+
+	run()
+	s := ic.Stats()
+	assert.Equal(uint64(0), s.Hits)
+	assert.Equal(uint64(1), s.SkipReasons[SkipDisabled])
+
+	// the backend is closed even when flushing times out
+	cc = &closingCacher{mapCacher: mapCacher{entries: make(map[string]cache.Entry)}}
+	ic, err = NewInterceptor(&Config{Cache: cc, AsyncSetWorkers: 1})
+	assert.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ic.Close(ctx)
+	assert.True(err == nil || errors.Is(err, context.Canceled))
+	assert.Equal(1, cc.closes)
+}
@@ -0,0 +1,326 @@
+// Command sqlcachectl inspects, purges, dumps and restores query results
+// cached by sqlcache in redis.
+//
+// Usage:
+//
+//	sqlcachectl [flags] key -query QUERY [-args JSON] [-principal P]
+//	sqlcachectl [flags] get (-key KEY | -query QUERY [-args JSON] [-principal P])
+//	sqlcachectl [flags] del (-key KEY | -query QUERY [-args JSON] [-principal P] | -prefix PREFIX | -fingerprint FP)
+//	sqlcachectl [flags] dump -file FILE
+//	sqlcachectl [flags] restore -file FILE
+//
+// Args are given as a JSON array; integral numbers are passed as int64,
+// other numbers as float64 and strings, booleans and null as is. Keys are
+// computed as the interceptor does, so flags such as -hash, -normalize and
+// -instance-key must match the Config of the program that cached the
+// results, and -principal must be given for queries with the
+// @cache-per-principal attribute. Keys of queries run through a driver
+// with a DriverPolicy.InstanceKey are computed with it as -instance-key.
+// Deleting by -fingerprint requires the program to use
+// sqlcache.WithFingerprintIndex. Results in the L1 caches of running
+// programs aren't deleted and expire within their Config.L1TTL.
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prashanthpai/sqlcache"
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const usage = `usage: sqlcachectl [flags] <command> [command flags]
+
+commands:
+  key      print the cache key of a query and its args
+  get      print the cached item of a key or query as JSON
+  del      delete the cached item of a key or query, all keys with a prefix
+           or all items of a query fingerprint
+  dump     write all cached items to a file
+  restore  write the items of a dump into the cache
+
+flags:
+`
+
+type options struct {
+	addr      string
+	prefix    string
+	codec     string
+	hash      string
+	normalize bool
+	instance  string
+	timeout   time.Duration
+}
+
+func main() {
+	var o options
+	fs := flag.NewFlagSet("sqlcachectl", flag.ExitOnError)
+	fs.StringVar(&o.addr, "addr", "127.0.0.1:6379", "comma separated redis addresses")
+	fs.StringVar(&o.prefix, "key-prefix", "sqc", "key prefix passed to sqlcache.NewRedis")
+	fs.StringVar(&o.codec, "codec", "msgpack", "codec of cached items: msgpack or columnar")
+	fs.StringVar(&o.hash, "hash", "default", "Config.HashFunc: default, strict or xxhash")
+	fs.BoolVar(&o.normalize, "normalize", false, "Config.NormalizeQuery")
+	fs.StringVar(&o.instance, "instance-key", "", "Config.InstanceKey, or DriverPolicy.InstanceKey of the driver queries run through")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "timeout of the command")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(o, fs.Arg(0), fs.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "sqlcachectl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(o options, cmd string, args []string) error {
+	var (
+		key, query, argsJSON, principal, prefix, fp, file string
+	)
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.StringVar(&query, "query", "", "query text, including cache attributes")
+	fs.StringVar(&argsJSON, "args", "[]", "query args as a JSON array")
+	fs.StringVar(&principal, "principal", "", "principal of queries with @cache-per-principal, as returned by Config.Principal")
+	switch cmd {
+	case "key":
+	case "get":
+		fs.StringVar(&key, "key", "", "cache key")
+	case "del":
+		fs.StringVar(&key, "key", "", "cache key")
+		fs.StringVar(&prefix, "prefix", "", "delete all keys starting with this prefix")
+		fs.StringVar(&fp, "fingerprint", "", "delete all items of the query with this fingerprint")
+	case "dump", "restore":
+		fs.StringVar(&file, "file", "-", "dump file; - for stdout or stdin")
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	_ = fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	rc := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: strings.Split(o.addr, ","),
+	})
+	defer rc.Close()
+
+	var codec cache.Codec
+	switch o.codec {
+	case "msgpack":
+		codec = sqlcache.MsgpackCodec{}
+	case "columnar":
+		codec = sqlcache.ColumnarCodec{}
+	default:
+		return fmt.Errorf("unknown codec %q", o.codec)
+	}
+	ropts := []sqlcache.RedisOption{sqlcache.WithCodec(codec)}
+	if fp != "" {
+		ropts = append(ropts, sqlcache.WithFingerprintIndex())
+	}
+	backend := sqlcache.NewRedis(rc, o.prefix, ropts...)
+
+	switch cmd {
+	case "dump":
+		return dump(ctx, backend, file)
+	case "restore":
+		return restore(ctx, backend, file)
+	}
+
+	if cmd == "del" && prefix != "" {
+		n, err := backend.DeletePrefix(ctx, prefix)
+		fmt.Printf("deleted %d keys\n", n)
+		return err
+	}
+	if cmd == "del" && fp != "" {
+		n, err := backend.DeleteFingerprint(ctx, fp)
+		fmt.Printf("deleted %d keys\n", n)
+		return err
+	}
+
+	if key == "" {
+		if query == "" {
+			return errors.New("-query or -key must be set")
+		}
+		var err error
+		if key, err = queryKey(o, backend, query, argsJSON, principal); err != nil {
+			return err
+		}
+	}
+
+	switch cmd {
+	case "key":
+		fmt.Println(key)
+	case "get":
+		item, ok, err := backend.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("key %q not found", key)
+		}
+		ttl, _, err := backend.TTL(ctx, key)
+		if err != nil {
+			return err
+		}
+		return printItem(key, item, ttl)
+	case "del":
+		if err := backend.Delete(ctx, key); err != nil {
+			return err
+		}
+		fmt.Printf("deleted %s\n", key)
+	}
+
+	return nil
+}
+
+// queryKey returns the key of the query run with the args of argsJSON for
+// the principal, if any.
+func queryKey(o options, backend cache.Cacher, query, argsJSON, principal string) (string, error) {
+	var hash func(string, []driver.NamedValue) (string, error)
+	switch o.hash {
+	case "default":
+	case "strict":
+		hash = sqlcache.StrictHash
+	case "xxhash":
+		hash = sqlcache.XXHash
+	default:
+		return "", fmt.Errorf("unknown hash %q", o.hash)
+	}
+
+	args, err := parseArgs(argsJSON)
+	if err != nil {
+		return "", err
+	}
+
+	ic, err := sqlcache.NewInterceptor(&sqlcache.Config{
+		Cache:          backend,
+		HashFunc:       hash,
+		NormalizeQuery: o.normalize,
+		InstanceKey:    o.instance,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ic.Key(query, args...)
+	if err != nil || principal == "" {
+		return key, err
+	}
+
+	return sqlcache.PrincipalKey(key, principal), nil
+}
+
+// parseArgs decodes a JSON array of args, with integral numbers as int64.
+func parseArgs(s string) ([]interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var args []interface{}
+	if err := dec.Decode(&args); err != nil {
+		return nil, fmt.Errorf("parsing -args failed: %w", err)
+	}
+
+	for n, arg := range args {
+		num, ok := arg.(json.Number)
+		if !ok {
+			continue
+		}
+		if i, err := num.Int64(); err == nil {
+			args[n] = i
+		} else if f, err := num.Float64(); err == nil {
+			args[n] = f
+		} else {
+			return nil, fmt.Errorf("parsing arg %d failed: %w", n+1, err)
+		}
+	}
+
+	return args, nil
+}
+
+func printItem(key string, item *cache.Item, ttl time.Duration) error {
+	out := struct {
+		Key         string
+		Fingerprint string
+		CreatedAt   time.Time
+		// TTL is the time left before the item expires; "0s" if it
+		// doesn't.
+		TTL    string
+		Digest []byte `json:",omitempty"`
+		Cols   []string
+		Rows   [][]driver.Value
+	}{
+		Key:         key,
+		Fingerprint: item.Fingerprint,
+		CreatedAt:   item.CreatedAt,
+		TTL:         ttl.String(),
+		Digest:      item.Digest,
+		Cols:        item.Cols,
+		Rows:        item.Rows,
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := os.Stdout.Write(buf.Bytes())
+
+	return err
+}
+
+func dump(ctx context.Context, backend cache.Cacher, file string) error {
+	w := os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := sqlcache.Dump(ctx, backend, w)
+	if err != nil {
+		return err
+	}
+	if file != "-" {
+		if err := w.Sync(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "dumped %d items\n", n)
+
+	return nil
+}
+
+func restore(ctx context.Context, backend cache.Cacher, file string) error {
+	r := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	n, err := sqlcache.Restore(ctx, backend, r)
+	fmt.Fprintf(os.Stderr, "restored %d items\n", n)
+
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prashanthpai/sqlcache"
+	"github.com/prashanthpai/sqlcache/sqlcachetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	assert := require.New(t)
+
+	args, err := parseArgs(`[18, 2.5, "John", true, null, 1e3]`)
+	assert.Nil(err)
+	assert.Equal([]interface{}{int64(18), 2.5, "John", true, nil, float64(1000)}, args)
+
+	_, err = parseArgs(`{"age": 18}`)
+	assert.NotNil(err)
+}
+
+func TestQueryKey(t *testing.T) {
+	assert := require.New(t)
+
+	backend := sqlcachetest.NewCache(nil)
+	query := "-- @cache-ttl 30\n-- @cache-max-rows 10\nSELECT name FROM users WHERE age > ?"
+	key, err := queryKey(options{hash: "default"}, backend, query, "[18]", "")
+	assert.Nil(err)
+	ic, err := sqlcache.NewInterceptor(&sqlcache.Config{Cache: backend})
+	assert.Nil(err)
+	want, err := ic.Key(query, 18)
+	assert.Nil(err)
+	assert.Equal(want, key)
+
+	key, err = queryKey(options{hash: "default"}, backend, query, "[18]", "alice")
+	assert.Nil(err)
+	assert.Equal(sqlcache.PrincipalKey(want, "alice"), key)
+
+	key, err = queryKey(options{hash: "default", instance: "replica"}, backend, query, "[18]", "")
+	assert.Nil(err)
+	ic, err = sqlcache.NewInterceptor(&sqlcache.Config{Cache: backend, InstanceKey: "replica"})
+	assert.Nil(err)
+	want, err = ic.Key(query, 18)
+	assert.Nil(err)
+	assert.Equal(want, key)
+}
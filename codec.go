@@ -0,0 +1,129 @@
+package sqlcache
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v4"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// MsgpackCodec implements cache.Codec interface and encodes items row-wise
+// using msgpack. This is the default codec used by the redis backend.
+type MsgpackCodec struct {
+	// ZonedTimes encodes time.Time values along with their location, so
+	// that times served from cache are in the same location as those
+	// returned by the driver, rather than as msgpack's own times, which
+	// decode in the local time zone. Items with zoned times are decoded
+	// regardless of this setting but can't be decoded by earlier releases;
+	// set it only once every process sharing the backend has been
+	// upgraded.
+	ZonedTimes bool
+}
+
+// Marshal encodes the item into msgpack bytes.
+func (c MsgpackCodec) Marshal(item *cache.Item) ([]byte, error) {
+	rows, err := encodeCustomValues(item.Rows, c.ZonedTimes)
+	if err != nil {
+		return nil, err
+	}
+
+	cpy := *item
+	cpy.Rows = rows
+
+	return marshalMsgpack(&cpy)
+}
+
+// Unmarshal decodes msgpack bytes into item.
+func (MsgpackCodec) Unmarshal(b []byte, item *cache.Item) error {
+	if err := msgpack.Unmarshal(b, item); err != nil {
+		return err
+	}
+
+	return decodeCustomValues(item.Rows)
+}
+
+// NewRowsReader returns a reader that decodes the rows of the msgpack
+// encoded item in b one at a time as they are read.
+func (MsgpackCodec) NewRowsReader(b []byte) (cache.RowsReader, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := new(cache.Item)
+	for f := 0; f < n; f++ {
+		field, err := dec.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+
+		switch field {
+		case "Cols":
+			err = dec.Decode(&hdr.Cols)
+		case "CreatedAt":
+			err = dec.Decode(&hdr.CreatedAt)
+		case "Fingerprint":
+			err = dec.Decode(&hdr.Fingerprint)
+		case "Digest":
+			err = dec.Decode(&hdr.Digest)
+		case "StaleAt":
+			err = dec.Decode(&hdr.StaleAt)
+		case "Rows":
+			// Rows is the last field of cache.Item; fields encoded
+			// after it (if any) are ignored.
+			numRows, err := dec.DecodeArrayLen()
+			if err != nil {
+				return nil, err
+			}
+			return &msgpackRowsReader{dec: dec, hdr: hdr, remaining: numRows}, nil
+		default:
+			err = dec.Skip()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &msgpackRowsReader{hdr: hdr}, nil
+}
+
+type msgpackRowsReader struct {
+	dec       *msgpack.Decoder
+	hdr       *cache.Item
+	remaining int
+	row       [1][]driver.Value
+}
+
+func (r *msgpackRowsReader) Header() *cache.Item {
+	return r.hdr
+}
+
+func (r *msgpackRowsReader) Next(dest []driver.Value) error {
+	if r.remaining <= 0 {
+		return io.EOF
+	}
+
+	n, err := r.dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+
+	for c := 0; c < n; c++ {
+		v, err := r.dec.DecodeInterface()
+		if err != nil {
+			return err
+		}
+		if c < len(dest) {
+			dest[c] = v
+		}
+	}
+	r.remaining--
+
+	r.row[0] = dest
+	return decodeCustomValues(r.row[:])
+}
@@ -0,0 +1,287 @@
+package sqlcache
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// ColumnarCodec implements cache.Codec interface and encodes items
+// column-wise. Values of each column are stored together in a typed array
+// when all non-NULL values of the column share the same type, which
+// compresses far better and decodes faster than per-row arrays for large,
+// homogeneous result sets. Columns with mixed types fall back to a generic
+// array of values.
+type ColumnarCodec struct {
+	// ZonedTimes encodes time.Time values along with their location, as
+	// MsgpackCodec.ZonedTimes does, and is subject to the same upgrade
+	// constraint.
+	ZonedTimes bool
+}
+
+type columnKind uint8
+
+const (
+	kindNull columnKind = iota
+	kindMixed
+	kindInt64
+	kindFloat64
+	kindBool
+	kindString
+	kindBytes
+	// kindTime columns hold times without their location, and
+	// kindZonedTime columns with it, as written with ZonedTimes.
+	kindTime
+	kindZonedTime
+)
+
+type columnarItem struct {
+	_msgpack    struct{} `msgpack:",asArray"`
+	Cols        []string
+	NumRows     int
+	Columns     []column
+	CreatedAt   time.Time
+	Fingerprint string
+	Digest      []byte
+	StaleAt     time.Time
+}
+
+type column struct {
+	_msgpack struct{} `msgpack:",asArray"`
+	Kind     columnKind
+	Nulls    []bool
+	Int64s   []int64
+	Float64s []float64
+	Bools    []bool
+	Strings  []string
+	Bytes    [][]byte
+	Times    []time.Time
+	Values   []interface{}
+	Zoned    []zonedTime
+}
+
+// Marshal encodes the item column-wise into msgpack bytes.
+func (cc ColumnarCodec) Marshal(item *cache.Item) ([]byte, error) {
+	numCols := len(item.Cols)
+	if numCols == 0 && len(item.Rows) > 0 {
+		numCols = len(item.Rows[0])
+	}
+
+	rows, err := encodeCustomValues(item.Rows, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ci := columnarItem{
+		Cols:        item.Cols,
+		NumRows:     len(rows),
+		Columns:     make([]column, numCols),
+		CreatedAt:   item.CreatedAt,
+		Fingerprint: item.Fingerprint,
+		Digest:      item.Digest,
+		StaleAt:     item.StaleAt,
+	}
+
+	for c := range ci.Columns {
+		col, err := encodeColumn(rows, c, cc.ZonedTimes)
+		if err != nil {
+			return nil, err
+		}
+		ci.Columns[c] = col
+	}
+
+	return marshalMsgpack(&ci)
+}
+
+// Unmarshal decodes bytes produced by ColumnarCodec.Marshal into item.
+func (ColumnarCodec) Unmarshal(b []byte, item *cache.Item) error {
+	var ci columnarItem
+	if err := msgpack.Unmarshal(b, &ci); err != nil {
+		return err
+	}
+
+	item.Cols = ci.Cols
+	item.CreatedAt = ci.CreatedAt
+	item.Fingerprint = ci.Fingerprint
+	item.Digest = ci.Digest
+	item.StaleAt = ci.StaleAt
+	item.Rows = make([][]driver.Value, ci.NumRows)
+	for r := range item.Rows {
+		item.Rows[r] = make([]driver.Value, len(ci.Columns))
+	}
+
+	for c := range ci.Columns {
+		if err := decodeColumn(&ci.Columns[c], item.Rows, c); err != nil {
+			return err
+		}
+	}
+
+	return decodeCustomValues(item.Rows)
+}
+
+func kindOf(v driver.Value, zoned bool) columnKind {
+	switch v.(type) {
+	case nil:
+		return kindNull
+	case int64:
+		return kindInt64
+	case float64:
+		return kindFloat64
+	case bool:
+		return kindBool
+	case string:
+		return kindString
+	case []byte:
+		return kindBytes
+	case time.Time:
+		if zoned {
+			return kindZonedTime
+		}
+		return kindTime
+	default:
+		return kindMixed
+	}
+}
+
+func encodeColumn(rows [][]driver.Value, c int, zoned bool) (column, error) {
+	col := column{Kind: kindNull}
+	hasNulls := false
+	for _, row := range rows {
+		if c >= len(row) {
+			return column{}, fmt.Errorf("ColumnarCodec: row has %d values, expected at least %d", len(row), c+1)
+		}
+		k := kindOf(row[c], zoned)
+		switch {
+		case k == kindNull:
+			hasNulls = true
+		case col.Kind == kindNull:
+			col.Kind = k
+		case col.Kind != k:
+			col.Kind = kindMixed
+		}
+	}
+
+	n := len(rows)
+	if hasNulls && col.Kind != kindMixed && col.Kind != kindNull {
+		col.Nulls = make([]bool, n)
+	}
+
+	switch col.Kind {
+	case kindNull:
+		return col, nil
+	case kindMixed:
+		col.Values = make([]interface{}, n)
+		var times []zonedTime
+		for r, row := range rows {
+			v := row[c]
+			if t, ok := v.(time.Time); ok && zoned {
+				if times == nil {
+					times = make([]zonedTime, 0, n-r)
+				}
+				times = append(times, newZonedTime(t))
+				v = &times[len(times)-1]
+			}
+			col.Values[r] = v
+		}
+		return col, nil
+	case kindInt64:
+		col.Int64s = make([]int64, n)
+	case kindFloat64:
+		col.Float64s = make([]float64, n)
+	case kindBool:
+		col.Bools = make([]bool, n)
+	case kindString:
+		col.Strings = make([]string, n)
+	case kindBytes:
+		col.Bytes = make([][]byte, n)
+	case kindTime:
+		col.Times = make([]time.Time, n)
+	case kindZonedTime:
+		col.Zoned = make([]zonedTime, n)
+	}
+
+	for r, row := range rows {
+		switch v := row[c].(type) {
+		case nil:
+			col.Nulls[r] = true
+		case int64:
+			col.Int64s[r] = v
+		case float64:
+			col.Float64s[r] = v
+		case bool:
+			col.Bools[r] = v
+		case string:
+			col.Strings[r] = v
+		case []byte:
+			col.Bytes[r] = v
+		case time.Time:
+			if zoned {
+				col.Zoned[r] = newZonedTime(v)
+			} else {
+				col.Times[r] = v
+			}
+		}
+	}
+
+	return col, nil
+}
+
+func decodeColumn(col *column, rows [][]driver.Value, c int) error {
+	n := len(rows)
+	var length int
+	switch col.Kind {
+	case kindNull:
+		return nil
+	case kindMixed:
+		length = len(col.Values)
+	case kindInt64:
+		length = len(col.Int64s)
+	case kindFloat64:
+		length = len(col.Float64s)
+	case kindBool:
+		length = len(col.Bools)
+	case kindString:
+		length = len(col.Strings)
+	case kindBytes:
+		length = len(col.Bytes)
+	case kindTime:
+		length = len(col.Times)
+	case kindZonedTime:
+		length = len(col.Zoned)
+	default:
+		return fmt.Errorf("ColumnarCodec: unknown column kind %d", col.Kind)
+	}
+	if length != n || (col.Nulls != nil && len(col.Nulls) != n) {
+		return fmt.Errorf("ColumnarCodec: column %d has %d values, expected %d", c, length, n)
+	}
+
+	for r := range rows {
+		if col.Nulls != nil && col.Nulls[r] {
+			continue
+		}
+		switch col.Kind {
+		case kindMixed:
+			rows[r][c] = col.Values[r]
+		case kindInt64:
+			rows[r][c] = col.Int64s[r]
+		case kindFloat64:
+			rows[r][c] = col.Float64s[r]
+		case kindBool:
+			rows[r][c] = col.Bools[r]
+		case kindString:
+			rows[r][c] = col.Strings[r]
+		case kindBytes:
+			rows[r][c] = col.Bytes[r]
+		case kindTime:
+			rows[r][c] = col.Times[r]
+		case kindZonedTime:
+			rows[r][c] = col.Zoned[r].time()
+		}
+	}
+
+	return nil
+}
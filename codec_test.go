@@ -0,0 +1,172 @@
+package sqlcache
+
+import (
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnarCodec(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Unix(1700000000, 0).UTC()
+	item := &cache.Item{
+		StaleAt: now.Add(time.Minute),
+		Cols:    []string{"id", "name", "score", "active", "blob", "created", "mixed", "empty"},
+		Rows: [][]driver.Value{
+			{int64(1), "John", 1.5, true, []byte("a"), now, int64(1), nil},
+			{int64(2), nil, 2.5, false, []byte("b"), now.Add(time.Hour), "one", nil},
+			{nil, "Lisa", nil, nil, nil, nil, nil, nil},
+		},
+	}
+
+	var codec ColumnarCodec
+	b, err := codec.Marshal(item)
+	assert.Nil(err)
+
+	var got cache.Item
+	assert.Nil(codec.Unmarshal(b, &got))
+	assert.Equal(item.Cols, got.Cols)
+	assert.True(item.StaleAt.Equal(got.StaleAt))
+	assert.Equal(len(item.Rows), len(got.Rows))
+	for r := range item.Rows {
+		for c := range item.Rows[r] {
+			if want, ok := item.Rows[r][c].(time.Time); ok {
+				assert.True(want.Equal(got.Rows[r][c].(time.Time)))
+				continue
+			}
+			assert.EqualValues(item.Rows[r][c], got.Rows[r][c], "row %d col %d", r, c)
+		}
+	}
+
+	// typed columns must preserve the exact driver.Value type
+	assert.IsType(int64(0), got.Rows[0][0])
+
+	// empty result set
+	empty := &cache.Item{Cols: []string{"id"}}
+	b, err = codec.Marshal(empty)
+	assert.Nil(err)
+	got = cache.Item{}
+	assert.Nil(codec.Unmarshal(b, &got))
+	assert.Equal(empty.Cols, got.Cols)
+	assert.Len(got.Rows, 0)
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	assert := require.New(t)
+
+	item := &cache.Item{
+		Cols: []string{"name"},
+		Rows: [][]driver.Value{{"John"}, {"Lisa"}},
+	}
+
+	var codec MsgpackCodec
+	b, err := codec.Marshal(item)
+	assert.Nil(err)
+
+	var got cache.Item
+	assert.Nil(codec.Unmarshal(b, &got))
+	assert.Equal(item, &got)
+}
+
+type testUUID [16]byte
+
+func TestRegisterValueType(t *testing.T) {
+	assert := require.New(t)
+
+	RegisterValueType("test-uuid", testUUID{},
+		func(v driver.Value) ([]byte, error) {
+			u := v.(testUUID)
+			return u[:], nil
+		},
+		func(b []byte) (driver.Value, error) {
+			var u testUUID
+			copy(u[:], b)
+			return u, nil
+		})
+
+	assert.Panics(func() {
+		RegisterValueType("test-uuid", "", func(driver.Value) ([]byte, error) { return nil, nil },
+			func([]byte) (driver.Value, error) { return nil, nil })
+	})
+
+	u := testUUID{1, 2, 3, 4}
+	item := &cache.Item{
+		Cols: []string{"id", "name"},
+		Rows: [][]driver.Value{{u, "John"}, {nil, "Lisa"}},
+	}
+
+	for _, codec := range []cache.Codec{MsgpackCodec{}, ColumnarCodec{}} {
+		b, err := codec.Marshal(item)
+		assert.Nil(err)
+		// source item must not be modified
+		assert.Equal(u, item.Rows[0][0])
+
+		var got cache.Item
+		assert.Nil(codec.Unmarshal(b, &got))
+		assert.Equal(u, got.Rows[0][0])
+		assert.Nil(got.Rows[1][0])
+		assert.Equal("Lisa", got.Rows[1][1])
+	}
+}
+
+func TestMsgpackRowsReader(t *testing.T) {
+	assert := require.New(t)
+
+	item := &cache.Item{
+		Cols:        []string{"id", "name"},
+		CreatedAt:   time.Unix(1700000000, 0),
+		Fingerprint: "abc",
+		StaleAt:     time.Unix(1700000060, 0),
+		Rows:        [][]driver.Value{{int64(1), "John"}, {int64(2), nil}},
+	}
+
+	var codec MsgpackCodec
+	b, err := codec.Marshal(item)
+	assert.Nil(err)
+
+	rr, err := codec.NewRowsReader(b)
+	assert.Nil(err)
+	assert.Equal(item.Cols, rr.Header().Cols)
+	assert.Equal("abc", rr.Header().Fingerprint)
+	assert.True(item.CreatedAt.Equal(rr.Header().CreatedAt))
+	assert.True(item.StaleAt.Equal(rr.Header().StaleAt))
+	assert.Nil(rr.Header().Rows)
+
+	dest := make([]driver.Value, 2)
+	for _, want := range item.Rows {
+		assert.Nil(rr.Next(dest))
+		assert.EqualValues(want, dest)
+	}
+	assert.Equal(io.EOF, rr.Next(dest))
+
+	// truncated payload surfaces an error while reading rows
+	rr, err = codec.NewRowsReader(b[:len(b)-2])
+	assert.Nil(err)
+	assert.Nil(rr.Next(dest))
+	assert.NotNil(rr.Next(dest))
+}
+
+func BenchmarkCodecMarshal(b *testing.B) {
+	item := &cache.Item{Cols: []string{"id", "name", "score"}}
+	for n := 0; n < 1000; n++ {
+		item.Rows = append(item.Rows, []driver.Value{int64(n), "John", 1.5})
+	}
+
+	for name, codec := range map[string]cache.Codec{
+		"msgpack":  MsgpackCodec{},
+		"columnar": ColumnarCodec{},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				_, _ = codec.Marshal(item)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package sqlcache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prashanthpai/sqlcache/internal/sqlscan"
+)
+
+// queryComments returns the comments of the query, outside of quoted
+// strings and identifiers, as found by sqlscan.Scan.
+func queryComments(query string) []sqlscan.Comment {
+	comments, _, _ := sqlscan.Scan(query, false)
+	return comments
+}
+
+// doubleQuoteEscapes returns the query with the quotes of its strings that
+// are escaped by backslashes, as in 'O\'Brien', escaped by doubling them
+// instead, which databases with backslash escapes read the same way and
+// the other functions of this file understand. Backslashes are taken to
+// escape quotes of all strings, as with Config.BackslashEscapes.
+func doubleQuoteEscapes(query string) string {
+	if !strings.Contains(query, "\\") {
+		return query
+	}
+	_, _, escapes := sqlscan.Scan(query, true)
+	if len(escapes) == 0 {
+		return query
+	}
+
+	b := []byte(query)
+	for _, n := range escapes {
+		b[n] = query[n+1]
+	}
+
+	return string(b)
+}
+
+// stripComments returns the query with its comments, including optimizer
+// hints, replaced by spaces.
+func stripComments(query string) string {
+	comments := queryComments(query)
+	if len(comments) == 0 {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query))
+	prev := 0
+	for _, c := range comments {
+		b.WriteString(query[prev:c.Start])
+		b.WriteByte(' ')
+		prev = c.End
+	}
+	b.WriteString(query[prev:])
+
+	return b.String()
+}
+
+// stripLiterals returns the query with its comments replaced by spaces, as
+// by stripComments, and its quoted strings and identifiers by empty
+// strings, so that words within them aren't taken for keywords.
+func stripLiterals(query string) string {
+	comments, literals, _ := sqlscan.Scan(query, false)
+	if len(comments) == 0 && len(literals) == 0 {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query))
+	prev := 0
+	for len(comments) > 0 || len(literals) > 0 {
+		var (
+			s    sqlscan.Span
+			repl = "''"
+		)
+		if len(literals) == 0 || len(comments) > 0 && comments[0].Start < literals[0].Start {
+			s, repl = sqlscan.Span{Start: comments[0].Start, End: comments[0].End}, " "
+			comments = comments[1:]
+		} else {
+			s = literals[0]
+			literals = literals[1:]
+		}
+		b.WriteString(query[prev:s.Start])
+		b.WriteString(repl)
+		prev = s.End
+	}
+	b.WriteString(query[prev:])
+
+	return b.String()
+}
+
+// attrText returns the text of the comments of the query that may hold
+// cache attributes, one per line. Attributes within optimizer hints
+// aren't, as databases would parse them as hints, which Oracle stops
+// doing at the first it doesn't know; an error is returned for them.
+func attrText(query string) (string, error) {
+	if !strings.Contains(query, "@cache-") {
+		return "", nil
+	}
+
+	var (
+		b   strings.Builder
+		err error
+	)
+	for _, c := range queryComments(query) {
+		text := query[c.Start:c.End]
+		if !strings.Contains(text, "@cache-") {
+			continue
+		}
+		if c.Hint {
+			if err == nil {
+				err = fmt.Errorf("cache attributes within optimizer hint %q", text)
+			}
+			continue
+		}
+		b.WriteString(text)
+		b.WriteByte('\n')
+	}
+
+	return b.String(), err
+}
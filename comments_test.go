@@ -0,0 +1,138 @@
+package sqlcache
+
+import (
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripComments(t *testing.T) {
+	assert := require.New(t)
+
+	tests := []struct {
+		query, stripped string
+	}{
+		{"SELECT 1 -- one\nFROM t", "SELECT 1  \nFROM t"},
+		{"SELECT /* a */ 1 /* b */", "SELECT   1  "},
+		// nested, as in T-SQL and PostgreSQL
+		{"/* a /* b */ c */ SELECT 1", "  SELECT 1"},
+		// unterminated by nesting, as in MySQL and Oracle
+		{"/* src/*.sql */ SELECT 1", "  SELECT 1"},
+		{"SELECT /*+ INDEX(u users_idx) */ name FROM users u", "SELECT   name FROM users u"},
+		{"SELECT '--', \"/*\", `--`, [a--b], q'[it's -- ok]' -- x", "SELECT '--', \"/*\", `--`, [a--b], q'[it's -- ok]'  "},
+		{"SELECT 'it''s -- ok' FROM t", "SELECT 'it''s -- ok' FROM t"},
+		{"SELECT N'x' /* y */", "SELECT N'x'  "},
+		{"SELECT nq'{a'b}' -- z", "SELECT nq'{a'b}'  "},
+		{"SELECT 1 /* open", "SELECT 1  "},
+		// backslashes escape quotes of PostgreSQL escape strings only
+		{`SELECT E'it\'s -- ok', e'\\' -- x`, `SELECT E'it\'s -- ok', e'\\'  `},
+		{`SELECT 'C:\' -- x`, `SELECT 'C:\'  `},
+		{`SELECT name'x' -- y`, `SELECT name'x'  `},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.stripped, stripComments(tt.query), tt.query)
+	}
+}
+
+func TestStripLiterals(t *testing.T) {
+	assert := require.New(t)
+
+	tests := []struct {
+		query, stripped string
+	}{
+		{"SELECT 'update' FROM t", "SELECT '' FROM t"},
+		{"SELECT \"into\", `for`, [delete] -- for update\nFROM t", "SELECT '', '', ''  \nFROM t"},
+		{"SELECT 'it''s', q'[a'b]' /* x */", "SELECT '', ''  "},
+		{`SELECT E'it\'s update'`, `SELECT ''`},
+		{"SELECT 1", "SELECT 1"},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.stripped, stripLiterals(tt.query), tt.query)
+	}
+}
+
+func TestAttrText(t *testing.T) {
+	assert := require.New(t)
+
+	tests := []struct {
+		query string
+		ttl   int
+	}{
+		// T-SQL
+		{"/* @cache-ttl 30 /* nested */ @cache-max-rows 10 */ SELECT name FROM [users]", 30},
+		{"SELECT name FROM [users -- @cache-ttl 30] -- @cache-ttl 60 @cache-max-rows 10", 60},
+		// Oracle
+		{"SELECT /*+ FULL(u) */ name FROM users u -- @cache-ttl 30 @cache-max-rows 10", 30},
+		{"-- @cache-ttl 30\n--+ FULL(u)\n-- @cache-max-rows 10\nSELECT name FROM users u", 30},
+		{"SELECT q'[-- @cache-ttl 60]' /* @cache-ttl 30 @cache-max-rows 10 */ FROM dual", 30},
+		{"SELECT '@cache-ttl 60' /* @cache-ttl 30 @cache-max-rows 10 */", 30},
+		// PostgreSQL
+		{`SELECT E'O\'Brien -- @cache-ttl 60' /* @cache-ttl 30 @cache-max-rows 10 */`, 30},
+		{`SELECT 'C:\' -- @cache-ttl 30 @cache-max-rows 10`, 30},
+	}
+	for _, tt := range tests {
+		attrs := getAttrs(tt.query)
+		assert.NotNil(attrs, tt.query)
+		assert.Nil(attrs.validate(ZeroTTLSkip), tt.query)
+		assert.Equal(tt.ttl, attrs.ttl, tt.query)
+		assert.Equal(10, attrs.maxRows, tt.query)
+	}
+
+	// attributes outside comments, such as T-SQL variables, aren't read
+	assert.Nil(getAttrs("DECLARE @cache INT; SELECT @cache-ttl 30, @cache-max-rows 10"))
+
+	// attributes within hints are reported instead of being ignored
+	for _, query := range []string{
+		"SELECT /*+ FULL(u) @cache-ttl 30 @cache-max-rows 10 */ name FROM users u",
+		"--+ @cache-ttl 30 @cache-max-rows 10\nSELECT name FROM users u",
+		"SELECT /*+ FULL(u) @cache-mask email */ name FROM users u -- @cache-ttl 30 @cache-max-rows 10",
+	} {
+		attrs := getAttrs(query)
+		assert.NotNil(attrs, query)
+		assert.NotNil(attrs.validate(ZeroTTLSkip), query)
+	}
+}
+
+func TestBackslashEscapes(t *testing.T) {
+	assert := require.New(t)
+
+	tests := []struct {
+		query, doubled string
+	}{
+		{`SELECT 'O\'Brien -- @cache-ttl 60' /* @cache-ttl 30 @cache-max-rows 10 */`,
+			`SELECT 'O''Brien -- @cache-ttl 60' /* @cache-ttl 30 @cache-max-rows 10 */`},
+		{`SELECT "say \"hi\" -- @cache-ttl 60", 'it\'s', 'C:\\' -- @cache-ttl 30 @cache-max-rows 10`,
+			`SELECT "say ""hi"" -- @cache-ttl 60", 'it''s', 'C:\\' -- @cache-ttl 30 @cache-max-rows 10`},
+		{`SELECT "it\'s", '\n' -- @cache-ttl 30 @cache-max-rows 10`,
+			`SELECT "it\'s", '\n' -- @cache-ttl 30 @cache-max-rows 10`},
+	}
+	for _, tt := range tests {
+		doubled := doubleQuoteEscapes(tt.query)
+		assert.Equal(tt.doubled, doubled, tt.query)
+		attrs := getAttrs(doubled)
+		assert.NotNil(attrs, tt.query)
+		assert.Nil(attrs.validate(ZeroTTLSkip), tt.query)
+		assert.Equal(30, attrs.ttl, tt.query)
+	}
+
+	query := tests[0].query
+	for _, backslash := range []bool{false, true} {
+		ic, err := NewInterceptor(&Config{
+			Cache:            &mapCacher{entries: make(map[string]cache.Entry)},
+			BackslashEscapes: backslash,
+		})
+		assert.Nil(err)
+		p := ic.prepare(query)
+		assert.Equal(query, p.query)
+		if !backslash {
+			// the string is taken to end at \', so that the attribute
+			// within it is read too and repeated
+			assert.NotNil(p.attrs.err)
+			continue
+		}
+		assert.NotNil(p.attrs)
+		assert.Equal(30, p.attrs.ttl)
+	}
+}
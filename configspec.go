@@ -0,0 +1,510 @@
+package sqlcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultRistrettoNumCounters = 1e6
+
+// ConfigSpec is a declarative description of a Config, as read from a
+// YAML or JSON document by ParseConfigSpec and from environment variables
+// by ApplyEnv, so that services needn't wire up the interceptor in code.
+// Fields have the meaning of the Config fields of the same name.
+type ConfigSpec struct {
+	// Backend is "redis", "memcached" or "ristretto".
+	Backend   string        `yaml:"backend"`
+	Redis     RedisSpec     `yaml:"redis"`
+	Memcached MemcachedSpec `yaml:"memcached"`
+	Ristretto RistrettoSpec `yaml:"ristretto"`
+
+	// Hash is "default", "strict", "xxhash" or "noop".
+	Hash              string        `yaml:"hash"`
+	NormalizeQuery    bool          `yaml:"normalize_query"`
+	BackslashEscapes  bool          `yaml:"backslash_escapes"`
+	InstanceKey       string        `yaml:"instance_key"`
+	VerifyDigest      bool          `yaml:"verify_digest"`
+	DisableTxCaching  bool          `yaml:"disable_tx_caching"`
+	UTCTimes          bool          `yaml:"utc_times"`
+	InvalidateOnDDL   bool          `yaml:"invalidate_on_ddl"`
+	TrackTableChanges bool          `yaml:"track_table_changes"`
+	DryRun            bool          `yaml:"dry_run"`
+	MaxTrackedQueries int           `yaml:"max_tracked_queries"`
+	LockTimeout       time.Duration `yaml:"lock_timeout"`
+	AsyncSetWorkers   int           `yaml:"async_set_workers"`
+	AsyncSetQueueSize int           `yaml:"async_set_queue_size"`
+	L1Size            int           `yaml:"l1_size"`
+	L1TTL             time.Duration `yaml:"l1_ttl"`
+	Retry             RetrySpec     `yaml:"retry"`
+	HealthCheck       HealthSpec    `yaml:"health_check"`
+
+	SampleRate float64 `yaml:"sample_rate"`
+	// ZeroTTL is "skip" or "no-expiry".
+	ZeroTTL string `yaml:"zero_ttl"`
+	// NonDeterministic is "allow", "warn" or "skip".
+	NonDeterministic       string        `yaml:"non_deterministic"`
+	CacheWrites            bool          `yaml:"cache_writes"`
+	DisableNegativeCaching bool          `yaml:"disable_negative_caching"`
+	NegativeTTL            time.Duration `yaml:"negative_ttl"`
+	MaxItemBytes           int           `yaml:"max_item_bytes"`
+	MaxRecordBytes         int           `yaml:"max_record_bytes"`
+	MinQueryLatency        time.Duration `yaml:"min_query_latency"`
+	GetTimeout             time.Duration `yaml:"get_timeout"`
+	SetTimeout             time.Duration `yaml:"set_timeout"`
+	DetachSets             bool          `yaml:"detach_sets"`
+	MinLookupBudget        time.Duration `yaml:"min_lookup_budget"`
+	FailFastOnDeadline     bool          `yaml:"fail_fast_on_deadline"`
+	MaxConcurrentMisses    int           `yaml:"max_concurrent_misses"`
+	MissLimit              string        `yaml:"miss_limit"` // "wait", "stale" or "fail"
+	AllowFingerprints      []string      `yaml:"allow_fingerprints"`
+	DenyFingerprints       []string      `yaml:"deny_fingerprints"`
+	// TTLOverrides are given in the environment as a comma separated list
+	// of fingerprint=duration pairs.
+	TTLOverrides map[string]time.Duration `yaml:"ttl_overrides"`
+}
+
+// RedisSpec describes the redis backend.
+type RedisSpec struct {
+	// Addrs are the addresses of a single server, of the nodes of a
+	// cluster, or of the sentinels when MasterName is set.
+	Addrs []string `yaml:"addrs"`
+	// MasterName is the name of the primary monitored by Sentinel.
+	MasterName string `yaml:"master_name"`
+	// ReadFrom is where lookups are sent to in clusters and Sentinel
+	// deployments: "primary", the default, "replicas", "latency" for the
+	// closest node or "random" for any node. Writes go to the primary;
+	// see WithReadClient.
+	ReadFrom  string `yaml:"read_from"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix"`
+	// Codec is "msgpack", the default, or "columnar".
+	Codec string `yaml:"codec"`
+	// ZonedTimes sets ZonedTimes of the codec.
+	ZonedTimes bool `yaml:"zoned_times"`
+}
+
+// MemcachedSpec describes the memcached backend.
+type MemcachedSpec struct {
+	// Addr is the address of the server. It's required.
+	Addr      string `yaml:"addr"`
+	KeyPrefix string `yaml:"key_prefix"`
+	// Codec is "msgpack", the default, or "columnar".
+	Codec string `yaml:"codec"`
+	// ZonedTimes sets ZonedTimes of the codec.
+	ZonedTimes bool `yaml:"zoned_times"`
+	// CompressMin is the size of encoded items from which they're
+	// compressed; zero disables compression.
+	CompressMin  int `yaml:"compress_min"`
+	MaxIdleConns int `yaml:"max_idle_conns"`
+}
+
+// RistrettoSpec describes the ristretto backend.
+type RistrettoSpec struct {
+	// MaxCost is the capacity of the cache in rows, or in bytes when
+	// CostBy is "bytes". It's required.
+	MaxCost int64 `yaml:"max_cost"`
+	// NumCounters defaults to a million, which suits caches of up to
+	// about 100k items.
+	NumCounters int64 `yaml:"num_counters"`
+	// CostBy is "rows", the default, or "bytes".
+	CostBy string `yaml:"cost_by"`
+}
+
+// RetrySpec describes Config.Retry, which is set when MaxRetries is
+// positive.
+type RetrySpec struct {
+	MaxRetries int           `yaml:"max_retries"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	Jitter     float64       `yaml:"jitter"`
+}
+
+// HealthSpec describes Config.HealthCheck, which is set when
+// FailureThreshold is positive.
+type HealthSpec struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	ProbeInterval    time.Duration `yaml:"probe_interval"`
+	ProbeTimeout     time.Duration `yaml:"probe_timeout"`
+}
+
+// LoadConfig builds a Config from the YAML or JSON document at path, if
+// path isn't empty, with fields overridden by environment variables whose
+// names start with envPrefix, as by ConfigSpec.ApplyEnv.
+func LoadConfig(path, envPrefix string) (*Config, error) {
+	spec := new(ConfigSpec)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if spec, err = ParseConfigSpec(f); err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %w", path, err)
+		}
+	}
+	if err := spec.ApplyEnv(envPrefix); err != nil {
+		return nil, err
+	}
+
+	return spec.Build()
+}
+
+// ParseConfigSpec reads a YAML or JSON document with the keys of the yaml
+// tags of ConfigSpec. Durations are strings such as "250ms". Unknown keys
+// are rejected.
+func ParseConfigSpec(r io.Reader) (*ConfigSpec, error) {
+	spec := new(ConfigSpec)
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(spec); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// ApplyEnv overrides fields of the spec with the environment variables
+// named by prefix followed by the upper-cased yaml keys of the field and
+// the fields enclosing it, joined by underscores, such as
+// SQLCACHE_REDIS_ADDRS for a prefix of "SQLCACHE_". Lists are comma
+// separated.
+func (s *ConfigSpec) ApplyEnv(prefix string) error {
+	return applyEnv(reflect.ValueOf(s).Elem(), prefix)
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+		name := prefix + strings.ToUpper(f.Tag.Get("yaml"))
+		if f.Type.Kind() == reflect.Struct {
+			if err := applyEnv(v.Field(n), name+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(v.Field(n), s); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setFromString(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var list []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m := make(map[string]time.Duration)
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			k, d, ok := strings.Cut(e, "=")
+			if !ok {
+				return fmt.Errorf("%q isn't a key=duration pair", e)
+			}
+			ttl, err := time.ParseDuration(strings.TrimSpace(d))
+			if err != nil {
+				return err
+			}
+			m[strings.TrimSpace(k)] = ttl
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// Build returns the Config described by the spec, with a new backend
+// which lives as long as the process.
+func (s *ConfigSpec) Build() (*Config, error) {
+	c := &Config{
+		NormalizeQuery:         s.NormalizeQuery,
+		BackslashEscapes:       s.BackslashEscapes,
+		InstanceKey:            s.InstanceKey,
+		VerifyDigest:           s.VerifyDigest,
+		DisableTxCaching:       s.DisableTxCaching,
+		UTCTimes:               s.UTCTimes,
+		InvalidateOnDDL:        s.InvalidateOnDDL,
+		TrackTableChanges:      s.TrackTableChanges,
+		DryRun:                 s.DryRun,
+		MaxTrackedQueries:      s.MaxTrackedQueries,
+		LockTimeout:            s.LockTimeout,
+		AsyncSetWorkers:        s.AsyncSetWorkers,
+		AsyncSetQueueSize:      s.AsyncSetQueueSize,
+		L1Size:                 s.L1Size,
+		L1TTL:                  s.L1TTL,
+		SampleRate:             s.SampleRate,
+		CacheWrites:            s.CacheWrites,
+		DisableNegativeCaching: s.DisableNegativeCaching,
+		NegativeTTL:            s.NegativeTTL,
+		MaxItemBytes:           s.MaxItemBytes,
+		MaxRecordBytes:         s.MaxRecordBytes,
+		MinQueryLatency:        s.MinQueryLatency,
+		GetTimeout:             s.GetTimeout,
+		SetTimeout:             s.SetTimeout,
+		DetachSets:             s.DetachSets,
+		MinLookupBudget:        s.MinLookupBudget,
+		FailFastOnDeadline:     s.FailFastOnDeadline,
+		MaxConcurrentMisses:    s.MaxConcurrentMisses,
+		AllowFingerprints:      s.AllowFingerprints,
+		DenyFingerprints:       s.DenyFingerprints,
+		TTLOverrides:           s.TTLOverrides,
+	}
+
+	switch s.Hash {
+	case "", "default":
+	case "strict":
+		c.HashFunc = StrictHash
+	case "xxhash":
+		c.HashFunc = XXHash
+	case "noop":
+		c.HashFunc = NoopHash
+	default:
+		return nil, fmt.Errorf("unknown hash %q", s.Hash)
+	}
+
+	switch s.ZeroTTL {
+	case "", "skip":
+		c.ZeroTTL = ZeroTTLSkip
+	case "no-expiry":
+		c.ZeroTTL = ZeroTTLNoExpiry
+	default:
+		return nil, fmt.Errorf("unknown zero_ttl policy %q", s.ZeroTTL)
+	}
+
+	switch s.NonDeterministic {
+	case "", "allow":
+		c.NonDeterministic = NonDeterministicAllow
+	case "warn":
+		c.NonDeterministic = NonDeterministicWarn
+	case "skip":
+		c.NonDeterministic = NonDeterministicSkip
+	default:
+		return nil, fmt.Errorf("unknown non_deterministic policy %q", s.NonDeterministic)
+	}
+
+	switch s.MissLimit {
+	case "", "wait":
+		c.MissLimit = MissLimitWait
+	case "stale":
+		c.MissLimit = MissLimitStale
+	case "fail":
+		c.MissLimit = MissLimitFail
+	default:
+		return nil, fmt.Errorf("unknown miss_limit policy %q", s.MissLimit)
+	}
+
+	if r := s.Retry; r.MaxRetries > 0 {
+		c.Retry = &RetryPolicy{
+			MaxRetries: r.MaxRetries,
+			Backoff:    r.Backoff,
+			MaxBackoff: r.MaxBackoff,
+			Jitter:     r.Jitter,
+		}
+	}
+	if h := s.HealthCheck; h.FailureThreshold > 0 {
+		c.HealthCheck = &HealthCheck{
+			FailureThreshold: h.FailureThreshold,
+			ProbeInterval:    h.ProbeInterval,
+			ProbeTimeout:     h.ProbeTimeout,
+		}
+	}
+
+	var err error
+	switch s.Backend {
+	case "redis":
+		c.Cache, err = s.Redis.build()
+	case "memcached":
+		c.Cache, err = s.Memcached.build()
+	case "ristretto":
+		c.Cache, err = s.Ristretto.build()
+	default:
+		err = fmt.Errorf("unknown backend %q", s.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *RedisSpec) build() (*Redis, error) {
+	if len(s.Addrs) == 0 {
+		return nil, fmt.Errorf("redis addrs must be set")
+	}
+
+	var opts []RedisOption
+	switch s.Codec {
+	case "", "msgpack":
+		if s.ZonedTimes {
+			opts = append(opts, WithCodec(MsgpackCodec{ZonedTimes: true}))
+		}
+	case "columnar":
+		opts = append(opts, WithCodec(ColumnarCodec{ZonedTimes: s.ZonedTimes}))
+	default:
+		return nil, fmt.Errorf("unknown redis codec %q", s.Codec)
+	}
+
+	uo := &redis.UniversalOptions{
+		Addrs:      s.Addrs,
+		MasterName: s.MasterName,
+		Username:   s.Username,
+		Password:   s.Password,
+		DB:         s.DB,
+	}
+	readFrom := s.ReadFrom
+	switch readFrom {
+	case "", "primary":
+		readFrom = ""
+	case "replicas", "latency", "random":
+		if s.MasterName == "" && len(s.Addrs) < 2 {
+			return nil, fmt.Errorf("redis read_from requires a cluster or master_name")
+		}
+	default:
+		return nil, fmt.Errorf("unknown redis read_from %q", s.ReadFrom)
+	}
+
+	if s.MasterName != "" && readFrom != "" {
+		fo := &redis.FailoverOptions{
+			MasterName:    s.MasterName,
+			SentinelAddrs: s.Addrs,
+			Username:      s.Username,
+			Password:      s.Password,
+			DB:            s.DB,
+		}
+		var read redis.UniversalClient
+		switch readFrom {
+		case "replicas":
+			fo.ReplicaOnly = true
+			read = redis.NewFailoverClient(fo)
+		case "latency":
+			fo.RouteByLatency = true
+			read = redis.NewFailoverClusterClient(fo)
+		case "random":
+			fo.RouteRandomly = true
+			read = redis.NewFailoverClusterClient(fo)
+		}
+		opts = append(opts, WithReadClient(read))
+	} else {
+		uo.ReadOnly = readFrom == "replicas"
+		uo.RouteByLatency = readFrom == "latency"
+		uo.RouteRandomly = readFrom == "random"
+	}
+
+	return NewRedis(redis.NewUniversalClient(uo), s.KeyPrefix, opts...), nil
+}
+
+func (s *MemcachedSpec) build() (*Memcached, error) {
+	if s.Addr == "" {
+		return nil, fmt.Errorf("memcached addr must be set")
+	}
+
+	var opts []MemcachedOption
+	switch s.Codec {
+	case "", "msgpack":
+		if s.ZonedTimes {
+			opts = append(opts, WithMemcachedCodec(MsgpackCodec{ZonedTimes: true}))
+		}
+	case "columnar":
+		opts = append(opts, WithMemcachedCodec(ColumnarCodec{ZonedTimes: s.ZonedTimes}))
+	default:
+		return nil, fmt.Errorf("unknown memcached codec %q", s.Codec)
+	}
+	if s.CompressMin > 0 {
+		opts = append(opts, WithMemcachedCompression(s.CompressMin))
+	}
+	if s.MaxIdleConns > 0 {
+		opts = append(opts, WithMemcachedMaxIdleConns(s.MaxIdleConns))
+	}
+
+	return NewMemcached(s.Addr, s.KeyPrefix, opts...), nil
+}
+
+func (s *RistrettoSpec) build() (*Ristretto, error) {
+	if s.MaxCost <= 0 {
+		return nil, fmt.Errorf("ristretto max_cost must be positive")
+	}
+
+	var opts []RistrettoOption
+	switch s.CostBy {
+	case "", "rows":
+	case "bytes":
+		opts = append(opts, WithByteSizeCost())
+	default:
+		return nil, fmt.Errorf("unknown ristretto cost_by %q", s.CostBy)
+	}
+
+	numCounters := s.NumCounters
+	if numCounters <= 0 {
+		numCounters = defaultRistrettoNumCounters
+	}
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        numCounters,
+		MaxCost:            s.MaxCost,
+		BufferItems:        64,
+		Metrics:            true,
+		IgnoreInternalCost: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRistretto(rc, opts...), nil
+}
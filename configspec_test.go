@@ -0,0 +1,153 @@
+package sqlcache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigSpec(t *testing.T) {
+	assert := require.New(t)
+
+	spec, err := ParseConfigSpec(strings.NewReader(`
+backend: redis
+redis:
+  addrs: ["127.0.0.1:6379"]
+  key_prefix: sqc
+  codec: columnar
+hash: xxhash
+zero_ttl: no-expiry
+non_deterministic: warn
+miss_limit: stale
+get_timeout: 50ms
+retry:
+  max_retries: 2
+  backoff: 10ms
+deny_fingerprints: [abc]
+ttl_overrides: {abc: 5m}
+`))
+	assert.Nil(err)
+	assert.Equal("redis", spec.Backend)
+	assert.Equal([]string{"127.0.0.1:6379"}, spec.Redis.Addrs)
+	assert.Equal(50*time.Millisecond, spec.GetTimeout)
+
+	c, err := spec.Build()
+	assert.Nil(err)
+	r, ok := c.Cache.(*Redis)
+	assert.True(ok)
+	assert.Equal("sqc", r.keyPrefix)
+	assert.IsType(ColumnarCodec{}, r.Codec())
+	assert.NotNil(c.HashFunc)
+	assert.Equal(ZeroTTLNoExpiry, c.ZeroTTL)
+	assert.Equal(NonDeterministicWarn, c.NonDeterministic)
+	assert.Equal(MissLimitStale, c.MissLimit)
+	assert.Equal(2, c.Retry.MaxRetries)
+	assert.Equal([]string{"abc"}, c.DenyFingerprints)
+	assert.Equal(map[string]time.Duration{"abc": 5 * time.Minute}, c.TTLOverrides)
+
+	// lookups of Sentinel deployments are sent to a client of replicas
+	spec, err = ParseConfigSpec(strings.NewReader("backend: redis\nredis: {addrs: [s1, s2], master_name: mymaster, read_from: latency}"))
+	assert.Nil(err)
+	c, err = spec.Build()
+	assert.Nil(err)
+	r = c.Cache.(*Redis)
+	assert.IsType(&redis.Client{}, r.c)
+	assert.IsType(&redis.ClusterClient{}, r.rc)
+	assert.Nil(r.Close())
+
+	spec, err = ParseConfigSpec(strings.NewReader("backend: memcached\nmemcached: {addr: 127.0.0.1:11211, codec: columnar, compress_min: 4096}"))
+	assert.Nil(err)
+	c, err = spec.Build()
+	assert.Nil(err)
+	m, ok := c.Cache.(*Memcached)
+	assert.True(ok)
+	assert.IsType(ColumnarCodec{}, m.Codec())
+	assert.Equal(4096, m.compressMin)
+
+	// JSON is YAML too
+	spec, err = ParseConfigSpec(strings.NewReader(`{"backend": "ristretto", "ristretto": {"max_cost": 1000}}`))
+	assert.Nil(err)
+	c, err = spec.Build()
+	assert.Nil(err)
+	assert.IsType(&Ristretto{}, c.Cache)
+
+	_, err = ParseConfigSpec(strings.NewReader("bogus: 1"))
+	assert.NotNil(err)
+	spec, err = ParseConfigSpec(strings.NewReader(""))
+	assert.Nil(err)
+
+	for _, doc := range []string{
+		"",
+		"backend: memcached",
+		"backend: memcached\nmemcached: {addr: x, codec: gob}",
+		"backend: ristretto",
+		"backend: redis",
+		"backend: redis\nredis: {addrs: [x], codec: gob}",
+		"backend: redis\nredis: {addrs: [x], read_from: replicas}",
+		"backend: redis\nredis: {addrs: [x, y], read_from: nearest}",
+		"backend: ristretto\nristretto: {max_cost: 1, cost_by: items}",
+		"backend: ristretto\nristretto: {max_cost: 1}\nhash: md5",
+		"backend: ristretto\nristretto: {max_cost: 1}\nzero_ttl: forever",
+		"backend: ristretto\nristretto: {max_cost: 1}\nnon_deterministic: panic",
+	} {
+		spec, err := ParseConfigSpec(strings.NewReader(doc))
+		assert.Nil(err)
+		_, err = spec.Build()
+		assert.NotNil(err, doc)
+	}
+}
+
+func TestConfigSpecApplyEnv(t *testing.T) {
+	assert := require.New(t)
+
+	t.Setenv("SQC_BACKEND", "redis")
+	t.Setenv("SQC_REDIS_ADDRS", "a:6379, b:6379")
+	t.Setenv("SQC_REDIS_DB", "3")
+	t.Setenv("SQC_DISABLE_TX_CACHING", "true")
+	t.Setenv("SQC_SAMPLE_RATE", "0.5")
+	t.Setenv("SQC_LOCK_TIMEOUT", "2s")
+	t.Setenv("SQC_RETRY_MAX_RETRIES", "4")
+	t.Setenv("SQC_TTL_OVERRIDES", "abc=1m, def=0s")
+
+	spec := &ConfigSpec{Backend: "ristretto", Hash: "strict"}
+	assert.Nil(spec.ApplyEnv("SQC_"))
+	assert.Equal("redis", spec.Backend)
+	assert.Equal([]string{"a:6379", "b:6379"}, spec.Redis.Addrs)
+	assert.Equal(3, spec.Redis.DB)
+	assert.True(spec.DisableTxCaching)
+	assert.Equal(0.5, spec.SampleRate)
+	assert.Equal(2*time.Second, spec.LockTimeout)
+	assert.Equal(4, spec.Retry.MaxRetries)
+	assert.Equal(map[string]time.Duration{"abc": time.Minute, "def": 0}, spec.TTLOverrides)
+	assert.Equal("strict", spec.Hash)
+
+	t.Setenv("SQC_TTL_OVERRIDES", "abc")
+	assert.NotNil(spec.ApplyEnv("SQC_"))
+	t.Setenv("SQC_TTL_OVERRIDES", "")
+
+	t.Setenv("SQC_LOCK_TIMEOUT", "soon")
+	err := spec.ApplyEnv("SQC_")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "SQC_LOCK_TIMEOUT")
+}
+
+func TestLoadConfig(t *testing.T) {
+	assert := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "sqlcache.yaml")
+	assert.Nil(os.WriteFile(path, []byte("backend: redis\nredis: {addrs: [a:6379]}\n"), 0o600))
+
+	t.Setenv("SQC_BACKEND", "ristretto")
+	t.Setenv("SQC_RISTRETTO_MAX_COST", "100")
+	c, err := LoadConfig(path, "SQC_")
+	assert.Nil(err)
+	assert.IsType(&Ristretto{}, c.Cache)
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"), "SQC_")
+	assert.NotNil(err)
+}
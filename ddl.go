@@ -0,0 +1,146 @@
+package sqlcache
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// ddlRegexp matches statements changing or removing tables and views,
+// capturing the verb, ALTER, DROP or RENAME, or TRUNCATE, and the rest of
+// the statement, which starts with the names of the tables.
+var ddlRegexp = regexp.MustCompile(`(?is)^[\s(]*(?:(ALTER|DROP|RENAME)\s+(?:TABLE|(?:MATERIALIZED\s+)?VIEW)|(TRUNCATE)(?:\s+TABLE)?)\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?(.*)$`)
+
+// ddlTables returns the unqualified names of the tables changed by the
+// statement, if it's DDL changing tables or views.
+func ddlTables(query string) ([]string, bool) {
+	stripped := strings.TrimSpace(stripComments(query))
+	m := ddlRegexp.FindStringSubmatch(stripped)
+	if m == nil {
+		return nil, false
+	}
+	parts := strings.Split(strings.TrimRight(m[3], "; \t\n"), ",")
+	if strings.EqualFold(m[1], "ALTER") {
+		// commas separate the changes of the table
+		parts = parts[:1]
+	}
+	var tables []string
+	for _, part := range parts {
+		// RENAME TABLE a TO b also changes b, which can't have been read
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		tables = append(tables, unqualifiedTableName(normalizeTableName(fields[0])))
+	}
+
+	return tables, len(tables) > 0
+}
+
+// ddlTracker tracks the generations of tables changed by DDL statements,
+// or as reported to Interceptor.TablesChanged, which are part of the keys
+// of the queries reading them so that results cached before the tables
+// changed aren't served afterwards.
+type ddlTracker struct {
+	mu   sync.RWMutex
+	gens map[string]uint64 // unqualified table name -> generation
+	// all is bumped by every DDL statement, for queries whose tables
+	// aren't known.
+	all uint64
+}
+
+// generation returns the generation of the tables, which changes whenever
+// one of them is changed. It's zero until then.
+func (d *ddlTracker) generation(tables []string) uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if len(tables) == 0 {
+		return d.all
+	}
+	var gen uint64
+	for _, t := range tables {
+		gen += d.gens[unqualifiedTableName(t)]
+	}
+
+	return gen
+}
+
+func (d *ddlTracker) bump(tables []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.gens == nil {
+		d.gens = make(map[string]uint64)
+	}
+	d.all++
+	for _, t := range tables {
+		d.gens[t]++
+	}
+}
+
+// withGeneration returns hash scoped by the generation of the tables read
+// by the query, when Config.InvalidateOnDDL or Config.TrackTableChanges is
+// set and they were changed.
+func (i *Interceptor) withGeneration(p *preparedQuery, hash string) string {
+	if i.ddl == nil {
+		return hash
+	}
+	gen := i.ddl.generation(p.tables)
+	if gen == 0 {
+		return hash
+	}
+
+	return hash + "|ddl" + strconv.FormatUint(gen, 10)
+}
+
+// observeDDL invalidates the results of the queries reading the tables
+// changed by query, if it's a DDL statement.
+func (i *Interceptor) observeDDL(ctx context.Context, query string) {
+	tables, ok := ddlTables(i.queryText(query))
+	if !ok {
+		return
+	}
+
+	atomic.AddUint64(&i.stats.DDLInvalidations, 1)
+	i.ddl.bump(tables)
+	i.log(ctx, LevelInfo, "sqlcache: DDL statement invalidated cached results", "tables", strings.Join(tables, ","))
+
+	// results cached by other processes are removed if the backend
+	// allows, as they don't know of the statement
+	if _, ok := cache.As[cache.Indexer](i.cacher()); !ok {
+		return
+	}
+	i.fingerprints.m.Range(func(k, v interface{}) bool {
+		p := v.(*fingerprintEntry).p
+		if !readsAny(p.tables, tables) {
+			return true
+		}
+		if _, err := i.InvalidateQuery(ctx, p.fingerprint); err != nil {
+			i.reportErr(ctx, &queryInfo{query: p.query, fingerprint: p.fingerprint}, err)
+		}
+		return true
+	})
+}
+
+// readsAny reports whether a query reading read, which is empty if they
+// aren't known, may read any of the tables changed.
+func readsAny(read, changed []string) bool {
+	if len(read) == 0 {
+		return true
+	}
+	for _, r := range read {
+		for _, c := range changed {
+			if unqualifiedTableName(r) == c {
+				return true
+			}
+		}
+	}
+
+	return false
+}
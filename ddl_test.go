@@ -0,0 +1,114 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDDLTables(t *testing.T) {
+	assert := require.New(t)
+
+	tests := []struct {
+		query  string
+		tables []string
+	}{
+		{"ALTER TABLE users ADD COLUMN age int, DROP COLUMN born", []string{"users"}},
+		{"alter table public.\"Users\" rename column a to b", []string{"users"}},
+		{"DROP TABLE IF EXISTS users, accounts CASCADE;", []string{"users", "accounts"}},
+		{"DROP MATERIALIZED VIEW top_users", []string{"top_users"}},
+		{"TRUNCATE users", []string{"users"}},
+		{"TRUNCATE TABLE ONLY `users`", []string{"users"}},
+		{"RENAME TABLE users TO old_users", []string{"users"}},
+		{"/* migration */ ALTER TABLE users ADD age int", []string{"users"}},
+		{"SELECT * FROM users", nil},
+		{"UPDATE users SET name = 'x'", nil},
+		{"CREATE TABLE users (id int)", nil},
+		{"DROP INDEX users_name", nil},
+	}
+	for _, tt := range tests {
+		tables, ok := ddlTables(tt.query)
+		assert.Equal(tt.tables != nil, ok, tt.query)
+		assert.Equal(tt.tables, tables, tt.query)
+	}
+}
+
+func TestInvalidateOnDDL(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	ic, err := NewInterceptor(&Config{
+		Cache:           &mapCacher{entries: make(map[string]cache.Entry)},
+		InvalidateOnDDL: true,
+	})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	users := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users WHERE id = ?`
+	accounts := `-- @cache-ttl 30
+	             -- @cache-max-rows 10
+	             SELECT name FROM accounts WHERE id = ?`
+	lookup := func(query string, miss bool) {
+		if miss {
+			qMock.ExpectQuery("SELECT name").WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+		}
+		rows, err := db.QueryContext(context.Background(), query, 1)
+		assert.Nil(err)
+		var name string
+		for rows.Next() {
+			assert.Nil(rows.Scan(&name))
+		}
+		assert.Nil(rows.Close())
+		assert.Equal("John", name)
+		assert.Nil(qMock.ExpectationsWereMet())
+	}
+	exec := func(query string) {
+		qMock.ExpectExec(".*").WillReturnResult(sqlmock.NewResult(0, 0))
+		_, err := db.ExecContext(context.Background(), query)
+		assert.Nil(err)
+	}
+
+	lookup(users, true)
+	lookup(users, false)
+	lookup(accounts, true)
+	lookup(accounts, false)
+
+	// writes don't invalidate results
+	exec("UPDATE users SET name = 'x'")
+	lookup(users, false)
+
+	exec("ALTER TABLE users ADD COLUMN age int")
+	assert.Equal(uint64(1), ic.Stats().DDLInvalidations)
+	lookup(users, true)
+	lookup(users, false)
+	lookup(accounts, false)
+
+	// failed statements change nothing
+	qMock.ExpectExec("TRUNCATE").WillReturnError(fmt.Errorf("locked"))
+	_, err = db.ExecContext(context.Background(), "TRUNCATE accounts")
+	assert.NotNil(err)
+	lookup(accounts, false)
+
+	exec("DROP TABLE accounts")
+	lookup(accounts, true)
+	lookup(users, false)
+	assert.Equal(uint64(2), ic.Stats().DDLInvalidations)
+}
@@ -0,0 +1,34 @@
+package sqlcache
+
+import (
+	"context"
+	"time"
+)
+
+// defaultDetachedSetTimeout bounds writes on detached contexts when
+// Config.SetTimeout isn't set, so that they can't block forever.
+const defaultDetachedSetTimeout = 5 * time.Second
+
+// detachedContext carries the values of its parent but neither its
+// deadline nor its cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// setContext returns the context to write the results of a query run
+// with ctx under, and the timeout of the write.
+func setContext(ctx context.Context, o *options) (context.Context, time.Duration) {
+	if !o.DetachSets {
+		return ctx, o.SetTimeout
+	}
+	if o.SetTimeout <= 0 {
+		return detachedContext{ctx}, defaultDetachedSetTimeout
+	}
+
+	return detachedContext{ctx}, o.SetTimeout
+}
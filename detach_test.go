@@ -0,0 +1,74 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ctxCacher is a mapCacher whose writes fail when their context is done.
+type ctxCacher struct {
+	*mapCacher
+	deadline bool
+	value    interface{}
+}
+
+type ctxValueKey struct{}
+
+func (c *ctxCacher) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
+	_, c.deadline = ctx.Deadline()
+	c.value = ctx.Value(ctxValueKey{})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.mapCacher.Set(ctx, key, item, ttl)
+}
+
+func TestDetachSets(t *testing.T) {
+	assert := require.New(t)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users`
+	// run reads all rows and cancels the query's context before closing
+	// them, as callers returning right after reading rows do.
+	run := func(ic *Interceptor) {
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxValueKey{}, "v"))
+		rows, err := ic.intercept(ctx, ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 2, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		cancel()
+		assert.Nil(rows.Close())
+	}
+
+	c := &ctxCacher{mapCacher: &mapCacher{entries: make(map[string]cache.Entry)}}
+	var errs []error
+	ic, err := NewInterceptor(&Config{
+		Cache:   c,
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	assert.Nil(err)
+	run(ic)
+	assert.Empty(c.entries)
+	assert.Len(errs, 1)
+	assert.True(errors.Is(errs[0], context.Canceled))
+
+	ic, err = NewInterceptor(&Config{Cache: c, DetachSets: true})
+	assert.Nil(err)
+	run(ic)
+	assert.Len(c.entries, 1)
+	assert.Equal(uint64(1), ic.Stats().Sets)
+	// writes keep the values of the query's context, with a timeout
+	assert.Equal("v", c.value)
+	assert.True(c.deadline)
+}
@@ -0,0 +1,66 @@
+package sqlcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// digestSize is the number of bytes of the SHA-256 digest kept.
+const digestSize = 16
+
+// queryDigest returns a digest of the query and args, normalized as by
+// the default hash function and ordered as by canonicalArgs, so that calls
+// sharing a key by normalization share a digest too. It's independent of
+// HashFunc and so unlikely to collide for queries whose keys do.
+func queryDigest(query string, args []driver.NamedValue) []byte {
+	h := sha256.New()
+	var buf [8]byte
+	writeString := func(s string) {
+		binary.LittleEndian.PutUint64(buf[:], uint64(len(s)))
+		h.Write(buf[:])
+		h.Write([]byte(s))
+	}
+
+	writeString(query)
+	for _, arg := range normalizeArgs(canonicalArgs(args)) {
+		writeString(arg.Name)
+		binary.LittleEndian.PutUint64(buf[:], uint64(arg.Ordinal))
+		h.Write(buf[:])
+		if b, ok := arg.Value.([]byte); ok {
+			writeString(string(b))
+			continue
+		}
+		writeString(fmt.Sprintf("%T:%v", arg.Value, arg.Value))
+	}
+
+	return h.Sum(nil)[:digestSize]
+}
+
+// verify reports whether the item holds the results of the query when
+// Config.VerifyDigest is set. Items of other queries, such as due to a
+// hash collision, are deleted from the backend when it implements
+// cache.Deleter.
+func (i *Interceptor) verify(ctx context.Context, q *queryInfo, item *cache.Item) bool {
+	if !i.verifyDigest || bytes.Equal(item.Digest, q.digest) {
+		return true
+	}
+
+	i.log(ctx, LevelWarn, "sqlcache: cached item holds results of another query",
+		"fingerprint", q.fingerprint, "key", q.key)
+	if d, ok := cache.As[cache.Deleter](i.cacher()); ok {
+		if err := d.Delete(ctx, q.key); err != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrCacheDelete, Op: "Cache.Delete", Key: q.key, Err: err})
+			return false
+		}
+		i.quota.release(q.key)
+		i.emit(Event{Type: EventInvalidate, Fingerprint: q.fingerprint, Driver: q.driver.name(), Key: q.key})
+	}
+
+	return false
+}
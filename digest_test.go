@@ -0,0 +1,102 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryDigest(t *testing.T) {
+	assert := require.New(t)
+
+	args := func(v interface{}) []driver.NamedValue {
+		return []driver.NamedValue{{Ordinal: 1, Value: v}}
+	}
+
+	assert.Len(queryDigest("SELECT 1", nil), digestSize)
+	assert.Equal(queryDigest("SELECT ?", args(int64(1))), queryDigest("SELECT ?", args(int32(1))))
+	assert.NotEqual(queryDigest("SELECT ?", args(int64(1))), queryDigest("SELECT ?", args(int64(2))))
+	assert.NotEqual(queryDigest("SELECT ?", args(int64(1))), queryDigest("SELECT ?", args("1")))
+	assert.NotEqual(queryDigest("SELECT ?", args(int64(1))), queryDigest("SELECT  ?", args(int64(1))))
+}
+
+func TestVerifyDigest(t *testing.T) {
+	assert := require.New(t)
+
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        100,
+		MaxCost:            100,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+	})
+	assert.Nil(err)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: NewRistretto(rc),
+		// every query collides
+		HashFunc: func(string, []driver.NamedValue) (string, error) {
+			return "k", nil
+		},
+		VerifyDigest:      true,
+		DisableCoalescing: true,
+	})
+
+	p := ic.prepare(`-- @cache-max-rows 10
+                     -- @cache-ttl 30
+                     SELECT name FROM users WHERE age > ?`)
+	run := func(age int64) int {
+		ran := 0
+		rows, err := ic.intercept(context.Background(), p, []driver.NamedValue{{Ordinal: 1, Value: age}}, false, nil, func() (driver.Rows, error) {
+			ran++
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+		rc.Wait()
+		return ran
+	}
+
+	assert.Equal(1, run(18))
+	assert.Equal(0, run(18))
+
+	// the entry of age 18 isn't served for age 21 and is deleted
+	assert.Equal(1, run(21))
+	item, ok := rc.Get("k")
+	assert.True(ok)
+	assert.Equal(queryDigest(p.hashQuery, []driver.NamedValue{{Ordinal: 1, Value: int64(21)}}), item.(*cache.Item).Digest)
+
+	s := ic.Stats()
+	assert.Equal(uint64(1), s.Hits)
+	assert.Equal(uint64(2), s.Misses)
+}
+
+func TestCodecsDigest(t *testing.T) {
+	assert := require.New(t)
+
+	item := &cache.Item{
+		Cols:   []string{"n"},
+		Digest: []byte{1, 2, 3},
+		Rows:   [][]driver.Value{{int64(1)}},
+	}
+	for _, codec := range []cache.Codec{MsgpackCodec{}, ColumnarCodec{}} {
+		b, err := codec.Marshal(item)
+		assert.Nil(err)
+		var got cache.Item
+		assert.Nil(codec.Unmarshal(b, &got))
+		assert.Equal(item.Digest, got.Digest)
+	}
+
+	b, err := MsgpackCodec{}.Marshal(item)
+	assert.Nil(err)
+	rr, err := MsgpackCodec{}.NewRowsReader(b)
+	assert.Nil(err)
+	assert.Equal(item.Digest, rr.Header().Digest)
+}
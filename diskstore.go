@@ -0,0 +1,166 @@
+package sqlcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// DiskStore implements cache.Cacher, cache.Deleter and cache.Ranger by
+// storing every item in a file of a directory, such as to persist the
+// items of the ristretto backend with WithPersistence. Items are encoded
+// as in dumps, keeping the location of times, and written atomically. Expired items
+// are removed when found, such as by Ristretto.Load, and it isn't bounded
+// otherwise: items are only removed once deleted, so the ristretto
+// backend must be created by NewRistrettoCache for items it evicts to be
+// removed. It's too slow to be used as a cache on its own.
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore returns a store of items in dir, which is created if it
+// doesn't exist.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &DiskStore{dir: dir}, nil
+}
+
+// path returns the path of the file of key.
+func (d *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+// read decodes the file at path, returning nil if it doesn't exist or
+// has expired, in which case it's removed.
+func (d *DiskStore) read(path string) (*dumpEntry, *cache.Item, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var de dumpEntry
+	if err := msgpack.Unmarshal(b, &de); err != nil {
+		return nil, nil, fmt.Errorf("decoding %s failed: %w", path, err)
+	}
+	if !de.ExpiresAt.IsZero() && !time.Now().Before(de.ExpiresAt) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+		return nil, nil, nil
+	}
+
+	item := new(cache.Item)
+	if err := dumpCodec.Unmarshal(de.Item, item); err != nil {
+		return nil, nil, fmt.Errorf("decoding %q failed: %w", de.Key, err)
+	}
+
+	return &de, item, nil
+}
+
+// Get gets an item from disk. Returns pointer to the item, a boolean which
+// represents whether key exists or not and an error.
+func (d *DiskStore) Get(ctx context.Context, key string) (*cache.Item, bool, error) {
+	de, item, err := d.read(d.path(key))
+	if err != nil || de == nil || de.Key != key {
+		return nil, false, err
+	}
+
+	return item, true, nil
+}
+
+// Set writes the item to disk with provided TTL duration.
+func (d *DiskStore) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
+	b, err := dumpCodec.Marshal(item)
+	if err != nil {
+		return err
+	}
+	de := dumpEntry{Key: key, Item: b}
+	if ttl > 0 {
+		de.ExpiresAt = time.Now().Add(ttl)
+	}
+	if b, err = msgpack.Marshal(&de); err != nil {
+		return err
+	}
+
+	// files are written under a temporary name and renamed so that
+	// readers never see them partially written
+	f, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), d.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// Delete implements cache.Deleter.
+func (d *DiskStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Range implements cache.Ranger. Items written meanwhile may not be seen.
+func (d *DiskStore) Range(ctx context.Context, fn func(e cache.Entry) error) error {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range entries {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		de, item, err := d.read(filepath.Join(d.dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		if de == nil {
+			continue
+		}
+		e := cache.Entry{Key: de.Key, Item: item}
+		if !de.ExpiresAt.IsZero() {
+			if e.TTL = time.Until(de.ExpiresAt); e.TTL <= 0 {
+				continue
+			}
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
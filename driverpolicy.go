@@ -0,0 +1,113 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/ngrok/sqlmw"
+)
+
+// DriverPolicy is the caching policy of queries run through a driver
+// wrapped by Interceptor.DriverWithPolicy, so that the drivers of a
+// primary/replica routing setup, sharing one interceptor and cache, can be
+// cached differently; for example, with longer TTLs on replicas and no
+// caching on the primary.
+type DriverPolicy struct {
+	// Name identifies the driver in Event.Driver and Stats.DriverMisses.
+	// It's required.
+	Name string
+	// NoCache runs queries through the driver without the cache; they're
+	// skipped with SkipDriverNoCache.
+	NoCache bool
+	// TTLScale, when positive, scales the @cache-ttl of queries run
+	// through the driver. TTL overrides aren't scaled.
+	TTLScale float64
+	// InstanceKey, when set, overrides Config.InstanceKey for queries run
+	// through the driver, such as when the drivers of an interceptor
+	// connect to different databases.
+	InstanceKey string
+}
+
+// driverPolicy is a DriverPolicy along with the stats of its driver.
+type driverPolicy struct {
+	DriverPolicy
+	misses uint64
+}
+
+// policyInterceptor intercepts queries run through a driver wrapped by
+// DriverWithPolicy; all other calls are handled by the interceptor.
+type policyInterceptor struct {
+	*Interceptor
+	dp *driverPolicy
+}
+
+// DriverWithPolicy is Driver with queries run through the returned driver
+// cached as per policy. Drivers wrapped by Driver and DriverWithPolicy
+// share the interceptor's cache, stats and runtime options.
+func (i *Interceptor) DriverWithPolicy(d driver.Driver, policy DriverPolicy) (driver.Driver, error) {
+	if policy.Name == "" {
+		return nil, fmt.Errorf("DriverPolicy.Name must be set")
+	}
+	if policy.TTLScale < 0 {
+		return nil, fmt.Errorf("DriverPolicy.TTLScale must not be negative")
+	}
+
+	dp := &driverPolicy{DriverPolicy: policy}
+	i.driversMu.Lock()
+	i.drivers = append(i.drivers, dp)
+	i.driversMu.Unlock()
+
+	return sqlmw.Driver(d, &policyInterceptor{Interceptor: i, dp: dp}), nil
+}
+
+// StmtQueryContext intecepts database/sql's stmt.QueryContext calls from a prepared statement.
+func (pi *policyInterceptor) StmtQueryContext(ctx context.Context, conn driver.StmtQueryContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	return pi.stmtQueryContext(ctx, pi.dp, conn, query, args)
+}
+
+// ConnPrepareContext intercepts database/sql's PrepareContext calls.
+func (pi *policyInterceptor) ConnPrepareContext(ctx context.Context, conn driver.ConnPrepareContext, query string) (context.Context, driver.Stmt, error) {
+	return pi.connPrepareContext(ctx, pi.dp, conn, query)
+}
+
+// ConnQueryContext intecepts database/sql's DB.QueryContext Conn.QueryContext calls.
+func (pi *policyInterceptor) ConnQueryContext(ctx context.Context, conn driver.QueryerContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	return pi.connQueryContext(ctx, pi.dp, conn, query, args)
+}
+
+// name returns the name of the driver, if it has a policy.
+func (dp *driverPolicy) name() string {
+	if dp == nil {
+		return ""
+	}
+
+	return dp.Name
+}
+
+// scaleTTL scales the TTL of queries run through the driver.
+func (dp *driverPolicy) scaleTTL(ttl time.Duration) time.Duration {
+	if dp == nil || dp.TTLScale <= 0 {
+		return ttl
+	}
+
+	return time.Duration(float64(ttl) * dp.TTLScale)
+}
+
+// driverMisses returns the misses of queries run through drivers with
+// policies, by name.
+func (i *Interceptor) driverMisses(load func(addr *uint64) uint64) map[string]uint64 {
+	i.driversMu.Lock()
+	defer i.driversMu.Unlock()
+
+	if len(i.drivers) == 0 {
+		return nil
+	}
+	misses := make(map[string]uint64, len(i.drivers))
+	for _, dp := range i.drivers {
+		misses[dp.Name] += load(&dp.misses)
+	}
+
+	return misses
+}
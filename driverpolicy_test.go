@@ -0,0 +1,67 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriverWithPolicy(t *testing.T) {
+	assert := require.New(t)
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{Cache: mc})
+	assert.Nil(err)
+	events := ic.Events()
+
+	open := func(name string, policy DriverPolicy) (*sql.DB, sqlmock.Sqlmock) {
+		dsn := fmt.Sprintf("fakeDSN:%s:%s", t.Name(), name)
+		mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+		assert.Nil(err)
+		t.Cleanup(func() { mockDB.Close() })
+
+		d, err := ic.DriverWithPolicy(mockDB.Driver(), policy)
+		assert.Nil(err)
+		driverName := fmt.Sprintf("mockdriver:%s:%s", t.Name(), name)
+		sql.Register(driverName, d)
+		db, err := sql.Open(driverName, dsn)
+		assert.Nil(err)
+		t.Cleanup(func() { db.Close() })
+		return db, qMock
+	}
+	replica, replicaMock := open("replica", DriverPolicy{Name: "replica", TTLScale: 2})
+	primary, primaryMock := open("primary", DriverPolicy{Name: "primary", NoCache: true})
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	runQuery(t, assert, replicaMock, replica, query, true)
+	assert.Len(mc.entries, 1)
+	for _, e := range mc.entries {
+		assert.Equal(time.Minute, e.TTL)
+	}
+	runQuery(t, assert, replicaMock, replica, query, false)
+	runQuery(t, assert, primaryMock, primary, query, true)
+	runQuery(t, assert, primaryMock, primary, query, true)
+
+	s := ic.Stats()
+	assert.Equal(uint64(1), s.Hits)
+	assert.Equal(uint64(2), s.SkipReasons[SkipDriverNoCache])
+	assert.Equal(map[string]uint64{"replica": 1, "primary": 0}, s.DriverMisses)
+
+	ev := <-events
+	assert.Equal(EventMiss, ev.Type)
+	assert.Equal("replica", ev.Driver)
+
+	_, err = ic.DriverWithPolicy(nil, DriverPolicy{})
+	assert.NotNil(err)
+	_, err = ic.DriverWithPolicy(nil, DriverPolicy{Name: "x", TTLScale: -1})
+	assert.NotNil(err)
+}
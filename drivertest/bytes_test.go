@@ -0,0 +1,114 @@
+package drivertest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache"
+	"github.com/prashanthpai/sqlcache/sqlcachetest"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/require"
+)
+
+// openCached opens the database given by the environment variable env with
+// drv wrapped by an interceptor configured by cfg, caching to a new
+// sqlcachetest.Cache unless cfg.Cache is set. The test is skipped if env
+// isn't set.
+func openCached(t *testing.T, env string, drv driver.Driver, cfg sqlcache.Config) (*sql.DB, *sqlcache.Interceptor) {
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		t.Skip(env + " not set")
+	}
+
+	if cfg.Cache == nil {
+		cfg.Cache = sqlcachetest.NewCache(nil)
+	}
+	ic, err := sqlcache.NewInterceptor(&cfg)
+	require.Nil(t, err)
+
+	driverName := "cached:" + t.Name()
+	sql.Register(driverName, ic.Driver(drv))
+	db, err := sql.Open(driverName, dsn)
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db, ic
+}
+
+func TestRecordedBytes(t *testing.T) {
+	tests := []struct {
+		name, env      string
+		drv            driver.Driver
+		create, insert string
+	}{
+		{
+			name:   "pgx",
+			env:    "SQLCACHE_TEST_POSTGRES_DSN",
+			drv:    stdlib.GetDefaultDriver(),
+			create: "CREATE TABLE sqlcache_bytes (id int PRIMARY KEY, data bytea, name text)",
+			insert: "INSERT INTO sqlcache_bytes VALUES ($1, $2, $3)",
+		},
+		{
+			name:   "mysql",
+			env:    "SQLCACHE_TEST_MYSQL_DSN",
+			drv:    &mysql.MySQLDriver{},
+			create: "CREATE TABLE sqlcache_bytes (id int PRIMARY KEY, data blob, name text)",
+			insert: "INSERT INTO sqlcache_bytes VALUES (?, ?, ?)",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			db, ic := openCached(t, tt.env, tt.drv, sqlcache.Config{})
+			_, err := db.Exec("DROP TABLE IF EXISTS sqlcache_bytes")
+			assert.Nil(err)
+			_, err = db.Exec(tt.create)
+			assert.Nil(err)
+			t.Cleanup(func() { _, _ = db.Exec("DROP TABLE sqlcache_bytes") })
+
+			type row struct {
+				id   int
+				data []byte
+				name string
+			}
+			// values of varying lengths, which drivers read into the same
+			// buffer row after row
+			var want []row
+			for n := 1; n <= 100; n++ {
+				r := row{id: n, data: []byte(strings.Repeat(fmt.Sprint(n%10), n)), name: fmt.Sprintf("name-%d", n)}
+				_, err := db.Exec(tt.insert, r.id, r.data, r.name)
+				assert.Nil(err)
+				want = append(want, r)
+			}
+
+			// queried without args, as MySQL then returns all values as
+			// []byte, using its text protocol
+			query := func() []row {
+				rows, err := db.Query(`-- @cache-ttl 30
+				                       -- @cache-max-rows 1000
+				                       SELECT id, data, name FROM sqlcache_bytes ORDER BY id`)
+				assert.Nil(err)
+				defer rows.Close()
+				var got []row
+				for rows.Next() {
+					var r row
+					assert.Nil(rows.Scan(&r.id, &r.data, &r.name))
+					got = append(got, r)
+				}
+				assert.Nil(rows.Err())
+				return got
+			}
+			assert.Equal(want, query())
+			assert.Equal(want, query())
+			assert.Equal(uint64(1), ic.Stats().Hits)
+		})
+	}
+}
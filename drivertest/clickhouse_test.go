@@ -0,0 +1,56 @@
+package drivertest
+
+import (
+	"testing"
+
+	"github.com/prashanthpai/sqlcache"
+
+	_ "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClickHouseBlocks(t *testing.T) {
+	assert := require.New(t)
+
+	var skipped []sqlcache.SkipReason
+	db, ic := openCached(t, "SQLCACHE_TEST_CLICKHOUSE_DSN", registeredDriver(t, "clickhouse"), sqlcache.Config{
+		MaxRecordBytes: 1 << 20,
+		OnSkip:         func(key string, reason sqlcache.SkipReason) { skipped = append(skipped, reason) },
+	})
+
+	// results are read from blocks of up to max_block_size rows, which
+	// are smaller than the results
+	query := func(q string, n int) {
+		rows, err := db.Query(q, n)
+		assert.Nil(err)
+		defer rows.Close()
+		var got int
+		for ; rows.Next(); got++ {
+			var number uint64
+			var s string
+			assert.Nil(rows.Scan(&number, &s))
+			assert.Equal(uint64(got), number)
+		}
+		assert.Nil(rows.Err())
+		assert.Equal(n, got)
+	}
+	const numbers = `-- @cache-ttl 30
+	                 -- @cache-max-rows 100000
+	                 SELECT number, toString(number) FROM numbers(?)
+	                 SETTINGS max_block_size = 1000`
+
+	query(numbers, 5000)
+	query(numbers, 5000)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+
+	// results larger than @cache-max-rows or MaxRecordBytes stream through
+	// in full without being cached
+	query(numbers, 200000)
+	query(numbers, 200000)
+	query(`-- @cache-ttl 30
+	       -- @cache-max-rows 1000000
+	       SELECT number, repeat('x', 100) FROM numbers(?)
+	       SETTINGS max_block_size = 1000`, 100000)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+	assert.Equal([]sqlcache.SkipReason{sqlcache.SkipMaxRows, sqlcache.SkipMaxRows, sqlcache.SkipMaxRecordBytes}, skipped)
+}
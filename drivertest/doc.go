@@ -0,0 +1,5 @@
+// Package drivertest tests sqlcache against real database drivers. It's a
+// separate module so that sqlcache doesn't depend on them. Tests of
+// drivers of database servers run against the servers given by
+// environment variables and are skipped otherwise.
+package drivertest
@@ -0,0 +1,92 @@
+package drivertest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache"
+
+	_ "github.com/microsoft/go-mssqldb"
+	_ "github.com/sijms/go-ora/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// registeredDriver returns the driver registered with database/sql as name.
+func registeredDriver(t *testing.T, name string) driver.Driver {
+	db, err := sql.Open(name, "")
+	require.Nil(t, err)
+	defer db.Close()
+
+	return db.Driver()
+}
+
+func TestNamedArgs(t *testing.T) {
+	tests := []struct {
+		name, env, driver string
+		drop, create      string
+		insert, query     string
+	}{
+		{
+			name:   "sqlserver",
+			env:    "SQLCACHE_TEST_SQLSERVER_DSN",
+			driver: "sqlserver",
+			drop:   "DROP TABLE IF EXISTS sqlcache_users",
+			create: "CREATE TABLE sqlcache_users (id int, org varchar(20), name varchar(20))",
+			insert: "INSERT INTO sqlcache_users VALUES (@id, @org, @name)",
+			query:  "SELECT name FROM sqlcache_users WHERE id = @id AND org = @org",
+		},
+		{
+			name:   "oracle",
+			env:    "SQLCACHE_TEST_ORACLE_DSN",
+			driver: "oracle",
+			drop:   "BEGIN EXECUTE IMMEDIATE 'DROP TABLE sqlcache_users'; EXCEPTION WHEN OTHERS THEN NULL; END;",
+			create: "CREATE TABLE sqlcache_users (id NUMBER(10), org VARCHAR2(20), name VARCHAR2(20))",
+			insert: "INSERT INTO sqlcache_users VALUES (:id, :org, :name)",
+			query:  "SELECT name FROM sqlcache_users WHERE id = :id AND org = :org",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			db, ic := openCached(t, tt.env, registeredDriver(t, tt.driver), sqlcache.Config{VerifyDigest: true})
+			_, err := db.Exec(tt.drop)
+			assert.Nil(err)
+			_, err = db.Exec(tt.create)
+			assert.Nil(err)
+			t.Cleanup(func() { _, _ = db.Exec(tt.drop) })
+			for _, u := range []struct {
+				id        int
+				org, name string
+			}{{1, "acme", "John"}, {2, "acme", "Lisa"}} {
+				_, err := db.Exec(tt.insert, sql.Named("id", u.id), sql.Named("org", u.org), sql.Named("name", u.name))
+				assert.Nil(err)
+			}
+
+			query := "-- @cache-ttl 30\n-- @cache-max-rows 10\n" + tt.query
+			lookup := func(args ...interface{}) string {
+				rows, err := db.Query(query, args...)
+				assert.Nil(err)
+				defer rows.Close()
+				var name string
+				for rows.Next() {
+					assert.Nil(rows.Scan(&name))
+				}
+				assert.Nil(rows.Err())
+				return name
+			}
+
+			assert.Equal("John", lookup(sql.Named("id", 1), sql.Named("org", "acme")))
+			// replayed regardless of the order of named args
+			assert.Equal("John", lookup(sql.Named("org", "acme"), sql.Named("id", 1)))
+			assert.Equal(uint64(1), ic.Stats().Hits)
+
+			assert.Equal("Lisa", lookup(sql.Named("org", "acme"), sql.Named("id", 2)))
+			assert.Equal("Lisa", lookup(sql.Named("id", 2), sql.Named("org", "acme")))
+			assert.Equal(uint64(2), ic.Stats().Hits)
+			assert.Equal(uint64(2), ic.Stats().Misses)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package drivertest
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache"
+	"github.com/prashanthpai/sqlcache/sqlcachetest"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteTablesChanged(t *testing.T) {
+	for _, disableTxCaching := range []bool{false, true} {
+		disableTxCaching := disableTxCaching
+		t.Run(fmt.Sprintf("DisableTxCaching=%t", disableTxCaching), func(t *testing.T) {
+			assert := require.New(t)
+
+			ic, err := sqlcache.NewInterceptor(&sqlcache.Config{
+				Cache:             sqlcachetest.NewCache(nil),
+				TrackTableChanges: true,
+				DisableTxCaching:  disableTxCaching,
+			})
+			assert.Nil(err)
+
+			driverName := "sqlite3-cached:" + t.Name()
+			sql.Register(driverName, ic.Driver(&sqlite3.SQLiteDriver{
+				ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+					conn.RegisterUpdateHook(ic.SQLiteUpdateHook(conn))
+					return nil
+				},
+			}))
+			db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db"))
+			assert.Nil(err)
+			defer db.Close()
+
+			_, err = db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+			assert.Nil(err)
+			_, err = db.Exec("INSERT INTO users VALUES (1, 'John')")
+			assert.Nil(err)
+
+			name := func() string {
+				rows, err := db.Query(`-- @cache-ttl 30
+				                       -- @cache-max-rows 10
+				                       SELECT name FROM users WHERE id = ?`, 1)
+				assert.Nil(err)
+				defer rows.Close()
+				var name string
+				for rows.Next() {
+					assert.Nil(rows.Scan(&name))
+				}
+				assert.Nil(rows.Err())
+				return name
+			}
+			assert.Equal("John", name())
+			assert.Equal("John", name())
+			assert.Equal(uint64(1), ic.Stats().Hits)
+
+			_, err = db.Exec("UPDATE users SET name = 'Lisa' WHERE id = 1")
+			assert.Nil(err)
+			assert.Equal("Lisa", name())
+			assert.Equal("Lisa", name())
+
+			// results read by other conns before the transaction commits
+			// aren't served afterwards
+			tx, err := db.Begin()
+			assert.Nil(err)
+			_, err = tx.Exec("UPDATE users SET name = 'Mary' WHERE id = 1")
+			assert.Nil(err)
+			assert.Equal("Lisa", name())
+			_, err = tx.Exec("UPDATE users SET name = 'Anna' WHERE id = 1")
+			assert.Nil(err)
+			assert.Equal("Lisa", name())
+			assert.Nil(tx.Commit())
+			assert.Equal("Anna", name())
+
+			// changes rolled back are only invalidated at once
+			hits := ic.Stats().Hits
+			tx, err = db.Begin()
+			assert.Nil(err)
+			_, err = tx.Exec("UPDATE users SET name = 'Mary' WHERE id = 1")
+			assert.Nil(err)
+			assert.Nil(tx.Rollback())
+			assert.Equal("Anna", name())
+			assert.Equal("Anna", name())
+			assert.Equal(hits+1, ic.Stats().Hits)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package sqlcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// dryRunSweepInterval is how often expired entries are removed from the
+// entries tracked in dry-run mode.
+const dryRunSweepInterval = time.Second
+
+// DryRunStats contains statistics of dry-run mode; see Config.DryRun.
+type DryRunStats struct {
+	// Hits counts queries that would have been served from cache had the
+	// results of earlier queries been written to it.
+	Hits uint64
+	// Sets counts query results that would have been written to cache.
+	Sets uint64
+	// Bytes is the encoded size, as measured by Config.Codec, of the
+	// results that would be in cache now.
+	Bytes uint64
+}
+
+// dryRunTracker tracks the keys and sizes of results that would have been
+// cached, without their rows.
+type dryRunTracker struct {
+	hits uint64
+	sets uint64
+
+	mu        sync.Mutex
+	now       func() time.Time
+	entries   map[string]dryRunEntry
+	bytes     uint64
+	lastSweep time.Time
+}
+
+type dryRunEntry struct {
+	expiresAt time.Time // zero if it doesn't expire
+	size      uint64
+}
+
+func newDryRunTracker(now func() time.Time) *dryRunTracker {
+	return &dryRunTracker{
+		now:       now,
+		entries:   make(map[string]dryRunEntry),
+		lastSweep: now(),
+	}
+}
+
+// lookup accounts for a lookup of key.
+func (d *dryRunTracker) lookup(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.entries[key]
+	if !ok {
+		return
+	}
+	if !e.expiresAt.IsZero() && !d.now().Before(e.expiresAt) {
+		d.remove(key, e)
+		return
+	}
+	atomic.AddUint64(&d.hits, 1)
+}
+
+// record accounts for results of size bytes that would have been cached
+// under key for ttl.
+func (d *dryRunTracker) record(key string, size int, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.entries[key]; ok {
+		d.remove(key, e)
+	}
+	e := dryRunEntry{size: uint64(size)}
+	if ttl > 0 {
+		e.expiresAt = d.now().Add(ttl)
+	}
+	d.entries[key] = e
+	d.bytes += e.size
+	atomic.AddUint64(&d.sets, 1)
+	d.sweep(false)
+}
+
+// liveBytes returns the size of the results that haven't expired.
+func (d *dryRunTracker) liveBytes() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(true)
+	return d.bytes
+}
+
+// sweep removes expired entries, at most once per dryRunSweepInterval
+// unless forced. d.mu must be held.
+func (d *dryRunTracker) sweep(force bool) {
+	now := d.now()
+	if !force && now.Sub(d.lastSweep) < dryRunSweepInterval {
+		return
+	}
+	d.lastSweep = now
+	for key, e := range d.entries {
+		if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+			d.remove(key, e)
+		}
+	}
+}
+
+func (d *dryRunTracker) remove(key string, e dryRunEntry) {
+	delete(d.entries, key)
+	d.bytes -= e.size
+}
+
+// recordDryRun accounts for the item that would have been written to
+// cache in dry-run mode.
+func (i *Interceptor) recordDryRun(ctx context.Context, q *queryInfo, item *cache.Item, ttl time.Duration) {
+	b, err := i.codec.Marshal(item)
+	if err != nil {
+		i.reportErr(ctx, q, &Error{Kind: ErrEncode, Op: "Codec.Marshal", Key: q.key, Err: err})
+		return
+	}
+	if max := q.maxItemBytes(i.options()); max > 0 && len(b) > max {
+		i.skip(ctx, q, SkipMaxBytes)
+		return
+	}
+	i.dryRun.record(q.key, len(b), ttl)
+}
@@ -0,0 +1,100 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	backend := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{
+		Cache:  backend,
+		Clock:  clock,
+		DryRun: true,
+	})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	q := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	queryRow := func(name string) string {
+		qMock.ExpectQuery("SELECT name").WithArgs(18).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(name))
+		var got string
+		assert.Nil(db.QueryRow(q, 18).Scan(&got))
+		return got
+	}
+	query := func(name string) string {
+		qMock.ExpectQuery("SELECT name").WithArgs(18).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(name))
+		rows, err := db.Query(q, 18)
+		assert.Nil(err)
+		defer rows.Close()
+		var got string
+		for rows.Next() {
+			assert.Nil(rows.Scan(&got))
+		}
+		return got
+	}
+
+	assert.Equal("John", query("John"))
+	s := ic.Stats()
+	assert.Empty(backend.entries)
+	assert.Equal(uint64(1), s.Misses)
+	assert.Equal(uint64(1), s.DryRun.Sets)
+	assert.Zero(s.DryRun.Hits)
+	assert.NotZero(s.DryRun.Bytes)
+
+	// results are always fresh
+	clock.Advance(10 * time.Second)
+	assert.Equal("Lisa", query("Lisa"))
+	s = ic.Stats()
+	assert.Equal(uint64(1), s.DryRun.Hits)
+	assert.Equal(uint64(2), s.DryRun.Sets)
+
+	// even on hits in the backend
+	key, err := ic.Key(q, 18)
+	assert.Nil(err)
+	backend.entries[key] = cache.Entry{Key: key, Item: &cache.Item{
+		Cols: []string{"name"}, Rows: [][]driver.Value{{"Cached"}},
+	}}
+	assert.Equal("Mary", queryRow("Mary"))
+	assert.Nil(qMock.ExpectationsWereMet())
+	prev := ic.Stats()
+	assert.Equal(uint64(1), prev.Hits)
+	assert.Equal(uint64(2), prev.DryRun.Hits)
+
+	// results would have expired
+	clock.Advance(30 * time.Second)
+	s = ic.Stats()
+	assert.Zero(s.DryRun.Bytes)
+	assert.Equal(&DryRunStats{}, s.Delta(prev).DryRun)
+	assert.Len(backend.entries, 1)
+
+	// dry-run stats are only reported in dry-run mode
+	ic, err = NewInterceptor(&Config{Cache: backend})
+	assert.Nil(err)
+	assert.Nil(ic.Stats().DryRun)
+}
@@ -0,0 +1,155 @@
+package sqlcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const (
+	dumpMagic = "sqlcache-dump"
+	// dumpVersion is 2 since items are encoded with zoned times, which
+	// version 1 dumps don't have.
+	dumpVersion = 2
+
+	restoreBatchSize = 100
+)
+
+// dumpHeader starts a dump, followed by dumpEntry records till the end.
+type dumpHeader struct {
+	_msgpack  struct{} `msgpack:",asArray"`
+	Magic     string
+	Version   int
+	CreatedAt time.Time
+}
+
+type dumpEntry struct {
+	_msgpack struct{} `msgpack:",asArray"`
+	Key      string
+	// ExpiresAt is zero for items that don't expire, so that the time a
+	// dump spends in transit counts against the TTL of its items.
+	ExpiresAt time.Time
+	// Item is encoded with dumpCodec irrespective of the codec of the
+	// backend dumped, so that dumps can be restored into any backend.
+	Item []byte
+}
+
+// dumpCodec encodes the items of dumps.
+var dumpCodec = MsgpackCodec{ZonedTimes: true}
+
+// Dump writes all items of the backend, which must implement cache.Ranger,
+// to w in a format read by Restore, and returns the number of items
+// written. Use it to migrate cached results between backends or to warm a
+// new redis cluster before switching over to it.
+func Dump(ctx context.Context, c cache.Cacher, w io.Writer) (int, error) {
+	ranger, ok := cache.As[cache.Ranger](c)
+	if !ok {
+		return 0, errors.New("sqlcache: backend can't enumerate its items")
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := msgpack.NewEncoder(bw)
+	now := time.Now()
+	if err := enc.Encode(&dumpHeader{Magic: dumpMagic, Version: dumpVersion, CreatedAt: now}); err != nil {
+		return 0, err
+	}
+
+	var n int
+	err := ranger.Range(ctx, func(e cache.Entry) error {
+		b, err := dumpCodec.Marshal(e.Item)
+		if err != nil {
+			return fmt.Errorf("encoding %q failed: %w", e.Key, err)
+		}
+		de := dumpEntry{Key: e.Key, Item: b}
+		if e.TTL > 0 {
+			de.ExpiresAt = now.Add(e.TTL)
+		}
+		if err := enc.Encode(&de); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	return n, bw.Flush()
+}
+
+// Restore writes the items dumped by Dump from r into the backend and
+// returns the number of items written. Items that have expired since are
+// skipped. Items are written in batches when the backend implements
+// cache.BatchCacher.
+func Restore(ctx context.Context, c cache.Cacher, r io.Reader) (int, error) {
+	dec := msgpack.NewDecoder(bufio.NewReader(r))
+	var hdr dumpHeader
+	if err := dec.Decode(&hdr); err != nil || hdr.Magic != dumpMagic {
+		return 0, errors.New("sqlcache: not a dump")
+	}
+	if hdr.Version < 1 || hdr.Version > dumpVersion {
+		return 0, fmt.Errorf("sqlcache: unsupported dump version %d", hdr.Version)
+	}
+
+	var (
+		n     int
+		batch []cache.Entry
+	)
+	bc, _ := cache.As[cache.BatchCacher](c)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := bc.SetMulti(ctx, batch); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		var de dumpEntry
+		err := dec.Decode(&de)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+
+		var ttl time.Duration
+		if !de.ExpiresAt.IsZero() {
+			if ttl = time.Until(de.ExpiresAt); ttl <= 0 {
+				continue
+			}
+		}
+		item := new(cache.Item)
+		if err := dumpCodec.Unmarshal(de.Item, item); err != nil {
+			return n, fmt.Errorf("decoding %q failed: %w", de.Key, err)
+		}
+
+		if bc == nil {
+			if err := c.Set(ctx, de.Key, item, ttl); err != nil {
+				return n, err
+			}
+			n++
+			continue
+		}
+		batch = append(batch, cache.Entry{Key: de.Key, Item: item, TTL: ttl})
+		if len(batch) == restoreBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, flush()
+}
@@ -0,0 +1,115 @@
+package sqlcache
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// mapCacher is a cache.Ranger of a fixed set of entries.
+type mapCacher struct {
+	entries map[string]cache.Entry
+}
+
+func (m *mapCacher) Get(ctx context.Context, key string) (*cache.Item, bool, error) {
+	e, ok := m.entries[key]
+	return e.Item, ok, nil
+}
+
+func (m *mapCacher) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
+	m.entries[key] = cache.Entry{Key: key, Item: item, TTL: ttl}
+	return nil
+}
+
+func (m *mapCacher) Range(ctx context.Context, fn func(e cache.Entry) error) error {
+	for _, e := range m.entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestDumpRestore(t *testing.T) {
+	assert := require.New(t)
+
+	created := time.Unix(1700000000, 0).UTC()
+	src := &mapCacher{entries: map[string]cache.Entry{
+		"a": {Key: "a", TTL: time.Hour, Item: &cache.Item{
+			Cols:        []string{"name", "born"},
+			CreatedAt:   created,
+			Fingerprint: "f",
+			Rows:        [][]driver.Value{{"John", created}, {"Lisa", nil}},
+		}},
+		"b": {Key: "b", Item: &cache.Item{Cols: []string{"n"}}},
+	}}
+
+	var buf bytes.Buffer
+	n, err := Dump(context.Background(), src, &buf)
+	assert.Nil(err)
+	assert.Equal(2, n)
+
+	dst := &mapCacher{entries: make(map[string]cache.Entry)}
+	n, err = Restore(context.Background(), dst, bytes.NewReader(buf.Bytes()))
+	assert.Nil(err)
+	assert.Equal(2, n)
+
+	a := dst.entries["a"]
+	assert.InDelta(time.Hour, a.TTL, float64(time.Minute))
+	want := src.entries["a"].Item
+	assert.True(want.CreatedAt.Equal(a.Item.CreatedAt))
+	assert.Equal(want.Fingerprint, a.Item.Fingerprint)
+	assert.Equal(want.Cols, a.Item.Cols)
+	assert.Equal(want.Rows, a.Item.Rows)
+	assert.Equal(time.Duration(0), dst.entries["b"].TTL)
+	assert.Equal([]string{"n"}, dst.entries["b"].Item.Cols)
+
+	// expired items aren't restored
+	src.entries["a"] = cache.Entry{Key: "a", TTL: time.Nanosecond, Item: src.entries["a"].Item}
+	buf.Reset()
+	_, err = Dump(context.Background(), src, &buf)
+	assert.Nil(err)
+	time.Sleep(time.Millisecond)
+	dst = &mapCacher{entries: make(map[string]cache.Entry)}
+	n, err = Restore(context.Background(), dst, &buf)
+	assert.Nil(err)
+	assert.Equal(1, n)
+	assert.Contains(dst.entries, "b")
+
+	_, err = Dump(context.Background(), new(mocks.Cacher), &buf)
+	assert.NotNil(err)
+	_, err = Restore(context.Background(), dst, strings.NewReader("garbage"))
+	assert.NotNil(err)
+}
+
+func TestRestoreVersion1(t *testing.T) {
+	assert := require.New(t)
+
+	// version 1 dumps encode items without zoned times
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	assert.Nil(enc.Encode(&dumpHeader{Magic: dumpMagic, Version: 1, CreatedAt: time.Now()}))
+	b, err := MsgpackCodec{}.Marshal(&cache.Item{Cols: []string{"n"}, Rows: [][]driver.Value{{int64(1)}}})
+	assert.Nil(err)
+	assert.Nil(enc.Encode(&dumpEntry{Key: "a", Item: b}))
+
+	dst := &mapCacher{entries: make(map[string]cache.Entry)}
+	n, err := Restore(context.Background(), dst, &buf)
+	assert.Nil(err)
+	assert.Equal(1, n)
+	assert.Equal([][]driver.Value{{int64(1)}}, dst.entries["a"].Item.Rows)
+
+	buf.Reset()
+	assert.Nil(msgpack.NewEncoder(&buf).Encode(&dumpHeader{Magic: dumpMagic, Version: dumpVersion + 1}))
+	_, err = Restore(context.Background(), dst, &buf)
+	assert.NotNil(err)
+}
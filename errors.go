@@ -0,0 +1,67 @@
+package sqlcache
+
+import "errors"
+
+// Kinds of errors reported to Config.OnError. The reported errors are of
+// type *Error and match their kind with errors.Is, for example:
+//
+//	if errors.Is(err, sqlcache.ErrCacheGet) { ... }
+//
+// Results not being cached aren't errors; see SkipReason and Config.OnSkip.
+var (
+	// ErrCacheGet is the kind of errors looking up the cache backend.
+	ErrCacheGet = errors.New("sqlcache: cache lookup failed")
+	// ErrCacheSet is the kind of errors writing to the cache backend.
+	ErrCacheSet = errors.New("sqlcache: cache write failed")
+	// ErrCacheDelete is the kind of errors deleting from the cache
+	// backend.
+	ErrCacheDelete = errors.New("sqlcache: cache delete failed")
+	// ErrHash is the kind of errors returned by Config.HashFunc.
+	ErrHash = errors.New("sqlcache: hashing query failed")
+	// ErrEncode is the kind of errors encoding items with Config.Codec.
+	ErrEncode = errors.New("sqlcache: encoding item failed")
+	// ErrDecode is the kind of errors decoding cached rows as they're read.
+	// Errors of lookups that found items they couldn't decode, of kind
+	// ErrCacheGet, match it too.
+	ErrDecode = errors.New("sqlcache: decoding cached rows failed")
+	// ErrLock is the kind of errors acquiring or releasing a cache.Locker
+	// lock.
+	ErrLock = errors.New("sqlcache: cache lock failed")
+	// ErrBackendStats is the kind of errors fetching backend stats.
+	ErrBackendStats = errors.New("sqlcache: fetching backend stats failed")
+	// ErrPanic is the kind of panics recovered from user callbacks such as
+	// Config.HashFunc, Config.OnError, Config.OnSkip and Logger. Errors
+	// of other kinds caused by a panic, such as ErrHash, match it too.
+	ErrPanic = errors.New("sqlcache: user callback panicked")
+	// ErrParse is the kind of errors returned by Config.Parser.
+	ErrParse = errors.New("sqlcache: parsing query failed")
+	// ErrExport is the kind of errors exporting stats, such as to statsd.
+	ErrExport = errors.New("sqlcache: exporting stats failed")
+)
+
+// Error is an error reported to Config.OnError. It wraps the underlying
+// error and matches its Kind with errors.Is.
+type Error struct {
+	// Kind is one of the Err* kinds of errors above.
+	Kind error
+	// Op is the failed operation, such as "Cache.Get".
+	Op string
+	// Key is the cache key of the query, if known.
+	Key string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Op + " failed: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of the error.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
@@ -0,0 +1,24 @@
+package sqlcache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	assert := require.New(t)
+
+	cause := errors.New("connection refused")
+	var err error = &Error{Kind: ErrCacheGet, Op: "Cache.Get", Key: "k", Err: cause}
+
+	assert.Equal("Cache.Get failed: connection refused", err.Error())
+	assert.True(errors.Is(err, ErrCacheGet))
+	assert.False(errors.Is(err, ErrCacheSet))
+	assert.True(errors.Is(err, cause))
+
+	var e *Error
+	assert.True(errors.As(err, &e))
+	assert.Equal("k", e.Key)
+}
@@ -0,0 +1,103 @@
+package sqlcache
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultEventBufferSize = 1024
+
+// EventType is the type of an Event.
+type EventType string
+
+// Types of events emitted by the interceptor.
+const (
+	// EventHit is emitted when a query is served from cache.
+	EventHit EventType = "hit"
+	// EventMiss is emitted when a query isn't found in cache.
+	EventMiss EventType = "miss"
+	// EventSet is emitted when query results are written to cache.
+	EventSet EventType = "set"
+	// EventSkip is emitted when query results aren't cached.
+	EventSkip EventType = "skip"
+	// EventError is emitted when a cache operation or HashFunc fails.
+	EventError EventType = "error"
+	// EventInvalidate is emitted when the interceptor removes an entry, or
+	// all entries of a query, from cache.
+	EventInvalidate EventType = "invalidate"
+	// EventUnhealthy is emitted when the cache backend is bypassed after
+	// repeated failures, with the last error; see Config.HealthCheck.
+	EventUnhealthy EventType = "unhealthy"
+	// EventHealthy is emitted when a bypassed cache backend is used again.
+	EventHealthy EventType = "healthy"
+)
+
+// Event describes something that happened in the interceptor. Fields that
+// aren't relevant to the event type are left at their zero values.
+type Event struct {
+	Type        EventType
+	Time        time.Time
+	Fingerprint string
+	Key         string
+	// Driver is the name of the DriverPolicy of the driver the query was
+	// run through, if any.
+	Driver string
+	// Duration is the latency of the backend operation for hit, miss and
+	// set events.
+	Duration time.Duration
+	// Rows is the number of rows cached, for set events.
+	Rows int
+	// TTL is the TTL of the cache entry, for set events.
+	TTL time.Duration
+	// Reason is why results weren't cached, for skip events.
+	Reason SkipReason
+	// Err is the error, for error events.
+	Err error
+}
+
+// eventStream is a bounded buffer of events which drops the oldest event
+// when full.
+type eventStream struct {
+	mu sync.Mutex
+	ch chan Event
+}
+
+// Events returns a channel of events emitted by the interceptor, which
+// enables building custom telemetry pipelines and live debugging tools
+// without polling. Events are only emitted once Events has been called.
+// The channel is buffered (see Config.EventBufferSize) and when it's full,
+// the oldest events are dropped to make room for new ones so that a slow
+// consumer never blocks queries. All calls return the same channel.
+func (i *Interceptor) Events() <-chan Event {
+	i.eventsOnce.Do(func() {
+		i.events.Store(&eventStream{
+			ch: make(chan Event, i.eventBufSize),
+		})
+	})
+
+	return i.events.Load().(*eventStream).ch
+}
+
+func (i *Interceptor) emit(e Event) {
+	es, ok := i.events.Load().(*eventStream)
+	if !ok {
+		return
+	}
+
+	e.Time = i.clock.Now()
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for {
+		select {
+		case es.ch <- e:
+			return
+		default:
+		}
+		// drop the oldest event to make room
+		select {
+		case <-es.ch:
+		default:
+		}
+	}
+}
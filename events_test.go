@@ -0,0 +1,74 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, 30*time.Second).Return(nil)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:           mCacher,
+		EventBufferSize: 3,
+	})
+
+	// no events are emitted until subscribed
+	ic.emit(Event{Type: EventHit})
+
+	events := ic.Events()
+	assert.True(events == ic.Events())
+	assert.Equal(3, cap(events))
+	assert.Len(events, 0)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	runQuery(t, assert, qMock, db, query, true)
+
+	e := <-events
+	assert.Equal(EventMiss, e.Type)
+	assert.Equal(fingerprint(query), e.Fingerprint)
+	assert.NotEmpty(e.Key)
+	assert.False(e.Time.IsZero())
+
+	e = <-events
+	assert.Equal(EventSet, e.Type)
+	assert.Equal(2, e.Rows)
+	assert.Equal(30*time.Second, e.TTL)
+
+	// oldest events are dropped when the buffer is full
+	for _, r := range []SkipReason{SkipMaxRows, SkipMaxBytes, SkipIncomplete, SkipInTx} {
+		ic.skip(nil, &queryInfo{}, r)
+	}
+	assert.Len(events, 3)
+	for _, r := range []SkipReason{SkipMaxBytes, SkipIncomplete, SkipInTx} {
+		e = <-events
+		assert.Equal(EventSkip, e.Type)
+		assert.Equal(r, e.Reason)
+	}
+}
@@ -0,0 +1,119 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultExplainPrefix = "EXPLAIN "
+	// maxExplainedQueries bounds the number of query plans retained.
+	maxExplainedQueries = 1000
+	// maxPlanBytes bounds the size of a single retained query plan.
+	maxPlanBytes = 4096
+)
+
+// planStore retains query plans captured in explain mode by fingerprint.
+type planStore struct {
+	mu    sync.RWMutex
+	plans map[string]string
+}
+
+// attempt reports whether the plan of the query with the fingerprint
+// should be captured, reserving the slot if so.
+func (ps *planStore) attempt(fp string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := ps.plans[fp]; ok || len(ps.plans) >= maxExplainedQueries {
+		return false
+	}
+	if ps.plans == nil {
+		ps.plans = make(map[string]string)
+	}
+	ps.plans[fp] = ""
+
+	return true
+}
+
+func (ps *planStore) set(fp, plan string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.plans[fp] = plan
+}
+
+func (ps *planStore) get(fp string) string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	return ps.plans[fp]
+}
+
+// QueryPlan returns the plan of the query with the fingerprint, as captured
+// when Config.Explain is set. The boolean returned is false when no plan
+// has been captured.
+func (i *Interceptor) QueryPlan(fingerprint string) (string, bool) {
+	plan := i.plans.get(fingerprint)
+	return plan, plan != ""
+}
+
+// capturePlan runs EXPLAIN for the query, the first time it's seen, on the
+// connection the query is about to run on.
+func (i *Interceptor) capturePlan(ctx context.Context, q *queryInfo, conn driver.QueryerContext, args []driver.NamedValue) {
+	if !i.plans.attempt(q.fingerprint) {
+		return
+	}
+
+	plan, err := explain(ctx, conn, i.explainPrefix+q.query, args)
+	if err != nil {
+		i.log(ctx, LevelWarn, "sqlcache: capturing query plan failed",
+			"fingerprint", q.fingerprint, "error", err)
+		return
+	}
+
+	i.plans.set(q.fingerprint, plan)
+}
+
+// explain runs the explain query and formats the rows returned into text,
+// one line per row with columns separated by " | ".
+func explain(ctx context.Context, conn driver.QueryerContext, query string, args []driver.NamedValue) (string, error) {
+	rows, err := conn.QueryContext(ctx, query, args)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	dest := make([]driver.Value, len(rows.Columns()))
+	cols := make([]string, len(dest))
+	for b.Len() < maxPlanBytes {
+		if err := rows.Next(dest); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", err
+		}
+		for n, v := range dest {
+			if bs, ok := v.([]byte); ok {
+				v = string(bs)
+			}
+			cols[n] = fmt.Sprint(v)
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(strings.Join(cols, " | "))
+	}
+
+	plan := b.String()
+	if len(plan) > maxPlanBytes {
+		plan = plan[:maxPlanBytes]
+	}
+
+	return plan, nil
+}
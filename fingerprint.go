@@ -0,0 +1,11 @@
+package sqlcache
+
+import (
+	fp "github.com/prashanthpai/sqlcache/fingerprint"
+)
+
+// fingerprint returns an identifier of the query text which is insensitive
+// to differences in whitespace; see fingerprint.Of.
+func fingerprint(query string) string {
+	return fp.Of(query)
+}
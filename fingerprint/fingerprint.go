@@ -0,0 +1,122 @@
+// Package fingerprint identifies and normalizes query text the way sqlcache
+// does, so that applications can correlate their own logs and metrics with
+// sqlcache's per-fingerprint stats, events and overrides:
+//
+//	log.Printf("fingerprint=%s query took %s", fingerprint.Of(query), d)
+package fingerprint
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/prashanthpai/sqlcache/internal/sqlscan"
+)
+
+// Of returns the fingerprint of the query, the identifier of its text which
+// is insensitive to differences in whitespace. It's what sqlcache reports
+// as the fingerprint of queries and expects in Config.TTLOverrides and
+// similar per-query options.
+func Of(query string) string {
+	h := fnv.New64a()
+	for i, field := range strings.Fields(query) {
+		if i > 0 {
+			h.Write([]byte{' '})
+		}
+		h.Write([]byte(field))
+	}
+
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// keywords are lowercased by Normalize. Identifiers aren't, as they're
+// case sensitive in some databases.
+var keywords = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, kw := range strings.Fields(`
+		all and any as asc between by case cross current desc distinct else end
+		except exists false fetch first for from full group having ilike in
+		inner intersect into is join lateral left like limit natural next not
+		null nulls offset on only or order outer over partition right row rows
+		select set some then true union using values when where window with`) {
+		m[kw] = true
+	}
+	return m
+}()
+
+// Normalize returns the query with comments stripped, runs of whitespace
+// collapsed into a single space and keywords lowercased, leaving quoted
+// strings and identifiers intact, so that formatting differences don't
+// matter. Strings and identifiers are found as sqlcache finds them, so
+// that, for instance, the text of PostgreSQL's E'...' and Oracle's
+// q'[...]' strings and T-SQL's [...] identifiers is copied through as is.
+// It's the text sqlcache hashes into cache keys when Config.NormalizeQuery
+// is set.
+func Normalize(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	space := false
+	emit := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+
+	comments, literals, _ := sqlscan.Scan(query, false)
+	// next returns the start of the next comment or literal, which words
+	// don't extend into, as in nq'[...]'.
+	next := func() int {
+		switch {
+		case len(comments) > 0 && (len(literals) == 0 || comments[0].Start < literals[0].Start):
+			return comments[0].Start
+		case len(literals) > 0:
+			return literals[0].Start
+		}
+		return len(query)
+	}
+
+	for n := 0; n < len(query); {
+		c := query[n]
+		switch {
+		case len(comments) > 0 && n == comments[0].Start:
+			n = comments[0].End
+			comments = comments[1:]
+			space = true
+		case len(literals) > 0 && n == literals[0].Start:
+			emit(query[n:literals[0].End])
+			n = literals[0].End
+			literals = literals[1:]
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+			n++
+		case isIdentStart(c):
+			end, stop := n+1, next()
+			for end < stop && isIdentPart(query[end]) {
+				end++
+			}
+			word := query[n:end]
+			if lower := strings.ToLower(word); keywords[lower] {
+				word = lower
+			}
+			emit(word)
+			n = end
+		default:
+			emit(query[n : n+1])
+			n++
+		}
+	}
+
+	return b.String()
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c < unicode.MaxASCII && unicode.IsLetter(rune(c))
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '$'
+}
@@ -0,0 +1,45 @@
+package fingerprint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOf(t *testing.T) {
+	assert := require.New(t)
+
+	fp := Of("SELECT name FROM users WHERE age > ?")
+	assert.Equal(fp, Of("  SELECT name\n\tFROM users   WHERE age > ?\n"))
+	assert.NotEqual(fp, Of("select name from users where age > ?"))
+	assert.NotEqual(fp, Of("SELECT name FROM users WHERE age < ?"))
+	// pins fingerprints, which users may have configured overrides for
+	assert.Equal("199e7bca63ea84f2", Of("SELECT 1"))
+}
+
+func TestNormalize(t *testing.T) {
+	assert := require.New(t)
+
+	tests := map[string]string{
+		"SELECT name FROM users WHERE age > ?":           "select name from users where age > ?",
+		"-- @cache-ttl 30\nSELECT 1 /* inline */ FROM t": "select 1 from t",
+		"SELECT 'it''s  -- not a comment' FROM Users":    "select 'it''s  -- not a comment' from Users",
+		"SELECT x FROM t WHERE a = E'\\' -- foo'":        "select x from t where a = E'\\' -- foo'",
+		"SELECT nq'[it's  SELECT]' FROM dual":            "select nq'[it's  SELECT]' from dual",
+		"SELECT [Order  Date] FROM [Select  Orders]":     "select [Order  Date] from [Select  Orders]",
+		"SELECT 1 /* outer /* nested */ */ FROM t":       "select 1 from t",
+	}
+	for query, want := range tests {
+		assert.Equal(want, Normalize(query), query)
+	}
+
+	// text within literals isn't normalized, so that queries differing
+	// only there don't share a cache key
+	for _, queries := range [][2]string{
+		{"SELECT x FROM t WHERE a = E'\\' -- foo'", "SELECT x FROM t WHERE a = E'\\' -- bar'"},
+		{"SELECT q'[it's  SELECT]' FROM dual", "SELECT q'[it's select]' FROM dual"},
+		{"SELECT [a  b] FROM t", "SELECT [a b] FROM t"},
+	} {
+		assert.NotEqual(Normalize(queries[0]), Normalize(queries[1]), queries[0])
+	}
+}
@@ -0,0 +1,143 @@
+package sqlcache
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxFingerprints bounds the number of fingerprints remembered for
+// Interceptor.Fingerprints; queries first seen beyond it aren't listed.
+const maxFingerprints = 10000
+
+// FingerprintInfo describes a query with cache attributes seen by the
+// interceptor, identified by its fingerprint.
+type FingerprintInfo struct {
+	Fingerprint string
+	// Query is the query text as first seen, normalized as by
+	// Config.NormalizeQuery.
+	Query    string
+	LastSeen time.Time
+	// Stats are the statistics of the query, which are nil unless
+	// Config.MaxTrackedQueries is set and the query is among those
+	// tracked.
+	Stats  *QueryStats
+	Policy FingerprintPolicy
+}
+
+// FingerprintPolicy is how queries of a fingerprint are currently cached,
+// as per their cache attributes and the Options.
+type FingerprintPolicy struct {
+	// TTL is the @cache-ttl of the query, or its TTL override.
+	TTL           time.Duration
+	TTLOverridden bool
+	MaxRows       int
+	// SampleRate is the @cache-sample-rate of the query, or
+	// Options.SampleRate.
+	SampleRate float64
+	// Denied is set when the fingerprint isn't in AllowFingerprints or is
+	// in DenyFingerprints.
+	Denied bool
+	// Write is set when the query isn't a read, such as an INSERT with a
+	// RETURNING clause.
+	Write bool
+	// NonDeterministic is the first non-deterministic function called by
+	// the query, if any.
+	NonDeterministic string
+	// Auto is set when the query has no cache attributes and is cached as
+	// per Config.AutoCache.
+	Auto bool
+	// Invalid is the reason the cache attributes of the query can't be
+	// used, if any.
+	Invalid string
+}
+
+type fingerprintEntry struct {
+	p        *preparedQuery
+	query    string
+	lastSeen int64 // unix nanoseconds
+}
+
+// fingerprintRegistry remembers the queries seen by fingerprint, up to
+// maxFingerprints.
+type fingerprintRegistry struct {
+	m sync.Map // fingerprint -> *fingerprintEntry
+	n int64
+}
+
+func (r *fingerprintRegistry) seen(p *preparedQuery, now time.Time) {
+	if v, ok := r.m.Load(p.fingerprint); ok {
+		atomic.StoreInt64(&v.(*fingerprintEntry).lastSeen, now.UnixNano())
+		return
+	}
+	if atomic.LoadInt64(&r.n) >= maxFingerprints {
+		return
+	}
+
+	e := &fingerprintEntry{p: p, query: normalizeQuery(p.text), lastSeen: now.UnixNano()}
+	if v, loaded := r.m.LoadOrStore(p.fingerprint, e); loaded {
+		atomic.StoreInt64(&v.(*fingerprintEntry).lastSeen, now.UnixNano())
+		return
+	}
+	atomic.AddInt64(&r.n, 1)
+}
+
+// Fingerprints returns the queries with cache attributes seen by the
+// interceptor, up to 10000 of them, ordered by fingerprint, along with
+// their statistics and current caching policy, for admin tools to list
+// without scanning the backend.
+func (i *Interceptor) Fingerprints() []FingerprintInfo {
+	o := i.options()
+	stats := make(map[string]QueryStats)
+	for _, qs := range i.queryStats.snapshot() {
+		stats[qs.Fingerprint] = qs
+	}
+
+	var infos []FingerprintInfo
+	i.fingerprints.m.Range(func(k, v interface{}) bool {
+		e := v.(*fingerprintEntry)
+		fp := k.(string)
+		info := FingerprintInfo{
+			Fingerprint: fp,
+			Query:       e.query,
+			LastSeen:    time.Unix(0, atomic.LoadInt64(&e.lastSeen)),
+			Policy:      i.fingerprintPolicy(e.p, o),
+		}
+		if qs, ok := stats[fp]; ok {
+			info.Stats = &qs
+		}
+		infos = append(infos, info)
+		return true
+	})
+	sort.Slice(infos, func(a, b int) bool {
+		return infos[a].Fingerprint < infos[b].Fingerprint
+	})
+
+	return infos
+}
+
+func (i *Interceptor) fingerprintPolicy(p *preparedQuery, o *options) FingerprintPolicy {
+	attrs := p.attrs
+	pol := FingerprintPolicy{
+		TTL:              time.Duration(attrs.ttl) * time.Second,
+		MaxRows:          attrs.maxRows,
+		SampleRate:       o.SampleRate,
+		Denied:           !o.allowed(p.fingerprint),
+		Write:            p.write,
+		NonDeterministic: i.nonDeterministicCall(p.text),
+		Auto:             attrs.auto,
+	}
+	if ttl, ok := o.TTLOverrides[p.fingerprint]; ok {
+		pol.TTL = ttl
+		pol.TTLOverridden = true
+	}
+	if attrs.sampleRate != nil {
+		pol.SampleRate = *attrs.sampleRate
+	}
+	if err := attrs.validate(o.ZeroTTL); err != nil {
+		pol.Invalid = err.Error()
+	}
+
+	return pol
+}
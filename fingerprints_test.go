@@ -0,0 +1,94 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprints(t *testing.T) {
+	assert := require.New(t)
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	ic, err := NewInterceptor(&Config{
+		Cache:             &mapCacher{entries: make(map[string]cache.Entry)},
+		Clock:             clock,
+		MaxTrackedQueries: 1,
+		SampleRate:        0.5,
+	})
+	assert.Nil(err)
+	assert.Empty(ic.Fingerprints())
+
+	users := `-- @cache-ttl 30
+              -- @cache-max-rows 10
+              SELECT name FROM users WHERE id = ?`
+	books := `-- @cache-ttl 60
+              -- @cache-max-rows 0
+              -- @cache-sample-rate 1
+              SELECT title, NOW() FROM books`
+	run := func(query string) {
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	run(users)
+	clock.Advance(time.Minute)
+	run(books)
+	run("SELECT 1")
+
+	infos := ic.Fingerprints()
+	assert.Len(infos, 2)
+	byFp := make(map[string]FingerprintInfo)
+	for _, info := range infos {
+		byFp[info.Fingerprint] = info
+	}
+	assert.Less(infos[0].Fingerprint, infos[1].Fingerprint)
+
+	u := byFp[fingerprint(users)]
+	assert.Equal("select name from users where id = ?", u.Query)
+	assert.True(clock.Now().Add(-time.Minute).Equal(u.LastSeen))
+	// only the most recent query is tracked
+	assert.Nil(u.Stats)
+	assert.Equal(FingerprintPolicy{TTL: 30 * time.Second, MaxRows: 10, SampleRate: 0.5}, u.Policy)
+
+	b := byFp[fingerprint(books)]
+	assert.True(clock.Now().Equal(b.LastSeen))
+	assert.NotNil(b.Stats)
+	assert.Equal(uint64(1), b.Stats.Misses)
+	assert.Equal(FingerprintPolicy{TTL: time.Minute, SampleRate: 1, NonDeterministic: "NOW"}, b.Policy)
+
+	// policies reflect the current options
+	o := ic.Options()
+	o.DenyFingerprints = []string{u.Fingerprint}
+	o.TTLOverrides = map[string]time.Duration{u.Fingerprint: 0}
+	o.ZeroTTL = ZeroTTLNoExpiry
+	assert.Nil(ic.UpdateOptions(o))
+	invalid := `-- @cache-ttl 0
+                -- @cache-max-rows 0
+                SELECT name FROM authors`
+	run(invalid)
+	for _, info := range ic.Fingerprints() {
+		switch info.Fingerprint {
+		case u.Fingerprint:
+			assert.True(info.Policy.Denied)
+			assert.True(info.Policy.TTLOverridden)
+			assert.Zero(info.Policy.TTL)
+		case b.Fingerprint:
+			assert.Empty(info.Policy.Invalid)
+		default:
+			assert.Equal(fingerprint(invalid), info.Fingerprint)
+			assert.NotEmpty(info.Policy.Invalid)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+var (
+	// fragmentAttrRegexp matches the @cache-fragment-by and @cache-row-key
+	// attributes, which name the column of the results holding the values
+	// of the query's IN list.
+	fragmentAttrRegexp = regexp.MustCompile(`@cache-(fragment-by|row-key) ([A-Za-z0-9_$]+)`)
+	// inListRegexp matches an IN list of placeholders, capturing them.
+	inListRegexp = regexp.MustCompile(`(?i)\bIN\s*\(\s*((?:\?|\$\d+)(?:\s*,\s*(?:\?|\$\d+))*)\s*\)`)
+	// unfragmentableRegexp matches clauses whose results aren't the union
+	// of the results for each value of the IN list.
+	unfragmentableRegexp = regexp.MustCompile(`(?i)\b(?:ORDER\s+BY|GROUP\s+BY|HAVING|LIMIT|OFFSET|FETCH|DISTINCT|UNION|INTERSECT|EXCEPT)\b|\b(?:COUNT|SUM|AVG|MIN|MAX|ARRAY_AGG|STRING_AGG|GROUP_CONCAT|JSON_AGG)\s*\(`)
+)
+
+// getFragmentAttr returns the column named by the @cache-fragment-by or
+// @cache-row-key attribute of the query, lowercase, if any, and whether
+// it's the latter.
+func getFragmentAttr(query string) (col string, rowKey bool, err error) {
+	matches := fragmentAttrRegexp.FindAllStringSubmatch(query, -1)
+	if len(matches) == 0 {
+		return "", false, nil
+	}
+
+	col, rowKey = strings.ToLower(matches[0][2]), matches[0][1] == "row-key"
+	for _, m := range matches[1:] {
+		if m[1] != matches[0][1] {
+			return col, rowKey, errors.New("@cache-fragment-by and @cache-row-key can't be combined")
+		}
+		err = fmt.Errorf("@cache-%s repeated", m[1])
+	}
+
+	return col, rowKey, err
+}
+
+// inList is the IN list of placeholders of a query with the
+// @cache-fragment-by or @cache-row-key attribute, whose results are cached by value of the
+// list so that any combination of values can be served from the results
+// of each.
+type inList struct {
+	// prefix and suffix are the query text around the placeholders.
+	prefix, suffix string
+	// idx is the index in args of the first value of the list, which has
+	// n values.
+	idx, n int
+	// dollar is set for lists of numbered placeholders, such as $1.
+	dollar bool
+	// hashQuery is the text hashed for the results of a single value.
+	hashQuery string
+}
+
+// parseInList returns the IN list of the query, which must have a single
+// one and no clauses such as ORDER BY or LIMIT whose results can't be
+// assembled from the results of each value.
+func parseInList(query string) (*inList, error) {
+	stripped := stripComments(query)
+	if unfragmentableRegexp.MatchString(stripped) {
+		return nil, errors.New("can't be used with ORDER BY, LIMIT, GROUP BY, DISTINCT, aggregates or set operations")
+	}
+	locs := inListRegexp.FindAllStringSubmatchIndex(query, -1)
+	if len(locs) != 1 || len(inListRegexp.FindAllStringIndex(stripped, -1)) != 1 {
+		return nil, errors.New("requires a single IN list of placeholders")
+	}
+
+	loc := locs[0]
+	list := placeholderRegexp.FindAllString(query[loc[2]:loc[3]], -1)
+	l := &inList{
+		prefix: query[:loc[2]],
+		suffix: query[loc[3]:],
+		n:      len(list),
+		dollar: list[0] != "?",
+	}
+	others := placeholderRegexp.FindAllString(stripComments(l.prefix+" "+l.suffix), -1)
+	if !l.dollar {
+		for _, ph := range append(list, others...) {
+			if ph != "?" {
+				return nil, errors.New("requires placeholders of a single style")
+			}
+		}
+		l.idx = strings.Count(stripComments(l.prefix), "?")
+		return l, nil
+	}
+
+	first, _ := strconv.Atoi(list[0][1:])
+	for n, ph := range list {
+		if ph != "$"+strconv.Itoa(first+n) {
+			return nil, errors.New("requires the placeholders of the IN list to be numbered in sequence")
+		}
+	}
+	for _, ph := range others {
+		ord, err := strconv.Atoi(strings.TrimPrefix(ph, "$"))
+		if err != nil || (ord >= first && ord < first+l.n) {
+			return nil, errors.New("requires placeholders of the IN list to be used only there")
+		}
+	}
+	l.idx = first - 1
+
+	return l, nil
+}
+
+// rewrite returns the query with an IN list of n placeholders, renumbering
+// those after the list.
+func (l *inList) rewrite(n int) string {
+	phs := make([]string, n)
+	for j := range phs {
+		phs[j] = "?"
+		if l.dollar {
+			phs[j] = "$" + strconv.Itoa(l.idx+1+j)
+		}
+	}
+	list := strings.Join(phs, ", ")
+	if !l.dollar {
+		return l.prefix + list + l.suffix
+	}
+
+	renumber := func(s string) string {
+		return placeholderRegexp.ReplaceAllStringFunc(s, func(ph string) string {
+			ord, _ := strconv.Atoi(ph[1:])
+			if ord > l.idx+l.n {
+				ord += n - l.n
+			}
+			return "$" + strconv.Itoa(ord)
+		})
+	}
+
+	return renumber(l.prefix) + list + renumber(l.suffix)
+}
+
+// values returns the args of the IN list. The boolean returned is false
+// when there are too few args.
+func (l *inList) values(args []driver.NamedValue) ([]driver.NamedValue, bool) {
+	if len(args) < l.idx+l.n {
+		return nil, false
+	}
+
+	return args[l.idx : l.idx+l.n], true
+}
+
+// args returns the args of the query rewritten for the values.
+func (l *inList) args(args []driver.NamedValue, values []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, 0, len(args)-l.n+len(values))
+	out = append(out, args[:l.idx]...)
+	for j, v := range values {
+		out = append(out, driver.NamedValue{Ordinal: l.idx + 1 + j, Value: v})
+	}
+	for _, arg := range args[l.idx+l.n:] {
+		arg.Ordinal += len(values) - l.n
+		out = append(out, arg)
+	}
+
+	return out
+}
+
+// fragmentID identifies a value of an IN list, or of the column holding
+// it, by its text, irrespective of its type, so that values of args match
+// those of columns read as text, as from drivers using MySQL's text
+// protocol, which returns integers as []byte.
+func fragmentID(v driver.Value) string {
+	switch v := normalizeValue(v).(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		// as stored by databases without a boolean type, such as MySQL
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// fragment is the results of a query for a single value of its IN list.
+type fragment struct {
+	value driver.Value
+	q     *queryInfo
+	item  *cache.Item
+}
+
+// interceptFragments serves a query with the @cache-fragment-by or
+// @cache-row-key attribute by looking up the results of each value of its IN list, and running the
+// query on conn only for the values missing, whose results are then cached
+// by value. The results are returned grouped by value, in the order of the
+// list. Coalescing of misses, Config.LockTimeout and
+// Config.MaxConcurrentMisses don't apply to such queries.
+func (i *Interceptor) interceptFragments(ctx context.Context, q *queryInfo, p *preparedQuery, args, values []driver.NamedValue, conn driver.QueryerContext, ttl time.Duration, overridden bool, o *options, queryFn func() (driver.Rows, error)) (driver.Rows, error) {
+	l := p.in
+	var (
+		frags   []*fragment
+		byID    = make(map[string]*fragment, len(values))
+		missing []*fragment
+		cols    []string
+		start   = time.Now()
+	)
+	for _, v := range values {
+		id := fragmentID(v.Value)
+		if byID[id] != nil {
+			continue
+		}
+		fargs := l.args(args, []driver.Value{v.Value})
+		hash, err := i.callHashFunc(i.hashFunc, l.hashQuery, fargs)
+		if err != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrHash, Op: "HashFunc", Err: err})
+			i.skip(ctx, q, SkipHashError)
+			return queryFn()
+		}
+		f := &fragment{value: v.Value, q: &queryInfo{
+			query:       q.query,
+			fingerprint: q.fingerprint,
+			attrs:       q.attrs,
+			driver:      q.driver,
+			principal:   q.principal,
+			args:        fargs,
+			fragment:    true,
+		}}
+		f.q.key = i.cacheKey(f.q, p, hash)
+		if i.verifyDigest {
+			f.q.digest = queryDigest(l.hashQuery, fargs)
+		}
+		frags = append(frags, f)
+		byID[id] = f
+
+		// lookup failures are reported and treated as misses
+		if rows, _ := i.checkCache(ctx, f.q, o); rows != nil {
+			if f.item, err = readRows(rows, false); err != nil {
+				f.item = nil
+			}
+		}
+		switch {
+		case f.item == nil:
+			missing = append(missing, f)
+		case cols == nil:
+			cols = f.item.Cols
+		case !reflect.DeepEqual(cols, f.item.Cols):
+			// cached before the columns changed
+			f.item = nil
+			missing = append(missing, f)
+		}
+	}
+
+	// the lookups of the fragments count as a single one
+	if len(missing) == 0 {
+		atomic.AddUint64(&i.stats.Hits, 1)
+		i.queryStats.recordHit(q, time.Since(start))
+		return newRowsCached(ctx, assemble(cols, frags), false), nil
+	}
+
+	i.countMiss(q)
+
+	mvalues := make([]driver.Value, len(missing))
+	for j, f := range missing {
+		mvalues[j] = f.value
+	}
+	start = time.Now()
+	rows, err := conn.QueryContext(ctx, l.rewrite(len(missing)), l.args(args, mvalues))
+	if err != nil {
+		return nil, err
+	}
+	fetched, err := readRows(rows, i.utcTimes)
+	if err != nil {
+		return nil, err
+	}
+	i.queryStats.recordMiss(q, time.Since(start))
+	if cols != nil && !reflect.DeepEqual(cols, fetched.Cols) {
+		// the columns changed since values were cached
+		return queryFn()
+	}
+
+	col := -1
+	for n, c := range fetched.Cols {
+		if strings.EqualFold(c, q.attrs.fragmentBy) {
+			col = n
+		}
+	}
+	matched := col >= 0
+	seen := make(map[*fragment]bool, len(missing))
+	for _, row := range fetched.Rows {
+		if !matched {
+			break
+		}
+		// rows must be told apart by the values missing, with a row
+		// per value for @cache-row-key
+		f := byID[fragmentID(row[col])]
+		matched = f != nil && f.item == nil && !(q.attrs.rowKey && seen[f])
+		seen[f] = true
+	}
+	if !matched {
+		i.skip(ctx, q, SkipFragmentMismatch)
+		item := assemble(fetched.Cols, frags)
+		item.Rows = append(item.Rows, fetched.Rows...)
+		return newRowsCached(ctx, item, false), nil
+	}
+
+	for _, f := range missing {
+		f.item = &cache.Item{Cols: fetched.Cols}
+	}
+	for _, row := range fetched.Rows {
+		f := byID[fragmentID(row[col])]
+		f.item.Rows = append(f.item.Rows, row)
+	}
+	for _, f := range missing {
+		i.setFragment(ctx, f, ttl, overridden, o)
+	}
+
+	return newRowsCached(ctx, assemble(fetched.Cols, frags), false), nil
+}
+
+// setFragment caches the results of a single value of an IN list.
+func (i *Interceptor) setFragment(ctx context.Context, f *fragment, ttl time.Duration, overridden bool, o *options) {
+	q := f.q
+	if max := q.attrs.maxRows; max > 0 && len(f.item.Rows) > max {
+		i.skip(ctx, q, SkipMaxRows)
+		return
+	}
+	if len(f.item.Rows) == 0 && o.DisableNegativeCaching {
+		i.skip(ctx, q, SkipEmpty)
+		return
+	}
+
+	item := &cache.Item{
+		Cols:        f.item.Cols,
+		CreatedAt:   i.clock.Now(),
+		Fingerprint: q.fingerprint,
+		Digest:      q.digest,
+		Rows:        f.item.Rows,
+	}
+	item, itemTTL, ok := i.itemToCache(ctx, q, item, ttl, overridden, o)
+	if !ok {
+		return
+	}
+	if i.quota != nil {
+		q.tenant = i.tenant(ctx, q)
+	}
+	i.setCache(ctx, q, item, itemTTL)
+}
+
+// assemble returns the results of the fragments, in order, with the
+// columns.
+func assemble(cols []string, frags []*fragment) *cache.Item {
+	item := &cache.Item{Cols: cols}
+	for _, f := range frags {
+		if f.item != nil {
+			item.Rows = append(item.Rows, f.item.Rows...)
+		}
+	}
+
+	return item
+}
+
+// readRows reads the rows to the end and closes them, returning them as an
+// item. Byte slices are copied, as drivers may reuse them, and times are
+// converted to UTC if utc is set.
+func readRows(rows driver.Rows, utc bool) (*cache.Item, error) {
+	item := &cache.Item{Cols: rows.Columns()}
+	dest := make([]driver.Value, len(item.Cols))
+	for {
+		err := rows.Next(dest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		row := make([]driver.Value, len(dest))
+		for n, v := range dest {
+			switch tv := v.(type) {
+			case []byte:
+				if tv != nil {
+					v = append(make([]byte, 0, len(tv)), tv...)
+				}
+			case time.Time:
+				if utc {
+					v = tv.UTC()
+				}
+			}
+			row[n] = v
+		}
+		item.Rows = append(item.Rows, row)
+	}
+
+	return item, rows.Close()
+}
+
+// InvalidateRows removes the cached results of a query with the
+// @cache-fragment-by or @cache-row-key attribute for each value of its IN
+// list in args, such as after the rows of those ids were updated, leaving
+// the results of other values cached. Args are converted as by Inspect,
+// and results of queries with @cache-per-principal are removed for the
+// principal of ctx. It returns the number of values whose results were
+// removed from the backend, which must implement cache.Deleter.
+//
+// Results in the L1 cache of other processes expire within Config.L1TTL.
+func (i *Interceptor) InvalidateRows(ctx context.Context, query string, args ...interface{}) (int, error) {
+	deleter, ok := cache.As[cache.Deleter](i.cacher())
+	if !ok {
+		return 0, errors.New("sqlcache: backend doesn't delete keys")
+	}
+	p := i.prepare(query)
+	if p.in == nil {
+		if p.attrs != nil && p.attrs.err != nil {
+			return 0, p.attrs.err
+		}
+		return 0, errors.New("sqlcache: query has no @cache-fragment-by or @cache-row-key attribute")
+	}
+	nvs, err := namedValues(args)
+	if err != nil {
+		return 0, err
+	}
+	values, ok := p.in.values(nvs)
+	if !ok {
+		return 0, fmt.Errorf("sqlcache: query takes at least %d args", p.in.idx+p.in.n)
+	}
+
+	q := &queryInfo{query: query, fingerprint: p.fingerprint, attrs: p.attrs}
+	if p.attrs.perPrincipal && i.principal != nil {
+		if q.principal = i.callPrincipal(ctx, q); q.principal == "" {
+			return 0, nil
+		}
+	}
+	seen := make(map[string]bool, len(values))
+	var n int
+	for _, v := range values {
+		hash, err := i.callHashFunc(i.hashFunc, p.in.hashQuery, p.in.args(nvs, []driver.Value{v.Value}))
+		if err != nil {
+			return n, &Error{Kind: ErrHash, Op: "HashFunc", Err: err}
+		}
+		key := i.cacheKey(q, p, hash)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if i.l1 != nil {
+			i.l1.delete(key)
+		}
+		if err := deleter.Delete(ctx, key); err != nil {
+			return n, &Error{Kind: ErrCacheDelete, Op: "Cache.Delete", Key: key, Err: err}
+		}
+		i.quota.release(key)
+		i.emit(Event{Type: EventInvalidate, Fingerprint: p.fingerprint, Key: key})
+		n++
+	}
+
+	return n, nil
+}
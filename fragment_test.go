@@ -0,0 +1,235 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/dgraph-io/ristretto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInList(t *testing.T) {
+	assert := require.New(t)
+
+	l, err := parseInList("SELECT * FROM users WHERE org = ? AND id IN (?, ?,?) AND active = ?")
+	assert.Nil(err)
+	assert.Equal(1, l.idx)
+	assert.Equal(3, l.n)
+	assert.Equal("SELECT * FROM users WHERE org = ? AND id IN (?) AND active = ?", l.rewrite(1))
+	args := []driver.NamedValue{
+		{Ordinal: 1, Value: "acme"},
+		{Ordinal: 2, Value: 1}, {Ordinal: 3, Value: 2}, {Ordinal: 4, Value: 3},
+		{Ordinal: 5, Value: true},
+	}
+	values, ok := l.values(args)
+	assert.True(ok)
+	assert.Equal(args[1:4], values)
+	assert.Equal([]driver.NamedValue{
+		{Ordinal: 1, Value: "acme"},
+		{Ordinal: 2, Value: 3},
+		{Ordinal: 3, Value: true},
+	}, l.args(args, []driver.Value{3}))
+	_, ok = l.values(args[:3])
+	assert.False(ok)
+
+	// numbered placeholders after the list are renumbered
+	l, err = parseInList("SELECT * FROM users WHERE org = $4 AND id IN ($1, $2, $3)")
+	assert.Nil(err)
+	assert.Equal(0, l.idx)
+	assert.Equal("SELECT * FROM users WHERE org = $3 AND id IN ($1, $2)", l.rewrite(2))
+
+	for _, query := range []string{
+		"SELECT * FROM users WHERE id IN (?, ?) ORDER BY id",
+		"SELECT * FROM users WHERE id IN (?, ?) LIMIT 10",
+		"SELECT org, COUNT(*) FROM users WHERE id IN (?, ?)",
+		"SELECT * FROM users WHERE id IN (?) AND org IN (?)",
+		"SELECT * FROM users WHERE id = ?",
+		"SELECT * FROM users WHERE id IN ($1, $3) AND org = $2",
+		"SELECT * FROM users WHERE id IN ($1, $2) AND org = ?",
+	} {
+		_, err := parseInList(query)
+		assert.NotNil(err, query)
+	}
+}
+
+func TestFragments(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	ic, err := NewInterceptor(&Config{Cache: &mapCacher{entries: make(map[string]cache.Entry)}})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          -- @cache-fragment-by id
+	          SELECT id, name FROM users WHERE id IN (%s)`
+	names := map[int64]string{1: "John", 2: "Lisa", 3: "Mary"}
+	values := func(ids []int64) []interface{} {
+		v := make([]interface{}, len(ids))
+		for n, id := range ids {
+			v[n] = id
+		}
+		return v
+	}
+	driverValues := func(ids []int64) []driver.Value {
+		v := make([]driver.Value, len(ids))
+		for n, id := range ids {
+			v[n] = id
+		}
+		return v
+	}
+	// lookup looks up the users with ids, of which those fetched are
+	// expected to miss the cache.
+	lookup := func(ids []int64, fetched ...int64) {
+		if len(fetched) > 0 {
+			rows := sqlmock.NewRows([]string{"id", "name"})
+			for _, id := range fetched {
+				if name, ok := names[id]; ok {
+					rows.AddRow(id, name)
+				}
+			}
+			qMock.ExpectQuery("SELECT id, name FROM users").
+				WithArgs(driverValues(fetched)...).WillReturnRows(rows)
+		}
+		phs := strings.Repeat(", ?", len(ids))[2:]
+		rows, err := db.QueryContext(context.Background(), fmt.Sprintf(query, phs), values(ids)...)
+		assert.Nil(err)
+		var got []string
+		for rows.Next() {
+			var (
+				id   int64
+				name string
+			)
+			assert.Nil(rows.Scan(&id, &name))
+			got = append(got, name)
+		}
+		assert.Nil(rows.Close())
+		var want []string
+		for _, id := range ids {
+			if name, ok := names[id]; ok {
+				want = append(want, name)
+			}
+		}
+		assert.Equal(want, got)
+		assert.Nil(qMock.ExpectationsWereMet())
+	}
+
+	lookup([]int64{1, 2}, 1, 2)
+	lookup([]int64{2, 1}) // served from fragments
+	lookup([]int64{1, 3, 4}, 3, 4)
+	lookup([]int64{4, 3, 2, 1}) // including the empty results of 4
+	// counted once per query rather than per value
+	assert.Equal(uint64(2), ic.Stats().Hits)
+	assert.Equal(uint64(2), ic.Stats().Misses)
+
+	// values of columns read as text match those of args, as with MySQL's
+	// text protocol
+	qMock.ExpectQuery("SELECT id, name FROM users").WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow([]byte("7"), "Ann"))
+	for n := 0; n < 2; n++ {
+		rows, err := db.QueryContext(context.Background(), fmt.Sprintf(query, "?"), int64(7))
+		assert.Nil(err)
+		assert.True(rows.Next())
+		assert.Nil(rows.Close())
+	}
+	assert.Nil(qMock.ExpectationsWereMet())
+	assert.Equal(uint64(3), ic.Stats().Hits)
+	assert.Equal(uint64(0), ic.Stats().SkipReasons[SkipFragmentMismatch])
+
+	// rows that can't be told apart by value aren't cached
+	qMock.ExpectQuery("SELECT id, name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(6, "Eve"))
+	rows, err := db.QueryContext(context.Background(), fmt.Sprintf(query, "?"), 5)
+	assert.Nil(err)
+	assert.True(rows.Next())
+	assert.Nil(rows.Close())
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipFragmentMismatch])
+}
+
+func TestInvalidateRows(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        1e4,
+		MaxCost:            1 << 30,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+	})
+	assert.Nil(err)
+	defer rc.Close()
+	ic, err := NewInterceptor(&Config{Cache: NewRistretto(rc), L1Size: 10, Quota: &Quota{MaxEntries: 10}})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          -- @cache-row-key id
+	          SELECT id, name FROM users WHERE id IN (?, ?)`
+	lookup := func(rows *sqlmock.Rows, args ...driver.Value) int {
+		if rows != nil {
+			qMock.ExpectQuery("SELECT id, name FROM users").WithArgs(args...).WillReturnRows(rows)
+		}
+		r, err := db.QueryContext(context.Background(), query, 1, 2)
+		assert.Nil(err)
+		var n int
+		for ; r.Next(); n++ {
+		}
+		assert.Nil(r.Close())
+		assert.Nil(qMock.ExpectationsWereMet())
+		rc.Wait()
+		return n
+	}
+
+	assert.Equal(2, lookup(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "John").AddRow(2, "Lisa"), 1, 2))
+	assert.Equal(2, lookup(nil))
+
+	total, _ := ic.QuotaUsage()
+	assert.Equal(2, total.Entries)
+
+	n, err := ic.InvalidateRows(context.Background(), query, 1, 1)
+	assert.Nil(err)
+	assert.Equal(1, n)
+	rc.Wait()
+	// deleted results no longer count against quotas
+	total, _ = ic.QuotaUsage()
+	assert.Equal(1, total.Entries)
+	assert.Equal(2, lookup(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Jane"), 1))
+
+	// values with more than a row aren't row keys
+	_, err = ic.InvalidateRows(context.Background(), query, 1, 2)
+	assert.Nil(err)
+	rc.Wait()
+	assert.Equal(3, lookup(sqlmock.NewRows([]string{"id", "name"}).
+		AddRow(1, "Jane").AddRow(1, "Jim").AddRow(2, "Lisa"), 1, 2))
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipFragmentMismatch])
+
+	_, err = ic.InvalidateRows(context.Background(), "SELECT name FROM users WHERE id IN (?)", 1)
+	assert.NotNil(err)
+}
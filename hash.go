@@ -2,28 +2,119 @@ package sqlcache
 
 import (
 	"database/sql/driver"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
-	"github.com/mitchellh/hashstructure/v2"
+	"github.com/cespare/xxhash/v2"
 )
 
+// defaultHashFunc hashes the query and args normalized by normalizeArgs,
+// so that logically identical calls made from different code paths share a
+// cache entry. Keys are of the format described by KeyFormatVersion.
 func defaultHashFunc(query string, args []driver.NamedValue) (string, error) {
-	u64, err := hashstructure.Hash(struct {
-		Query string
-		Args  []driver.NamedValue
-	}{
-		Query: query,
-		Args:  args,
-	}, hashstructure.FormatV2, nil)
-	if err != nil {
-		return "", err
+	return StrictHash(query, normalizeArgs(args))
+}
+
+// normalizeArgs returns a copy of args with values converted to a
+// canonical form: driver.Valuers are replaced by their values, integers by
+// int64 (or uint64 if too large), float32 by float64, []byte by string and
+// times by their UTC equivalent.
+func normalizeArgs(args []driver.NamedValue) []driver.NamedValue {
+	if len(args) == 0 {
+		return args
+	}
+
+	norm := make([]driver.NamedValue, len(args))
+	for n, arg := range args {
+		arg.Value = normalizeValue(arg.Value)
+		norm[n] = arg
+	}
+
+	return norm
+}
+
+// canonicalArgs returns args in an order independent of that of named
+// args, which drivers bind by name: positional args first, renumbered in
+// order, then named args sorted by name with an ordinal of zero. Args
+// without names are returned as is.
+func canonicalArgs(args []driver.NamedValue) []driver.NamedValue {
+	named := false
+	for _, arg := range args {
+		named = named || arg.Name != ""
+	}
+	if !named {
+		return args
+	}
+
+	canon := make([]driver.NamedValue, 0, len(args))
+	for _, arg := range args {
+		if arg.Name == "" {
+			arg.Ordinal = len(canon) + 1
+			canon = append(canon, arg)
+		}
+	}
+	positional := len(canon)
+	for _, arg := range args {
+		if arg.Name != "" {
+			arg.Ordinal = 0
+			canon = append(canon, arg)
+		}
+	}
+	sort.SliceStable(canon[positional:], func(a, b int) bool {
+		return canon[positional+a].Name < canon[positional+b].Name
+	})
+
+	return canon
+}
+
+func normalizeValue(v interface{}) interface{} {
+	if valuer, ok := v.(driver.Valuer); ok {
+		if val, err := valuer.Value(); err == nil {
+			v = val
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return normalizeUint(uint64(v))
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return normalizeUint(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC()
 	}
 
-	key := fmt.Sprintf("q%da%dh%s", len(query), len(args), strconv.FormatUint(u64, 10))
-	return key, nil
+	return v
+}
+
+func normalizeUint(u uint64) interface{} {
+	if u > math.MaxInt64 {
+		return u
+	}
+	return int64(u)
 }
 
 // NoopHash returns a string representation of the query and args. Whitespaces
@@ -41,3 +132,93 @@ func NoopHash(query string, args []driver.NamedValue) (string, error) {
 
 	return b.String(), nil
 }
+
+// Type tags written before each argument value by XXHash so that values of
+// different types with the same encoding hash differently.
+const (
+	xxTagNil byte = iota
+	xxTagInt64
+	xxTagFloat64
+	xxTagBool
+	xxTagString
+	xxTagBytes
+	xxTagTime
+	xxTagOther
+)
+
+// XXHash hashes the query and args using xxhash. Unlike the default hash
+// function, it doesn't use reflection and doesn't allocate, other than for
+// the returned key, when args are of the types produced by the driver's
+// default parameter converter (nil, int64, float64, bool, string, []byte
+// and time.Time). Times are hashed by instant, ignoring their location.
+// It's considerably faster than the default hash function; see
+// BenchmarkHashFuncs.
+func XXHash(query string, args []driver.NamedValue) (string, error) {
+	return xxSumArgs(xxQueryDigest(query), args), nil
+}
+
+// xxQueryDigest returns the XXHash digest of the query, which is copied
+// and completed with the args by xxSumArgs. Prepared statements hash their
+// query just once this way.
+func xxQueryDigest(query string) xxhash.Digest {
+	var (
+		d   xxhash.Digest
+		buf [9]byte
+	)
+	d.Reset()
+
+	buf[0] = xxTagString
+	binary.LittleEndian.PutUint64(buf[1:], uint64(len(query)))
+	_, _ = d.Write(buf[:])
+	_, _ = d.WriteString(query)
+
+	return d
+}
+
+func xxSumArgs(d xxhash.Digest, args []driver.NamedValue) string {
+	var buf [9]byte
+
+	writeUint := func(tag byte, u uint64) {
+		buf[0] = tag
+		binary.LittleEndian.PutUint64(buf[1:], u)
+		_, _ = d.Write(buf[:])
+	}
+	writeString := func(tag byte, s string) {
+		writeUint(tag, uint64(len(s)))
+		_, _ = d.WriteString(s)
+	}
+
+	for _, arg := range args {
+		writeUint(xxTagInt64, uint64(arg.Ordinal))
+		writeString(xxTagString, arg.Name)
+
+		switch v := arg.Value.(type) {
+		case nil:
+			writeUint(xxTagNil, 0)
+		case int64:
+			writeUint(xxTagInt64, uint64(v))
+		case float64:
+			writeUint(xxTagFloat64, math.Float64bits(v))
+		case bool:
+			var u uint64
+			if v {
+				u = 1
+			}
+			writeUint(xxTagBool, u)
+		case string:
+			writeString(xxTagString, v)
+		case []byte:
+			writeUint(xxTagBytes, uint64(len(v)))
+			_, _ = d.Write(v)
+		case time.Time:
+			writeUint(xxTagTime, uint64(v.Unix()))
+			writeUint(xxTagTime, uint64(v.Nanosecond()))
+		default:
+			writeString(xxTagOther, fmt.Sprintf("%T:%v", v, v))
+		}
+	}
+
+	var key [17]byte
+	key[0] = 'x'
+	return string(strconv.AppendUint(key[:1], d.Sum64(), 16))
+}
@@ -1,9 +1,17 @@
 package sqlcache
 
 import (
+	"context"
+	"database/sql"
 	"database/sql/driver"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -37,3 +45,198 @@ func TestNoopHash(t *testing.T) {
 		assert.Equal(tc.expected, h)
 	}
 }
+
+func TestXXHash(t *testing.T) {
+	assert := require.New(t)
+
+	query := "SELECT name FROM users WHERE age > ?"
+	args := func(v driver.Value) []driver.NamedValue {
+		return []driver.NamedValue{{Ordinal: 1, Value: v}}
+	}
+
+	now := time.Now()
+	values := []driver.Value{nil, int64(1), float64(1), true, false, "1", []byte("1"), now, uint8(1)}
+	seen := make(map[string]driver.Value)
+	for _, v := range values {
+		key, err := XXHash(query, args(v))
+		assert.Nil(err)
+		assert.NotContains(seen, key, "%v collides with %v", v, seen[key])
+		seen[key] = v
+
+		again, _ := XXHash(query, args(v))
+		assert.Equal(key, again)
+	}
+
+	// times are hashed by instant
+	k1, _ := XXHash(query, args(now))
+	k2, _ := XXHash(query, args(now.UTC()))
+	assert.Equal(k1, k2)
+
+	// argument boundaries are unambiguous
+	k1, _ = XXHash(query, []driver.NamedValue{{Ordinal: 1, Value: "ab"}, {Ordinal: 2, Value: "c"}})
+	k2, _ = XXHash(query, []driver.NamedValue{{Ordinal: 1, Value: "a"}, {Ordinal: 2, Value: "bc"}})
+	assert.NotEqual(k1, k2)
+
+	nvs := []driver.NamedValue{
+		{Ordinal: 1, Value: int64(18)},
+		{Ordinal: 2, Value: "John"},
+		{Ordinal: 3, Value: now},
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = XXHash(query, nvs)
+	})
+	assert.LessOrEqual(allocs, float64(1))
+}
+
+func BenchmarkHashFuncs(b *testing.B) {
+	query := `-- @cache-ttl 30
+		-- @cache-max-rows 10
+		SELECT name, pages FROM books WHERE pages > $1 AND author = $2 AND published < $3`
+	args := []driver.NamedValue{
+		{Ordinal: 1, Value: int64(100)},
+		{Ordinal: 2, Value: "John"},
+		{Ordinal: 3, Value: time.Now()},
+	}
+
+	for name, fn := range map[string]func(string, []driver.NamedValue) (string, error){
+		"default": defaultHashFunc,
+		"strict":  StrictHash,
+		"xxhash":  XXHash,
+		"noop":    NoopHash,
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				_, _ = fn(query, args)
+			}
+		})
+	}
+}
+
+// upperValuer is a driver.Valuer of an upper cased string.
+type upperValuer string
+
+func (u upperValuer) Value() (driver.Value, error) {
+	return strings.ToUpper(string(u)), nil
+}
+
+func TestDefaultHashNormalizesArgs(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Now()
+	loc := time.FixedZone("UTC+5", 5*60*60)
+	hash := func(fn func(string, []driver.NamedValue) (string, error), vals ...interface{}) string {
+		args := make([]driver.NamedValue, len(vals))
+		for n, v := range vals {
+			args[n] = driver.NamedValue{Ordinal: n + 1, Value: v}
+		}
+		key, err := fn("SELECT 1", args)
+		assert.Nil(err)
+		return key
+	}
+
+	same := [][2][]interface{}{
+		{{int32(7), uint8(1)}, {int64(7), 1}},
+		{{float32(0.5)}, {0.5}},
+		{{[]byte("abc")}, {"abc"}},
+		{{now.In(loc)}, {now.UTC()}},
+		{{upperValuer("abc")}, {"ABC"}},
+	}
+	for _, tc := range same {
+		assert.Equal(hash(defaultHashFunc, tc[0]...), hash(defaultHashFunc, tc[1]...), tc)
+		assert.NotEqual(hash(StrictHash, tc[0]...), hash(StrictHash, tc[1]...), tc)
+	}
+
+	assert.Equal(hash(defaultHashFunc, uint64(42)), hash(defaultHashFunc, int64(42)))
+	assert.NotEqual(hash(defaultHashFunc, "1"), hash(defaultHashFunc, int64(1)))
+}
+
+func TestCanonicalArgs(t *testing.T) {
+	assert := require.New(t)
+
+	args := []driver.NamedValue{{Ordinal: 1, Value: 1}, {Ordinal: 2, Value: 2}}
+	assert.Equal(args, canonicalArgs(args))
+
+	args = []driver.NamedValue{
+		{Ordinal: 1, Name: "org", Value: "acme"},
+		{Ordinal: 2, Value: 1},
+		{Ordinal: 3, Name: "id", Value: 7},
+		{Ordinal: 4, Value: 2},
+	}
+	assert.Equal([]driver.NamedValue{
+		{Ordinal: 1, Value: 1},
+		{Ordinal: 2, Value: 2},
+		{Name: "id", Value: 7},
+		{Name: "org", Value: "acme"},
+	}, canonicalArgs(args))
+	assert.Equal(3, args[2].Ordinal)
+}
+
+func TestNamedArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		hashFunc func(string, []driver.NamedValue) (string, error)
+		query    string
+	}{
+		{"sqlserver", nil, "SELECT name FROM users WHERE id = @id AND org = @org"},
+		{"oracle", XXHash, "SELECT name FROM users WHERE id = :id AND org = :org"},
+		{"strict", StrictHash, "SELECT name FROM users WHERE id = @id AND org = @org"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+			mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+			assert.Nil(err)
+			defer mockDB.Close()
+
+			ic, err := NewInterceptor(&Config{
+				Cache:        &mapCacher{entries: make(map[string]cache.Entry)},
+				HashFunc:     tc.hashFunc,
+				VerifyDigest: true,
+			})
+			assert.Nil(err)
+
+			driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+			sql.Register(driverName, ic.Driver(mockDB.Driver()))
+			db, err := sql.Open(driverName, dsn)
+			assert.Nil(err)
+			defer db.Close()
+
+			query := "-- @cache-ttl 30\n-- @cache-max-rows 10\n" + tc.query
+			lookup := func(want string, args ...interface{}) {
+				rows, err := db.QueryContext(context.Background(), query, args...)
+				assert.Nil(err)
+				var name string
+				for rows.Next() {
+					assert.Nil(rows.Scan(&name))
+				}
+				assert.Nil(rows.Close())
+				assert.Equal(want, name)
+				assert.Nil(qMock.ExpectationsWereMet())
+			}
+
+			qMock.ExpectQuery("SELECT name").
+				WithArgs(sql.Named("id", 1), sql.Named("org", "acme")).
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+			lookup("John", sql.Named("id", 1), sql.Named("org", "acme"))
+			// replayed regardless of the order of named args
+			lookup("John", sql.Named("org", "acme"), sql.Named("id", 1))
+			assert.Equal(uint64(1), ic.Stats().Hits)
+
+			qMock.ExpectQuery("SELECT name").
+				WithArgs(sql.Named("org", "acme"), sql.Named("id", 2)).
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Lisa"))
+			lookup("Lisa", sql.Named("org", "acme"), sql.Named("id", 2))
+			lookup("Lisa", sql.Named("id", 2), sql.Named("org", "acme"))
+			assert.Equal(uint64(2), ic.Stats().Hits)
+
+			key, err := ic.Key(query, sql.Named("org", "acme"), sql.Named("id", 1))
+			assert.Nil(err)
+			info, ok, err := ic.Inspect(context.Background(), query, sql.Named("id", 1), sql.Named("org", "acme"))
+			assert.Nil(err)
+			assert.True(ok)
+			assert.Equal(key, info.Key)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package sqlcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const (
+	defaultProbeInterval = 5 * time.Second
+	defaultProbeTimeout  = time.Second
+	// probeKey is looked up to probe backends that don't implement
+	// cache.Pinger.
+	probeKey = "sqlcache:probe"
+)
+
+// HealthCheck configures bypassing of the cache backend after repeated
+// failures, such as when Redis is down, so that queries don't each pay for
+// a failed lookup and write. While bypassed, queries go straight to the
+// database and are skipped with SkipUnhealthy, and the backend is probed
+// periodically until it responds, when it's used again. Changes are
+// emitted as EventUnhealthy and EventHealthy events and reported in
+// Stats.Health.
+type HealthCheck struct {
+	// FailureThreshold is the number of consecutive failed lookups and
+	// writes after which the backend is bypassed. Failures due to the
+	// cancellation of a query's context don't count. It's required.
+	FailureThreshold int
+	// ProbeInterval is the time between probes of a bypassed backend.
+	// Defaults to 5s.
+	ProbeInterval time.Duration
+	// ProbeTimeout bounds every probe. Defaults to 1s.
+	ProbeTimeout time.Duration
+	// OnChange, when set, is called with false when the backend is
+	// bypassed and with true when it's used again.
+	OnChange func(healthy bool)
+}
+
+// HealthStats contains statistics of health checking; see
+// Config.HealthCheck.
+type HealthStats struct {
+	// Healthy is false while the backend is bypassed.
+	Healthy bool
+	// Trips counts the times the backend was bypassed.
+	Trips uint64
+	// FailedProbes counts probes of a bypassed backend that failed.
+	FailedProbes uint64
+}
+
+func validateHealthCheck(h *HealthCheck) (*HealthCheck, error) {
+	if h.FailureThreshold <= 0 {
+		return nil, fmt.Errorf("HealthCheck.FailureThreshold must be positive")
+	}
+	if h.ProbeInterval < 0 || h.ProbeTimeout < 0 {
+		return nil, fmt.Errorf("HealthCheck.ProbeInterval and ProbeTimeout must not be negative")
+	}
+
+	cpy := *h
+	if cpy.ProbeInterval == 0 {
+		cpy.ProbeInterval = defaultProbeInterval
+	}
+	if cpy.ProbeTimeout == 0 {
+		cpy.ProbeTimeout = defaultProbeTimeout
+	}
+
+	return &cpy, nil
+}
+
+// healthChecker tracks consecutive backend failures and probes the backend
+// while it's bypassed.
+type healthChecker struct {
+	cfg      *HealthCheck
+	failures int64
+	down     atomic.Bool
+	trips    uint64
+	failed   uint64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newHealthChecker(cfg *HealthCheck) *healthChecker {
+	return &healthChecker{cfg: cfg, stop: make(chan struct{})}
+}
+
+// bypassed reports whether the backend is to be bypassed.
+func (h *healthChecker) bypassed() bool {
+	return h != nil && h.down.Load()
+}
+
+// close stops probing.
+func (h *healthChecker) close() {
+	if h != nil {
+		h.stopOnce.Do(func() { close(h.stop) })
+	}
+}
+
+func (h *healthChecker) stats() *HealthStats {
+	if h == nil {
+		return nil
+	}
+
+	return &HealthStats{
+		Healthy:      !h.down.Load(),
+		Trips:        atomic.LoadUint64(&h.trips),
+		FailedProbes: atomic.LoadUint64(&h.failed),
+	}
+}
+
+// observeHealth accounts for the result of a backend lookup or write,
+// bypassing the backend once FailureThreshold consecutive ones fail.
+func (i *Interceptor) observeHealth(ctx context.Context, err error) {
+	h := i.health
+	if h == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil {
+		atomic.StoreInt64(&h.failures, 0)
+		return
+	}
+	if atomic.AddInt64(&h.failures, 1) < int64(h.cfg.FailureThreshold) || !h.down.CompareAndSwap(false, true) {
+		return
+	}
+
+	atomic.AddUint64(&h.trips, 1)
+	i.log(ctx, LevelWarn, "sqlcache: cache backend unhealthy, bypassing it",
+		"failures", h.cfg.FailureThreshold, "error", err)
+	i.emit(Event{Type: EventUnhealthy, Err: err})
+	i.notifyHealth(ctx, false)
+	go i.probe()
+}
+
+// probe probes the bypassed backend until it responds or the interceptor
+// is closed.
+func (i *Interceptor) probe() {
+	h := i.health
+	for {
+		wait, stop := i.clock.NewTimer(h.cfg.ProbeInterval)
+		select {
+		case <-wait:
+		case <-h.stop:
+			stop()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), h.cfg.ProbeTimeout)
+		err := i.ping(ctx)
+		cancel()
+		if err != nil {
+			atomic.AddUint64(&h.failed, 1)
+			i.log(ctx, LevelDebug, "sqlcache: cache backend probe failed", "error", err)
+			continue
+		}
+
+		atomic.StoreInt64(&h.failures, 0)
+		h.down.Store(false)
+		i.log(ctx, LevelInfo, "sqlcache: cache backend healthy again")
+		i.emit(Event{Type: EventHealthy})
+		i.notifyHealth(ctx, true)
+		return
+	}
+}
+
+// ping checks that the backend responds, using cache.Pinger if
+// implemented or a lookup otherwise.
+func (i *Interceptor) ping(ctx context.Context) error {
+	c := i.cacher()
+	if p, ok := cache.As[cache.Pinger](c); ok {
+		return p.Ping(ctx)
+	}
+	_, _, err := c.Get(ctx, probeKey)
+
+	return err
+}
@@ -0,0 +1,136 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyCacher is a mapCacher whose operations fail while down is set.
+type flakyCacher struct {
+	mu sync.Mutex
+	*mapCacher
+	down atomic.Bool
+}
+
+var errBackendDown = errors.New("backend down")
+
+func (c *flakyCacher) Get(ctx context.Context, key string) (*cache.Item, bool, error) {
+	if c.down.Load() {
+		return nil, false, errBackendDown
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mapCacher.Get(ctx, key)
+}
+
+func (c *flakyCacher) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
+	if c.down.Load() {
+		return errBackendDown
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mapCacher.Set(ctx, key, item, ttl)
+}
+
+func TestHealthCheck(t *testing.T) {
+	assert := require.New(t)
+
+	for _, h := range []*HealthCheck{{}, {FailureThreshold: 1, ProbeInterval: -1}} {
+		_, err := NewInterceptor(&Config{Cache: &mapCacher{entries: make(map[string]cache.Entry)}, HealthCheck: h})
+		assert.NotNil(err)
+	}
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	c := &flakyCacher{mapCacher: &mapCacher{entries: make(map[string]cache.Entry)}}
+	var (
+		mu      sync.Mutex
+		changes []bool
+	)
+	ic, err := NewInterceptor(&Config{
+		Cache: c,
+		Clock: clock,
+		HealthCheck: &HealthCheck{
+			FailureThreshold: 3,
+			ProbeInterval:    time.Second,
+			OnChange: func(healthy bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				changes = append(changes, healthy)
+			},
+		},
+	})
+	assert.Nil(err)
+	defer ic.Close(context.Background())
+	events := ic.Events()
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users`
+	run := func() {
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	// successes reset the count of consecutive failures
+	c.down.Store(true)
+	run()
+	c.down.Store(false)
+	run()
+	assert.True(ic.Stats().Health.Healthy)
+
+	// the lookup and write of the first query and the lookup of the
+	// second fail
+	c.down.Store(true)
+	run()
+	run()
+	s := ic.Stats()
+	assert.False(s.Health.Healthy)
+	assert.Equal(uint64(1), s.Health.Trips)
+	run()
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipUnhealthy])
+
+	// the backend is probed until it responds
+	waitTimer := func() {
+		for clock.Timers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitTimer()
+	clock.Advance(time.Second)
+	waitTimer()
+	assert.Equal(uint64(1), ic.Stats().Health.FailedProbes)
+	assert.False(ic.Stats().Health.Healthy)
+
+	c.down.Store(false)
+	clock.Advance(time.Second)
+	assert.Eventually(func() bool { return ic.Stats().Health.Healthy }, time.Second, time.Millisecond)
+	run()
+	// served from the results cached before the backend went down
+	assert.Equal(uint64(1), ic.Stats().Hits)
+
+	mu.Lock()
+	assert.Equal([]bool{false, true}, changes)
+	mu.Unlock()
+	var types []EventType
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventUnhealthy || e.Type == EventHealthy {
+			types = append(types, e.Type)
+		}
+	}
+	assert.Equal([]EventType{EventUnhealthy, EventHealthy}, types)
+}
@@ -0,0 +1,98 @@
+package sqlcache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// HitInfo describes how the cache served a query; see WithHitInfo.
+type HitInfo struct {
+	// Hit is set when the results were served from cache.
+	Hit bool
+	// Coalesced is set when the results were those of a concurrent
+	// identical query; see Config.DisableCoalescing.
+	Coalesced bool
+	// Memoized is set when the results were served from a request memo;
+	// see WithRequestMemo.
+	Memoized bool
+	// Stale is set when the results were cached past their TTL; see
+	// Config.StaleBudget.
+	Stale bool
+	// Key is the cache key of the query, if it was computed.
+	Key string
+	// Fingerprint identifies the query text.
+	Fingerprint string
+	// Age is the time elapsed since the results were cached, for hits and
+	// coalesced results.
+	Age time.Duration
+	// SkipReason is why the results weren't served from or written to
+	// cache, if so. Reasons found as rows are read, such as SkipMaxRows,
+	// are only set once the rows are closed.
+	SkipReason SkipReason
+}
+
+type hitInfoKey struct{}
+
+// hitInfoRecorder records the HitInfo of the last query run with a
+// context.
+type hitInfoRecorder struct {
+	mu   sync.Mutex
+	info HitInfo
+}
+
+// WithHitInfo returns a context that records how the cache serves queries
+// run with it, and a function returning the HitInfo of the last of them,
+// so that hits and misses can be observed at the call site:
+//
+//	ctx, hitInfo := sqlcache.WithHitInfo(ctx)
+//	rows, err := db.QueryContext(ctx, query, args...)
+//	...
+//	rows.Close()
+//	log.Printf("hit=%t age=%s", hitInfo().Hit, hitInfo().Age)
+//
+// Use a context per query to tell queries apart.
+func WithHitInfo(ctx context.Context) (context.Context, func() HitInfo) {
+	r := new(hitInfoRecorder)
+
+	return context.WithValue(ctx, hitInfoKey{}, r), func() HitInfo {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.info
+	}
+}
+
+// recordHitInfo updates the HitInfo of the query run with ctx, if
+// recorded.
+func recordHitInfo(ctx context.Context, fn func(h *HitInfo)) {
+	if ctx == nil {
+		return
+	}
+	r, ok := ctx.Value(hitInfoKey{}).(*hitInfoRecorder)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	fn(&r.info)
+	r.mu.Unlock()
+}
+
+// beginHitInfo starts recording the HitInfo of a query run with ctx.
+func beginHitInfo(ctx context.Context, p *preparedQuery) {
+	recordHitInfo(ctx, func(h *HitInfo) {
+		*h = HitInfo{Fingerprint: p.fingerprint}
+	})
+}
+
+// servedHitInfo records that the query was served with the results of
+// item.
+func (i *Interceptor) servedHitInfo(ctx context.Context, q *queryInfo, item *cache.Item, coalesced bool) {
+	recordHitInfo(ctx, func(h *HitInfo) {
+		h.Hit, h.Coalesced = !coalesced, coalesced
+		h.Key = q.key
+		h.Age = i.clock.Now().Sub(item.CreatedAt)
+	})
+}
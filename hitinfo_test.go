@@ -0,0 +1,76 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHitInfo(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{Cache: mc, Clock: clock})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	run := func(query string) HitInfo {
+		ctx, hitInfo := WithHitInfo(context.Background())
+		rows, err := db.QueryContext(ctx, query, 18)
+		assert.Nil(err)
+		for rows.Next() {
+		}
+		assert.Nil(rows.Close())
+		return hitInfo()
+	}
+
+	query := `-- @cache-max-rows 2
+	          -- @cache-ttl 30
+	          SELECT name FROM users WHERE age > ?`
+	qMock.ExpectQuery("SELECT name").WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	miss := run(query)
+	key, err := ic.Key(query, 18)
+	assert.Nil(err)
+	assert.Equal(HitInfo{Key: key, Fingerprint: fingerprint(query)}, miss)
+
+	clock.Advance(10 * time.Second)
+	hit := run(query)
+	assert.Equal(HitInfo{Hit: true, Key: key, Fingerprint: fingerprint(query), Age: 10 * time.Second}, hit)
+
+	// reasons results weren't cached are known once rows are closed
+	other := `-- @cache-max-rows 1
+	          -- @cache-ttl 30
+	          SELECT name FROM accounts WHERE age > ?`
+	qMock.ExpectQuery("SELECT name").WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John").AddRow("Lisa"))
+	info := run(other)
+	assert.False(info.Hit)
+	assert.Equal(SkipMaxRows, info.SkipReason)
+
+	qMock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	ctx, hitInfo := WithHitInfo(context.Background())
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	assert.Nil(err)
+	assert.Nil(rows.Close())
+	assert.Equal(SkipNoAttributes, hitInfo().SkipReason)
+	assert.Nil(qMock.ExpectationsWereMet())
+}
@@ -0,0 +1,144 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// ItemInfo describes a cached query result.
+type ItemInfo struct {
+	// Key is the cache key of the item.
+	Key string
+	// Fingerprint identifies the query text the item holds results of.
+	Fingerprint string
+	// CreatedAt is the time at which the query results were recorded.
+	CreatedAt time.Time
+	// Age is the time elapsed since CreatedAt.
+	Age time.Duration
+	// TTL is the time left before the item expires, which is zero if it
+	// doesn't. It's negative when the backend doesn't implement
+	// cache.TTLReporter.
+	TTL time.Duration
+	// StaleAt is when the results stop being fresh, before the item
+	// expires, when they're kept to be served stale; see StaleBudget.
+	StaleAt time.Time
+	// Hits is the number of hits served from the item. Always zero unless
+	// Config.CountHits is set.
+	Hits uint64
+	// Rows is the number of rows in the cached result.
+	Rows int
+	// Plan is the query plan captured when Config.Explain is set.
+	Plan string
+}
+
+// Inspect looks up the cached results of query when run with args and
+// returns information about the cache item. The boolean returned is false
+// when the results aren't cached. Args are converted in the same way as
+// database/sql does before being passed to the driver, so drivers that
+// customise argument conversion may yield keys that don't match. Results
+// of queries with the @cache-per-principal attribute are looked up for the
+// principal of ctx. Inspect doesn't affect stats.
+func (i *Interceptor) Inspect(ctx context.Context, query string, args ...interface{}) (*ItemInfo, bool, error) {
+	key, err := i.Key(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	if attrs := getAttrs(i.queryText(query)); attrs != nil && attrs.perPrincipal && i.principal != nil {
+		principal := i.callPrincipal(ctx, &queryInfo{query: query, key: key})
+		if principal == "" {
+			return nil, false, nil
+		}
+		key = withPrincipal(key, principal)
+	}
+
+	item, ok, err := i.cacher().Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	ttl, ok, err := i.remainingTTL(ctx, key)
+	if err != nil || !ok {
+		// expired meanwhile
+		return nil, false, err
+	}
+
+	return &ItemInfo{
+		Key:         key,
+		Fingerprint: item.Fingerprint,
+		CreatedAt:   item.CreatedAt,
+		Age:         i.clock.Now().Sub(item.CreatedAt),
+		TTL:         ttl,
+		StaleAt:     item.StaleAt,
+		Hits:        atomic.LoadUint64(&item.Hits),
+		Rows:        len(item.Rows),
+		Plan:        i.plans.get(item.Fingerprint),
+	}, true, nil
+}
+
+// Key returns the cache key of query when run with args, as computed by
+// Config.HashFunc with the query normalized as per Config.NormalizeQuery
+// and prefixed with Config.InstanceKey, if set. Keys of queries with the
+// @cache-per-principal attribute are further scoped by the principal they
+// run for, which Key doesn't know; see PrincipalKey. Args are converted as
+// described for Inspect.
+func (i *Interceptor) Key(query string, args ...interface{}) (string, error) {
+	nvs, err := namedValues(args)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := i.hash(i.prepare(query), nvs)
+	if err != nil {
+		return "", &Error{Kind: ErrHash, Op: "HashFunc", Err: err}
+	}
+
+	return withInstance(i.instance, key), nil
+}
+
+// PrincipalKey returns the key of a query with the @cache-per-principal
+// attribute, as returned by Interceptor.Key, scoped by the principal, as
+// returned by Config.Principal, the query runs for.
+func PrincipalKey(key, principal string) string {
+	return withPrincipal(key, principal)
+}
+
+// remainingTTL returns the time left before the item of key expires, as
+// reported by backends implementing cache.TTLReporter, or -1 for others.
+// The boolean returned is false when the item isn't present.
+func (i *Interceptor) remainingTTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	r, ok := cache.As[cache.TTLReporter](i.cacher())
+	if !ok {
+		return -1, true, nil
+	}
+
+	return r.TTL(ctx, key)
+}
+
+// namedValues converts args into driver.NamedValue using the driver's
+// default parameter converter. Args of sql.Named are named as by
+// database/sql.
+func namedValues(args []interface{}) ([]driver.NamedValue, error) {
+	nvs := make([]driver.NamedValue, len(args))
+	for n, arg := range args {
+		var name string
+		if na, ok := arg.(sql.NamedArg); ok {
+			name, arg = na.Name, na.Value
+		}
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("converting argument %d failed: %w", n+1, err)
+		}
+		nvs[n] = driver.NamedValue{
+			Name:    name,
+			Ordinal: n + 1,
+			Value:   v,
+		}
+	}
+
+	return nvs, nil
+}
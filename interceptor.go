@@ -1,9 +1,12 @@
 package sqlcache
 
 import (
+	"bytes"
 	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -11,6 +14,15 @@ import (
 	"github.com/prashanthpai/sqlcache/cache"
 )
 
+// ErrDeadlineTooShort is returned for queries with less than
+// Config.MinLookupBudget left before their deadline when
+// Config.FailFastOnDeadline is set. It wraps context.DeadlineExceeded.
+var ErrDeadlineTooShort = fmt.Errorf("sqlcache: too little time left before deadline: %w", context.DeadlineExceeded)
+
+// ErrTooManyMisses is returned for cache misses beyond
+// Config.MaxConcurrentMisses when Config.MissLimit is MissLimitFail.
+var ErrTooManyMisses = errors.New("sqlcache: too many concurrent misses of query")
+
 // Config is the configuration passed to NewInterceptor for creating new
 // Interceptor instances.
 type Config struct {
@@ -19,23 +31,436 @@ type Config struct {
 	// field and cannot be nil.
 	Cache cache.Cacher
 	// OnError is called whenever methods of cache.Cacher interface or HashFunc
-	// returns error. Since sqlcache package does not log any failures, you can
-	// use this hook to log errors or even choose to disable/bypass sqlcache.
+	// returns error. Since sqlcache package does not log any failures unless
+	// Logger is set, you can use this hook to log errors or even choose to
+	// disable/bypass sqlcache. Errors other than ErrNonDeterministic
+	// warnings are of type *Error and can be told apart with errors.Is
+	// using the Err* kinds, such as ErrCacheGet.
 	OnError func(error)
+	// Logger can be optionally set to receive leveled, structured events
+	// about errors, slow cache operations and skipped queries. Use
+	// NewSlogLogger to log using log/slog.
+	Logger Logger
+	// SlowOpThreshold, when set along with Logger, logs a warning for every
+	// cache backend operation that takes longer than this duration.
+	SlowOpThreshold time.Duration
+	// MaxTrackedQueries, when set to a positive value, enables collection of
+	// per query statistics (see Interceptor.QueryStats) for up to this many
+	// distinct queries. When the limit is reached, statistics of the least
+	// recently used query are evicted.
+	MaxTrackedQueries int
+	// DisableTxCaching disables caching of queries run within
+	// transactions, which may observe uncommitted writes: such queries are
+	// then neither served from nor written to cache.
+	DisableTxCaching bool
 	// HashFunc can be optionally set to provide a custom hashing function. By
-	// default sqlcache uses mitchellh/hashstructure which internally uses FNV.
-	// If hash collision is a concern to you, consider using NoopHash.
+	// default sqlcache hashes queries and args into keys of the stable format
+	// described by KeyFormatVersion, after normalizing args so that, for
+	// example, int32(1) and int64(1) hash alike; StrictHash opts out of
+	// normalization. If hash collision is a concern to you, consider using
+	// NoopHash. For high-QPS services, XXHash is much faster. Named args,
+	// such as of sql.Named, are passed sorted by name after positional
+	// args, so that their order doesn't matter.
 	HashFunc func(query string, args []driver.NamedValue) (string, error)
+	// NormalizeQuery normalizes the query text passed to HashFunc by
+	// stripping comments, collapsing whitespace and lowercasing keywords,
+	// so that formatting differences between call sites don't result in
+	// separate cache entries. Quoted strings and identifiers are left as
+	// is.
+	NormalizeQuery bool
+	// BackslashEscapes is set for databases whose strings delimited by '
+	// or " may escape quotes with backslashes, as in 'O\'Brien', such as
+	// MySQL and MariaDB unless NO_BACKSLASH_ESCAPES is set, so that cache
+	// attributes and statements are found in their queries. Otherwise
+	// backslashes are taken as is, as by the SQL standard, except in
+	// PostgreSQL's E'...' strings.
+	BackslashEscapes bool
+	// InstanceKey, when set, is made part of every cache key so that
+	// processes using different databases, such as staging and production
+	// sharing a Redis, never read each other's entries. It's typically
+	// derived from the DSN using InstanceKeyFromDSN, and can be overridden
+	// per driver by DriverPolicy.InstanceKey.
+	InstanceKey string
+	// VerifyDigest stores a digest of the query and args, computed
+	// independently of HashFunc, with every item and verifies it on hits.
+	// Items of other queries, due to hash collisions or reuse of keys, are
+	// then treated as misses and deleted if the backend implements
+	// cache.Deleter.
+	VerifyDigest bool
+	// CountHits enables counting of hits served from each cache item. The
+	// count is available via Interceptor.Inspect and is only accurate for
+	// in-memory backends such as ristretto which don't serialize items.
+	CountHits bool
+	// MaxItemBytes, when set to a positive value, skips caching of query
+	// results whose encoded size exceeds this many bytes. Number of rows
+	// alone is a poor proxy for the memory cost of an item.
+	MaxItemBytes int
+	// MaxRecordBytes, when set to a positive value, stops recording the
+	// rows of results once their size in memory, as estimated by ItemSize,
+	// exceeds this many bytes; the rest of the rows are passed through
+	// without being copied, and the results aren't cached. Unlike
+	// MaxItemBytes, which is checked once all rows are read, it bounds the
+	// memory held for results that are too large to cache, such as the
+	// millions of rows analytical databases like ClickHouse may return.
+	MaxRecordBytes int
+	// Codec is used to measure the encoded size of items when MaxItemBytes
+	// is set. Defaults to the codec used by the backend if it exposes one
+	// (such as Redis) or MsgpackCodec otherwise.
+	Codec cache.Codec
+	// OnSkip is called whenever the results of a query with cache attributes
+	// aren't cached, along with the cache key and the reason.
+	OnSkip func(key string, reason SkipReason)
+	// OnSet, when set, is called with the text and args of every query
+	// whose results were written to cache, their key and the item as
+	// written, after TransformItem and ColumnProtection, so that
+	// applications can populate their own caches or search indexes from
+	// the same results without running the query again. It's called on
+	// the goroutine writing the item and mustn't modify it.
+	OnSet func(query string, args []driver.NamedValue, key string, item *cache.Item)
+	// Enabler, when set, is called with the context and text of every
+	// query with cache attributes to decide whether to use the cache for
+	// it, so that caching can be turned on for some users, tenants or a
+	// percentage of traffic by a feature flag service. Queries for which
+	// it returns false, or panics, are skipped with SkipNotEnabled. It's
+	// called for every execution and should be fast.
+	Enabler func(ctx context.Context, query string) bool
+	// Principal, when set, returns the authenticated principal, such as
+	// the user or database role, a query is run for, from its context.
+	// Cache entries of queries with the @cache-per-principal attribute,
+	// such as those whose results are filtered by Postgres row-level
+	// security, are then scoped by it so that principals never see each
+	// other's results. Such queries run without a principal, or for which
+	// it panics, are skipped with SkipNoPrincipal.
+	Principal func(ctx context.Context) string
+	// ColumnProtection, when set, masks or encrypts the values of
+	// sensitive columns in cached results; see ColumnProtection.
+	ColumnProtection *ColumnProtection
+	// TransformItem, when set, is called with the text of the query and
+	// its results before they're cached, and returns the item to cache
+	// instead, such as with PII columns redacted or large values
+	// truncated, and whether to cache it at all. It mustn't modify item,
+	// whose rows are returned to the caller, but may return a modified
+	// copy or item itself. Results it returns false or a nil item for, or
+	// panics on, are skipped with SkipTransformVeto.
+	TransformItem func(query string, item *cache.Item) (*cache.Item, bool)
+	// AuditSets, when set along with Logger, logs every write to cache at
+	// LevelInfo with the query fingerprint, key, number of rows, encoded
+	// size in bytes (as measured by Codec) and TTL. Results that can't be
+	// encoded by Codec aren't cached. It has no effect without Logger.
+	AuditSets bool
+	// Explain enables a debug mode in which the query plan is captured the
+	// first time each query misses the cache, to help confirm that the
+	// queries being cached are the expensive ones. Plans are available via
+	// Interceptor.QueryPlan and Interceptor.Inspect. Plans aren't captured
+	// for prepared statements. This adds a round trip to the database and
+	// must not be enabled in production.
+	Explain bool
+	// ExplainPrefix is prepended to queries to get their plan when Explain
+	// is set. Defaults to "EXPLAIN ".
+	ExplainPrefix string
+	// DisableCoalescing disables deduplication of concurrent cache misses.
+	// By default, when many callers miss the cache on the same key at the
+	// same time, only one of them runs the query while the rest wait for
+	// and share its results, for up to CoalesceTimeout.
+	DisableCoalescing bool
+	// CoalesceTimeout bounds how long callers wait for the results of a
+	// concurrent identical query before running the query themselves, as
+	// its caller may be slow to read them or may even be waiting on the
+	// callers, such as by running the query again while reading its
+	// results. Defaults to 1s.
+	CoalesceTimeout time.Duration
+	// LockTimeout enables stampede protection across processes sharing a
+	// backend that implements cache.Locker (such as Redis). On a cache
+	// miss, only the process that acquires the lock on the key runs the
+	// query while the others poll the cache for its results for up to
+	// LockTimeout before running the query themselves. The lock expires
+	// after LockTimeout in case its holder fails to release it.
+	LockTimeout time.Duration
+	// LockPollInterval is how often the cache is polled while waiting for
+	// the lock holder's results. Defaults to 50ms.
+	LockPollInterval time.Duration
+	// AsyncSetWorkers, when set to a positive value, writes query results
+	// to the cache backend asynchronously using this many goroutines so
+	// that slow writes don't add latency to queries. Writes use a context
+	// detached from the query's. Call Interceptor.Shutdown to drain pending
+	// writes before exiting.
+	AsyncSetWorkers int
+	// AsyncSetQueueSize bounds the number of pending asynchronous writes.
+	// When the queue is full, results aren't cached and the skip is
+	// accounted as SkipSetQueueFull. Defaults to 1024.
+	AsyncSetQueueSize int
+	// GetTimeout, when set, bounds the time spent looking up the cache
+	// backend, independently of the query's context. When it expires, the
+	// lookup is treated as failed and the query goes to the database.
+	// Backends implementing cache.StreamGetter must fetch the entire item
+	// within the timeout.
+	GetTimeout time.Duration
+	// SetTimeout, when set, bounds the time spent writing to the cache
+	// backend, independently of the query's context.
+	SetTimeout time.Duration
+	// DetachSets writes results to the cache on a context that carries the
+	// values of the query's context but not its deadline or cancellation,
+	// so that results read in full are still cached when the caller
+	// cancels its context right after consuming the rows. Such writes are
+	// bounded by SetTimeout, or 5 seconds if it isn't set.
+	DetachSets bool
+	// MinLookupBudget, when set, is the least time that must be left before
+	// the query's context deadline for the cache to be used. Queries with
+	// less time left go straight to the database, and their results aren't
+	// cached, instead of spending what's left on a cache round trip.
+	MinLookupBudget time.Duration
+	// FailFastOnDeadline makes queries with less than MinLookupBudget left
+	// before their deadline fail with ErrDeadlineTooShort instead of going
+	// to the database.
+	FailFastOnDeadline bool
+	// MaxConcurrentMisses, when set, limits the number of cache misses of
+	// the same query (by fingerprint, irrespective of arguments) that may
+	// run against the database at once, protecting it when a hot entry
+	// expires. What callers beyond the limit do is set by MissLimit.
+	MaxConcurrentMisses int
+	// MissLimit is the policy for callers beyond MaxConcurrentMisses.
+	// Defaults to MissLimitWait.
+	MissLimit MissLimitPolicy
+	// SetRateLimit limits the rate of writes to the cache backend, so that
+	// a burst of unique queries doesn't saturate it with writes of entries
+	// unlikely to be read again. Results beyond the limit aren't cached.
+	SetRateLimit RateLimit
+	// QuerySetRateLimit limits the rate of writes of the results of each
+	// query, by fingerprint, irrespective of arguments.
+	QuerySetRateLimit RateLimit
+	// CacheWrites allows caching the rows returned by statements with cache
+	// attributes that aren't reads, such as INSERT, UPDATE or DELETE with a
+	// RETURNING clause. By default such statements are run without the
+	// cache and skipped with SkipNotRead, as serving them from cache would
+	// skip the write.
+	CacheWrites bool
+	// DisableNegativeCaching disables caching of results with no rows,
+	// which are then skipped with SkipEmpty. By default, an empty result
+	// is cached like any other, as an item with Cols set and no Rows, and
+	// a hit on it is served as rows that are immediately exhausted.
+	DisableNegativeCaching bool
+	// NegativeTTL, when set, caps the TTL of cached empty results, so that
+	// rows inserted after a lookup found none show up sooner.
+	NegativeTTL time.Duration
+	// DrainOnClose, when set, reads and records the rows left unread when
+	// rows are closed before the end, as by code that stops iterating early
+	// or uses QueryRow, so that their results are cached too. Rows beyond
+	// @cache-max-rows or the budget aren't read and the results aren't
+	// cached.
+	DrainOnClose *DrainBudget
+	// UTCTimes converts time.Time values returned by queries with cache
+	// attributes to UTC, both when recorded on a miss and as returned to
+	// the caller, so that times compare and format identically whether
+	// served from the database or from cache, in any process. Otherwise
+	// times keep the location set by the driver, which codecs with
+	// ZonedTimes set preserve when the location is available in the
+	// process reading them.
+	UTCTimes bool
+	// ZeroTTL is what `@cache-ttl 0` means. Defaults to ZeroTTLSkip.
+	// `@cache-max-rows 0` always means the number of rows isn't limited;
+	// combined with `@cache-ttl 0` under ZeroTTLNoExpiry it's rejected as
+	// invalid and the query isn't cached.
+	ZeroTTL ZeroTTLPolicy
+	// NonDeterministic is the policy for queries with cache attributes that
+	// call functions such as NOW() or RANDOM(), whose results differ on
+	// every execution. Defaults to NonDeterministicAllow.
+	NonDeterministic NonDeterministicPolicy
+	// Retry, when set, retries failed cache backend lookups and writes so
+	// that transient backend errors aren't counted as misses and errors.
+	Retry *RetryPolicy
+	// MinQueryLatency, when set, caches the results of a query only if the
+	// moving average of its execution time, tracked by fingerprint, is at
+	// least this long, so that the cache isn't filled with results of
+	// queries that are about as cheap to run as to look up.
+	MinQueryLatency time.Duration
+	// AdaptiveTTL, when set, scales the TTL of query results by the cost
+	// of recomputing them and how often they're hit.
+	AdaptiveTTL *AdaptiveTTL
+	// L1Size, when set, enables a tiny in-process cache of up to L1Size
+	// items consulted before Cache, saving round trips to a remote backend
+	// for the hottest keys. Items are added on a hit in Cache and kept for
+	// L1TTL, so they may be served for up to L1TTL past their expiry in
+	// Cache. Items of backends implementing cache.StreamGetter are then
+	// decoded in full on a hit, as they must be to be added.
+	L1Size int
+	// L1TTL is how long items are kept in the in-process cache. Defaults
+	// to 2s.
+	L1TTL time.Duration
+	// AllowFingerprints, when not empty, restricts caching to queries with
+	// these fingerprints, as reported by QueryStats and Events.
+	AllowFingerprints []string
+	// DenyFingerprints lists fingerprints of queries whose results aren't
+	// cached, taking precedence over AllowFingerprints. Queries not
+	// allowed are skipped with SkipDenied.
+	DenyFingerprints []string
+	// TTLOverrides replaces the @cache-ttl of queries with the given
+	// fingerprints, regardless of Config.ZeroTTL and Config.AdaptiveTTL.
+	// An override of zero stops caching the query, which is skipped with
+	// SkipZeroTTL. It's meant to be changed with Interceptor.UpdateOptions
+	// to tune or disable caching of a problematic query without a deploy.
+	TTLOverrides map[string]time.Duration
+	// TTLFunc, when set, is called with the text and args of every query
+	// with cache attributes, and the TTL its results would be cached for,
+	// and returns the TTL to cache them for instead, so that results can
+	// be kept for longer or shorter depending on the args, such as for
+	// historical and recent date ranges. A TTL of zero means what
+	// @cache-ttl 0 does. It isn't called for queries with TTLOverrides,
+	// and the TTL is kept as is when it panics.
+	TTLFunc func(query string, args []driver.NamedValue, ttl time.Duration) time.Duration
+	// SampleRate, when set to a value below 1, caches the results of only
+	// this fraction of queries with cache attributes, so that caching can
+	// be rolled out gradually. Queries are sampled by key, so the same
+	// queries are cached in every process, and the rest are skipped with
+	// SkipNotSampled. The @cache-sample-rate attribute overrides it per
+	// query. Zero, the default, caches all queries.
+	SampleRate float64
+	// DryRun, when set, makes the interceptor look up the cache and record
+	// the results of queries as it otherwise would, but always serve
+	// results from the database and never write to the cache. Keys and
+	// sizes of the results that would have been cached are kept in memory
+	// instead, so that achievable hit rates and memory needs can be
+	// measured with Stats().DryRun before enabling caching. Coalescing of
+	// misses and LockTimeout don't apply in dry-run mode.
+	DryRun bool
+	// AutoCache, when set, caches the results of SELECT statements without
+	// cache attributes that pass its guardrails; see AutoCache.
+	AutoCache *AutoCache
+	// Parser, when set, analyzes queries instead of the default
+	// heuristics; see Parser.
+	Parser Parser
+	// InvalidateOnDDL detects statements such as ALTER TABLE, DROP TABLE
+	// and TRUNCATE run through the interceptor and stops serving the
+	// results of queries reading the tables they change, which may no
+	// longer scan into the same columns. Queries whose tables can't be
+	// determined are invalidated by any such statement. Results cached by
+	// other processes are removed as by InvalidateQuery when the backend
+	// implements cache.Indexer, and otherwise expire with their TTL.
+	InvalidateOnDDL bool
+	// TrackTableChanges stops serving the results of queries reading
+	// tables reported as changed by Interceptor.TablesChanged, such as
+	// from SQLite's update hook; see SQLiteUpdateHook. Like
+	// InvalidateOnDDL, it's limited to this process and relies on the
+	// tables read by queries being known, either by Config.Parser or
+	// heuristically.
+	TrackTableChanges bool
+	// Quota, when set, limits the number and size of entries written to
+	// the cache, overall and per tenant; see Quota.
+	Quota *Quota
+	// HealthCheck, when set, bypasses the cache backend after repeated
+	// failures until it recovers; see HealthCheck.
+	HealthCheck *HealthCheck
+	// Shadow, when set, verifies a sample of cache hits against the
+	// database in the background; see Shadow.
+	Shadow *Shadow
+	// StaleBudget, when set, serves results past their TTL when the
+	// database is slow to run the query; see StaleBudget.
+	StaleBudget *StaleBudget
+	// Clock, when set, replaces the system clock; see Clock.
+	Clock Clock
+	// EventBufferSize is the capacity of the channel returned by
+	// Interceptor.Events. Defaults to 1024.
+	EventBufferSize int
 }
 
 // Interceptor is a ngrok/sqlmw interceptor that caches SQL queries and
 // their responses.
 type Interceptor struct {
-	c        cache.Cacher
+	cache    atomic.Value // cacheBox
+	hookFns  atomic.Value // *hooks
+	hooksMu  sync.Mutex   // serializes changes of hookFns
 	hashFunc func(query string, args []driver.NamedValue) (string, error)
-	onErr    func(error)
-	stats    Stats
-	disabled bool
+	xxHash   bool // hashFunc is XXHash
+	// strictHash is set when hashFunc is StrictHash or the default, which
+	// normalizeArgs is set for.
+	strictHash    bool
+	normalizeArgs bool
+	normalize     bool
+	backslash     bool // Config.BackslashEscapes
+	instance      string
+	verifyDigest  bool
+	stats         Stats
+	disabled      atomic.Bool
+	closed        atomic.Bool
+	closeOnce     sync.Once
+	closeErr      error
+	countHits     bool
+	auditSets     bool
+	principal     func(ctx context.Context) string
+	transform     func(query string, item *cache.Item) (*cache.Item, bool)
+	ttlFunc       func(query string, args []driver.NamedValue, ttl time.Duration) time.Duration
+	columns       *columnProtector
+	codec         cache.Codec
+	logger        Logger
+	slowOp        time.Duration
+	clock         Clock
+
+	stmts sync.Map // driver.Stmt -> *stmtState
+
+	opts         atomic.Value // *options
+	optsMu       sync.Mutex   // serializes UpdateOptions and TTL overrides
+	drain        *DrainBudget
+	utcTimes     bool
+	nonDetWarned sync.Map // fingerprint -> struct{}
+
+	retry      *RetryPolicy
+	setLimiter *setLimiter
+
+	queryStats   *queryStatsTracker
+	fingerprints fingerprintRegistry
+	skips        [len(skipReasons)]uint64
+	backendStats backendStatsCache
+
+	cacheInTx bool
+	txConns   sync.Map // parent driver.Conn -> struct{}
+	txs       sync.Map // driver.Tx -> parent driver.Conn
+
+	coalesce        bool
+	coalesceTimeout time.Duration
+	flights         flightGroup
+
+	lockTimeout time.Duration
+	lockPoll    time.Duration
+
+	l1 *l1Cache
+
+	shadow *shadower
+	stale  *staler
+	dryRun *dryRunTracker
+	health *healthChecker
+
+	adaptiveTTL *AdaptiveTTL
+	quota       *quotaTracker
+	autoCache   *autoCacher
+
+	parser  Parser
+	parsed  sync.Map // query -> *ParsedQuery
+	nParsed int64
+
+	// ddl tracks the generations of tables changed by DDL statements when
+	// invalidateOnDDL is set, or by changes reported to TablesChanged.
+	ddl             *ddlTracker
+	invalidateOnDDL bool
+	changes         *tableChanges
+
+	driversMu sync.Mutex
+	drivers   []*driverPolicy
+	trends    trendTracker
+
+	missLimiter missLimiter
+
+	setQueue *setQueue
+
+	explain       bool
+	explainPrefix string
+	plans         planStore
+
+	eventBufSize int
+	eventsOnce   sync.Once
+	events       atomic.Value // *eventStream
+
+	obsMu       sync.RWMutex
+	opObservers []opObserver
+
 	sqlmw.NullInterceptor
 }
 
@@ -50,23 +475,210 @@ func NewInterceptor(config *Config) (*Interceptor, error) {
 		return nil, fmt.Errorf("cache must be set in Config")
 	}
 
+	opts, err := newOptions(config.options())
+	if err != nil {
+		return nil, err
+	}
+
 	if config.HashFunc == nil {
 		config.HashFunc = defaultHashFunc
 	}
 
-	return &Interceptor{
-		config.Cache,
-		config.HashFunc,
-		config.OnError,
-		Stats{},
-		false,
-		sqlmw.NullInterceptor{},
-	}, nil
+	if config.LockPollInterval <= 0 {
+		config.LockPollInterval = defaultLockPollInterval
+	}
+
+	if config.CoalesceTimeout <= 0 {
+		config.CoalesceTimeout = defaultCoalesceTimeout
+	}
+
+	if config.ExplainPrefix == "" {
+		config.ExplainPrefix = defaultExplainPrefix
+	}
+
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	if config.EventBufferSize <= 0 {
+		config.EventBufferSize = defaultEventBufferSize
+	}
+	if r := config.Retry; r != nil {
+		cpy := *r
+		if cpy.Backoff <= 0 {
+			cpy.Backoff = defaultRetryBackoff
+		}
+		if cpy.Retryable == nil {
+			cpy.Retryable = defaultRetryable
+		}
+		config.Retry = &cpy
+	}
+	if a := config.AdaptiveTTL; a != nil {
+		if a.BaseLatency <= 0 {
+			return nil, fmt.Errorf("AdaptiveTTL.BaseLatency must be positive")
+		}
+		cpy := *a
+		if cpy.MinFactor <= 0 {
+			cpy.MinFactor = defaultAdaptiveMinFactor
+		}
+		if cpy.MaxFactor <= 0 {
+			cpy.MaxFactor = defaultAdaptiveMaxFactor
+		}
+		if cpy.MinFactor > cpy.MaxFactor {
+			return nil, fmt.Errorf("AdaptiveTTL.MinFactor must not be greater than MaxFactor")
+		}
+		config.AdaptiveTTL = &cpy
+	}
+	if d := config.DrainOnClose; d != nil {
+		cpy := *d
+		if cpy.MaxDuration == 0 {
+			cpy.MaxDuration = defaultDrainMaxDuration
+		}
+		config.DrainOnClose = &cpy
+	}
+	columns, err := newColumnProtector(config.ColumnProtection)
+	if err != nil {
+		return nil, err
+	}
+	var autoCache *autoCacher
+	if a := config.AutoCache; a != nil {
+		if autoCache, err = newAutoCacher(a); err != nil {
+			return nil, err
+		}
+	}
+	if q := config.Quota; q != nil {
+		if err := validateQuota(q); err != nil {
+			return nil, err
+		}
+	}
+	var health *healthChecker
+	if h := config.HealthCheck; h != nil {
+		cpy, err := validateHealthCheck(h)
+		if err != nil {
+			return nil, err
+		}
+		health = newHealthChecker(cpy)
+	}
+	if s := config.Shadow; s != nil {
+		cpy, err := validateShadow(s)
+		if err != nil {
+			return nil, err
+		}
+		config.Shadow = cpy
+	}
+	if s := config.StaleBudget; s != nil {
+		cpy, err := validateStaleBudget(s)
+		if err != nil {
+			return nil, err
+		}
+		config.StaleBudget = cpy
+	}
+	if config.L1TTL <= 0 {
+		config.L1TTL = defaultL1TTL
+	}
+
+	if config.Codec == nil {
+		if cp, ok := cache.As[interface{ Codec() cache.Codec }](config.Cache); ok {
+			config.Codec = cp.Codec()
+		} else {
+			config.Codec = MsgpackCodec{}
+		}
+	}
+
+	strict, normalized := isStrictHash(config.HashFunc)
+	i := &Interceptor{
+		hashFunc:      config.HashFunc,
+		xxHash:        isXXHash(config.HashFunc),
+		strictHash:    strict,
+		normalizeArgs: normalized,
+		normalize:     config.NormalizeQuery,
+		backslash:     config.BackslashEscapes,
+		instance:      config.InstanceKey,
+		verifyDigest:  config.VerifyDigest,
+		countHits:     config.CountHits,
+		auditSets:     config.AuditSets,
+		transform:     config.TransformItem,
+		ttlFunc:       config.TTLFunc,
+		principal:     config.Principal,
+		columns:       columns,
+		codec:         config.Codec,
+		logger:        config.Logger,
+		slowOp:        config.SlowOpThreshold,
+		clock:         config.Clock,
+
+		drain:    config.DrainOnClose,
+		utcTimes: config.UTCTimes,
+
+		retry:      config.Retry,
+		setLimiter: newSetLimiter(config.SetRateLimit, config.QuerySetRateLimit, config.Clock.Now),
+
+		queryStats: newQueryStatsTracker(config.MaxTrackedQueries, config.Clock.Now),
+		cacheInTx:  !config.DisableTxCaching,
+
+		coalesce:        !config.DisableCoalescing,
+		coalesceTimeout: config.CoalesceTimeout,
+
+		lockTimeout: config.LockTimeout,
+		lockPoll:    config.LockPollInterval,
+
+		adaptiveTTL: config.AdaptiveTTL,
+		quota:       newQuotaTracker(config.Quota, config.Clock.Now),
+		health:      health,
+		autoCache:   autoCache,
+
+		parser: config.Parser,
+
+		explain:       config.Explain,
+		explainPrefix: config.ExplainPrefix,
+
+		eventBufSize: config.EventBufferSize,
+	}
+
+	i.cache.Store(cacheBox{config.Cache})
+	i.opts.Store(opts)
+	i.hookFns.Store(&hooks{onErr: config.OnError, onSkip: config.OnSkip, onSet: config.OnSet, enabler: config.Enabler})
+
+	if config.AsyncSetWorkers > 0 {
+		i.setQueue = newSetQueue(i, config.AsyncSetWorkers, config.AsyncSetQueueSize)
+	}
+	if config.L1Size > 0 {
+		i.l1 = newL1Cache(config.L1Size, config.L1TTL, config.Clock.Now)
+	}
+	if config.Shadow != nil {
+		i.shadow = newShadower(config.Shadow)
+	}
+	if config.StaleBudget != nil {
+		i.stale = &staler{StaleBudget: config.StaleBudget}
+	}
+	if config.InvalidateOnDDL || config.TrackTableChanges {
+		i.ddl = &ddlTracker{}
+	}
+	i.invalidateOnDDL = config.InvalidateOnDDL
+	if config.TrackTableChanges {
+		i.changes = &tableChanges{}
+	}
+	if config.DryRun {
+		i.dryRun = newDryRunTracker(config.Clock.Now)
+		i.coalesce = false
+		i.lockTimeout = 0
+	}
+
+	return i, nil
+}
+
+// withTimeout returns ctx with the timeout applied, if set.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, d)
 }
 
 // Driver returns the supplied driver.Driver with a new object that has
 // all of its calls intercepted by the sqlcache.Interceptor. Any DB call
-// without a context passed will not be intercepted.
+// without a context passed will not be intercepted. d may itself be wrapped
+// by other middlewares, and the returned driver may be wrapped in turn.
 func (i *Interceptor) Driver(d driver.Driver) driver.Driver {
 	return sqlmw.Driver(d, i)
 }
@@ -74,143 +686,708 @@ func (i *Interceptor) Driver(d driver.Driver) driver.Driver {
 // Enable enables the interceptor. Interceptor instance is enabled by default
 // on creation.
 func (i *Interceptor) Enable() {
-	i.disabled = false
+	i.disabled.Store(false)
 }
 
 // Disable disables the interceptor resulting in cache bypass. All queries
-// would go directly to the SQL backend.
+// would go directly to the SQL backend. It may be called concurrently with
+// queries.
 func (i *Interceptor) Disable() {
-	i.disabled = true
+	i.disabled.Store(true)
 }
 
 // StmtQueryContext intecepts database/sql's stmt.QueryContext calls from a prepared statement.
 func (i *Interceptor) StmtQueryContext(ctx context.Context, conn driver.StmtQueryContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	return i.stmtQueryContext(ctx, nil, conn, query, args)
+}
+
+func (i *Interceptor) stmtQueryContext(ctx context.Context, dp *driverPolicy, conn driver.StmtQueryContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	var (
+		p    *preparedQuery
+		inTx bool
+	)
+	switch st, tracked := i.stmtState(conn); {
+	case st != nil:
+		p, inTx = st.preparedQuery, st.inTx && !i.cacheInTx
+		if st.driver != nil {
+			dp = st.driver
+		}
+	case !tracked:
+		// statements that can't be tracked may be within a transaction
+		p, inTx = i.prepare(query), !i.cacheInTx
+	default:
+		p = i.prepare(query)
+	}
+	rows, err := i.interceptDriver(ctx, dp, p, args, inTx, nil, func() (driver.Rows, error) {
+		return conn.QueryContext(ctx, args)
+	})
+	return ctx, rows, err
+}
+
+// ConnQueryContext intecepts database/sql's DB.QueryContext Conn.QueryContext calls.
+func (i *Interceptor) ConnQueryContext(ctx context.Context, conn driver.QueryerContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	return i.connQueryContext(ctx, nil, conn, query, args)
+}
+
+func (i *Interceptor) connQueryContext(ctx context.Context, dp *driverPolicy, conn driver.QueryerContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	rows, err := i.interceptDriver(ctx, dp, i.prepare(query), args, i.connInTx(conn), conn, func() (driver.Rows, error) {
+		return conn.QueryContext(ctx, query, args)
+	})
+	return ctx, rows, err
+}
 
-	if i.disabled {
-		rows, err := conn.QueryContext(ctx, args)
-		return ctx, rows, err
+// queryInfo holds the state of a single call of a query that has cache
+// attributes.
+type queryInfo struct {
+	query       string
+	fingerprint string
+	key         string
+	attrs       *attributes
+	// tenant is set when Config.Quota is, before the results are written.
+	tenant string
+	// driver is the policy of the driver the query is run through, if any.
+	driver *driverPolicy
+	// principal is set for queries with @cache-per-principal.
+	principal string
+	// args are the args the query is run with.
+	args []driver.NamedValue
+	// digest is set when Config.VerifyDigest is set.
+	digest []byte
+	// stale is set to the results found past their soft TTL on lookup,
+	// when they may be served stale.
+	stale *cache.Item
+	// fragment is set for the results of a single value of an IN list,
+	// whose lookups are counted in Stats once per query instead.
+	fragment bool
+}
+
+// intercept serves the query from cache when possible. On a cache miss, the
+// query is run using queryFn and the rows returned are recorded for caching.
+// The connection the query runs on, if known, is used to capture its plan.
+func (i *Interceptor) intercept(ctx context.Context, p *preparedQuery, args []driver.NamedValue, inTx bool, conn driver.QueryerContext, queryFn func() (driver.Rows, error)) (driver.Rows, error) {
+	return i.interceptDriver(ctx, nil, p, args, inTx, conn, queryFn)
+}
+
+// interceptDriver is intercept for queries run through a driver with the
+// policy dp, if not nil.
+func (i *Interceptor) interceptDriver(ctx context.Context, dp *driverPolicy, p *preparedQuery, args []driver.NamedValue, inTx bool, conn driver.QueryerContext, queryFn func() (driver.Rows, error)) (driver.Rows, error) {
+	beginHitInfo(ctx, p)
+	if i.disabled.Load() || i.closed.Load() {
+		i.skip(ctx, &queryInfo{query: p.query, driver: dp}, SkipDisabled)
+		return queryFn()
 	}
 
-	attrs := getAttrs(query)
+	if m := requestMemoFrom(ctx); m != nil {
+		return i.memoize(ctx, m, p, args, inTx, func() (driver.Rows, error) {
+			return i.interceptCache(ctx, dp, p, args, inTx, conn, queryFn)
+		})
+	}
+
+	return i.interceptCache(ctx, dp, p, args, inTx, conn, queryFn)
+}
+
+// interceptCache is interceptDriver past the request memo, if any.
+func (i *Interceptor) interceptCache(ctx context.Context, dp *driverPolicy, p *preparedQuery, args []driver.NamedValue, inTx bool, conn driver.QueryerContext, queryFn func() (driver.Rows, error)) (driver.Rows, error) {
+	attrs := p.attrs
 	if attrs == nil {
-		rows, err := conn.QueryContext(ctx, args)
-		return ctx, rows, err
+		i.skip(ctx, &queryInfo{query: p.query, driver: dp}, SkipNoAttributes)
+		return queryFn()
+	}
+	if i.health.bypassed() {
+		i.skip(ctx, &queryInfo{query: p.query, fingerprint: p.fingerprint, attrs: attrs, driver: dp}, SkipUnhealthy)
+		return queryFn()
 	}
 
-	hash, err := i.hashFunc(query, args)
-	if err != nil {
-		atomic.AddUint64(&i.stats.Errors, 1)
-		if i.onErr != nil {
-			i.onErr(fmt.Errorf("HashFunc failed: %w", err))
+	q := &queryInfo{
+		query:       p.query,
+		fingerprint: p.fingerprint,
+		attrs:       attrs,
+		driver:      dp,
+		args:        args,
+	}
+	o := i.options()
+	i.fingerprints.seen(p, i.clock.Now())
+
+	if err := attrs.validate(o.ZeroTTL); err != nil {
+		i.log(ctx, LevelWarn, "sqlcache: invalid cache attributes",
+			"fingerprint", q.fingerprint, "error", err)
+		i.skip(ctx, q, SkipInvalidAttributes)
+		return queryFn()
+	}
+	if len(attrs.encrypt) > 0 && i.columns.aead == nil {
+		i.log(ctx, LevelWarn, "sqlcache: invalid cache attributes",
+			"fingerprint", q.fingerprint, "error", "@cache-encrypt requires ColumnProtection.Key")
+		i.skip(ctx, q, SkipInvalidAttributes)
+		return queryFn()
+	}
+	if attrs.perPrincipal && i.principal == nil {
+		i.log(ctx, LevelWarn, "sqlcache: invalid cache attributes",
+			"fingerprint", q.fingerprint, "error", "@cache-per-principal requires Config.Principal")
+		i.skip(ctx, q, SkipInvalidAttributes)
+		return queryFn()
+	}
+
+	if dp != nil && dp.NoCache {
+		i.skip(ctx, q, SkipDriverNoCache)
+		return queryFn()
+	}
+
+	ttl := dp.scaleTTL(time.Duration(attrs.ttl) * time.Second)
+	override, overridden := o.TTLOverrides[q.fingerprint]
+	if overridden {
+		ttl = override
+	} else if i.ttlFunc != nil {
+		ttl = i.callTTLFunc(ctx, q, args, ttl)
+	}
+	if ttl == 0 && (overridden || o.ZeroTTL == ZeroTTLSkip) {
+		i.skip(ctx, q, SkipZeroTTL)
+		return queryFn()
+	}
+
+	if !o.allowed(q.fingerprint) {
+		i.skip(ctx, q, SkipDenied)
+		return queryFn()
+	}
+
+	if !i.enabled(ctx, q) {
+		i.skip(ctx, q, SkipNotEnabled)
+		return queryFn()
+	}
+
+	if inTx {
+		i.skip(ctx, q, SkipInTx)
+		return queryFn()
+	}
+
+	if p.write && !o.CacheWrites {
+		i.skip(ctx, q, SkipNotRead)
+		return queryFn()
+	}
+
+	if o.NonDeterministic != NonDeterministicAllow {
+		if fn := p.nonDeterministicCall(); fn != "" {
+			if o.NonDeterministic == NonDeterministicSkip {
+				i.skip(ctx, q, SkipNonDeterministic)
+				return queryFn()
+			}
+			i.warnNonDeterministic(ctx, p.fingerprint, fn)
 		}
-		rows, err := conn.QueryContext(ctx, args)
-		return ctx, rows, err
 	}
 
-	if cached := i.checkCache(ctx, hash); cached != nil {
-		return ctx, cached, nil
+	if o.MinLookupBudget > 0 {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < o.MinLookupBudget {
+			i.skip(ctx, q, SkipDeadline)
+			if o.FailFastOnDeadline {
+				return nil, ErrDeadlineTooShort
+			}
+			return queryFn()
+		}
 	}
 
-	rows, err := conn.QueryContext(ctx, args)
+	hash, err := i.hash(p, args)
 	if err != nil {
-		return ctx, rows, err
+		i.reportErr(ctx, q, &Error{Kind: ErrHash, Op: "HashFunc", Err: err})
+		i.skip(ctx, q, SkipHashError)
+		return queryFn()
+	}
+	q.key = i.cacheKey(q, p, hash)
+	if attrs.perPrincipal {
+		if q.principal = i.callPrincipal(ctx, q); q.principal == "" {
+			i.skip(ctx, q, SkipNoPrincipal)
+			return queryFn()
+		}
+		q.key = withPrincipal(q.key, q.principal)
+	}
+	if !i.sampled(q, o.SampleRate) {
+		i.skip(ctx, q, SkipNotSampled)
+		return queryFn()
+	}
+	if p.in != nil && conn != nil && i.dryRun == nil {
+		if values, ok := p.in.values(args); ok {
+			return i.interceptFragments(ctx, q, p, args, values, conn, ttl, overridden, o, queryFn)
+		}
+	}
+	if i.verifyDigest {
+		q.digest = queryDigest(p.hashQuery, args)
 	}
 
-	cacheSetter := func(item *cache.Item) {
-		err := i.c.Set(ctx, hash, item, time.Duration(attrs.ttl)*time.Second)
-		if err != nil {
-			atomic.AddUint64(&i.stats.Errors, 1)
-			if i.onErr != nil {
-				i.onErr(fmt.Errorf("Cache.Set failed: %w", err))
+	start := time.Now()
+	cached, err := i.checkCache(ctx, q, o)
+	if cached != nil {
+		i.queryStats.recordHit(q, time.Since(start))
+		if i.dryRun == nil {
+			i.maybeShadow(q, cached, args)
+			return cached, nil
+		}
+		_ = cached.Close()
+		recordHitInfo(ctx, func(h *HitInfo) { h.Hit, h.Age = false, 0 })
+	}
+	if i.dryRun != nil {
+		i.dryRun.lookup(q.key)
+	}
+
+	var f *flight
+	if i.coalesce {
+		var leader bool
+		if f, leader = i.flights.join(q.key); !leader {
+			landed, err := i.awaitFlight(ctx, q.key, f)
+			if err != nil {
+				return nil, err
+			}
+			if landed && f.item != nil && i.verify(ctx, q, f.item) {
+				atomic.AddUint64(&i.stats.Coalesced, 1)
+				i.servedHitInfo(ctx, q, f.item, true)
+				return i.cachedRows(ctx, q, f.item, true), nil
 			}
+			// the results couldn't be recorded in time; run the query
+			// instead
+			f = nil
+		}
+	}
+	var landOnce sync.Once
+	land := func(item *cache.Item) {
+		if f != nil {
+			landOnce.Do(func() {
+				i.flights.land(q.key, f, item)
+			})
 		}
 	}
 
-	rows = newRowsRecorder(cacheSetter, rows, attrs.maxRows)
-	return ctx, rows, err
-}
+	// releasers free resources held while the query runs
+	var (
+		releasers   []func()
+		releaseOnce sync.Once
+	)
+	release := func() {
+		releaseOnce.Do(func() {
+			for _, fn := range releasers {
+				fn()
+			}
+		})
+	}
 
-// ConnQueryContext intecepts database/sql's DB.QueryContext Conn.QueryContext calls.
-func (i *Interceptor) ConnQueryContext(ctx context.Context, conn driver.QueryerContext, query string, args []driver.NamedValue) (context.Context, driver.Rows, error) {
+	if locker, ok := cache.As[cache.Locker](i.cacher()); ok && i.lockTimeout > 0 {
+		item, unlock := i.lockOrWait(ctx, q, locker)
+		if item != nil {
+			land(item)
+			return i.cachedRows(ctx, q, item, i.itemsShared()), nil
+		}
+		if unlock != nil {
+			releasers = append(releasers, unlock)
+		}
+	}
 
-	if i.disabled {
-		rows, err := conn.QueryContext(ctx, query, args)
-		return ctx, rows, err
+	if o.MaxConcurrentMisses > 0 {
+		failFast := o.MissLimit == MissLimitFail || o.MissLimit == MissLimitStale && q.stale != nil
+		done, lErr := i.missLimiter.acquire(ctx, q.fingerprint, o.MaxConcurrentMisses, failFast)
+		if lErr == ErrTooManyMisses && o.MissLimit == MissLimitStale {
+			land(q.stale)
+			release()
+			return i.staleRows(ctx, q, q.stale), nil
+		}
+		if lErr != nil {
+			land(nil)
+			release()
+			if lErr == ErrTooManyMisses {
+				atomic.AddUint64(&i.stats.Shed, 1)
+			}
+			return nil, lErr
+		}
+		releasers = append(releasers, done)
 	}
 
-	attrs := getAttrs(query)
-	if attrs == nil {
-		rows, err := conn.QueryContext(ctx, query, args)
-		return ctx, rows, err
+	// setItem writes the results of the query to cache under ctx
+	setItem := func(ctx context.Context, item *cache.Item) {
+		empty := len(item.Rows) == 0
+		if empty && o.DisableNegativeCaching {
+			land(nil)
+			i.skip(ctx, q, SkipEmpty)
+			release()
+			return
+		}
+		item.CreatedAt = i.clock.Now()
+		item.Fingerprint = q.fingerprint
+		item.Digest = q.digest
+		land(item)
+		item, itemTTL, ok := i.itemToCache(ctx, q, item, ttl, overridden, o)
+		if !ok {
+			release()
+			return
+		}
+		if i.dryRun != nil {
+			i.recordDryRun(ctx, q, item, itemTTL)
+		} else {
+			if i.quota != nil {
+				q.tenant = i.tenant(ctx, q)
+			}
+			i.setCache(ctx, q, item, itemTTL)
+		}
+		release()
 	}
 
-	hash, err := i.hashFunc(query, args)
-	if err != nil {
-		atomic.AddUint64(&i.stats.Errors, 1)
-		if i.onErr != nil {
-			i.onErr(fmt.Errorf("HashFunc failed: %w", err))
+	if i.stale != nil && i.dryRun == nil && err == nil {
+		if rows, ok, sErr := i.serveStale(ctx, q, args, setItem, land, release); ok {
+			return rows, sErr
 		}
-		rows, err := conn.QueryContext(ctx, query, args)
-		return ctx, rows, err
 	}
 
-	if cached := i.checkCache(ctx, hash); cached != nil {
-		return ctx, cached, nil
+	if i.explain && conn != nil && err == nil {
+		i.capturePlan(ctx, q, conn, args)
 	}
 
-	rows, err := conn.QueryContext(ctx, query, args)
-	if err != nil {
-		return ctx, rows, err
+	start = time.Now()
+	rows, qErr := queryFn()
+	if qErr != nil {
+		land(nil)
+		release()
+		return rows, qErr
+	}
+	if err == nil {
+		d := time.Since(start)
+		i.queryStats.recordMiss(q, d)
+		if o.MinQueryLatency > 0 || i.adaptiveTTL != nil {
+			if avg := i.trends.observe(q.fingerprint, d); avg < o.MinQueryLatency {
+				land(nil)
+				i.skip(ctx, q, SkipFastQuery)
+				release()
+				return rows, nil
+			}
+		}
 	}
 
 	cacheSetter := func(item *cache.Item) {
-		err := i.c.Set(ctx, hash, item, time.Duration(attrs.ttl)*time.Second)
-		if err != nil {
-			atomic.AddUint64(&i.stats.Errors, 1)
-			if i.onErr != nil {
-				i.onErr(fmt.Errorf("Cache.Set failed: %w", err))
-			}
+		setItem(ctx, item)
+	}
+
+	cacheSkipper := func(reason SkipReason) {
+		land(nil)
+		i.skip(ctx, q, reason)
+		release()
+	}
+
+	rr := newRowsRecorder(ctx, cacheSetter, cacheSkipper, rows, attrs.maxRows)
+	rr.drain = i.drain
+	rr.utcTimes = i.utcTimes
+	rr.maxBytes = int64(o.MaxRecordBytes)
+	return rr, nil
+}
+
+// cacheKey returns the cache key of the query for the hash of its text and
+// args, scoped by its principal once known.
+func (i *Interceptor) cacheKey(q *queryInfo, p *preparedQuery, hash string) string {
+	key := withInstance(i.instanceKey(q.driver), i.withGeneration(p, hash))
+	if q.principal != "" {
+		key = withPrincipal(key, q.principal)
+	}
+
+	return key
+}
+
+// itemToCache returns the item to cache for the results of the query, as
+// transformed and protected, and its TTL, which is ttl unless overridden
+// is set. The boolean returned is false, after the skip or error is
+// reported, when the results mustn't be cached.
+func (i *Interceptor) itemToCache(ctx context.Context, q *queryInfo, item *cache.Item, ttl time.Duration, overridden bool, o *options) (*cache.Item, time.Duration, bool) {
+	empty := len(item.Rows) == 0
+	if i.transform != nil {
+		var ok bool
+		if item, ok = i.transformItem(ctx, q, item); !ok {
+			i.skip(ctx, q, SkipTransformVeto)
+			return nil, 0, false
+		}
+	}
+	if i.columns.needed(q.attrs) {
+		var err error
+		if item, err = i.columns.protect(item, q.attrs, q.key); err != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrEncode, Op: "ColumnProtection", Key: q.key, Err: err})
+			return nil, 0, false
+		}
+	}
+	if i.adaptiveTTL != nil && !overridden {
+		ttl = i.trends.scaleTTL(q.fingerprint, ttl, i.adaptiveTTL)
+	}
+	// a TTL of zero here means no expiry
+	if empty && o.NegativeTTL > 0 && (ttl == 0 || o.NegativeTTL < ttl) {
+		ttl = o.NegativeTTL
+	}
+
+	return item, ttl, true
+}
+
+func (i *Interceptor) setCache(ctx context.Context, q *queryInfo, item *cache.Item, ttl time.Duration) {
+	if i.setLimiter != nil && !i.setLimiter.allow(q.fingerprint) {
+		i.skip(ctx, q, SkipRateLimited)
+		return
+	}
+
+	if i.setQueue != nil {
+		switch i.setQueue.enqueue(setTask{q, item, ttl}) {
+		case errSetQueueFull:
+			i.skip(ctx, q, SkipSetQueueFull)
+			return
+		case nil:
+			return
 		}
+		// queue has been shut down; write synchronously
 	}
 
-	rows = newRowsRecorder(cacheSetter, rows, attrs.maxRows)
-	return ctx, rows, err
+	i.writeCache(ctx, q, item, ttl)
 }
 
-func (i *Interceptor) checkCache(ctx context.Context, hash string) driver.Rows {
-	item, ok, err := i.c.Get(ctx, hash)
+// writeCache writes the item to the cache backend.
+func (i *Interceptor) writeCache(ctx context.Context, q *queryInfo, item *cache.Item, ttl time.Duration) {
+	o := i.options()
+	item, hardTTL := i.softTTL(item, ttl)
+	size := -1
+	maxBytes := q.maxItemBytes(o)
+	audit := i.auditSets && i.logger != nil
+	if maxBytes > 0 || audit || i.quota.needsSize() {
+		b, err := i.codec.Marshal(item)
+		if err != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrEncode, Op: "Codec.Marshal", Key: q.key, Err: err})
+			return
+		}
+		size = len(b)
+		if maxBytes > 0 && size > maxBytes {
+			i.skip(ctx, q, SkipMaxBytes)
+			return
+		}
+	}
+
+	if i.quota != nil && !i.quota.reserve(q.key, q.fingerprint, q.tenant, int64(size), hardTTL) {
+		i.skip(ctx, q, SkipQuotaExceeded)
+		return
+	}
+
+	start := time.Now()
+	ctx, timeout := setContext(ctx, o)
+	err := i.withRetries(ctx, func() error {
+		opStart := time.Now()
+		setCtx, cancel := withTimeout(ctx, timeout)
+		err := i.cacher().Set(setCtx, q.key, item, hardTTL)
+		cancel()
+		i.observeOp(ctx, opSet, q.key, time.Since(opStart), err)
+		return err
+	})
+	d := time.Since(start)
 	if err != nil {
-		atomic.AddUint64(&i.stats.Errors, 1)
-		if i.onErr != nil {
-			i.onErr(fmt.Errorf("Cache.Get failed: %w", err))
+		i.reportErr(ctx, q, &Error{Kind: ErrCacheSet, Op: "Cache.Set", Key: q.key, Err: err})
+		i.skip(ctx, q, SkipBackendError)
+		i.quota.release(q.key)
+		return
+	}
+	atomic.AddUint64(&i.stats.Sets, 1)
+	i.queryStats.recordSet(q, ttl)
+	i.notifySet(ctx, q, item)
+	if audit {
+		i.log(ctx, LevelInfo, "sqlcache: query result cached",
+			"fingerprint", q.fingerprint, "key", q.key, "rows", len(item.Rows),
+			"bytes", size, "ttl", ttl)
+	}
+	i.emit(Event{Type: EventSet, Fingerprint: q.fingerprint, Driver: q.driver.name(), Key: q.key, Duration: d, Rows: len(item.Rows), TTL: ttl})
+}
+
+// maxItemBytes returns the largest encoded size of results of the query
+// that may be cached, or zero if it isn't limited.
+func (q *queryInfo) maxItemBytes(o *options) int {
+	max := o.MaxItemBytes
+	if m := q.attrs.maxBytes; m > 0 && (max == 0 || m < max) {
+		max = m
+	}
+
+	return max
+}
+
+func (i *Interceptor) skip(ctx context.Context, q *queryInfo, reason SkipReason) {
+	atomic.AddUint64(&i.skips[skipReasonIndex[reason]], 1)
+	recordHitInfo(ctx, func(h *HitInfo) {
+		h.SkipReason = reason
+		if q.key != "" {
+			h.Key = q.key
 		}
-		return nil
+	})
+
+	if reason == SkipNoAttributes || reason == SkipDisabled {
+		i.log(ctx, LevelDebug, "sqlcache: query not cached", "query", q.query, "reason", string(reason))
+	} else {
+		i.log(ctx, LevelInfo, "sqlcache: query result not cached",
+			"fingerprint", q.fingerprint, "key", q.key, "reason", string(reason))
+	}
+
+	i.notifySkip(ctx, q, reason)
+	i.emit(Event{Type: EventSkip, Fingerprint: q.fingerprint, Driver: q.driver.name(), Key: q.key, Reason: reason})
+}
+
+// reportErr accounts for the error in stats and reports it via OnError and
+// Logger.
+func (i *Interceptor) reportErr(ctx context.Context, q *queryInfo, err error) {
+	atomic.AddUint64(&i.stats.Errors, 1)
+	i.queryStats.recordErr(q)
+	i.notifyErr(ctx, err)
+	i.log(ctx, LevelError, "sqlcache: cache operation failed",
+		"fingerprint", q.fingerprint, "key", q.key, "error", err)
+	i.emit(Event{Type: EventError, Fingerprint: q.fingerprint, Driver: q.driver.name(), Key: q.key, Err: err})
+}
+
+func (i *Interceptor) hit(ctx context.Context, q *queryInfo, item *cache.Item, d time.Duration) {
+	if !q.fragment {
+		atomic.AddUint64(&i.stats.Hits, 1)
+	}
+	i.servedHitInfo(ctx, q, item, false)
+	if i.adaptiveTTL != nil {
+		i.trends.lookup(q.fingerprint, true)
 	}
+	i.emit(Event{Type: EventHit, Fingerprint: q.fingerprint, Driver: q.driver.name(), Key: q.key, Duration: d})
+}
 
-	if !ok {
-		atomic.AddUint64(&i.stats.Misses, 1)
-		return nil
+func (i *Interceptor) miss(ctx context.Context, q *queryInfo, d time.Duration) {
+	recordHitInfo(ctx, func(h *HitInfo) { h.Key = q.key })
+	if !q.fragment {
+		i.countMiss(q)
+	}
+	if i.adaptiveTTL != nil {
+		i.trends.lookup(q.fingerprint, false)
 	}
-	atomic.AddUint64(&i.stats.Hits, 1)
+	i.emit(Event{Type: EventMiss, Fingerprint: q.fingerprint, Driver: q.driver.name(), Key: q.key, Duration: d})
+}
 
-	return &rowsCached{
-		item,
-		0,
+// countMiss counts a miss of the query in Stats.
+func (i *Interceptor) countMiss(q *queryInfo) {
+	atomic.AddUint64(&i.stats.Misses, 1)
+	if q.driver != nil {
+		atomic.AddUint64(&q.driver.misses, 1)
 	}
 }
 
-// Stats contains sqlcache statistics.
-type Stats struct {
-	Hits   uint64
-	Misses uint64
-	Errors uint64
+// itemsShared reports whether items got from the backend may be shared
+// with other callers.
+func (i *Interceptor) itemsShared() bool {
+	fg, ok := cache.As[cache.FreshGetter](i.cacher())
+	return !ok || !fg.FreshItems() || i.l1 != nil
 }
 
-// Stats returns sqlcache stats.
-func (i *Interceptor) Stats() *Stats {
-	return &Stats{
-		Hits:   atomic.LoadUint64(&i.stats.Hits),
-		Misses: atomic.LoadUint64(&i.stats.Misses),
-		Errors: atomic.LoadUint64(&i.stats.Errors),
+// checkCache returns the cached rows of the query on a hit. A non-nil error
+// is returned (after being reported) when the backend lookup failed.
+func (i *Interceptor) checkCache(ctx context.Context, q *queryInfo, o *options) (driver.Rows, error) {
+	if i.l1 != nil {
+		// items of other queries are left to expire from the L1 cache
+		if item, ok := i.l1.get(q.key); ok && i.fresh(item) && (!i.verifyDigest || bytes.Equal(item.Digest, q.digest)) {
+			if !q.fragment {
+				atomic.AddUint64(&i.stats.L1Hits, 1)
+			}
+			i.hit(ctx, q, item, 0)
+			if i.countHits {
+				atomic.AddUint64(&item.Hits, 1)
+			}
+			return i.cachedRows(ctx, q, item, true), nil
+		}
+	}
+
+	// streamed rows can't be added to the L1 cache, which needs the
+	// entire item
+	if sg, ok := cache.As[cache.StreamGetter](i.cacher()); ok && i.l1 == nil {
+		return i.checkCacheStream(ctx, sg, q, o)
+	}
+
+	var (
+		item  *cache.Item
+		ok    bool
+		start = time.Now()
+	)
+	err := i.withRetries(ctx, func() error {
+		opStart := time.Now()
+		getCtx, cancel := withTimeout(ctx, o.GetTimeout)
+		var err error
+		item, ok, err = i.cacher().Get(getCtx, q.key)
+		cancel()
+		i.observeOp(ctx, opGet, q.key, time.Since(opStart), err)
+		return lookupErr(err, ok)
+	})
+	d := time.Since(start)
+	if err != nil {
+		err = &Error{Kind: ErrCacheGet, Op: "Cache.Get", Key: q.key, Err: err}
+		i.reportErr(ctx, q, err)
+		return nil, err
+	}
+
+	if !ok || !i.verify(ctx, q, item) {
+		i.miss(ctx, q, d)
+		return nil, nil
+	}
+	if !i.fresh(item) {
+		if i.stale != nil {
+			q.stale = item
+		}
+		i.miss(ctx, q, d)
+		return nil, nil
+	}
+	i.hit(ctx, q, item, d)
+	if i.countHits {
+		atomic.AddUint64(&item.Hits, 1)
 	}
+	if i.l1 != nil {
+		i.l1.set(q.key, item)
+	}
+	if i.refreshAhead(item) {
+		// served by serveStale while the query refreshes it
+		q.stale = item
+		return nil, nil
+	}
+
+	return i.cachedRows(ctx, q, item, i.itemsShared()), nil
+}
+
+func (i *Interceptor) checkCacheStream(ctx context.Context, sg cache.StreamGetter, q *queryInfo, o *options) (driver.Rows, error) {
+	var (
+		rr    cache.RowsReader
+		ok    bool
+		start = time.Now()
+	)
+	err := i.withRetries(ctx, func() error {
+		opStart := time.Now()
+		getCtx, cancel := withTimeout(ctx, o.GetTimeout)
+		var err error
+		rr, ok, err = sg.GetStream(getCtx, q.key)
+		cancel()
+		i.observeOp(ctx, opGet, q.key, time.Since(opStart), err)
+		return lookupErr(err, ok)
+	})
+	d := time.Since(start)
+	if err != nil {
+		err = &Error{Kind: ErrCacheGet, Op: "Cache.GetStream", Key: q.key, Err: err}
+		i.reportErr(ctx, q, err)
+		return nil, err
+	}
+
+	if !ok || !i.verify(ctx, q, rr.Header()) {
+		i.miss(ctx, q, d)
+		return nil, nil
+	}
+	if !i.fresh(rr.Header()) {
+		if i.stale != nil {
+			q.stale = i.readStale(ctx, q, rr)
+		}
+		i.miss(ctx, q, d)
+		return nil, nil
+	}
+	if i.refreshAhead(rr.Header()) {
+		// served by serveStale while the query refreshes it
+		if q.stale = i.readStale(ctx, q, rr); q.stale == nil {
+			i.miss(ctx, q, d)
+			return nil, nil
+		}
+		i.hit(ctx, q, q.stale, d)
+		return nil, nil
+	}
+	i.hit(ctx, q, rr.Header(), d)
+
+	return &rowsStreamed{
+		r:    rr,
+		ctx:  ctx,
+		done: ctx.Done(),
+		onErr: func(err error) {
+			i.reportErr(ctx, q, &Error{Kind: ErrDecode, Op: "RowsReader.Next", Key: q.key, Err: err})
+		},
+		open: i.opener(ctx, q),
+	}, nil
 }
@@ -6,6 +6,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io"
 	"testing"
 	"time"
 
@@ -186,11 +187,11 @@ func TestCacheMiss(t *testing.T) {
 				mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil)
 			}
 
-			ic.c = mCacher
+			assert.Nil(ic.SetCache(mCacher))
 			onErrCalled := 0
-			ic.onErr = func(e error) {
+			ic.SetOnError(func(e error) {
 				onErrCalled++
-			}
+			})
 
 			runQuery(t, assert, qMock, db, query, cacheMissExpected)
 			runQueryPrepared(t, assert, qMock, db, query, cacheMissExpected)
@@ -240,7 +241,7 @@ func TestCacheHit(t *testing.T) {
 	for i := 0; i < 2; i++ { // once each for runQuery and runQueryPrepared
 		mCacher.On("Get", mock.Anything, mock.Anything).Return(cacheItem, true, nil)
 	}
-	ic.c = mCacher
+	assert.Nil(ic.SetCache(mCacher))
 
 	cacheMissExpected := false
 	runQuery(t, assert, qMock, db, query, cacheMissExpected)
@@ -289,7 +290,7 @@ func TestDisabled(t *testing.T) {
 			} else {
 				ic.Disable()
 			}
-			ic.c = mCacher
+			assert.Nil(ic.SetCache(mCacher))
 
 			cacheMissExpected := true
 			runQuery(t, assert, qMock, db, query, cacheMissExpected)
@@ -331,7 +332,7 @@ func TestMaxRows(t *testing.T) {
 		// note that despite cache miss, no call must be made for cache.Set
 		// as max rows has been exceeded
 	}
-	ic.c = mCacher
+	assert.Nil(ic.SetCache(mCacher))
 
 	cacheMissExpected := true
 	runQuery(t, assert, qMock, db, query, cacheMissExpected)
@@ -433,3 +434,1091 @@ func TestCacheSetErr(t *testing.T) {
 	assert.True(mCacher.AssertExpectations(t))
 	assert.Equal(ic.Stats().Errors, uint64(2))
 }
+
+func TestItemMetadata(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	var stored *cache.Item
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Once()
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).
+		Run(func(args mock.Arguments) {
+			stored = args.Get(2).(*cache.Item)
+		}).Return(nil).Once()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:     mCacher,
+		CountHits: true,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	before := time.Now()
+	runQuery(t, assert, qMock, db, query, true)
+	assert.NotNil(stored)
+	assert.False(stored.CreatedAt.Before(before))
+	assert.Equal(fingerprint(query), stored.Fingerprint)
+
+	// the same query formatted differently has the same fingerprint
+	assert.Equal(fingerprint(query), fingerprint(`-- @cache-max-rows 10 -- @cache-ttl 30 SELECT name FROM users WHERE age > ?`))
+
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(stored, true, nil)
+	runQuery(t, assert, qMock, db, query, false)
+
+	info, ok, err := ic.Inspect(context.Background(), query, 18)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(stored.Fingerprint, info.Fingerprint)
+	assert.Equal(stored.CreatedAt, info.CreatedAt)
+	assert.Equal(uint64(1), info.Hits)
+	assert.Equal(2, info.Rows)
+	// the backend doesn't report TTLs
+	assert.Equal(time.Duration(-1), info.TTL)
+	assert.Equal(mCacher.Calls[0].Arguments.String(1), info.Key)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+}
+
+func TestMaxItemBytes(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	for i := 0; i < 2; i++ { // once each for runQuery and runQueryPrepared
+		mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil) // cache miss
+		// note that despite cache miss, no call must be made for cache.Set
+		// as max item bytes has been exceeded
+	}
+
+	var skipped []SkipReason
+	ic, _ := NewInterceptor(&Config{
+		Cache:        mCacher,
+		MaxItemBytes: 16,
+		OnSkip: func(key string, reason SkipReason) {
+			skipped = append(skipped, reason)
+		},
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	cacheMissExpected := true
+	runQuery(t, assert, qMock, db, query, cacheMissExpected)
+	runQueryPrepared(t, assert, qMock, db, query, cacheMissExpected)
+
+	assert.True(mCacher.AssertExpectations(t))
+	assert.Equal([]SkipReason{SkipMaxBytes, SkipMaxBytes}, skipped)
+	assert.Equal(uint64(2), ic.Stats().Skips)
+}
+
+// streamCacher is a cache.StreamGetter backed by a mocked cache.Cacher
+type streamCacher struct {
+	*mocks.Cacher
+}
+
+func (s streamCacher) GetStream(ctx context.Context, key string) (cache.RowsReader, bool, error) {
+	item, ok, err := s.Get(ctx, key)
+	if item == nil {
+		return nil, ok, err
+	}
+	return &itemReader{item: item}, ok, err
+}
+
+func TestCacheHitStream(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	cacheItem := &cache.Item{
+		Cols: []string{"name"},
+		Rows: [][]driver.Value{
+			{"John"},
+			{"Lisa"},
+		},
+	}
+
+	mCacher := new(mocks.Cacher)
+	for i := 0; i < 2; i++ { // once each for runQuery and runQueryPrepared
+		mCacher.On("Get", mock.Anything, mock.Anything).Return(cacheItem, true, nil)
+	}
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: streamCacher{mCacher},
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	cacheMissExpected := false
+	runQuery(t, assert, qMock, db, query, cacheMissExpected)
+	runQueryPrepared(t, assert, qMock, db, query, cacheMissExpected)
+
+	assert.True(mCacher.AssertExpectations(t))
+	assert.Equal(uint64(2), ic.Stats().Hits)
+}
+
+func TestSkipReasons(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+
+	var skipped []SkipReason
+	ic, _ := NewInterceptor(&Config{
+		Cache:            mCacher,
+		DisableTxCaching: true,
+		OnSkip: func(key string, reason SkipReason) {
+			skipped = append(skipped, reason)
+		},
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	// no attributes
+	runQuery(t, assert, qMock, db, `SELECT name FROM users WHERE age > ?`, true)
+
+	// disabled
+	ic.Disable()
+	runQuery(t, assert, qMock, db, query, true)
+	ic.Enable()
+
+	// max rows exceeded
+	runQuery(t, assert, qMock, db, `-- @cache-max-rows 1
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`, true)
+
+	// rows not read till the end
+	qMock.ExpectQuery(query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John").AddRow("Lisa"))
+	var name string
+	assert.Nil(db.QueryRowContext(context.Background(), query, 18).Scan(&name))
+
+	// within a transaction, both for queries and prepared statements
+	qMock.ExpectBegin()
+	tx, err := db.BeginTx(context.Background(), nil)
+	assert.Nil(err)
+	qMock.ExpectQuery(query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	rows, err := tx.QueryContext(context.Background(), query, 18)
+	assert.Nil(err)
+	assert.Nil(rows.Close())
+	qMock.ExpectPrepare(query)
+	stmt, err := tx.PrepareContext(context.Background(), query)
+	assert.Nil(err)
+	qMock.ExpectQuery(query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	rows, err = stmt.QueryContext(context.Background(), 18)
+	assert.Nil(err)
+	assert.Nil(rows.Close())
+	qMock.ExpectCommit()
+	assert.Nil(tx.Commit())
+	assert.Nil(qMock.ExpectationsWereMet())
+
+	assert.Equal([]SkipReason{
+		SkipNoAttributes,
+		SkipDisabled,
+		SkipMaxRows,
+		SkipIncomplete,
+		SkipInTx,
+		SkipInTx,
+	}, skipped)
+
+	s := ic.Stats()
+	assert.Equal(uint64(6), s.Skips)
+	assert.Equal(uint64(2), s.SkipReasons[SkipInTx])
+	assert.Equal(uint64(0), s.SkipReasons[SkipHashError])
+
+	// transaction tracking ends with the transaction
+	assert.False(ic.connInTx(nil))
+	n := 0
+	ic.txConns.Range(func(k, v interface{}) bool { n++; return true })
+	assert.Equal(0, n)
+}
+
+func TestTxCaching(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	ic, err := NewInterceptor(&Config{Cache: &mapCacher{entries: make(map[string]cache.Entry)}})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	// queries within transactions are cached unless DisableTxCaching is set
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	qMock.ExpectBegin()
+	tx, err := db.Begin()
+	assert.Nil(err)
+	qMock.ExpectQuery(query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	for n := 0; n < 2; n++ {
+		rows, err := tx.Query(query, 18)
+		assert.Nil(err)
+		for rows.Next() {
+		}
+		assert.Nil(rows.Close())
+	}
+	qMock.ExpectCommit()
+	assert.Nil(tx.Commit())
+	assert.Nil(qMock.ExpectationsWereMet())
+	assert.Equal(uint64(1), ic.Stats().Hits)
+	assert.Equal(uint64(0), ic.Stats().SkipReasons[SkipInTx])
+}
+
+func TestExplain(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	var stored *cache.Item
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Twice()
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).
+		Run(func(args mock.Arguments) {
+			stored = args.Get(2).(*cache.Item)
+		}).Return(nil)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:   mCacher,
+		Explain: true,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	qMock.ExpectQuery("EXPLAIN " + query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "detail"}).
+			AddRow(2, []byte("SCAN users")).AddRow(3, "USE INDEX"))
+	runQuery(t, assert, qMock, db, query, true)
+
+	plan, ok := ic.QueryPlan(fingerprint(query))
+	assert.True(ok)
+	assert.Equal("2 | SCAN users\n3 | USE INDEX", plan)
+
+	// plan is only captured on the first miss
+	runQuery(t, assert, qMock, db, query, true)
+
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(stored, true, nil)
+	info, ok, err := ic.Inspect(context.Background(), query, 18)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(plan, info.Plan)
+
+	_, ok = ic.QueryPlan("unknown")
+	assert.False(ok)
+}
+
+func TestCoalescing(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Once()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: mCacher,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	qMock.ExpectQuery(query).WithArgs(18).WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John").AddRow("Lisa"))
+
+	inFlight := func() int {
+		ic.flights.mu.Lock()
+		defer ic.flights.mu.Unlock()
+		return len(ic.flights.flights)
+	}
+
+	const callers = 5
+	results := make(chan []string, callers)
+	run := func() {
+		var names []string
+		rows, err := db.QueryContext(context.Background(), query, 18)
+		if err == nil {
+			for rows.Next() {
+				var name string
+				if rows.Scan(&name) == nil {
+					names = append(names, name)
+				}
+			}
+			rows.Close()
+		}
+		results <- names
+	}
+
+	go run()
+	for inFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for n := 1; n < callers; n++ {
+		go run()
+	}
+
+	for n := 0; n < callers; n++ {
+		assert.Equal([]string{"John", "Lisa"}, <-results)
+	}
+	assert.Nil(qMock.ExpectationsWereMet())
+	assert.Equal(0, inFlight())
+
+	s := ic.Stats()
+	assert.Equal(uint64(callers), s.Misses)
+	assert.Equal(uint64(callers-1), s.Coalesced)
+	assert.Equal(uint64(1), s.Sets)
+	assert.True(mCacher.AssertExpectations(t))
+}
+
+func TestCoalesceTimeout(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:           mCacher,
+		CoalesceTimeout: 10 * time.Millisecond,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	for n := 0; n < 2; n++ {
+		qMock.ExpectQuery(query).WithArgs(18).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	}
+
+	// running the query again while reading its results doesn't wait on
+	// them for ever
+	rows, err := db.QueryContext(context.Background(), query, 18)
+	assert.Nil(err)
+	assert.True(rows.Next())
+	again, err := db.QueryContext(context.Background(), query, 18)
+	assert.Nil(err)
+	for again.Next() {
+	}
+	assert.Nil(again.Close())
+	for rows.Next() {
+	}
+	assert.Nil(rows.Close())
+	assert.Nil(qMock.ExpectationsWereMet())
+
+	s := ic.Stats()
+	assert.Equal(uint64(2), s.Misses)
+	assert.Equal(uint64(0), s.Coalesced)
+}
+
+func TestCoalesceAbandoned(t *testing.T) {
+	assert := require.New(t)
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	ic, err := NewInterceptor(&Config{
+		Cache: &mapCacher{entries: make(map[string]cache.Entry)},
+		Clock: clock,
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-max-rows 10
+	          -- @cache-ttl 30
+	          SELECT name FROM users`
+	run := func() driver.Rows {
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		return rows
+	}
+	inFlight := func() bool {
+		ic.flights.mu.Lock()
+		defer ic.flights.mu.Unlock()
+		return len(ic.flights.flights) > 0
+	}
+
+	// the leader never closes its rows
+	leader := run()
+	assert.True(inFlight())
+
+	// the first caller waits on it for CoalesceTimeout and abandons it
+	done := make(chan driver.Rows)
+	go func() { done <- run() }()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(defaultCoalesceTimeout)
+	rows := <-done
+	assert.Nil(rows.Close())
+	assert.False(inFlight())
+
+	// later callers don't wait on it but lead a flight of their own
+	rows = run()
+	assert.True(inFlight())
+	assert.Nil(rows.Close())
+	assert.Equal(uint64(0), ic.Stats().Coalesced)
+	assert.Equal(uint64(3), ic.Stats().Misses)
+
+	// the leader landing late leaves later flights be
+	f, _ := ic.flights.join("other")
+	ic.flights.land("other", &flight{done: make(chan struct{})}, nil)
+	assert.True(inFlight())
+	ic.flights.land("other", f, nil)
+	assert.False(inFlight())
+	assert.Nil(leader.Close())
+}
+
+func TestMaxConcurrentMisses(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Once()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:               mCacher,
+		MaxConcurrentMisses: 1,
+		MissLimit:           MissLimitFail,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	qMock.ExpectQuery(query).WithArgs(18).WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+
+	limited := func() int {
+		ic.missLimiter.mu.Lock()
+		defer ic.missLimiter.mu.Unlock()
+		return len(ic.missLimiter.slots)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		rows, err := db.QueryContext(context.Background(), query, 18)
+		if err == nil {
+			for rows.Next() {
+			}
+			err = rows.Close()
+		}
+		done <- err
+	}()
+	for limited() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// different args, same fingerprint
+	_, err = db.QueryContext(context.Background(), query, 21)
+	assert.ErrorIs(err, ErrTooManyMisses)
+
+	assert.Nil(<-done)
+	assert.Nil(qMock.ExpectationsWereMet())
+	assert.Equal(0, limited())
+	assert.Equal(uint64(1), ic.Stats().Shed)
+
+	// waiting callers give up when their context is done
+	release, err := ic.missLimiter.acquire(context.Background(), "f", 1, false)
+	assert.Nil(err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ic.missLimiter.acquire(ctx, "f", 1, false)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	release()
+	assert.Equal(0, limited())
+}
+
+// lockCacher is a cache.Locker backed by a mocked cache.Cacher
+type lockCacher struct {
+	*mocks.Cacher
+	held     bool
+	hung     bool // unlocking blocks until ctx is done
+	unlocked int
+}
+
+func (l *lockCacher) TryLock(ctx context.Context, key string, ttl time.Duration) (func(context.Context) error, bool, error) {
+	if l.held {
+		return nil, false, nil
+	}
+	return func(ctx context.Context) error {
+		l.unlocked++
+		if l.hung {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}, true, nil
+}
+
+func TestLocker(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	lc := &lockCacher{Cacher: mCacher}
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:            lc,
+		LockTimeout:      100 * time.Millisecond,
+		LockPollInterval: time.Millisecond,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	// lock acquired; query is run and lock released once cached
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Once()
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Once()
+	runQuery(t, assert, qMock, db, query, true)
+	assert.Equal(1, lc.unlocked)
+
+	// lock held elsewhere; results show up in cache while polling
+	lc.held = true
+	item := &cache.Item{
+		Cols: []string{"name"},
+		Rows: [][]driver.Value{{"John"}, {"Lisa"}},
+	}
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Twice()
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(item, true, nil).Once()
+	runQuery(t, assert, qMock, db, query, false)
+	assert.Equal(uint64(0), ic.Stats().Hits)
+	assert.Equal(uint64(2), ic.Stats().Misses)
+
+	// lock held elsewhere; gives up after timeout and runs the query
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Once()
+	start := time.Now()
+	runQuery(t, assert, qMock, db, query, true)
+	assert.True(time.Since(start) >= 100*time.Millisecond)
+	assert.Equal(1, lc.unlocked)
+
+	// releasing a lock gives up after the lock timeout, when the lock
+	// has expired anyway
+	lc.held, lc.hung = false, true
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Once()
+	errs := ic.Stats().Errors
+	runQuery(t, assert, qMock, db, query, true)
+	assert.Equal(2, lc.unlocked)
+	assert.Equal(errs+1, ic.Stats().Errors)
+	assert.True(mCacher.AssertExpectations(t))
+}
+
+// hungCacher is a cache.Cacher whose operations block until ctx is done.
+type hungCacher struct{}
+
+func (hungCacher) Get(ctx context.Context, key string) (*cache.Item, bool, error) {
+	<-ctx.Done()
+	return nil, false, ctx.Err()
+}
+
+func (hungCacher) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestOpTimeouts(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	var errs []error
+	ic, _ := NewInterceptor(&Config{
+		Cache:      hungCacher{},
+		GetTimeout: 10 * time.Millisecond,
+		SetTimeout: 10 * time.Millisecond,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	runQuery(t, assert, qMock, db, query, true)
+
+	assert.Len(errs, 2)
+	assert.ErrorIs(errs[0], context.DeadlineExceeded)
+	assert.ErrorIs(errs[0], ErrCacheGet)
+	assert.Contains(errs[0].Error(), "Cache.Get failed")
+	assert.ErrorIs(errs[1], context.DeadlineExceeded)
+	assert.ErrorIs(errs[1], ErrCacheSet)
+	assert.Contains(errs[1].Error(), "Cache.Set failed")
+}
+
+func TestMinLookupBudget(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	ic, _ := NewInterceptor(&Config{
+		Cache:           mCacher,
+		MinLookupBudget: time.Second,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	// cache isn't used
+	qMock.ExpectQuery(query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	rows, err := db.QueryContext(ctx, query, 18)
+	assert.Nil(err)
+	assert.Nil(rows.Close())
+	assert.Nil(qMock.ExpectationsWereMet())
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipDeadline])
+
+	o := ic.Options()
+	o.FailFastOnDeadline = true
+	assert.Nil(ic.UpdateOptions(o))
+	_, err = db.QueryContext(ctx, query, 18)
+	assert.ErrorIs(err, ErrDeadlineTooShort)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.True(mCacher.AssertExpectations(t))
+}
+
+func TestNegativeCaching(t *testing.T) {
+	assert := require.New(t)
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name, age FROM users WHERE age > ?`
+	args := []driver.NamedValue{{Ordinal: 1, Value: 150}}
+	queryFn := func() (driver.Rows, error) {
+		return &seqRows{n: 0, cols: 2}, nil
+	}
+	drain := func(rows driver.Rows) {
+		// the recorder mustn't depend on columns being asked for
+		assert.Equal(io.EOF, rows.Next(nil))
+		assert.Nil(rows.Close())
+	}
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Once()
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.MatchedBy(func(item *cache.Item) bool {
+		return len(item.Cols) == 2 && len(item.Rows) == 0
+	}), 5*time.Second).Return(nil).Once()
+	ic, _ := NewInterceptor(&Config{
+		Cache:       mCacher,
+		NegativeTTL: 5 * time.Second,
+	})
+	rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, queryFn)
+	assert.Nil(err)
+	drain(rows)
+	assert.True(mCacher.AssertExpectations(t))
+
+	// empty results are served on a hit
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(&cache.Item{Cols: []string{"name", "age"}}, true, nil).Once()
+	rows, err = ic.intercept(context.Background(), ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+		t.Fatal("query run on hit")
+		return nil, nil
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"name", "age"}, rows.Columns())
+	drain(rows)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+
+	mCacher = new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Once()
+	ic, _ = NewInterceptor(&Config{
+		Cache:                  mCacher,
+		DisableNegativeCaching: true,
+	})
+	rows, err = ic.intercept(context.Background(), ic.prepare(query), args, false, nil, queryFn)
+	assert.Nil(err)
+	drain(rows)
+	assert.True(mCacher.AssertExpectations(t))
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipEmpty])
+}
+
+func TestGetAttrs(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Nil(getAttrs(`-- @cache-ttl 30
+		SELECT 1`))
+
+	attrs := getAttrs(`-- @cache-ttl 30
+		-- @cache-max-rows 0
+		SELECT 1`)
+	assert.Equal(&attributes{ttl: 30}, attrs)
+	assert.Nil(attrs.validate(ZeroTTLNoExpiry))
+
+	attrs = getAttrs(`-- @cache-ttl 0
+		-- @cache-max-rows 0
+		SELECT 1`)
+	assert.Nil(attrs.validate(ZeroTTLSkip))
+	assert.NotNil(attrs.validate(ZeroTTLNoExpiry))
+
+	// previously read as a max rows of zero
+	attrs = getAttrs(`-- @cache-ttl 30
+		-- @cache-ttl 60
+		-- @cache-max-rows 10
+		SELECT 1`)
+	assert.NotNil(attrs.validate(ZeroTTLSkip))
+
+	attrs = getAttrs(`-- @cache-ttl 99999999999999999999
+		-- @cache-max-rows 10
+		SELECT 1`)
+	assert.NotNil(attrs.validate(ZeroTTLSkip))
+
+	attrs = getAttrs(`/* @cache-ttl 30 @cache-max-rows 10 @cache-sample-rate 0.5 */ SELECT 1`)
+	assert.Nil(attrs.validate(ZeroTTLSkip))
+	assert.Equal(0.5, *attrs.sampleRate)
+
+	for _, rate := range []string{"1.5", "0.5.1", "0.5 @cache-sample-rate 0.5"} {
+		attrs = getAttrs(`-- @cache-ttl 30
+			-- @cache-max-rows 10
+			-- @cache-sample-rate ` + rate + `
+			SELECT 1`)
+		assert.NotNil(attrs.validate(ZeroTTLSkip), rate)
+	}
+}
+
+func TestZeroAttrs(t *testing.T) {
+	assert := require.New(t)
+
+	queryFn := func() (driver.Rows, error) {
+		return &seqRows{n: 100, cols: 1}, nil
+	}
+	drain := func(rows driver.Rows) {
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	_, err := NewInterceptor(&Config{Cache: new(mocks.Cacher), ZeroTTL: 5})
+	assert.NotNil(err)
+
+	// the results of queries with a TTL of zero aren't cached by default
+	ic, _ := NewInterceptor(&Config{Cache: new(mocks.Cacher)})
+	rows, err := ic.intercept(context.Background(), ic.prepare(`-- @cache-ttl 0
+		-- @cache-max-rows 10
+		SELECT id FROM users`), nil, false, nil, queryFn)
+	assert.Nil(err)
+	drain(rows)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipZeroTTL])
+
+	// max rows of zero is unlimited; TTL of zero is no expiry
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Once()
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.MatchedBy(func(item *cache.Item) bool {
+		return len(item.Rows) == 100
+	}), time.Duration(0)).Return(nil).Once()
+	ic, _ = NewInterceptor(&Config{Cache: mCacher, ZeroTTL: ZeroTTLNoExpiry})
+	rows, err = ic.intercept(context.Background(), ic.prepare(`-- @cache-ttl 0
+		-- @cache-max-rows 100
+		SELECT id FROM users`), nil, false, nil, queryFn)
+	assert.Nil(err)
+	drain(rows)
+	assert.True(mCacher.AssertExpectations(t))
+
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Once()
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.MatchedBy(func(item *cache.Item) bool {
+		return len(item.Rows) == 100
+	}), 30*time.Second).Return(nil).Once()
+	rows, err = ic.intercept(context.Background(), ic.prepare(`-- @cache-ttl 30
+		-- @cache-max-rows 0
+		SELECT id FROM users`), nil, false, nil, queryFn)
+	assert.Nil(err)
+	drain(rows)
+	assert.True(mCacher.AssertExpectations(t))
+
+	rows, err = ic.intercept(context.Background(), ic.prepare(`-- @cache-ttl 0
+		-- @cache-max-rows 0
+		SELECT id FROM users`), nil, false, nil, queryFn)
+	assert.Nil(err)
+	drain(rows)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipInvalidAttributes])
+}
+
+func TestKey(t *testing.T) {
+	assert := require.New(t)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:          new(mocks.Cacher),
+		NormalizeQuery: true,
+	})
+	query := `-- @cache-max-rows 10
+		-- @cache-ttl 30
+		SELECT name FROM users WHERE age > ?`
+	key, err := ic.Key(query, 18)
+	assert.Nil(err)
+	want, _ := defaultHashFunc(normalizeQuery(query), []driver.NamedValue{{Ordinal: 1, Value: int64(18)}})
+	assert.Equal(want, key)
+
+	_, err = ic.Key("SELECT 1", struct{}{})
+	assert.NotNil(err)
+}
+
+func TestTransformItem(t *testing.T) {
+	assert := require.New(t)
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	var errs []error
+	ic, err := NewInterceptor(&Config{
+		Cache:   mc,
+		OnError: func(err error) { errs = append(errs, err) },
+		TransformItem: func(query string, item *cache.Item) (*cache.Item, bool) {
+			switch len(item.Rows) {
+			case 1:
+				// redact the second column
+				out := &cache.Item{Cols: item.Cols}
+				for _, row := range item.Rows {
+					out.Rows = append(out.Rows, []driver.Value{row[0], nil})
+				}
+				return out, true
+			case 2:
+				return nil, false
+			case 3:
+				panic("boom")
+			}
+			return item, true
+		},
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT id, email FROM users WHERE id > ?`
+	run := func(n int) [][]driver.Value {
+		args := []driver.NamedValue{{Ordinal: 1, Value: int64(n)}}
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: n, cols: 2}, nil
+		})
+		assert.Nil(err)
+		var got [][]driver.Value
+		for {
+			dest := make([]driver.Value, 2)
+			if rows.Next(dest) != nil {
+				break
+			}
+			got = append(got, dest)
+		}
+		assert.Nil(rows.Close())
+		return got
+	}
+
+	// the caller gets the results as read from the database
+	assert.Equal([][]driver.Value{{int64(0), int64(1)}}, run(1))
+	key, err := ic.Key(query, 1)
+	assert.Nil(err)
+	item := mc.entries[key].Item
+	assert.Equal([][]driver.Value{{int64(0), nil}}, item.Rows)
+	assert.Equal(fingerprint(query), item.Fingerprint)
+	assert.False(item.CreatedAt.IsZero())
+	assert.Equal([][]driver.Value{{int64(0), nil}}, run(1))
+
+	run(2)
+	run(3)
+	assert.Len(mc.entries, 1)
+	assert.Equal(uint64(2), ic.Stats().SkipReasons[SkipTransformVeto])
+	assert.Len(errs, 1)
+	assert.True(errors.Is(errs[0], ErrPanic))
+
+	run(4)
+	assert.Len(mc.entries, 2)
+}
+
+func TestOnSet(t *testing.T) {
+	assert := require.New(t)
+
+	type set struct {
+		query string
+		args  []driver.NamedValue
+		key   string
+		item  *cache.Item
+	}
+	var (
+		sets []set
+		errs []error
+	)
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{
+		Cache:   mc,
+		OnError: func(err error) { errs = append(errs, err) },
+		OnSet: func(query string, args []driver.NamedValue, key string, item *cache.Item) {
+			sets = append(sets, set{query, args, key, item})
+		},
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT id, name FROM users WHERE id > ?`
+	run := func(n int) {
+		args := []driver.NamedValue{{Ordinal: 1, Value: int64(n)}}
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: n, cols: 2}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 2)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	run(2)
+	run(2) // hits aren't written
+	assert.Len(sets, 1)
+	key, err := ic.Key(query, 2)
+	assert.Nil(err)
+	assert.Equal(query, sets[0].query)
+	assert.Equal([]driver.NamedValue{{Ordinal: 1, Value: int64(2)}}, sets[0].args)
+	assert.Equal(key, sets[0].key)
+	assert.Same(mc.entries[key].Item, sets[0].item)
+	assert.Len(sets[0].item.Rows, 2)
+
+	ic.SetOnSet(func(string, []driver.NamedValue, string, *cache.Item) { panic("boom") })
+	run(3)
+	assert.Len(mc.entries, 2)
+	assert.Len(errs, 1)
+	assert.True(errors.Is(errs[0], ErrPanic))
+
+	ic.SetOnSet(nil)
+	run(4)
+	assert.Len(sets, 1)
+	assert.Len(errs, 1)
+}
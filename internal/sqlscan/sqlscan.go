@@ -0,0 +1,183 @@
+// Package sqlscan finds the comments and the quoted strings and
+// identifiers of queries, for sqlcache and its fingerprint package.
+package sqlscan
+
+import "strings"
+
+// Comment is the span of a comment of a query, including its delimiters.
+type Comment struct {
+	Start, End int
+	// Hint is set for optimizer hints, such as Oracle's /*+ ... */ and
+	// --+ ..., which the database parses.
+	Hint bool
+}
+
+// Span is the span of a quoted string or identifier of a query, including
+// its delimiters.
+type Span struct {
+	Start, End int
+}
+
+// Scan returns the comments of the query, outside of quoted strings and
+// identifiers, its quoted strings and identifiers, and the indexes of the
+// backslashes escaping the closing quotes of strings, as in E'O\'Brien'.
+// Besides the standard syntax, it knows of T-SQL bracketed identifiers,
+// Oracle's q'[...]' strings, PostgreSQL's E'...' strings, whose quotes may
+// be escaped by backslashes, and block comments nested as in T-SQL and
+// PostgreSQL; a block comment left unterminated by nesting ends at its
+// first */ instead, as in MySQL and Oracle. Backslashes escape quotes of
+// all strings delimited by ' or " when backslash is set, as in MySQL.
+func Scan(query string, backslash bool) (comments []Comment, literals []Span, escapes []int) {
+	for n := 0; n < len(query); {
+		c := query[n]
+		start := n
+		switch {
+		case c == '-' && strings.HasPrefix(query[n:], "--"):
+			end := strings.IndexByte(query[n:], '\n')
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += n
+			}
+			comments = append(comments, Comment{Start: n, End: end, Hint: strings.HasPrefix(query[n+2:], "+")})
+			n = end
+		case c == '/' && strings.HasPrefix(query[n:], "/*"):
+			end := blockCommentEnd(query, n)
+			comments = append(comments, Comment{Start: n, End: end, Hint: strings.HasPrefix(query[n+2:], "+")})
+			n = end
+		case backslash && (c == '\'' || c == '"'):
+			n, escapes = escapedQuotedEnd(query, n+1, c, escapes)
+			literals = append(literals, Span{start, n})
+		case c == '\'' || c == '"' || c == '`':
+			n = quotedEnd(query, n+1, c)
+			literals = append(literals, Span{start, n})
+		case c == '[':
+			n = quotedEnd(query, n+1, ']')
+			literals = append(literals, Span{start, n})
+		case isOracleQuote(query, n):
+			n = oracleQuotedEnd(query, n+2)
+			literals = append(literals, Span{start, n})
+		case isEscapeString(query, n):
+			n, escapes = escapedQuotedEnd(query, n+2, '\'', escapes)
+			literals = append(literals, Span{start, n})
+		default:
+			n++
+		}
+	}
+
+	return comments, literals, escapes
+}
+
+// blockCommentEnd returns the index just past the block comment starting
+// at n.
+func blockCommentEnd(query string, n int) int {
+	depth := 0
+	for i := n; i+1 < len(query); i++ {
+		switch {
+		case query[i] == '/' && query[i+1] == '*':
+			depth++
+			i++
+		case query[i] == '*' && query[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	if end := strings.Index(query[n+2:], "*/"); end >= 0 {
+		return n + 2 + end + 2
+	}
+
+	return len(query)
+}
+
+// quotedEnd returns the index just past the quoted string or identifier
+// whose text starts at n and which is closed by q. Closing quotes are
+// escaped by doubling them.
+func quotedEnd(query string, n int, q byte) int {
+	for i := n; i < len(query); i++ {
+		if query[i] != q {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == q {
+			i++
+			continue
+		}
+		return i + 1
+	}
+
+	return len(query)
+}
+
+// escapedQuotedEnd returns the index just past the quoted string whose
+// text starts at n and which is closed by q, like quotedEnd, for strings
+// whose characters may also be escaped by backslashes. The indexes of the
+// backslashes escaping q are appended to escapes.
+func escapedQuotedEnd(query string, n int, q byte, escapes []int) (int, []int) {
+	for i := n; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if i+1 < len(query) && query[i+1] == q {
+				escapes = append(escapes, i)
+			}
+			i++
+		case q:
+			if i+1 < len(query) && query[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1, escapes
+		}
+	}
+
+	return len(query), escapes
+}
+
+// isEscapeString reports whether a PostgreSQL escape string, such as
+// E'it\'s', starts at n.
+func isEscapeString(query string, n int) bool {
+	if c := query[n]; c != 'E' && c != 'e' || !strings.HasPrefix(query[n+1:], "'") {
+		return false
+	}
+
+	return n == 0 || !isIdentByte(query[n-1])
+}
+
+// isOracleQuote reports whether an Oracle alternative quoted string, such
+// as q'[it's]' or nq'[it's]', starts at n.
+func isOracleQuote(query string, n int) bool {
+	if c := query[n]; c != 'q' && c != 'Q' || !strings.HasPrefix(query[n+1:], "'") || len(query) < n+3 {
+		return false
+	}
+	if n > 0 && (query[n-1] == 'n' || query[n-1] == 'N') {
+		n--
+	}
+
+	return n == 0 || !isIdentByte(query[n-1])
+}
+
+// oracleQuotedEnd returns the index just past the Oracle alternative
+// quoted string, such as q'[it's]', whose delimiter is at n.
+func oracleQuotedEnd(query string, n int) int {
+	closing := query[n]
+	switch closing {
+	case '[':
+		closing = ']'
+	case '(':
+		closing = ')'
+	case '{':
+		closing = '}'
+	case '<':
+		closing = '>'
+	}
+	if end := strings.Index(query[n+1:], string(closing)+"'"); end >= 0 {
+		return n + 1 + end + 2
+	}
+
+	return len(query)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
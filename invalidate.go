@@ -0,0 +1,37 @@
+package sqlcache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// InvalidateQuery removes the cached results of the query with the
+// fingerprint, as reported by Fingerprints, QueryStats and events, for all
+// args at once, such as after a bulk update of the tables it reads. It
+// returns the number of entries removed from the backend, which must
+// implement cache.Indexer (see WithFingerprintIndex).
+//
+// Entries of the query in the L1 cache of this process are removed too,
+// but not those of other processes, which expire within Config.L1TTL.
+// Results written asynchronously with Config.AsyncSetWorkers may still be
+// written afterwards unless Flush is called first.
+func (i *Interceptor) InvalidateQuery(ctx context.Context, fingerprint string) (int, error) {
+	indexer, ok := cache.As[cache.Indexer](i.cacher())
+	if !ok {
+		return 0, errors.New("sqlcache: backend doesn't index keys by fingerprint")
+	}
+
+	if i.l1 != nil {
+		i.l1.deleteFingerprint(fingerprint)
+	}
+	n, err := indexer.DeleteFingerprint(ctx, fingerprint)
+	if err != nil {
+		return n, &Error{Kind: ErrCacheDelete, Op: "Cache.DeleteFingerprint", Err: err}
+	}
+	i.quota.releaseFingerprint(fingerprint)
+	i.emit(Event{Type: EventInvalidate, Fingerprint: fingerprint})
+
+	return n, nil
+}
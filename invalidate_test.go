@@ -0,0 +1,111 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyIndex(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Now()
+	x := newKeyIndex(func() time.Time { return now })
+
+	x.add("a", "f", time.Second)
+	x.add("b", "f", 0)
+	x.add("c", "g", time.Hour)
+	x.add("d", "", time.Hour)
+	// keys overwritten with results of another query move
+	x.add("c", "f", time.Hour)
+	x.remove("b")
+
+	now = now.Add(time.Second)
+	assert.ElementsMatch([]string{"c"}, x.take("f"))
+	assert.Empty(x.take("f"))
+	assert.Empty(x.take("g"))
+
+	// expired keys are swept
+	x.add("a", "f", time.Second)
+	x.add("b", "f", 0)
+	now = now.Add(keyIndexSweepInterval)
+	x.add("c", "g", 0)
+	assert.Equal(map[string]string{"b": "f", "c": "g"}, x.fps)
+}
+
+func TestInvalidateQuery(t *testing.T) {
+	assert := require.New(t)
+
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        1e4,
+		MaxCost:            1 << 30,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+	})
+	assert.Nil(err)
+	defer rc.Close()
+	ic, err := NewInterceptor(&Config{
+		Cache:  NewRistretto(rc, WithRistrettoFingerprintIndex()),
+		L1Size: 10,
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users WHERE id = ?`
+	other := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM accounts WHERE id = ?`
+	run := func(query string, id int64) {
+		args := []driver.NamedValue{{Ordinal: 1, Value: id}}
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+		rc.Wait()
+	}
+
+	for id := int64(1); id <= 3; id++ {
+		run(query, id)
+		run(query, id) // hit, added to L1
+	}
+	run(other, 1)
+	assert.Equal(uint64(3), ic.Stats().Hits)
+
+	n, err := ic.InvalidateQuery(context.Background(), fingerprint(query))
+	assert.Nil(err)
+	assert.Equal(3, n)
+	rc.Wait()
+
+	for id := int64(1); id <= 3; id++ {
+		run(query, id)
+	}
+	run(other, 1)
+	s := ic.Stats()
+	assert.Equal(uint64(4), s.Hits)
+	assert.Equal(uint64(7), s.Misses)
+
+	n, err = ic.InvalidateQuery(context.Background(), "unknown")
+	assert.Nil(err)
+	assert.Zero(n)
+
+	// the backend must index keys
+	ic, err = NewInterceptor(&Config{Cache: NewRistretto(rc)})
+	assert.Nil(err)
+	_, err = ic.InvalidateQuery(context.Background(), fingerprint(query))
+	assert.NotNil(err)
+	ic, err = NewInterceptor(&Config{Cache: &mapCacher{entries: make(map[string]cache.Entry)}})
+	assert.Nil(err)
+	_, err = ic.InvalidateQuery(context.Background(), fingerprint(query))
+	assert.NotNil(err)
+}
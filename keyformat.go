@@ -0,0 +1,165 @@
+package sqlcache
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"time"
+)
+
+// KeyFormatVersion is the version of the format of keys returned by the
+// default hash function and StrictHash, which is part of every key. It's
+// incremented whenever the keys of the same query and args change, so that
+// processes of different versions sharing a backend never read each other's
+// entries under a different meaning.
+//
+// Keys of version 1 are "v1:" followed by the hex encoded first 16 bytes of
+// the SHA-256 of:
+//
+//   - the length of the query as a little endian uint64, and the query;
+//   - for each arg, its ordinal as a little endian uint64, the length of its
+//     name as a little endian uint64 and the name, a byte tagging the type of
+//     its value and the value encoded as below.
+//
+// Values are encoded by type, with their tags in parentheses: nil (0) as
+// nothing, int64 (1) as little endian, uint64 (2) as little endian, float64
+// (3) as its little endian IEEE 754 bits, bool (4) as a byte of 0 or 1,
+// string (5) and []byte (6) by length, as a little endian uint64, followed by
+// their bytes, and time.Time (7) as its Unix seconds and nanoseconds, both
+// little endian int64, followed by its zone offset in seconds as a little
+// endian int64. Values of other types (8) are encoded as strings formatted
+// with "%T:%v", which may not be stable across Go versions; convert them to
+// one of the types above with driver.Valuer to get stable keys.
+//
+// Keys are therefore identical across architectures and dependencies'
+// versions; TestKeyFormatGolden pins them.
+const KeyFormatVersion = 1
+
+// Type tags of values in version 1 keys.
+const (
+	keyTagNil byte = iota
+	keyTagInt64
+	keyTagUint64
+	keyTagFloat64
+	keyTagBool
+	keyTagString
+	keyTagBytes
+	keyTagTime
+	keyTagOther
+)
+
+// keySize is the number of bytes of the SHA-256 kept in keys.
+const keySize = 16
+
+// StrictHash hashes the query and args like the default hash function but
+// without normalizing args, so that, for example, int32(1) and int64(1) or
+// []byte("a") and "a" hash differently. Keys are of the format described by
+// KeyFormatVersion; args of types other than those listed there are encoded
+// as strings.
+func StrictHash(query string, args []driver.NamedValue) (string, error) {
+	e := keyEncoder{h: sha256.New()}
+	e.writeString(query)
+
+	return e.sumArgs(args), nil
+}
+
+// strictQueryState returns the state of the hash of StrictHash once query
+// is written, so that the keys of its calls are computed without hashing
+// it again; see strictSumArgs.
+func strictQueryState(query string) []byte {
+	e := keyEncoder{h: sha256.New()}
+	e.writeString(query)
+	state, _ := e.h.(encoding.BinaryMarshaler).MarshalBinary()
+
+	return state
+}
+
+// strictSumArgs returns the key StrictHash returns for the query whose
+// hash state is state, as by strictQueryState, and args.
+func strictSumArgs(state []byte, args []driver.NamedValue) string {
+	e := keyEncoder{h: sha256.New()}
+	_ = e.h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+
+	return e.sumArgs(args)
+}
+
+// sumArgs writes args and returns the key.
+func (e *keyEncoder) sumArgs(args []driver.NamedValue) string {
+	for _, arg := range args {
+		e.writeUint(uint64(arg.Ordinal))
+		e.writeString(arg.Name)
+		e.writeValue(arg.Value)
+	}
+
+	var sum [sha256.Size]byte
+	var key [3 + 2*keySize]byte
+	copy(key[:], "v1:")
+	hex.Encode(key[3:], e.h.Sum(sum[:0])[:keySize])
+
+	return string(key[:])
+}
+
+type keyEncoder struct {
+	h   hash.Hash
+	buf [8]byte
+}
+
+func (e *keyEncoder) writeTag(tag byte) {
+	e.buf[0] = tag
+	_, _ = e.h.Write(e.buf[:1])
+}
+
+func (e *keyEncoder) writeUint(u uint64) {
+	binary.LittleEndian.PutUint64(e.buf[:], u)
+	_, _ = e.h.Write(e.buf[:])
+}
+
+func (e *keyEncoder) writeString(s string) {
+	e.writeUint(uint64(len(s)))
+	_, _ = e.h.Write([]byte(s))
+}
+
+func (e *keyEncoder) writeValue(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.writeTag(keyTagNil)
+	case int64:
+		e.writeTag(keyTagInt64)
+		e.writeUint(uint64(v))
+	case uint64:
+		e.writeTag(keyTagUint64)
+		e.writeUint(v)
+	case float64:
+		e.writeTag(keyTagFloat64)
+		e.writeUint(math.Float64bits(v))
+	case bool:
+		e.writeTag(keyTagBool)
+		if v {
+			e.buf[0] = 1
+		} else {
+			e.buf[0] = 0
+		}
+		_, _ = e.h.Write(e.buf[:1])
+	case string:
+		e.writeTag(keyTagString)
+		e.writeString(v)
+	case []byte:
+		e.writeTag(keyTagBytes)
+		e.writeUint(uint64(len(v)))
+		_, _ = e.h.Write(v)
+	case time.Time:
+		_, offset := v.Zone()
+		e.writeTag(keyTagTime)
+		e.writeUint(uint64(v.Unix()))
+		e.writeUint(uint64(v.Nanosecond()))
+		e.writeUint(uint64(int64(offset)))
+	default:
+		e.writeTag(keyTagOther)
+		e.writeString(fmt.Sprintf("%T:%v", v, v))
+	}
+}
@@ -0,0 +1,77 @@
+package sqlcache
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestKeyFormatGolden pins keys of the format described by
+// KeyFormatVersion. If it fails, keys changed and KeyFormatVersion must be
+// incremented along with the golden vectors.
+func TestKeyFormatGolden(t *testing.T) {
+	assert := require.New(t)
+
+	ts := time.Unix(1700000000, 5)
+	tcs := []struct {
+		fn    func(string, []driver.NamedValue) (string, error)
+		query string
+		args  []driver.NamedValue
+		key   string
+	}{
+		{
+			fn:    defaultHashFunc,
+			query: "SELECT 1",
+			key:   "v1:09b7bf2b19ceac419ff40c8cbe965d0c",
+		},
+		{
+			fn:    defaultHashFunc,
+			query: "SELECT name FROM users WHERE age > $1 AND name = $2",
+			args: []driver.NamedValue{
+				{Ordinal: 1, Value: int32(18)},
+				{Ordinal: 2, Value: []byte("John")},
+			},
+			key: "v1:56c74e7b941cd3e9ed17ae42c858e7f5",
+		},
+		{
+			fn:    StrictHash,
+			query: "SELECT ?",
+			args: []driver.NamedValue{
+				{Ordinal: 1, Value: nil},
+				{Ordinal: 2, Value: 0.5},
+				{Ordinal: 3, Value: true},
+				{Ordinal: 4, Value: []byte("ab")},
+				{Ordinal: 5, Value: uint64(1 << 63)},
+				{Ordinal: 6, Value: ts.UTC()},
+				{Ordinal: 7, Name: "n", Value: int32(7)},
+			},
+			key: "v1:0676aca4d946728aff8454dc7c41d2c2",
+		},
+		{
+			fn:    StrictHash,
+			query: "SELECT ?",
+			args: []driver.NamedValue{
+				{Ordinal: 1, Value: ts.In(time.FixedZone("IST", 19800))},
+			},
+			key: "v1:4dbf9dcd9dfb8fae7c612f5284ba449c",
+		},
+	}
+
+	for _, tc := range tcs {
+		key, err := tc.fn(tc.query, tc.args)
+		assert.Nil(err)
+		assert.Equal(tc.key, key, tc.args)
+
+		// keys of prepared statements are computed from the hash state
+		// of their text
+		strict, normalized := isStrictHash(tc.fn)
+		assert.True(strict)
+		args := tc.args
+		if normalized {
+			args = normalizeArgs(args)
+		}
+		assert.Equal(tc.key, strictSumArgs(strictQueryState(tc.query), args), tc.args)
+	}
+}
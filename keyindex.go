@@ -0,0 +1,109 @@
+package sqlcache
+
+import (
+	"sync"
+	"time"
+)
+
+// keyIndexSweepInterval is how often expired keys are removed from a
+// keyIndex.
+const keyIndexSweepInterval = time.Minute
+
+// keyIndex indexes keys by the fingerprint of the query whose results they
+// hold, for backends that can't maintain the index themselves.
+type keyIndex struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	keys      map[string]map[string]time.Time // fingerprint -> key -> expiry, zero if none
+	fps       map[string]string               // key -> fingerprint
+	lastSweep time.Time
+}
+
+func newKeyIndex(now func() time.Time) *keyIndex {
+	return &keyIndex{
+		now:       now,
+		keys:      make(map[string]map[string]time.Time),
+		fps:       make(map[string]string),
+		lastSweep: now(),
+	}
+}
+
+// add indexes key, which holds results of the query with fingerprint for
+// ttl.
+func (x *keyIndex) add(key, fingerprint string, ttl time.Duration) {
+	if fingerprint == "" {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeLocked(key)
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = x.now().Add(ttl)
+	}
+	keys, ok := x.keys[fingerprint]
+	if !ok {
+		keys = make(map[string]time.Time)
+		x.keys[fingerprint] = keys
+	}
+	keys[key] = expiry
+	x.fps[key] = fingerprint
+	x.sweep()
+}
+
+// remove removes key from the index.
+func (x *keyIndex) remove(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeLocked(key)
+}
+
+// take removes the keys of fingerprint from the index and returns those
+// that haven't expired.
+func (x *keyIndex) take(fingerprint string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	now := x.now()
+	var live []string
+	for key, expiry := range x.keys[fingerprint] {
+		if expiry.IsZero() || now.Before(expiry) {
+			live = append(live, key)
+		}
+		delete(x.fps, key)
+	}
+	delete(x.keys, fingerprint)
+
+	return live
+}
+
+// removeLocked removes key from the index. x.mu must be held.
+func (x *keyIndex) removeLocked(key string) {
+	fp, ok := x.fps[key]
+	if !ok {
+		return
+	}
+	delete(x.fps, key)
+	delete(x.keys[fp], key)
+	if len(x.keys[fp]) == 0 {
+		delete(x.keys, fp)
+	}
+}
+
+// sweep removes expired keys, at most once per keyIndexSweepInterval.
+// x.mu must be held.
+func (x *keyIndex) sweep() {
+	now := x.now()
+	if now.Sub(x.lastSweep) < keyIndexSweepInterval {
+		return
+	}
+	x.lastSweep = now
+	for key, fp := range x.fps {
+		if expiry := x.keys[fp][key]; !expiry.IsZero() && !now.Before(expiry) {
+			x.removeLocked(key)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package sqlcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const defaultL1TTL = 2 * time.Second
+
+// l1Cache is a tiny in-process LRU cache of items, consulted before the
+// backend. Items are only added on a backend hit so that it ends up
+// holding the hottest keys.
+type l1Cache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type l1Entry struct {
+	key     string
+	item    *cache.Item
+	expires time.Time
+}
+
+func newL1Cache(size int, ttl time.Duration, now func() time.Time) *l1Cache {
+	return &l1Cache{
+		size:  size,
+		ttl:   ttl,
+		now:   now,
+		lru:   list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *l1Cache) get(key string) (*cache.Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*l1Entry)
+	if !c.now().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+
+	return e.item, true
+}
+
+// deleteFingerprint removes the items of the query with fingerprint.
+func (c *l1Cache) deleteFingerprint(fingerprint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if el.Value.(*l1Entry).item.Fingerprint == fingerprint {
+			c.lru.Remove(el)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *l1Cache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.lru.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+func (c *l1Cache) set(key string, item *cache.Item) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*l1Entry)
+		e.item, e.expires = item, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&l1Entry{key, item, expires})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*l1Entry).key)
+	}
+}
@@ -0,0 +1,143 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestL1Cache(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Now()
+	c := newL1Cache(2, time.Second, time.Now)
+	c.now = func() time.Time { return now }
+
+	a, b := &cache.Item{Fingerprint: "a"}, &cache.Item{Fingerprint: "b"}
+	c.set("a", a)
+	c.set("b", b)
+
+	item, ok := c.get("a")
+	assert.True(ok)
+	assert.Equal(a, item)
+
+	// "b" is the least recently used
+	c.set("c", &cache.Item{})
+	_, ok = c.get("b")
+	assert.False(ok)
+	_, ok = c.get("a")
+	assert.True(ok)
+
+	now = now.Add(time.Second)
+	_, ok = c.get("a")
+	assert.False(ok)
+	assert.Equal(1, c.lru.Len())
+	assert.Len(c.items, 1)
+}
+
+func TestL1Hits(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	item := &cache.Item{
+		Cols: []string{"name"},
+		Rows: [][]driver.Value{{"John"}, {"Lisa"}},
+	}
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(item, true, nil).Once()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:  mCacher,
+		L1Size: 10,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	for n := 0; n < 3; n++ {
+		runQuery(t, assert, qMock, db, query, false)
+	}
+	assert.True(mCacher.AssertExpectations(t))
+
+	s := ic.Stats()
+	assert.Equal(uint64(3), s.Hits)
+	assert.Equal(uint64(2), s.L1Hits)
+}
+
+func TestL1HitsStream(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	item := &cache.Item{
+		Cols: []string{"name"},
+		Rows: [][]driver.Value{{"John"}, {"Lisa"}},
+	}
+	// items of cache.StreamGetter backends are got in full to be added to
+	// the L1 cache
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(item, true, nil).Once()
+	sc := &countingStreamCacher{streamCacher: streamCacher{mCacher}}
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:  sc,
+		L1Size: 10,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	for n := 0; n < 3; n++ {
+		runQuery(t, assert, qMock, db, query, false)
+	}
+	assert.True(mCacher.AssertExpectations(t))
+	assert.Zero(sc.streams)
+
+	s := ic.Stats()
+	assert.Equal(uint64(3), s.Hits)
+	assert.Equal(uint64(2), s.L1Hits)
+}
+
+// countingStreamCacher counts the calls to GetStream.
+type countingStreamCacher struct {
+	streamCacher
+	streams int
+}
+
+func (s *countingStreamCacher) GetStream(ctx context.Context, key string) (cache.RowsReader, bool, error) {
+	s.streams++
+	return s.streamCacher.GetStream(ctx, key)
+}
@@ -0,0 +1,50 @@
+package sqlcache
+
+import (
+	"sort"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of buckets of latency histograms.
+var latencyBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// LatencyHistogram is a distribution of latencies.
+type LatencyHistogram struct {
+	// Bounds are the inclusive upper bounds of the buckets, in increasing
+	// order.
+	Bounds []time.Duration
+	// Counts holds the number of observations in each bucket. It has one
+	// more element than Bounds, which counts observations greater than the
+	// last bound.
+	Counts []uint64
+}
+
+func newLatencyHistogram(counts []uint64) LatencyHistogram {
+	return LatencyHistogram{
+		Bounds: append([]time.Duration(nil), latencyBuckets[:]...),
+		Counts: append([]uint64(nil), counts...),
+	}
+}
+
+// latencyBucket returns the index of the bucket d falls in.
+func latencyBucket(d time.Duration) int {
+	return sort.Search(len(latencyBuckets), func(n int) bool {
+		return d <= latencyBuckets[n]
+	})
+}
@@ -0,0 +1,76 @@
+package sqlcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const defaultLockPollInterval = 50 * time.Millisecond
+
+// lockOrWait tries to acquire the backend lock on the key of the query
+// which has missed the cache. When acquired, a function releasing the lock
+// is returned. Otherwise, the cache is polled for the results of the query
+// run by the lock holder until LockTimeout and the item is returned if it
+// shows up, which is counted as the miss checkCache counted rather than as
+// a hit too, like results shared by coalesced queries. Both return values
+// are nil when the caller should run the query without holding the lock.
+func (i *Interceptor) lockOrWait(ctx context.Context, q *queryInfo, locker cache.Locker) (*cache.Item, func()) {
+	unlock, ok, err := locker.TryLock(ctx, q.key, i.lockTimeout)
+	if err != nil {
+		i.reportErr(ctx, q, &Error{Kind: ErrLock, Op: "Locker.TryLock", Key: q.key, Err: err})
+		return nil, nil
+	}
+	if ok {
+		return nil, func() {
+			// the query's context may be done by the time rows are closed;
+			// past the lock timeout, the lock has expired anyway
+			timeout := i.options().SetTimeout
+			if timeout <= 0 || timeout > i.lockTimeout {
+				timeout = i.lockTimeout
+			}
+			unlockCtx, cancel := withTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := unlock(unlockCtx); err != nil {
+				i.reportErr(ctx, q, &Error{Kind: ErrLock, Op: "Locker unlock", Key: q.key, Err: err})
+			}
+		}
+	}
+	// results being refreshed ahead of their soft TTL are still fresh
+	if q.stale != nil && i.fresh(q.stale) {
+		return q.stale, nil
+	}
+
+	timeout, stopTimeout := i.clock.NewTimer(i.lockTimeout)
+	defer stopTimeout()
+
+	for {
+		poll, stopPoll := i.clock.NewTimer(i.lockPoll)
+		select {
+		case <-poll:
+		case <-timeout:
+			stopPoll()
+			return nil, nil
+		case <-ctx.Done():
+			stopPoll()
+			return nil, nil
+		}
+
+		start := time.Now()
+		getCtx, cancel := withTimeout(ctx, i.options().GetTimeout)
+		item, ok, err := i.cacher().Get(getCtx, q.key)
+		cancel()
+		d := time.Since(start)
+		i.observeOp(ctx, opGet, q.key, d, err)
+		if err != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrCacheGet, Op: "Cache.Get", Key: q.key, Err: err})
+			return nil, nil
+		}
+		// results past their soft TTL are those the lock holder refreshes
+		if ok && i.fresh(item) && i.verify(ctx, q, item) {
+			i.servedHitInfo(ctx, q, item, false)
+			return item, nil
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package sqlcache
+
+import (
+	"context"
+)
+
+// LogLevel is the severity of a log event.
+type LogLevel int
+
+// Log levels, ordered by increasing severity. The values match those of
+// log/slog.
+const (
+	LevelDebug LogLevel = -4
+	LevelInfo  LogLevel = 0
+	LevelWarn  LogLevel = 4
+	LevelError LogLevel = 8
+)
+
+// Logger receives leveled, structured events from the interceptor such as
+// errors, slow cache operations and the reason query results weren't cached.
+// The args are alternating key-value pairs, as accepted by log/slog.
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, msg string, args ...interface{})
+}
+
+func (i *Interceptor) log(ctx context.Context, level LogLevel, msg string, args ...interface{}) {
+	if i.logger == nil {
+		return
+	}
+
+	// a panicking Logger can only be accounted for
+	defer func() {
+		if v := recover(); v != nil {
+			i.panicked(v)
+		}
+	}()
+	i.logger.Log(ctx, level, msg, args...)
+}
@@ -0,0 +1,22 @@
+//go:build go1.21
+
+package sqlcache
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger returns a Logger that writes events to the provided
+// *slog.Logger.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l}
+}
+
+func (s *slogLogger) Log(ctx context.Context, level LogLevel, msg string, args ...interface{}) {
+	s.l.Log(ctx, slog.Level(level), msg, args...)
+}
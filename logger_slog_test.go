@@ -0,0 +1,24 @@
+//go:build go1.21
+
+package sqlcache
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLogger(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	l := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	l.Log(context.Background(), LevelDebug, "dropped")
+	l.Log(context.Background(), LevelWarn, "slow", "op", "get")
+
+	assert.Equal("level=WARN msg=slow op=get\n", buf.String()[bytes.IndexByte(buf.Bytes(), ' ')+1:])
+}
@@ -0,0 +1,148 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type logEvent struct {
+	level LogLevel
+	msg   string
+	args  []interface{}
+}
+
+type testLogger struct {
+	events []logEvent
+}
+
+func (l *testLogger) Log(ctx context.Context, level LogLevel, msg string, args ...interface{}) {
+	l.events = append(l.events, logEvent{level, msg, args})
+}
+
+func TestLogger(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).
+		After(2 * time.Millisecond)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).
+		Return(errors.New("some error"))
+
+	logger := new(testLogger)
+	ic, _ := NewInterceptor(&Config{
+		Cache:           mCacher,
+		Logger:          logger,
+		SlowOpThreshold: time.Millisecond,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	runQuery(t, assert, qMock, db, `SELECT name FROM users WHERE age > ?`, true)
+	runQuery(t, assert, qMock, db, `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`, true)
+
+	var levels []LogLevel
+	for _, e := range logger.events {
+		levels = append(levels, e.level)
+	}
+	// no attributes, slow Get, failed Set, skip due to failed Set
+	assert.Equal([]LogLevel{LevelDebug, LevelWarn, LevelError, LevelInfo}, levels)
+	assert.Equal("sqlcache: slow cache operation", logger.events[1].msg)
+	assert.Contains(logger.events[2].args, "error")
+}
+
+func TestAuditSets(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil)
+
+	logger := new(testLogger)
+	ic, _ := NewInterceptor(&Config{
+		Cache:     mCacher,
+		Logger:    logger,
+		AuditSets: true,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	runQuery(t, assert, qMock, db, query, true)
+
+	assert.Len(logger.events, 1)
+	e := logger.events[0]
+	assert.Equal(LevelInfo, e.level)
+	assert.Equal("sqlcache: query result cached", e.msg)
+
+	args := make(map[interface{}]interface{})
+	for n := 0; n+1 < len(e.args); n += 2 {
+		args[e.args[n]] = e.args[n+1]
+	}
+	assert.Equal(fingerprint(query), args["fingerprint"])
+	assert.NotEmpty(args["key"])
+	assert.Equal(2, args["rows"])
+	assert.Greater(args["bytes"], 0)
+	assert.Equal(30*time.Second, args["ttl"])
+
+	// without a Logger, items aren't encoded to be measured
+	ic, err = NewInterceptor(&Config{
+		Cache:     mCacher,
+		Codec:     failingCodec{},
+		AuditSets: true,
+	})
+	assert.Nil(err)
+	driverName = fmt.Sprintf("mockdriver:%s:nologger", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err = sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+	runQuery(t, assert, qMock, db, query, true)
+	assert.Equal(uint64(1), ic.Stats().Sets)
+	assert.Equal(uint64(0), ic.Stats().Errors)
+}
+
+// failingCodec fails to encode and decode items.
+type failingCodec struct{}
+
+func (failingCodec) Marshal(*cache.Item) ([]byte, error) {
+	return nil, errors.New("failingCodec")
+}
+
+func (failingCodec) Unmarshal([]byte, *cache.Item) error {
+	return errors.New("failingCodec")
+}
@@ -0,0 +1,49 @@
+package sqlcache
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// MemoryBudget returns fraction of the memory limit of the process's
+// cgroup (v1 or v2), or fallback when there's no such limit or it can't be
+// read, such as outside Linux. It's meant for sizing the MaxCost of an
+// in-memory backend using WithByteSizeCost, so that the cache can't grow
+// the process past the limit of its container:
+//
+//	MaxCost: sqlcache.MemoryBudget(0.25, 256<<20),
+func MemoryBudget(fraction float64, fallback int64) int64 {
+	limit, ok := cgroupMemoryLimit(cgroupRoot)
+	if !ok {
+		return fallback
+	}
+
+	return int64(fraction * float64(limit))
+}
+
+// cgroupMemoryLimit returns the memory limit in bytes of the cgroup
+// filesystem mounted at root.
+func cgroupMemoryLimit(root string) (int64, bool) {
+	for _, path := range []string{
+		filepath.Join(root, "memory.max"),                      // v2
+		filepath.Join(root, "memory", "memory.limit_in_bytes"), // v1
+	} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// "max" in v2, a huge value in v1 when unlimited
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil || limit <= 0 || limit >= 1<<62 {
+			return 0, false
+		}
+		return limit, true
+	}
+
+	return 0, false
+}
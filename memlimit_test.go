@@ -0,0 +1,42 @@
+package sqlcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupMemoryLimit(t *testing.T) {
+	assert := require.New(t)
+
+	write := func(path, content string) {
+		assert.Nil(os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.Nil(os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	// no cgroup filesystem
+	_, ok := cgroupMemoryLimit(t.TempDir())
+	assert.False(ok)
+
+	v2 := t.TempDir()
+	write(filepath.Join(v2, "memory.max"), "max\n")
+	_, ok = cgroupMemoryLimit(v2)
+	assert.False(ok)
+	write(filepath.Join(v2, "memory.max"), "536870912\n")
+	limit, ok := cgroupMemoryLimit(v2)
+	assert.True(ok)
+	assert.Equal(int64(512<<20), limit)
+
+	v1 := t.TempDir()
+	write(filepath.Join(v1, "memory", "memory.limit_in_bytes"), "9223372036854771712\n")
+	_, ok = cgroupMemoryLimit(v1)
+	assert.False(ok)
+	write(filepath.Join(v1, "memory", "memory.limit_in_bytes"), "1073741824\n")
+	limit, ok = cgroupMemoryLimit(v1)
+	assert.True(ok)
+	assert.Equal(int64(1<<30), limit)
+
+	assert.Greater(MemoryBudget(0.25, 100), int64(0))
+}
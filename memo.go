@@ -0,0 +1,138 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// memoDrain is the budget for reading rows left unread, such as by
+// QueryRow, so that results are memoized.
+var memoDrain = &DrainBudget{MaxDuration: defaultDrainMaxDuration}
+
+type requestMemoKey struct{}
+
+// requestMemo holds the results of queries run with a context returned by
+// WithRequestMemo, by query and arguments.
+type requestMemo struct {
+	mu    sync.Mutex
+	items map[string]*cache.Item
+}
+
+// WithRequestMemo returns a context that memoizes the results of read
+// queries run with it, with or without cache attributes, so that the same
+// lookup repeated within a single request, as in the N+1 pattern, runs
+// once. It's meant to be derived from the context of a request and passed
+// to the queries the request runs. Results are kept in memory, for as long
+// as the context is referenced, once all their rows are read; rows left
+// unread, as by QueryRow, are read on Close within 100ms.
+//
+// Any other statement run with the context, such as an UPDATE or an
+// INSERT ... RETURNING, empties the memo so that later reads see its
+// effects. Queries within transactions aren't memoized. Writes made
+// elsewhere aren't seen by memoized queries, so the memo should only be
+// used for short-lived contexts.
+func WithRequestMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestMemoKey{}, &requestMemo{items: make(map[string]*cache.Item)})
+}
+
+func requestMemoFrom(ctx context.Context) *requestMemo {
+	m, _ := ctx.Value(requestMemoKey{}).(*requestMemo)
+	return m
+}
+
+func (m *requestMemo) get(key string) *cache.Item {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.items[key]
+}
+
+func (m *requestMemo) set(key string, item *cache.Item) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[key] = item
+}
+
+func (m *requestMemo) clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items = make(map[string]*cache.Item)
+}
+
+// memoize serves the query from the request memo when possible, and
+// otherwise runs it using next, recording the rows returned.
+func (i *Interceptor) memoize(ctx context.Context, m *requestMemo, p *preparedQuery, args []driver.NamedValue, inTx bool, next func() (driver.Rows, error)) (driver.Rows, error) {
+	if inTx {
+		return next()
+	}
+	if (p.attrs != nil && p.write) || (p.attrs == nil && !i.isRead(p.text)) {
+		m.clear()
+		return next()
+	}
+
+	key, err := XXHash(p.query, args)
+	if err != nil {
+		return next()
+	}
+	if item := m.get(key); item != nil {
+		atomic.AddUint64(&i.stats.MemoHits, 1)
+		recordHitInfo(ctx, func(h *HitInfo) { h.Memoized = true })
+		return newRowsCached(ctx, item, true), nil
+	}
+
+	rows, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	rr := newRowsRecorder(ctx, func(item *cache.Item) {
+		m.set(key, item)
+	}, func(SkipReason) {}, rows, 0)
+	rr.drain = memoDrain
+	return rr, nil
+}
+
+// ConnExecContext intercepts database/sql's DB.ExecContext and
+// Conn.ExecContext calls to empty the request memo, if any, to detect DDL
+// statements when Config.InvalidateOnDDL is set and to commit the table
+// changes of statements run outside of transactions.
+func (i *Interceptor) ConnExecContext(ctx context.Context, conn driver.ExecerContext, query string, args []driver.NamedValue) (driver.Result, error) {
+	if m := requestMemoFrom(ctx); m != nil {
+		m.clear()
+	}
+
+	res, err := conn.ExecContext(ctx, query, args)
+	if err == nil && i.invalidateOnDDL {
+		i.observeDDL(ctx, query)
+	}
+	if i.changes != nil && !i.inTx(conn) {
+		i.commitChanges(unwrapParent(conn, "Conn"))
+	}
+	return res, err
+}
+
+// StmtExecContext intercepts database/sql's Stmt.ExecContext calls to
+// empty the request memo, if any, to detect DDL statements and to commit
+// table changes.
+func (i *Interceptor) StmtExecContext(ctx context.Context, stmt driver.StmtExecContext, query string, args []driver.NamedValue) (driver.Result, error) {
+	if m := requestMemoFrom(ctx); m != nil {
+		m.clear()
+	}
+
+	res, err := stmt.ExecContext(ctx, args)
+	if err == nil && i.invalidateOnDDL {
+		i.observeDDL(ctx, query)
+	}
+	if i.changes != nil {
+		if st, _ := i.stmtState(stmt); st != nil && !st.inTx {
+			i.commitChanges(st.conn)
+		}
+	}
+	return res, err
+}
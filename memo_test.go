@@ -0,0 +1,82 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestMemo(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	ic, err := NewInterceptor(&Config{Cache: new(mocks.Cacher), DisableTxCaching: true})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `SELECT name FROM users WHERE id = ?`
+	lookup := func(ctx context.Context, id int, miss bool) {
+		if miss {
+			qMock.ExpectQuery(query).WithArgs(id).
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(fmt.Sprint("user", id)))
+		}
+		var name string
+		assert.Nil(db.QueryRowContext(ctx, query, id).Scan(&name))
+		assert.Equal(fmt.Sprint("user", id), name)
+		assert.Nil(qMock.ExpectationsWereMet())
+	}
+
+	ctx := WithRequestMemo(context.Background())
+	lookup(ctx, 1, true)
+	lookup(ctx, 1, false)
+	lookup(ctx, 2, true)
+	lookup(ctx, 2, false)
+	assert.Equal(uint64(2), ic.Stats().MemoHits)
+
+	// other requests don't share the memo
+	lookup(WithRequestMemo(context.Background()), 1, true)
+	lookup(context.Background(), 1, true)
+
+	// writes empty the memo
+	qMock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err = db.ExecContext(ctx, "UPDATE users SET name = 'x'")
+	assert.Nil(err)
+	lookup(ctx, 1, true)
+	lookup(ctx, 1, false)
+
+	returning := `INSERT INTO users (name) VALUES ('y') RETURNING id`
+	qMock.ExpectQuery("INSERT INTO users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	rows, err := db.QueryContext(ctx, returning)
+	assert.Nil(err)
+	assert.Nil(rows.Close())
+	lookup(ctx, 1, true)
+
+	// queries within transactions aren't memoized
+	qMock.ExpectBegin()
+	tx, err := db.BeginTx(ctx, nil)
+	assert.Nil(err)
+	for n := 0; n < 2; n++ {
+		qMock.ExpectQuery(query).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("user1"))
+		var name string
+		assert.Nil(tx.QueryRowContext(ctx, query, 1).Scan(&name))
+	}
+	qMock.ExpectCommit()
+	assert.Nil(tx.Commit())
+	assert.Nil(qMock.ExpectationsWereMet())
+	assert.Equal(uint64(3), ic.Stats().MemoHits)
+}
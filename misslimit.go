@@ -0,0 +1,79 @@
+package sqlcache
+
+import (
+	"context"
+	"sync"
+)
+
+// MissLimitPolicy is what callers beyond Config.MaxConcurrentMisses do.
+type MissLimitPolicy int
+
+const (
+	// MissLimitWait makes callers wait for a slot.
+	MissLimitWait MissLimitPolicy = iota
+	// MissLimitStale serves callers the results of the query past their
+	// TTL, kept as per Config.StaleBudget, if any, and makes them wait for
+	// a slot otherwise.
+	MissLimitStale
+	// MissLimitFail makes callers fail immediately with ErrTooManyMisses.
+	MissLimitFail
+)
+
+// missLimiter limits the number of concurrent cache misses of each query
+// fingerprint that run against the database.
+type missLimiter struct {
+	mu    sync.Mutex
+	slots map[string]*missSlots
+}
+
+type missSlots struct {
+	ch   chan struct{}
+	refs int
+}
+
+// acquire takes one of limit slots of the fingerprint, waiting for one to
+// free up unless failFast is set. The returned function frees the slot.
+func (l *missLimiter) acquire(ctx context.Context, fp string, limit int, failFast bool) (func(), error) {
+	l.mu.Lock()
+	if l.slots == nil {
+		l.slots = make(map[string]*missSlots)
+	}
+	s, ok := l.slots[fp]
+	if !ok {
+		s = &missSlots{ch: make(chan struct{}, limit)}
+		l.slots[fp] = s
+	}
+	s.refs++
+	l.mu.Unlock()
+
+	if failFast {
+		select {
+		case s.ch <- struct{}{}:
+		default:
+			l.put(fp, s)
+			return nil, ErrTooManyMisses
+		}
+	} else {
+		select {
+		case s.ch <- struct{}{}:
+		case <-ctx.Done():
+			l.put(fp, s)
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		<-s.ch
+		l.put(fp, s)
+	}, nil
+}
+
+// put drops a reference to the slots, removing them once unused.
+func (l *missLimiter) put(fp string, s *missSlots) {
+	l.mu.Lock()
+	s.refs--
+	if s.refs == 0 {
+		delete(l.slots, fp)
+	}
+	l.mu.Unlock()
+}
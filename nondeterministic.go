@@ -0,0 +1,60 @@
+package sqlcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrNonDeterministic is wrapped by the error reported to Config.OnError
+// for queries calling non-deterministic functions when
+// Config.NonDeterministic is NonDeterministicWarn.
+var ErrNonDeterministic = errors.New("sqlcache: query calls a non-deterministic function")
+
+// NonDeterministicPolicy is what's done with queries that have cache
+// attributes but call functions such as NOW() or RANDOM(), whose results
+// change on every execution.
+type NonDeterministicPolicy int
+
+const (
+	// NonDeterministicAllow caches such queries like any other.
+	NonDeterministicAllow NonDeterministicPolicy = iota
+	// NonDeterministicWarn caches such queries but reports them, once per
+	// fingerprint, to Config.OnError and Logger.
+	NonDeterministicWarn
+	// NonDeterministicSkip doesn't cache such queries.
+	NonDeterministicSkip
+)
+
+var (
+	// nonDeterministicRegexp matches calls of common non-deterministic
+	// functions of PostgreSQL, MySQL, SQLite, SQL Server and Oracle.
+	nonDeterministicRegexp = regexp.MustCompile(`(?i)\b(?:` +
+		`(?:now|sysdate|getdate|getutcdate|sysdatetime|curdate|curtime|utc_timestamp|` +
+		`clock_timestamp|statement_timestamp|transaction_timestamp|timeofday|` +
+		`random|rand|newid|uuid|uuid_short|gen_random_uuid|uuid_generate_v[14]|sys_guid|nextval)\s*\(` +
+		`|unix_timestamp\s*\(\s*\)` +
+		`|current_timestamp|current_date|current_time|localtimestamp|localtime` +
+		`)`)
+)
+
+// nonDeterministicCall returns the first call of a non-deterministic
+// function in the query, outside of comments, or an empty string.
+func nonDeterministicCall(query string) string {
+	m := nonDeterministicRegexp.FindString(stripComments(query))
+	return strings.TrimRight(m, " \t\n(")
+}
+
+// warnNonDeterministic reports the query, once per fingerprint.
+func (i *Interceptor) warnNonDeterministic(ctx context.Context, fp, fn string) {
+	if _, warned := i.nonDetWarned.LoadOrStore(fp, struct{}{}); warned {
+		return
+	}
+
+	err := fmt.Errorf("%w: %s", ErrNonDeterministic, fn)
+	i.notifyErr(ctx, err)
+	i.log(ctx, LevelWarn, "sqlcache: caching results of non-deterministic query",
+		"fingerprint", fp, "function", fn)
+}
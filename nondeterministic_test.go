@@ -0,0 +1,78 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonDeterministicCall(t *testing.T) {
+	assert := require.New(t)
+
+	tests := map[string]string{
+		"SELECT * FROM events WHERE at > NOW() - interval '1 day'": "NOW",
+		"SELECT * FROM events WHERE at > now ()":                   "now",
+		"SELECT CURRENT_TIMESTAMP":                                 "CURRENT_TIMESTAMP",
+		"SELECT id FROM users ORDER BY RANDOM() LIMIT 1":           "RANDOM",
+		"SELECT uuid_generate_v4()":                                "uuid_generate_v4",
+		"SELECT UNIX_TIMESTAMP()":                                  "UNIX_TIMESTAMP()",
+		"SELECT UNIX_TIMESTAMP(created_at) FROM users":             "",
+		"SELECT nowhere, known(1) FROM t":                          "",
+		"-- refreshed now()\nSELECT name FROM users":               "",
+		"/* current_date */ SELECT name FROM users":                "",
+	}
+	for query, want := range tests {
+		assert.Equal(want, nonDeterministicCall(query), query)
+	}
+}
+
+func TestNonDeterministicPolicy(t *testing.T) {
+	assert := require.New(t)
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE created_at > NOW() - ?`
+	args := []driver.NamedValue{{Ordinal: 1, Value: int64(18)}}
+	queryFn := func() (driver.Rows, error) {
+		return &seqRows{n: 1, cols: 1}, nil
+	}
+
+	// the cache isn't used
+	ic, _ := NewInterceptor(&Config{
+		Cache:            new(mocks.Cacher),
+		NonDeterministic: NonDeterministicSkip,
+	})
+	_, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, queryFn)
+	assert.Nil(err)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipNonDeterministic])
+
+	var warnings []error
+	ic, _ = NewInterceptor(&Config{
+		Cache:            new(mocks.Cacher),
+		NonDeterministic: NonDeterministicWarn,
+		OnError: func(err error) {
+			warnings = append(warnings, err)
+		},
+	})
+	p := ic.prepare(query)
+	assert.Equal("NOW", p.nonDeterministic)
+	for n := 0; n < 2; n++ {
+		ic.warnNonDeterministic(context.Background(), p.fingerprint, p.nonDeterministic)
+	}
+	assert.Len(warnings, 1)
+	assert.True(errors.Is(warnings[0], ErrNonDeterministic))
+	assert.Equal(uint64(0), ic.Stats().Errors)
+
+	ic, _ = NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+	p = ic.prepare(query)
+	assert.False(p.nonDetChecked)
+	// the policy may be changed at runtime
+	assert.Equal("NOW", p.nonDeterministicCall())
+}
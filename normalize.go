@@ -0,0 +1,13 @@
+package sqlcache
+
+import (
+	fp "github.com/prashanthpai/sqlcache/fingerprint"
+)
+
+// normalizeQuery returns the query with comments stripped, runs of
+// whitespace collapsed into a single space and keywords lowercased, leaving
+// quoted strings and identifiers intact, so that formatting differences
+// don't result in different cache keys; see fingerprint.Normalize.
+func normalizeQuery(query string) string {
+	return fp.Normalize(query)
+}
@@ -0,0 +1,62 @@
+package sqlcache
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	assert := require.New(t)
+
+	tests := map[string]string{
+		"SELECT name FROM users WHERE age > ?":            "select name from users where age > ?",
+		"  select   name\n\tFROM users  ":                 "select name from users",
+		"-- @cache-ttl 30\nSELECT 1 /* inline */ FROM t":  "select 1 from t",
+		"SELECT 'Hello  World', \"MixedCase\" FROM Users": "select 'Hello  World', \"MixedCase\" from Users",
+		"SELECT 'it''s  -- not a comment' FROM t":         "select 'it''s  -- not a comment' from t",
+		"SELECT `Col` FROM t WHERE x = $1":                "select `Col` from t where x = $1",
+		"SELECT * FROM t WHERE a = 1 /* unterminated":     "select * from t where a = 1",
+		"SELECT * FROM t WHERE name = 'unterminated":      "select * from t where name = 'unterminated",
+	}
+	for query, want := range tests {
+		assert.Equal(want, normalizeQuery(query), query)
+	}
+}
+
+func TestNormalizedKeys(t *testing.T) {
+	assert := require.New(t)
+
+	args := []driver.NamedValue{{Ordinal: 1, Value: int64(18)}}
+	a := `-- @cache-max-rows 10
+	      -- @cache-ttl 30
+	      SELECT name FROM users WHERE age > ?`
+	b := `-- @cache-ttl 60
+	      -- @cache-max-rows 10
+	      select name
+	      from users
+	      where age > ?`
+
+	for _, hashFunc := range []func(string, []driver.NamedValue) (string, error){nil, XXHash} {
+		ic, _ := NewInterceptor(&Config{
+			Cache:          new(mocks.Cacher),
+			HashFunc:       hashFunc,
+			NormalizeQuery: true,
+		})
+		ka, err := ic.hash(ic.prepare(a), args)
+		assert.Nil(err)
+		kb, err := ic.hash(ic.prepare(b), args)
+		assert.Nil(err)
+		assert.Equal(ka, kb)
+	}
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+	ka, _ := ic.hash(ic.prepare(a), args)
+	kb, _ := ic.hash(ic.prepare(b), args)
+	assert.NotEqual(ka, kb)
+}
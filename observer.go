@@ -0,0 +1,35 @@
+package sqlcache
+
+import (
+	"context"
+	"time"
+)
+
+// backend operations reported to observers
+const (
+	opGet = "get"
+	opSet = "set"
+)
+
+// opObserver is notified of the latency and outcome of each backend
+// operation performed by the interceptor.
+type opObserver func(op string, d time.Duration, err error)
+
+func (i *Interceptor) addOpObserver(o opObserver) {
+	i.obsMu.Lock()
+	defer i.obsMu.Unlock()
+	i.opObservers = append(i.opObservers, o)
+}
+
+func (i *Interceptor) observeOp(ctx context.Context, op, key string, d time.Duration, err error) {
+	i.observeHealth(ctx, err)
+	if i.slowOp > 0 && d > i.slowOp {
+		i.log(ctx, LevelWarn, "sqlcache: slow cache operation", "op", op, "key", key, "duration", d)
+	}
+
+	i.obsMu.RLock()
+	defer i.obsMu.RUnlock()
+	for _, o := range i.opObservers {
+		o(op, d, err)
+	}
+}
@@ -0,0 +1,194 @@
+package sqlcache
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options are the settings of an interceptor that can be changed at
+// runtime with Interceptor.UpdateOptions, such as from a configuration
+// service, without a restart. Each has the meaning of the Config field of
+// the same name.
+type Options struct {
+	SampleRate             float64
+	ZeroTTL                ZeroTTLPolicy
+	NonDeterministic       NonDeterministicPolicy
+	CacheWrites            bool
+	DisableNegativeCaching bool
+	NegativeTTL            time.Duration
+	MaxItemBytes           int
+	MaxRecordBytes         int
+	MinQueryLatency        time.Duration
+	GetTimeout             time.Duration
+	SetTimeout             time.Duration
+	DetachSets             bool
+	MinLookupBudget        time.Duration
+	FailFastOnDeadline     bool
+	MaxConcurrentMisses    int
+	MissLimit              MissLimitPolicy
+	AllowFingerprints      []string
+	DenyFingerprints       []string
+	TTLOverrides           map[string]time.Duration
+}
+
+// options are Options prepared for use by queries.
+type options struct {
+	Options
+	allow map[string]struct{}
+	deny  map[string]struct{}
+}
+
+func (c *Config) options() Options {
+	return Options{
+		SampleRate:             c.SampleRate,
+		ZeroTTL:                c.ZeroTTL,
+		NonDeterministic:       c.NonDeterministic,
+		CacheWrites:            c.CacheWrites,
+		DisableNegativeCaching: c.DisableNegativeCaching,
+		NegativeTTL:            c.NegativeTTL,
+		MaxItemBytes:           c.MaxItemBytes,
+		MaxRecordBytes:         c.MaxRecordBytes,
+		MinQueryLatency:        c.MinQueryLatency,
+		GetTimeout:             c.GetTimeout,
+		SetTimeout:             c.SetTimeout,
+		DetachSets:             c.DetachSets,
+		MinLookupBudget:        c.MinLookupBudget,
+		FailFastOnDeadline:     c.FailFastOnDeadline,
+		MaxConcurrentMisses:    c.MaxConcurrentMisses,
+		MissLimit:              c.MissLimit,
+		AllowFingerprints:      c.AllowFingerprints,
+		DenyFingerprints:       c.DenyFingerprints,
+		TTLOverrides:           c.TTLOverrides,
+	}
+}
+
+// newOptions validates o and returns it prepared for use.
+func newOptions(o Options) (*options, error) {
+	if o.ZeroTTL != ZeroTTLSkip && o.ZeroTTL != ZeroTTLNoExpiry {
+		return nil, fmt.Errorf("invalid ZeroTTL policy %d", o.ZeroTTL)
+	}
+	if o.MissLimit < MissLimitWait || o.MissLimit > MissLimitFail {
+		return nil, fmt.Errorf("invalid MissLimit policy %d", o.MissLimit)
+	}
+	if o.SampleRate < 0 || o.SampleRate > 1 {
+		return nil, fmt.Errorf("SampleRate must be between 0 and 1")
+	}
+	if o.SampleRate == 0 {
+		o.SampleRate = 1
+	}
+	for fp, ttl := range o.TTLOverrides {
+		if ttl < 0 {
+			return nil, fmt.Errorf("TTL override of %q must not be negative", fp)
+		}
+	}
+
+	o.AllowFingerprints = append([]string(nil), o.AllowFingerprints...)
+	o.DenyFingerprints = append([]string(nil), o.DenyFingerprints...)
+	o.TTLOverrides = copyTTLOverrides(o.TTLOverrides)
+	opts := &options{Options: o}
+	if len(o.AllowFingerprints) > 0 {
+		opts.allow = make(map[string]struct{}, len(o.AllowFingerprints))
+		for _, fp := range o.AllowFingerprints {
+			opts.allow[fp] = struct{}{}
+		}
+	}
+	if len(o.DenyFingerprints) > 0 {
+		opts.deny = make(map[string]struct{}, len(o.DenyFingerprints))
+		for _, fp := range o.DenyFingerprints {
+			opts.deny[fp] = struct{}{}
+		}
+	}
+
+	return opts, nil
+}
+
+// allowed reports whether queries of the fingerprint may be cached as per
+// AllowFingerprints and DenyFingerprints.
+func (o *options) allowed(fp string) bool {
+	if _, ok := o.deny[fp]; ok {
+		return false
+	}
+	if o.allow == nil {
+		return true
+	}
+	_, ok := o.allow[fp]
+
+	return ok
+}
+
+func (i *Interceptor) options() *options {
+	return i.opts.Load().(*options)
+}
+
+// Options returns the current runtime options. A SampleRate of zero is
+// returned as 1.
+func (i *Interceptor) Options() Options {
+	o := i.options().Options
+	o.AllowFingerprints = append([]string(nil), o.AllowFingerprints...)
+	o.DenyFingerprints = append([]string(nil), o.DenyFingerprints...)
+	o.TTLOverrides = copyTTLOverrides(o.TTLOverrides)
+
+	return o
+}
+
+// SetTTLOverride overrides the TTL of queries with the fingerprint, as by
+// Options.TTLOverrides; a TTL of zero stops caching them.
+func (i *Interceptor) SetTTLOverride(fingerprint string, ttl time.Duration) error {
+	return i.updateTTLOverrides(func(m map[string]time.Duration) {
+		m[fingerprint] = ttl
+	})
+}
+
+// ClearTTLOverride removes the TTL override of queries with the
+// fingerprint, which are cached as per their @cache-ttl again.
+func (i *Interceptor) ClearTTLOverride(fingerprint string) error {
+	return i.updateTTLOverrides(func(m map[string]time.Duration) {
+		delete(m, fingerprint)
+	})
+}
+
+func (i *Interceptor) updateTTLOverrides(fn func(m map[string]time.Duration)) error {
+	i.optsMu.Lock()
+	defer i.optsMu.Unlock()
+
+	o := i.Options()
+	if o.TTLOverrides == nil {
+		o.TTLOverrides = make(map[string]time.Duration)
+	}
+	fn(o.TTLOverrides)
+
+	return i.storeOptions(o)
+}
+
+func copyTTLOverrides(m map[string]time.Duration) map[string]time.Duration {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(map[string]time.Duration, len(m))
+	for fp, ttl := range m {
+		c[fp] = ttl
+	}
+
+	return c
+}
+
+// UpdateOptions replaces all runtime options at once; options are never
+// observed partially updated. Start from Interceptor.Options to change some
+// of them. A change of MaxConcurrentMisses applies to a query once its
+// misses in flight complete.
+func (i *Interceptor) UpdateOptions(o Options) error {
+	i.optsMu.Lock()
+	defer i.optsMu.Unlock()
+
+	return i.storeOptions(o)
+}
+
+func (i *Interceptor) storeOptions(o Options) error {
+	opts, err := newOptions(o)
+	if err != nil {
+		return err
+	}
+	i.opts.Store(opts)
+
+	return nil
+}
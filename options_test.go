@@ -0,0 +1,208 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateOptions(t *testing.T) {
+	assert := require.New(t)
+
+	ic, err := NewInterceptor(&Config{
+		Cache:            &mapCacher{entries: make(map[string]cache.Entry)},
+		DenyFingerprints: []string{"f"},
+	})
+	assert.Nil(err)
+
+	o := ic.Options()
+	assert.Equal(Options{SampleRate: 1, DenyFingerprints: []string{"f"}}, o)
+	// returned lists are copies
+	o.DenyFingerprints[0] = "g"
+	assert.Equal([]string{"f"}, ic.Options().DenyFingerprints)
+
+	assert.NotNil(ic.UpdateOptions(Options{SampleRate: 2}))
+	assert.NotNil(ic.UpdateOptions(Options{ZeroTTL: 5}))
+	assert.Equal([]string{"f"}, ic.Options().DenyFingerprints)
+
+	query := `-- @cache-ttl 30
+              -- @cache-max-rows 10
+              SELECT name FROM users WHERE id = ?`
+	fp := fingerprint(query)
+	run := func() {
+		args := []driver.NamedValue{{Ordinal: 1, Value: int64(1)}}
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	assert.Nil(ic.UpdateOptions(Options{DenyFingerprints: []string{fp}}))
+	run()
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipDenied])
+
+	assert.Nil(ic.UpdateOptions(Options{AllowFingerprints: []string{"f"}}))
+	run()
+	assert.Equal(uint64(2), ic.Stats().SkipReasons[SkipDenied])
+
+	// deny takes precedence
+	assert.Nil(ic.UpdateOptions(Options{AllowFingerprints: []string{fp}, DenyFingerprints: []string{fp}}))
+	run()
+	assert.Equal(uint64(3), ic.Stats().SkipReasons[SkipDenied])
+
+	assert.Nil(ic.UpdateOptions(Options{AllowFingerprints: []string{fp}}))
+	run()
+	run()
+	s := ic.Stats()
+	assert.Equal(uint64(3), s.SkipReasons[SkipDenied])
+	assert.Equal(uint64(1), s.Hits)
+
+	// options are updated while queries run
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for k := 0; k < 50; k++ {
+				if n == 0 {
+					o := ic.Options()
+					o.CacheWrites = !o.CacheWrites
+					assert.Nil(ic.UpdateOptions(o))
+					continue
+				}
+				run()
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func TestTTLOverrides(t *testing.T) {
+	assert := require.New(t)
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{Cache: mc})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+              -- @cache-max-rows 10
+              SELECT name FROM users WHERE id = ?`
+	fp := fingerprint(query)
+	run := func(id int64) {
+		args := []driver.NamedValue{{Ordinal: 1, Value: id}}
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+	ttlOf := func(id int64) time.Duration {
+		key, err := ic.Key(query, id)
+		assert.Nil(err)
+		return mc.entries[key].TTL
+	}
+
+	run(1)
+	assert.Equal(30*time.Second, ttlOf(1))
+
+	assert.Nil(ic.SetTTLOverride(fp, time.Minute))
+	assert.Equal(map[string]time.Duration{fp: time.Minute}, ic.Options().TTLOverrides)
+	run(2)
+	assert.Equal(time.Minute, ttlOf(2))
+
+	// zero stops caching, whatever the ZeroTTL policy
+	o := ic.Options()
+	o.ZeroTTL = ZeroTTLNoExpiry
+	assert.Nil(ic.UpdateOptions(o))
+	assert.Nil(ic.SetTTLOverride(fp, 0))
+	run(3)
+	assert.Len(mc.entries, 2)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipZeroTTL])
+
+	assert.Nil(ic.ClearTTLOverride(fp))
+	assert.Nil(ic.Options().TTLOverrides)
+	run(4)
+	assert.Equal(30*time.Second, ttlOf(4))
+
+	assert.NotNil(ic.SetTTLOverride(fp, -time.Second))
+	assert.Nil(ic.Options().TTLOverrides)
+
+	// overrides passed in are copied
+	overrides := map[string]time.Duration{fp: time.Hour}
+	assert.Nil(ic.UpdateOptions(Options{TTLOverrides: overrides}))
+	overrides[fp] = 0
+	run(5)
+	assert.Equal(time.Hour, ttlOf(5))
+}
+
+func TestTTLFunc(t *testing.T) {
+	assert := require.New(t)
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var errs []error
+	ic, err := NewInterceptor(&Config{
+		Cache: mc,
+		// recent days change, history doesn't
+		TTLFunc: func(query string, args []driver.NamedValue, ttl time.Duration) time.Duration {
+			day := args[0].Value.(time.Time)
+			switch {
+			case day.IsZero():
+				panic("no day")
+			case day.After(now):
+				return 0
+			case now.Sub(day) > 7*24*time.Hour:
+				return 24 * time.Hour
+			}
+			return ttl
+		},
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+              -- @cache-max-rows 10
+              SELECT SUM(amount) FROM sales WHERE day = ?`
+	run := func(day time.Time) time.Duration {
+		args := []driver.NamedValue{{Ordinal: 1, Value: day}}
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+		key, err := ic.Key(query, day)
+		assert.Nil(err)
+		return mc.entries[key].TTL
+	}
+
+	assert.Equal(30*time.Second, run(now.Add(-24*time.Hour)))
+	assert.Equal(24*time.Hour, run(now.Add(-30*24*time.Hour)))
+	run(now.Add(24 * time.Hour))
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipZeroTTL])
+	assert.Len(mc.entries, 2)
+
+	// the TTL is kept when it panics
+	assert.Equal(30*time.Second, run(time.Time{}))
+	assert.Len(errs, 1)
+	assert.ErrorIs(errs[0], ErrPanic)
+
+	// overrides take precedence
+	assert.Nil(ic.SetTTLOverride(fingerprint(query), time.Minute))
+	assert.Equal(time.Minute, run(now.Add(-60*24*time.Hour)))
+}
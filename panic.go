@@ -0,0 +1,204 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// PanicError is the underlying error of an *Error reported when a user
+// callback, such as Config.HashFunc, panicked. The panic is recovered so
+// that it doesn't crash the goroutine running the query. It matches
+// ErrPanic with errors.Is.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Is reports whether target is ErrPanic.
+func (e *PanicError) Is(target error) bool {
+	return target == ErrPanic
+}
+
+// panicked accounts for a panic recovered from a user callback and returns
+// it as an error.
+func (i *Interceptor) panicked(v interface{}) *PanicError {
+	atomic.AddUint64(&i.stats.Panics, 1)
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// callHashFunc calls Config.HashFunc, or its equivalent fn, returning a
+// panic as an error.
+func (i *Interceptor) callHashFunc(fn func(string, []driver.NamedValue) (string, error), query string, args []driver.NamedValue) (key string, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = i.panicked(v)
+		}
+	}()
+
+	return fn(query, args)
+}
+
+// notifyErr calls the Config.OnError hook, if any. A panic in the hook is
+// logged as it can't be reported to the hook itself.
+func (i *Interceptor) notifyErr(ctx context.Context, err error) {
+	onErr := i.hooks().onErr
+	if onErr == nil {
+		return
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			err := &Error{Kind: ErrPanic, Op: "OnError", Err: i.panicked(v)}
+			i.log(ctx, LevelError, "sqlcache: OnError hook panicked", "error", err)
+		}
+	}()
+	onErr(err)
+}
+
+// notifySkip calls the Config.OnSkip hook, if any. A panic in the hook is
+// reported like other errors.
+func (i *Interceptor) notifySkip(ctx context.Context, q *queryInfo, reason SkipReason) {
+	onSkip := i.hooks().onSkip
+	if onSkip == nil {
+		return
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrPanic, Op: "OnSkip", Key: q.key, Err: i.panicked(v)})
+		}
+	}()
+	onSkip(q.key, reason)
+}
+
+// notifySet calls the Config.OnSet hook, if any. A panic in the hook is
+// reported like other errors.
+func (i *Interceptor) notifySet(ctx context.Context, q *queryInfo, item *cache.Item) {
+	onSet := i.hooks().onSet
+	if onSet == nil {
+		return
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrPanic, Op: "OnSet", Key: q.key, Err: i.panicked(v)})
+		}
+	}()
+	onSet(q.query, q.args, q.key, item)
+}
+
+// enabled calls the Config.Enabler hook, if any. A panic in the hook is
+// reported and the cache isn't used for the query.
+func (i *Interceptor) enabled(ctx context.Context, q *queryInfo) (ok bool) {
+	enabler := i.hooks().enabler
+	if enabler == nil {
+		return true
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrPanic, Op: "Enabler", Err: i.panicked(v)})
+			ok = false
+		}
+	}()
+
+	return enabler(ctx, q.query)
+}
+
+// notifyHealth calls HealthCheck.OnChange, if set. A panic in it is
+// reported.
+func (i *Interceptor) notifyHealth(ctx context.Context, healthy bool) {
+	onChange := i.health.cfg.OnChange
+	if onChange == nil {
+		return
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			err := &Error{Kind: ErrPanic, Op: "HealthCheck.OnChange", Err: i.panicked(v)}
+			i.notifyErr(ctx, err)
+			i.log(ctx, LevelError, "sqlcache: HealthCheck.OnChange panicked", "error", err)
+		}
+	}()
+	onChange(healthy)
+}
+
+// callPrincipal calls Config.Principal. A panic in it is reported and no
+// principal is returned.
+func (i *Interceptor) callPrincipal(ctx context.Context, q *queryInfo) (principal string) {
+	defer func() {
+		if v := recover(); v != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrPanic, Op: "Principal", Key: q.key, Err: i.panicked(v)})
+			principal = ""
+		}
+	}()
+
+	return i.principal(ctx)
+}
+
+// transformItem calls Config.TransformItem with the results of the query,
+// keeping the fields set by the interceptor. A panic in it is reported and
+// the results aren't cached.
+func (i *Interceptor) transformItem(ctx context.Context, q *queryInfo, item *cache.Item) (out *cache.Item, ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrPanic, Op: "TransformItem", Key: q.key, Err: i.panicked(v)})
+			out, ok = nil, false
+		}
+	}()
+
+	out, ok = i.transform(q.query, item)
+	if !ok || out == nil {
+		return nil, false
+	}
+	out.CreatedAt, out.Fingerprint, out.Digest = item.CreatedAt, item.Fingerprint, item.Digest
+
+	return out, true
+}
+
+// callTTLFunc calls Config.TTLFunc, returning ttl when it panics, which is
+// reported. Negative TTLs are taken as zero.
+func (i *Interceptor) callTTLFunc(ctx context.Context, q *queryInfo, args []driver.NamedValue, ttl time.Duration) (out time.Duration) {
+	defer func() {
+		if v := recover(); v != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrPanic, Op: "TTLFunc", Err: i.panicked(v)})
+			out = ttl
+		}
+	}()
+
+	if out = i.ttlFunc(q.query, args, ttl); out < 0 {
+		out = 0
+	}
+
+	return out
+}
+
+// tenant calls Quota.Tenant, if set. A panic in it is reported and the
+// results are counted against the overall quotas only.
+func (i *Interceptor) tenant(ctx context.Context, q *queryInfo) (tenant string) {
+	fn := i.quota.cfg.Tenant
+	if fn == nil {
+		return ""
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrPanic, Op: "Quota.Tenant", Key: q.key, Err: i.panicked(v)})
+			tenant = ""
+		}
+	}()
+
+	return fn(ctx)
+}
@@ -0,0 +1,85 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type panicLogger struct{}
+
+func (panicLogger) Log(ctx context.Context, level LogLevel, msg string, args ...interface{}) {
+	panic("logger")
+}
+
+func TestRecoverPanics(t *testing.T) {
+	assert := require.New(t)
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users`
+	queryFn := func() (driver.Rows, error) {
+		return &seqRows{n: 1, cols: 1}, nil
+	}
+
+	var reported []error
+	ic, _ := NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+		HashFunc: func(string, []driver.NamedValue) (string, error) {
+			panic("hash")
+		},
+		OnError: func(err error) { reported = append(reported, err) },
+	})
+	rows, err := ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, queryFn)
+	assert.Nil(err)
+	assert.NotNil(rows)
+	assert.Len(reported, 1)
+	assert.ErrorIs(reported[0], ErrHash)
+	assert.ErrorIs(reported[0], ErrPanic)
+	var pe *PanicError
+	assert.True(errors.As(reported[0], &pe))
+	assert.Equal("hash", pe.Value)
+	assert.NotEmpty(pe.Stack)
+	s := ic.Stats()
+	assert.Equal(uint64(1), s.Panics)
+	assert.Equal(uint64(1), s.SkipReasons[SkipHashError])
+
+	// panics in hooks and the logger don't escape
+	logger := new(testLogger)
+	reported = nil
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, errors.New("get"))
+	ic, _ = NewInterceptor(&Config{
+		Cache:   mCacher,
+		Logger:  logger,
+		OnError: func(err error) { panic("onError") },
+		OnSkip:  func(string, SkipReason) { panic("onSkip") },
+	})
+	_, err = ic.intercept(context.Background(), ic.prepare("SELECT 1"), nil, false, nil, queryFn)
+	assert.Nil(err)
+	_, err = ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, queryFn)
+	assert.Nil(err)
+	// OnSkip, then OnError reporting it, and OnError for the failed lookup
+	assert.Equal(uint64(3), ic.Stats().Panics)
+	var logged int
+	for _, e := range logger.events {
+		if e.msg == "sqlcache: OnError hook panicked" {
+			logged++
+		}
+	}
+	assert.Equal(2, logged)
+
+	ic, _ = NewInterceptor(&Config{
+		Cache:  new(mocks.Cacher),
+		Logger: panicLogger{},
+	})
+	_, err = ic.intercept(context.Background(), ic.prepare("SELECT 1"), nil, false, nil, queryFn)
+	assert.Nil(err)
+	assert.Equal(uint64(1), ic.Stats().Panics)
+}
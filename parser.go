@@ -0,0 +1,127 @@
+package sqlcache
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+)
+
+// maxParsedQueries bounds the number of queries whose analysis by
+// Config.Parser is remembered.
+const maxParsedQueries = 10000
+
+// Parser analyzes the text of queries for the features that depend on
+// what a query does: detection of writes, Config.NonDeterministic and
+// Config.AutoCache. By default, queries are analyzed with heuristics that
+// work across SQL dialects but may be fooled by unusual syntax; a Parser
+// backed by a real SQL parser of the database's dialect, such as the one
+// in the vitessparser module for MySQL, is more robust.
+//
+// Queries are parsed once and their analysis is remembered, so Parse
+// needn't be fast. It must be safe for concurrent use.
+type Parser interface {
+	// Parse analyzes the query. Queries it returns an error for are
+	// reported to Config.OnError with ErrParse and analyzed with the
+	// default heuristics.
+	Parse(query string) (*ParsedQuery, error)
+}
+
+// ParsedQuery is the analysis of a query by a Parser.
+type ParsedQuery struct {
+	// Read is set for read-only statements, such as SELECT, as opposed
+	// to INSERT with RETURNING, SELECT ... INTO or DDL.
+	Read bool
+	// Locking is set for reads that lock rows, such as SELECT ... FOR
+	// UPDATE, which are run as writes even if Read is set.
+	Locking bool
+	// Tables are the names of the tables read by the query, lowercase,
+	// unquoted and qualified with a schema only as in the query. It's
+	// empty if they can't be determined; such queries aren't cached by
+	// Config.AutoCache.
+	Tables []string
+	// NonDeterministic is the first non-deterministic function, such as
+	// NOW(), called by the query, if any.
+	NonDeterministic string
+}
+
+// parseHeuristic analyzes the query with regular expressions.
+func parseHeuristic(query string) *ParsedQuery {
+	stripped, literals := stripComments(query), stripLiterals(query)
+	pq := &ParsedQuery{
+		Read:             isReadStatement(literals),
+		Locking:          lockingReadRegexp.MatchString(literals),
+		NonDeterministic: nonDeterministicCall(query),
+	}
+	// tables are only found in plain SELECT statements
+	if m := leadingKeywordRegexp.FindStringSubmatch(stripped); m != nil && strings.EqualFold(m[1], "SELECT") {
+		if tables, ok := queryTables(stripped); ok {
+			pq.Tables = tables
+		}
+	}
+
+	return pq
+}
+
+// analyze returns the analysis of the query by Config.Parser, or by the
+// default heuristics when it isn't set or fails to parse the query.
+func (i *Interceptor) analyze(query string) *ParsedQuery {
+	if pq := i.parse(query); pq != nil {
+		return pq
+	}
+
+	return parseHeuristic(query)
+}
+
+// isRead reports whether the query is a read-only statement.
+func (i *Interceptor) isRead(query string) bool {
+	if pq := i.parse(query); pq != nil {
+		return pq.Read && !pq.Locking
+	}
+
+	return isRead(query)
+}
+
+// nonDeterministicCall returns the first non-deterministic function called
+// by the query, if any.
+func (i *Interceptor) nonDeterministicCall(query string) string {
+	if pq := i.parse(query); pq != nil {
+		return pq.NonDeterministic
+	}
+
+	return nonDeterministicCall(query)
+}
+
+// parse returns the analysis of the query by Config.Parser, or nil when
+// it isn't set or fails to parse the query.
+func (i *Interceptor) parse(query string) *ParsedQuery {
+	if i.parser == nil {
+		return nil
+	}
+	if pq, ok := i.parsed.Load(query); ok {
+		return pq.(*ParsedQuery)
+	}
+
+	pq, err := i.callParser(query)
+	if err != nil {
+		i.notifyErr(context.Background(), &Error{Kind: ErrParse, Op: "Parser.Parse", Err: err})
+		pq = nil
+	}
+	if atomic.LoadInt64(&i.nParsed) < maxParsedQueries {
+		if _, loaded := i.parsed.LoadOrStore(query, pq); !loaded {
+			atomic.AddInt64(&i.nParsed, 1)
+		}
+	}
+
+	return pq
+}
+
+// callParser calls Config.Parser, returning a panic as an error.
+func (i *Interceptor) callParser(query string) (pq *ParsedQuery, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = i.panicked(v)
+		}
+	}()
+
+	return i.parser.Parse(query)
+}
@@ -0,0 +1,116 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mapParser returns the analysis of known queries and an error otherwise.
+type mapParser struct {
+	mu      sync.Mutex
+	queries map[string]*ParsedQuery
+	calls   map[string]int
+}
+
+func (p *mapParser) Parse(query string) (*ParsedQuery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls[query]++
+	if query == "panic" {
+		panic("boom")
+	}
+	pq, ok := p.queries[query]
+	if !ok {
+		return nil, errors.New("syntax error")
+	}
+
+	return pq, nil
+}
+
+func TestParseHeuristic(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(&ParsedQuery{Read: true, Tables: []string{"users"}},
+		parseHeuristic(`SELECT name FROM users -- NOW()`))
+	assert.Equal(&ParsedQuery{Read: true, Locking: true, Tables: []string{"users"}, NonDeterministic: "NOW"},
+		parseHeuristic(`SELECT NOW() FROM users FOR UPDATE`))
+	assert.Equal(&ParsedQuery{Read: true},
+		parseHeuristic(`WITH u AS (SELECT * FROM users) SELECT * FROM u`))
+	assert.Equal(&ParsedQuery{},
+		parseHeuristic(`UPDATE users SET name = 'x' RETURNING id`))
+}
+
+func TestParser(t *testing.T) {
+	assert := require.New(t)
+
+	const (
+		withQuery = `WITH u AS (SELECT * FROM users) SELECT * FROM u`
+		nowQuery  = `SELECT created_at FROM users`
+		callQuery = `-- @cache-ttl 30
+		             -- @cache-max-rows 10
+		             CALL report()`
+	)
+	p := &mapParser{
+		queries: map[string]*ParsedQuery{
+			withQuery: {Read: true, Tables: []string{"users"}},
+			nowQuery:  {Read: true, Tables: []string{"users"}, NonDeterministic: "created_at"},
+			callQuery: {Read: true},
+		},
+		calls: make(map[string]int),
+	}
+	var errs []error
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{
+		Cache:     mc,
+		Parser:    p,
+		AutoCache: &AutoCache{Tables: []string{"users"}, TTL: time.Minute, MaxRows: 10},
+		OnError:   func(err error) { errs = append(errs, err) },
+	})
+	assert.Nil(err)
+
+	run := func(query string) {
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	// CTEs and statements unknown to the heuristics are analyzed by the
+	// parser, once
+	run(withQuery)
+	run(withQuery)
+	run(callQuery)
+	assert.Len(mc.entries, 2)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+	assert.Equal(1, p.calls[withQuery])
+	assert.Equal(1, p.calls[callQuery])
+
+	run(nowQuery)
+	assert.Len(mc.entries, 2)
+	assert.Empty(errs)
+
+	// queries the parser fails on are analyzed with heuristics
+	run(`SELECT name FROM users WHERE id = 1`)
+	assert.Len(mc.entries, 3)
+	assert.Len(errs, 1)
+	assert.True(errors.Is(errs[0], ErrParse))
+
+	run("panic")
+	assert.Len(errs, 2)
+	assert.True(errors.Is(errs[1], ErrParse))
+	assert.True(errors.Is(errs[1], ErrPanic))
+	assert.Equal(uint64(1), ic.Stats().Panics)
+}
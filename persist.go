@@ -0,0 +1,280 @@
+package sqlcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/dgraph-io/ristretto/z"
+)
+
+const defaultPersistQueueSize = 1000
+
+// errPersistQueueFull is reported when a write is dropped because the
+// persistence queue is full.
+var errPersistQueueFull = errors.New("sqlcache: persistence queue full")
+
+// Persistence configures write-behind persistence of the ristretto backend
+// with WithPersistence.
+type Persistence struct {
+	// Store is the secondary store items are persisted to, such as a
+	// *DiskStore or a *Redis. It must implement cache.Ranger for items to
+	// be reloaded by Ristretto.Load. It's required and isn't closed with
+	// the ristretto backend.
+	Store cache.Cacher
+	// QueueSize bounds the writes waiting to be persisted. Writes made
+	// while it's full aren't persisted. Defaults to 1000.
+	QueueSize int
+	// Timeout bounds each write to Store. Zero means no timeout.
+	Timeout time.Duration
+	// OnError, if set, is called with errors persisting items, including
+	// writes dropped because the queue was full.
+	OnError func(err error)
+}
+
+// persistOp is a write to persist: a set, or a delete when item is nil.
+type persistOp struct {
+	key       string
+	item      *cache.Item
+	expiresAt time.Time // zero if the item doesn't expire
+}
+
+// persistedKey is the key of an item in ristretto, as found by the hashes
+// ristretto reports items it evicts with.
+type persistedKey struct {
+	key      string
+	conflict uint64
+}
+
+// persister writes to the secondary store in the background.
+type persister struct {
+	Persistence
+
+	mu     sync.RWMutex // guards sending on ops against closing it
+	ops    chan persistOp
+	closed bool
+	done   chan struct{}
+
+	// keys maps the hashes of the keys of items in ristretto to the keys,
+	// so that items evicted or rejected by ristretto are deleted from
+	// Store. It's nil unless the cache was created by NewRistrettoCache.
+	kmu       sync.Mutex
+	keys      map[uint64]persistedKey
+	keyToHash func(key interface{}) (uint64, uint64)
+}
+
+// WithPersistence persists items set in ristretto to a secondary store
+// asynchronously, and removes those deleted, so that Ristretto.Load can
+// warm the cache after a restart. Writes are applied in order by a single
+// goroutine; Close waits for those queued to complete.
+//
+// Items evicted by ristretto, or rejected by its admission policy, are
+// only removed from the store when the cache is created by
+// NewRistrettoCache, which hooks its evictions. Otherwise the store keeps
+// them, and Load reloads them, until they expire.
+func WithPersistence(p Persistence) RistrettoOption {
+	return func(r *Ristretto) {
+		if p.QueueSize <= 0 {
+			p.QueueSize = defaultPersistQueueSize
+		}
+		r.persist = &persister{
+			Persistence: p,
+			ops:         make(chan persistOp, p.QueueSize),
+			done:        make(chan struct{}),
+		}
+		go r.persist.work()
+	}
+}
+
+// enqueue queues op unless the queue is full or closed.
+func (p *persister) enqueue(op persistOp) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+
+	select {
+	case p.ops <- op:
+	default:
+		p.report(fmt.Errorf("persisting %q failed: %w", op.key, errPersistQueueFull))
+	}
+}
+
+func (p *persister) set(key string, item *cache.Item, ttl time.Duration) {
+	op := persistOp{key: key, item: item}
+	if ttl > 0 {
+		op.expiresAt = time.Now().Add(ttl)
+	}
+	p.enqueue(op)
+}
+
+func (p *persister) delete(key string) {
+	p.enqueue(persistOp{key: key})
+}
+
+// admit stores the item in ristretto with store and, if it's accepted and
+// persist is set, persists it. Items evicted or rejected by ristretto are
+// reported to evicted under the same lock, so their deletes are queued
+// after their writes.
+func (p *persister) admit(key string, item *cache.Item, ttl time.Duration, persist bool, store func() bool) {
+	p.kmu.Lock()
+	defer p.kmu.Unlock()
+
+	if !store() {
+		return
+	}
+	if p.keys != nil {
+		h, conflict := p.keyToHash(key)
+		p.keys[h] = persistedKey{key: key, conflict: conflict}
+	}
+	if persist {
+		p.set(key, item, ttl)
+	}
+}
+
+// forget deletes the item of key, deleted from ristretto, from Store.
+func (p *persister) forget(key string) {
+	p.kmu.Lock()
+	if p.keys != nil {
+		h, conflict := p.keyToHash(key)
+		if k, ok := p.keys[h]; ok && k.conflict == conflict {
+			delete(p.keys, h)
+		}
+	}
+	p.kmu.Unlock()
+
+	p.delete(key)
+}
+
+// evicted deletes the item evicted or rejected by ristretto from Store.
+func (p *persister) evicted(item *ristretto.Item) {
+	p.kmu.Lock()
+	defer p.kmu.Unlock()
+
+	k, ok := p.keys[item.Key]
+	if !ok || k.conflict != item.Conflict {
+		return
+	}
+	delete(p.keys, item.Key)
+	p.delete(k.key)
+}
+
+// NewRistrettoCache creates a ristretto cache with cfg and returns a
+// backend wrapping it, as NewRistretto does. Items the cache evicts or
+// rejects are then removed from the store of WithPersistence too. The
+// OnEvict and OnReject functions of cfg are still called.
+func NewRistrettoCache(cfg *ristretto.Config, opts ...RistrettoOption) (*Ristretto, error) {
+	r := NewRistretto(nil, opts...)
+	c := *cfg
+	if p := r.persist; p != nil {
+		p.keys = make(map[uint64]persistedKey)
+		p.keyToHash = z.KeyToHash
+		if c.KeyToHash != nil {
+			p.keyToHash = c.KeyToHash
+		}
+		onEvict, onReject := c.OnEvict, c.OnReject
+		c.OnEvict = func(item *ristretto.Item) {
+			p.evicted(item)
+			if onEvict != nil {
+				onEvict(item)
+			}
+		}
+		c.OnReject = func(item *ristretto.Item) {
+			p.evicted(item)
+			if onReject != nil {
+				onReject(item)
+			}
+		}
+	}
+
+	rc, err := ristretto.NewCache(&c)
+	if err != nil {
+		if r.persist != nil {
+			r.persist.close()
+		}
+		return nil, err
+	}
+	r.c = rc
+
+	return r, nil
+}
+
+func (p *persister) report(err error) {
+	if p.OnError != nil {
+		p.OnError(err)
+	}
+}
+
+func (p *persister) work() {
+	defer close(p.done)
+	for op := range p.ops {
+		if err := p.apply(op); err != nil {
+			p.report(fmt.Errorf("persisting %q failed: %w", op.key, err))
+		}
+	}
+}
+
+func (p *persister) apply(op persistOp) error {
+	ctx, cancel := withTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	if op.item == nil {
+		d, ok := cache.As[cache.Deleter](p.Store)
+		if !ok {
+			return nil
+		}
+		return d.Delete(ctx, op.key)
+	}
+
+	var ttl time.Duration
+	if !op.expiresAt.IsZero() {
+		if ttl = time.Until(op.expiresAt); ttl <= 0 {
+			return nil
+		}
+	}
+
+	return p.Store.Set(ctx, op.key, op.item, ttl)
+}
+
+// close stops queueing writes and waits for those queued to be persisted.
+func (p *persister) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.ops)
+	}
+	p.mu.Unlock()
+	<-p.done
+}
+
+// Load reloads the items persisted with WithPersistence into ristretto,
+// such as at startup before the cache is used, and returns the number of
+// items loaded. Items that have expired meanwhile aren't loaded, nor
+// written back to the store, and stores such as DiskStore remove them.
+func (r *Ristretto) Load(ctx context.Context) (int, error) {
+	if r.persist == nil {
+		return 0, errors.New("sqlcache: persistence not enabled; see WithPersistence")
+	}
+	ranger, ok := cache.As[cache.Ranger](r.persist.Store)
+	if !ok {
+		return 0, errors.New("sqlcache: persistence store can't enumerate its items")
+	}
+
+	var n int
+	err := ranger.Range(ctx, func(e cache.Entry) error {
+		r.persist.admit(e.Key, e.Item, e.TTL, false, func() bool {
+			return r.set(e.Key, e.Item, e.TTL)
+		})
+		n++
+		return nil
+	})
+	r.c.Wait()
+
+	return n, err
+}
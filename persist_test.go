@@ -0,0 +1,170 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/cache/cachetest"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskStoreConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cacher {
+		d, err := NewDiskStore(t.TempDir())
+		require.Nil(t, err)
+		return d
+	})
+}
+
+func newPersistedRistretto(t *testing.T, p Persistence) *Ristretto {
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 1e4,
+		MaxCost:     1 << 20,
+		BufferItems: 64,
+	})
+	require.Nil(t, err)
+
+	return NewRistretto(rc, WithPersistence(p))
+}
+
+func TestRistrettoPersistence(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	store, err := NewDiskStore(t.TempDir())
+	assert.Nil(err)
+	r := newPersistedRistretto(t, Persistence{Store: store})
+	item := func(name string) *cache.Item {
+		return &cache.Item{Cols: []string{"name"}, Rows: [][]driver.Value{{name}}}
+	}
+	assert.Nil(r.Set(ctx, "a", item("a"), time.Minute))
+	assert.Nil(r.Set(ctx, "b", item("b"), 0))
+	assert.Nil(r.Set(ctx, "c", item("c"), time.Minute))
+	assert.Nil(r.Delete(ctx, "c"))
+	// writes are persisted by Close at the latest
+	assert.Nil(r.Close())
+	_, ok, err := store.Get(ctx, "c")
+	assert.Nil(err)
+	assert.False(ok)
+
+	r = newPersistedRistretto(t, Persistence{Store: store})
+	defer r.Close()
+	n, err := r.Load(ctx)
+	assert.Nil(err)
+	assert.Equal(2, n)
+	got, ok, err := r.Get(ctx, "a")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(item("a").Rows, got.Rows)
+	ttl, ok, err := r.TTL(ctx, "a")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.True(ttl > 0 && ttl <= time.Minute, "TTL %v", ttl)
+	_, ok, err = r.Get(ctx, "b")
+	assert.Nil(err)
+	assert.True(ok)
+
+	_, err = NewRistretto(r.c).Load(ctx)
+	assert.NotNil(err)
+}
+
+// blockingCacher blocks Set until unblocked.
+type blockingCacher struct {
+	*mapCacher
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (b *blockingCacher) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
+	b.entered <- struct{}{}
+	<-b.unblock
+	return b.mapCacher.Set(ctx, key, item, ttl)
+}
+
+func TestRistrettoPersistenceQueueFull(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	store := &blockingCacher{
+		mapCacher: &mapCacher{entries: make(map[string]cache.Entry)},
+		entered:   make(chan struct{}, 3),
+		unblock:   make(chan struct{}),
+	}
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	r := newPersistedRistretto(t, Persistence{Store: store, QueueSize: 1, OnError: func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}})
+
+	item := &cache.Item{Rows: [][]driver.Value{{int64(1)}}}
+	assert.Nil(r.Set(ctx, "a", item, 0))
+	<-store.entered
+	assert.Nil(r.Set(ctx, "b", item, 0))
+	assert.Nil(r.Set(ctx, "c", item, 0))
+	close(store.unblock)
+	assert.Nil(r.Close())
+
+	assert.Len(errs, 1)
+	assert.True(errors.Is(errs[0], errPersistQueueFull))
+	assert.Contains(store.entries, "a")
+	assert.Contains(store.entries, "b")
+	assert.NotContains(store.entries, "c")
+}
+
+func TestRistrettoPersistenceEvictions(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	store, err := NewDiskStore(t.TempDir())
+	assert.Nil(err)
+	var rejected int
+	r, err := NewRistrettoCache(&ristretto.Config{
+		NumCounters:        100,
+		MaxCost:            2,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		OnReject:           func(*ristretto.Item) { rejected++ },
+	}, WithPersistence(Persistence{Store: store}))
+	assert.Nil(err)
+
+	item := func(rows int) *cache.Item {
+		item := &cache.Item{Cols: []string{"n"}}
+		for n := 0; n < rows; n++ {
+			item.Rows = append(item.Rows, []driver.Value{int64(n)})
+		}
+		return item
+	}
+	// rejected for costing more than the cache holds
+	assert.Nil(r.Set(ctx, "big", item(3), 0))
+	r.c.Wait()
+	assert.Equal(1, rejected)
+	// evicted to make room
+	assert.Nil(r.Set(ctx, "a", item(2), 0))
+	r.c.Wait()
+	assert.Nil(r.Set(ctx, "b", item(2), 0))
+	r.c.Wait()
+	_, ok, _ := r.Get(ctx, "a")
+	assert.False(ok)
+	assert.Nil(r.Close())
+
+	var keys []string
+	assert.Nil(store.Range(ctx, func(e cache.Entry) error {
+		keys = append(keys, e.Key)
+		return nil
+	}))
+	assert.Equal([]string{"b"}, keys)
+
+	_, err = NewRistrettoCache(&ristretto.Config{}, WithPersistence(Persistence{Store: store}))
+	assert.NotNil(err)
+}
@@ -0,0 +1,75 @@
+package sqlcache
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+const (
+	// maxSlabRows is the most rows whose values are allocated together in
+	// one slab by rowsRecorder. Slabs grow up to this size so that small
+	// results don't hold on to unused capacity.
+	maxSlabRows = 16
+	// maxPooledBufferSize is the largest encoding buffer returned to the
+	// pool, so that a few huge items don't pin memory.
+	maxPooledBufferSize = 1 << 20
+)
+
+// slabPool holds slabs of values released by recordings whose results
+// weren't cached.
+var slabPool sync.Pool // *[]driver.Value
+
+func getSlab(size int) []driver.Value {
+	if p, ok := slabPool.Get().(*[]driver.Value); ok {
+		// don't let oversized slabs waste memory in cached items
+		if s := *p; cap(s) >= size && cap(s) <= 2*size {
+			return s[:cap(s)]
+		}
+		slabPool.Put(p)
+	}
+
+	return make([]driver.Value, size)
+}
+
+func putSlab(s []driver.Value) {
+	s = s[:cap(s)]
+	for n := range s {
+		s[n] = nil
+	}
+	slabPool.Put(&s)
+}
+
+// encodeBuffer is a reusable msgpack encoder writing to a buffer.
+type encodeBuffer struct {
+	buf bytes.Buffer
+	enc *msgpack.Encoder
+}
+
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} {
+		eb := new(encodeBuffer)
+		eb.enc = msgpack.NewEncoder(&eb.buf)
+		return eb
+	},
+}
+
+// marshalMsgpack encodes v using a pooled scratch buffer and returns a copy
+// of the encoded bytes sized exactly.
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	eb := encodeBufferPool.Get().(*encodeBuffer)
+	defer func() {
+		if eb.buf.Cap() <= maxPooledBufferSize {
+			encodeBufferPool.Put(eb)
+		}
+	}()
+
+	eb.buf.Reset()
+	if err := eb.enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), eb.buf.Bytes()...), nil
+}
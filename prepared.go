@@ -0,0 +1,188 @@
+package sqlcache
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// preparedQuery holds what's derived from the query text alone, so that
+// it's computed once per prepared statement instead of on every execution.
+type preparedQuery struct {
+	query string
+	// text is the query text analyzed; see Interceptor.queryText.
+	text string
+	// hashQuery is the query text that's hashed, normalized when
+	// Config.NormalizeQuery is set.
+	hashQuery   string
+	attrs       *attributes
+	fingerprint string
+	// write is set for statements other than reads, such as INSERT with
+	// RETURNING.
+	write bool
+	// nonDeterministic is the first non-deterministic function called by
+	// the query, if any, when nonDetChecked is set.
+	nonDeterministic string
+	nonDetChecked    bool
+	// tables are the tables read by the query, if known, when
+	// Config.InvalidateOnDDL is set.
+	tables []string
+	// in is the IN list of queries with @cache-fragment-by.
+	in *inList
+	// digest is the partial hash of the query when HashFunc is XXHash.
+	digest *xxhash.Digest
+	// strictState is the hash state of the query when HashFunc is the
+	// default or StrictHash; see strictQueryState.
+	strictState []byte
+}
+
+func (i *Interceptor) prepare(query string) *preparedQuery {
+	text := i.queryText(query)
+	p := &preparedQuery{
+		query:     query,
+		text:      text,
+		hashQuery: query,
+		attrs:     getAttrs(text),
+	}
+	if p.attrs == nil {
+		p.attrs = i.autoCache.getAttrs(text, i.analyze)
+	}
+	if p.attrs == nil {
+		return p
+	}
+
+	p.fingerprint = fingerprint(text)
+	if i.normalize {
+		p.hashQuery = normalizeQuery(text)
+	}
+	p.write = !i.isRead(text)
+	if i.parser != nil || i.options().NonDeterministic != NonDeterministicAllow {
+		p.nonDeterministic = i.nonDeterministicCall(text)
+		p.nonDetChecked = true
+	}
+	if i.ddl != nil {
+		p.tables = i.analyze(text).Tables
+	}
+	if p.attrs.fragmentBy != "" && p.attrs.err == nil {
+		var err error
+		if p.in, err = parseInList(text); err != nil {
+			attr := "@cache-fragment-by"
+			if p.attrs.rowKey {
+				attr = "@cache-row-key"
+			}
+			p.attrs.err = fmt.Errorf("%s %w", attr, err)
+		} else {
+			p.in.hashQuery = p.in.rewrite(1)
+			if i.normalize {
+				p.in.hashQuery = normalizeQuery(p.in.hashQuery)
+			}
+		}
+	}
+	switch {
+	case i.xxHash:
+		d := xxQueryDigest(p.hashQuery)
+		p.digest = &d
+	case i.strictHash:
+		p.strictState = strictQueryState(p.hashQuery)
+	}
+
+	return p
+}
+
+// nonDeterministicCall returns the first non-deterministic function called
+// by the query, if any. Queries prepared while Config.NonDeterministic was
+// NonDeterministicAllow, without Config.Parser, are checked on every call.
+func (p *preparedQuery) nonDeterministicCall() string {
+	if p.nonDetChecked {
+		return p.nonDeterministic
+	}
+
+	return nonDeterministicCall(p.text)
+}
+
+// queryText returns the text of the query that's analyzed for cache
+// attributes and what the statement does: the query, with the quotes its
+// strings escape with backslashes doubled instead when
+// Config.BackslashEscapes is set, as by doubleQuoteEscapes. The queries of
+// fragments are rewritten from it, which databases read the same way.
+func (i *Interceptor) queryText(query string) string {
+	if !i.backslash {
+		return query
+	}
+
+	return doubleQuoteEscapes(query)
+}
+
+// hash returns the cache key of the query run with args. Named args are
+// passed to the hash function as by canonicalArgs, so that calls binding
+// the same names in a different order share a key.
+func (i *Interceptor) hash(p *preparedQuery, args []driver.NamedValue) (string, error) {
+	args = canonicalArgs(args)
+	if p.digest != nil {
+		return xxSumArgs(*p.digest, args), nil
+	}
+
+	if p.strictState != nil {
+		return i.callHashFunc(func(_ string, args []driver.NamedValue) (string, error) {
+			if i.normalizeArgs {
+				args = normalizeArgs(args)
+			}
+			return strictSumArgs(p.strictState, args), nil
+		}, p.hashQuery, args)
+	}
+
+	return i.callHashFunc(i.hashFunc, p.hashQuery, args)
+}
+
+// stmtState is what's tracked of a statement prepared through the
+// interceptor, so that its executions neither parse nor hash its text
+// again, and so that it can be told apart from other statements by
+// identity.
+type stmtState struct {
+	*preparedQuery
+	// driver is the policy of the driver the statement was prepared
+	// through, if any.
+	driver *driverPolicy
+	// conn is the conn the statement was prepared on, as by unwrapParent.
+	conn interface{}
+	// inTx is set when the statement was prepared within a transaction.
+	inTx bool
+}
+
+// stmtState returns the state of the statement, if it was prepared through
+// the interceptor, and whether it can be tracked at all.
+func (i *Interceptor) stmtState(stmt interface{}) (st *stmtState, tracked bool) {
+	key := unwrapParent(stmt, "Stmt")
+	if !isComparable(key) {
+		return nil, false
+	}
+	v, ok := i.stmts.Load(key)
+	if !ok {
+		return nil, true
+	}
+
+	return v.(*stmtState), true
+}
+
+func isXXHash(fn func(string, []driver.NamedValue) (string, error)) bool {
+	return sameFunc(fn, XXHash)
+}
+
+// isStrictHash reports whether fn is StrictHash or the default hash
+// function, which is StrictHash of normalized args.
+func isStrictHash(fn func(string, []driver.NamedValue) (string, error)) (strict, normalized bool) {
+	switch {
+	case sameFunc(fn, StrictHash):
+		return true, false
+	case sameFunc(fn, defaultHashFunc):
+		return true, true
+	}
+
+	return false, false
+}
+
+func sameFunc(a, b func(string, []driver.NamedValue) (string, error)) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
@@ -0,0 +1,171 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreparedQuery(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	key, _ := XXHash(query, []driver.NamedValue{{Ordinal: 1, Value: int64(18)}})
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, key).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, key, mock.Anything, time.Duration(30*time.Second)).Return(nil)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:    mCacher,
+		HashFunc: XXHash,
+	})
+	assert.True(ic.xxHash)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	prepared := func() []*preparedQuery {
+		var ps []*preparedQuery
+		ic.stmts.Range(func(_, v interface{}) bool {
+			ps = append(ps, v.(*stmtState).preparedQuery)
+			return true
+		})
+		return ps
+	}
+
+	qMock.ExpectPrepare(query)
+	stmt, err := db.PrepareContext(context.Background(), query)
+	assert.Nil(err)
+
+	ps := prepared()
+	assert.Len(ps, 1)
+	assert.Equal(&attributes{ttl: 30, maxRows: 10}, ps[0].attrs)
+	assert.Equal(fingerprint(query), ps[0].fingerprint)
+	assert.NotNil(ps[0].digest)
+
+	for n := 0; n < 2; n++ {
+		qMock.ExpectQuery(query).WithArgs(18).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+		rows, err := stmt.QueryContext(context.Background(), 18)
+		assert.Nil(err)
+		for rows.Next() {
+		}
+		assert.Nil(rows.Close())
+	}
+	assert.Nil(qMock.ExpectationsWereMet())
+	mCacher.AssertNumberOfCalls(t, "Set", 2)
+
+	assert.Nil(stmt.Close())
+	assert.Len(prepared(), 0)
+
+	ic, _ = NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+	assert.False(ic.xxHash)
+}
+
+func TestPreparedStmtState(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{Cache: mc, DisableTxCaching: true})
+	assert.Nil(err)
+	assert.True(ic.strictHash)
+
+	d, err := ic.DriverWithPolicy(mockDB.Driver(), DriverPolicy{Name: "replica"})
+	assert.Nil(err)
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, d)
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	states := func() []*stmtState {
+		var sts []*stmtState
+		ic.stmts.Range(func(_, v interface{}) bool {
+			sts = append(sts, v.(*stmtState))
+			return true
+		})
+		return sts
+	}
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	run := func(stmt *sql.Stmt) {
+		rows, err := stmt.QueryContext(context.Background(), 18)
+		assert.Nil(err)
+		for rows.Next() {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	// statements carry the policy of the driver and the hash state of
+	// their text
+	qMock.ExpectPrepare(query)
+	stmt, err := db.PrepareContext(context.Background(), query)
+	assert.Nil(err)
+	sts := states()
+	assert.Len(sts, 1)
+	assert.Equal("replica", sts[0].driver.name())
+	assert.False(sts[0].inTx)
+	assert.NotNil(sts[0].strictState)
+
+	qMock.ExpectQuery(query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	run(stmt)
+	run(stmt)
+	key, err := ic.Key(query, 18)
+	assert.Nil(err)
+	assert.Contains(mc.entries, key)
+	s := ic.Stats()
+	assert.Equal(uint64(1), s.Hits)
+	assert.Equal(uint64(1), s.DriverMisses["replica"])
+	assert.Nil(stmt.Close())
+
+	// and whether they were prepared within a transaction
+	qMock.ExpectBegin()
+	qMock.ExpectPrepare(query)
+	qMock.ExpectQuery(query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	qMock.ExpectCommit()
+	tx, err := db.BeginTx(context.Background(), nil)
+	assert.Nil(err)
+	stmt, err = tx.PrepareContext(context.Background(), query)
+	assert.Nil(err)
+	sts = states()
+	assert.Len(sts, 1)
+	assert.True(sts[0].inTx)
+	run(stmt)
+	assert.Nil(stmt.Close())
+	assert.Nil(tx.Commit())
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipInTx])
+	assert.Len(states(), 0)
+	assert.Nil(qMock.ExpectationsWereMet())
+}
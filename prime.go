@@ -0,0 +1,116 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+const defaultPrimeConcurrency = 4
+
+// PrimeSpec is a query, along with its arguments, to be run by
+// Interceptor.Prime. The query must have cache attributes.
+type PrimeSpec struct {
+	Query string
+	Args  []interface{}
+}
+
+// PrimeOption configures optional behaviour of Interceptor.Prime.
+type PrimeOption func(o *primeOptions)
+
+type primeOptions struct {
+	concurrency int
+}
+
+// WithPrimeConcurrency sets the maximum number of queries run concurrently
+// by Interceptor.Prime. Defaults to 4.
+func WithPrimeConcurrency(n int) PrimeOption {
+	return func(o *primeOptions) {
+		o.concurrency = n
+	}
+}
+
+// Prime runs the queries and reads all their rows to populate the cache,
+// to avoid a storm of cache misses after a deploy. db must have been opened
+// with a driver wrapped by this interceptor. Queries already cached are
+// served from cache. All queries are run even if some fail; the error
+// returned describes the failures. No more queries are started once ctx is
+// done.
+func (i *Interceptor) Prime(ctx context.Context, db *sql.DB, queries []PrimeSpec, opts ...PrimeOption) error {
+	o := primeOptions{concurrency: defaultPrimeConcurrency}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency <= 0 {
+		o.concurrency = 1
+	}
+
+	for n, spec := range queries {
+		attrs := getAttrs(i.queryText(spec.Query))
+		if attrs == nil {
+			return fmt.Errorf("query %d has no cache attributes", n)
+		}
+		if err := attrs.validate(i.options().ZeroTTL); err != nil {
+			return fmt.Errorf("query %d has invalid cache attributes: %w", n, err)
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failed   int
+		firstErr error
+	)
+	sem := make(chan struct{}, o.concurrency)
+	launched := 0
+	for n, spec := range queries {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		launched++
+		wg.Add(1)
+		go func(n int, spec PrimeSpec) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := prime(ctx, db, spec); err != nil {
+				mu.Lock()
+				failed++
+				if firstErr == nil {
+					firstErr = fmt.Errorf("query %d: %w", n, err)
+				}
+				mu.Unlock()
+			}
+		}(n, spec)
+	}
+	wg.Wait()
+
+	if launched < len(queries) {
+		return fmt.Errorf("priming stopped after %d of %d queries: %w", launched, len(queries), ctx.Err())
+	}
+	if failed > 0 {
+		return fmt.Errorf("priming failed for %d of %d queries: %w", failed, len(queries), firstErr)
+	}
+
+	return nil
+}
+
+func prime(ctx context.Context, db *sql.DB, spec PrimeSpec) error {
+	rows, err := db.QueryContext(ctx, spec.Query, spec.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// rows are recorded by the interceptor as they're read
+	for rows.Next() {
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,74 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrime(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Twice()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: mCacher,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	err = ic.Prime(context.Background(), db, []PrimeSpec{{Query: "SELECT 1"}})
+	assert.NotNil(err)
+
+	qMock.ExpectQuery(query).WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	qMock.ExpectQuery(query).WithArgs(20).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Lisa"))
+	qMock.ExpectQuery(query).WithArgs(30).WillReturnError(errors.New("some error"))
+
+	err = ic.Prime(context.Background(), db, []PrimeSpec{
+		{Query: query, Args: []interface{}{18}},
+		{Query: query, Args: []interface{}{20}},
+		{Query: query, Args: []interface{}{30}},
+	}, WithPrimeConcurrency(1))
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "1 of 3")
+	assert.Nil(qMock.ExpectationsWereMet())
+	assert.Equal(uint64(2), ic.Stats().Sets)
+
+	// no queries are started once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ic.Prime(ctx, db, []PrimeSpec{
+		{Query: query, Args: []interface{}{18}},
+		{Query: query, Args: []interface{}{20}},
+	})
+	assert.ErrorIs(err, context.Canceled)
+	assert.Contains(err.Error(), "stopped after 0 of 2")
+	assert.True(mCacher.AssertExpectations(t))
+}
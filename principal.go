@@ -0,0 +1,14 @@
+package sqlcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// withPrincipal returns the key scoped by the principal. The principal is
+// hashed so that user names and roles don't appear in keys.
+func withPrincipal(key, principal string) string {
+	sum := sha256.Sum256([]byte(principal))
+
+	return key + "|p:" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,94 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+type principalKey struct{}
+
+func TestPrincipal(t *testing.T) {
+	assert := require.New(t)
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	var errs []error
+	ic, err := NewInterceptor(&Config{
+		Cache: mc,
+		Principal: func(ctx context.Context) string {
+			p, _ := ctx.Value(principalKey{}).(string)
+			if p == "panic" {
+				panic("boom")
+			}
+			return p
+		},
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          -- @cache-per-principal
+	          SELECT title FROM documents`
+	run := func(ic *Interceptor, principal, query string) {
+		ctx := context.WithValue(context.Background(), principalKey{}, principal)
+		rows, err := ic.intercept(ctx, ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	run(ic, "alice", query)
+	run(ic, "alice", query)
+	run(ic, "bob", query)
+	assert.Len(mc.entries, 2)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+	for key := range mc.entries {
+		assert.False(strings.Contains(key, "alice"))
+		assert.False(strings.Contains(key, "bob"))
+	}
+
+	// queries without the attribute are shared by principals
+	shared := `-- @cache-ttl 30
+	           -- @cache-max-rows 10
+	           SELECT name FROM categories`
+	run(ic, "alice", shared)
+	run(ic, "bob", shared)
+	assert.Equal(uint64(2), ic.Stats().Hits)
+
+	key, err := ic.Key(query)
+	assert.Nil(err)
+	assert.Contains(mc.entries, PrincipalKey(key, "alice"))
+	assert.Contains(mc.entries, PrincipalKey(key, "bob"))
+
+	ctx := context.WithValue(context.Background(), principalKey{}, "alice")
+	_, ok, err := ic.Inspect(ctx, query)
+	assert.Nil(err)
+	assert.True(ok)
+	_, ok, err = ic.Inspect(context.Background(), query)
+	assert.Nil(err)
+	assert.False(ok)
+
+	// queries without a principal aren't cached
+	run(ic, "", query)
+	run(ic, "panic", query)
+	assert.Equal(uint64(2), ic.Stats().SkipReasons[SkipNoPrincipal])
+	assert.Len(errs, 1)
+	assert.True(errors.Is(errs[0], ErrPanic))
+
+	// the attribute requires Config.Principal
+	ic, err = NewInterceptor(&Config{Cache: mc})
+	assert.Nil(err)
+	run(ic, "alice", query)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipInvalidAttributes])
+}
@@ -0,0 +1,171 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// defaultProbeQuery is the statement prepared by ProbeDriver to inspect
+// the driver's statements.
+const defaultProbeQuery = "SELECT 1"
+
+// DriverReport describes the optional database/sql/driver interfaces
+// implemented by a driver, its conns and its statements, and the features
+// of the interceptor that are therefore available or degraded.
+type DriverReport struct {
+	// Driver, Conn and Stmt list the optional interfaces implemented, by
+	// name, such as "driver.QueryerContext".
+	Driver []string
+	Conn   []string
+	Stmt   []string
+	// Features are the features of the interceptor depending on them.
+	Features []DriverFeature
+}
+
+// DriverFeature is a feature of the interceptor as available with a
+// driver.
+type DriverFeature struct {
+	Name string
+	// Available is false when the feature doesn't work, or works only in
+	// part, with the driver.
+	Available bool
+	// Reason explains why the feature isn't available, and what's done
+	// instead.
+	Reason string
+}
+
+// Degraded returns the features that aren't available.
+func (r *DriverReport) Degraded() []DriverFeature {
+	var degraded []DriverFeature
+	for _, f := range r.Features {
+		if !f.Available {
+			degraded = append(degraded, f)
+		}
+	}
+
+	return degraded
+}
+
+// String returns the report in a form suitable for logs.
+func (r *DriverReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "driver: %s\n", strings.Join(r.Driver, ", "))
+	fmt.Fprintf(&b, "conn: %s\n", strings.Join(r.Conn, ", "))
+	fmt.Fprintf(&b, "stmt: %s\n", strings.Join(r.Stmt, ", "))
+	for _, f := range r.Features {
+		if f.Available {
+			fmt.Fprintf(&b, "%s: available\n", f.Name)
+		} else {
+			fmt.Fprintf(&b, "%s: degraded: %s\n", f.Name, f.Reason)
+		}
+	}
+
+	return b.String()
+}
+
+// ProbeDriver opens a connection to dsn with d, as passed to
+// Interceptor.Driver, prepares probeQuery on it, which defaults to
+// "SELECT 1", and reports which features of the interceptor work with the
+// driver, so that incompatibilities, such as queries of a driver whose
+// conns can't be tracked never being cached, surface at startup instead
+// of in production. The connection is closed before returning.
+func ProbeDriver(ctx context.Context, d driver.Driver, dsn, probeQuery string) (*DriverReport, error) {
+	if probeQuery == "" {
+		probeQuery = defaultProbeQuery
+	}
+
+	var (
+		conn driver.Conn
+		err  error
+	)
+	if dc, ok := d.(driver.DriverContext); ok {
+		var connector driver.Connector
+		if connector, err = dc.OpenConnector(dsn); err == nil {
+			conn, err = connector.Connect(ctx)
+		}
+	} else {
+		conn, err = d.Open(dsn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqlcache: opening connection failed: %w", err)
+	}
+	defer conn.Close()
+
+	var stmt driver.Stmt
+	if pc, ok := conn.(driver.ConnPrepareContext); ok {
+		stmt, err = pc.PrepareContext(ctx, probeQuery)
+	} else {
+		stmt, err = conn.Prepare(probeQuery)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqlcache: preparing probe query failed: %w", err)
+	}
+	defer stmt.Close()
+
+	return probe(d, conn, stmt), nil
+}
+
+func probe(d driver.Driver, conn driver.Conn, stmt driver.Stmt) *DriverReport {
+	r := &DriverReport{}
+	has := func(list *[]string, name string, ok bool) bool {
+		if ok {
+			*list = append(*list, name)
+		}
+		return ok
+	}
+
+	_, ok := d.(driver.DriverContext)
+	has(&r.Driver, "driver.DriverContext", ok)
+
+	_, ok = conn.(driver.QueryerContext)
+	queryerCtx := has(&r.Conn, "driver.QueryerContext", ok)
+	_, ok = conn.(driver.Queryer)
+	queryer := has(&r.Conn, "driver.Queryer", ok)
+	_, ok = conn.(driver.ExecerContext)
+	execerCtx := has(&r.Conn, "driver.ExecerContext", ok)
+	_, ok = conn.(driver.Execer)
+	execer := has(&r.Conn, "driver.Execer", ok)
+	_, ok = conn.(driver.ConnPrepareContext)
+	has(&r.Conn, "driver.ConnPrepareContext", ok)
+	_, ok = conn.(driver.ConnBeginTx)
+	has(&r.Conn, "driver.ConnBeginTx", ok)
+	_, ok = conn.(driver.NamedValueChecker)
+	checker := has(&r.Conn, "driver.NamedValueChecker", ok)
+	_, ok = conn.(driver.SessionResetter)
+	has(&r.Conn, "driver.SessionResetter", ok)
+	_, ok = conn.(driver.Validator)
+	has(&r.Conn, "driver.Validator", ok)
+	_, ok = conn.(driver.Pinger)
+	has(&r.Conn, "driver.Pinger", ok)
+
+	_, ok = stmt.(driver.StmtQueryContext)
+	has(&r.Stmt, "driver.StmtQueryContext", ok)
+	_, ok = stmt.(driver.StmtExecContext)
+	has(&r.Stmt, "driver.StmtExecContext", ok)
+	_, ok = stmt.(driver.NamedValueChecker)
+	checker = has(&r.Stmt, "driver.NamedValueChecker", ok) || checker
+	_, ok = stmt.(driver.ColumnConverter)
+	checker = has(&r.Stmt, "driver.ColumnConverter", ok) || checker
+
+	feature := func(name string, available bool, reason string) {
+		f := DriverFeature{Name: name, Available: available}
+		if !available {
+			f.Reason = reason
+		}
+		r.Features = append(r.Features, f)
+	}
+	feature("queries", queryerCtx || queryer,
+		"conns implement neither driver.QueryerContext nor driver.Queryer, so every query is prepared first; Config.Explain and @cache-fragment-by don't apply")
+	feature("transactions", isComparable(conn),
+		fmt.Sprintf("conns of type %T can't be tracked, so their queries are assumed to be within transactions and aren't cached when Config.DisableTxCaching is set", conn))
+	feature("prepared statements", isComparable(stmt),
+		fmt.Sprintf("statements of type %T can't be tracked, so they're assumed to be within transactions and aren't cached when Config.DisableTxCaching is set", stmt))
+	feature("exec", execerCtx || execer,
+		"conns implement neither driver.ExecerContext nor driver.Execer, so Exec calls on them fail through the interceptor")
+	feature("keys", !checker,
+		"the driver converts args itself, so Interceptor.Key, Inspect and InvalidateRows, which convert them as database/sql does by default, may compute other keys than queries")
+
+	return r
+}
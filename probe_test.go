@@ -0,0 +1,77 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+// bareDriver opens conns implementing none of the optional interfaces,
+// whose values can't be used as map keys.
+type bareDriver struct{}
+
+func (bareDriver) Open(dsn string) (driver.Conn, error) {
+	if dsn == "" {
+		return nil, errors.New("no dsn")
+	}
+	return bareConn{tags: []string{dsn}}, nil
+}
+
+type bareConn struct {
+	tags []string
+}
+
+func (bareConn) Prepare(query string) (driver.Stmt, error) { return bareStmt{}, nil }
+func (bareConn) Close() error                              { return nil }
+func (bareConn) Begin() (driver.Tx, error)                 { return nil, errors.New("unsupported") }
+
+type bareStmt struct{}
+
+func (bareStmt) Close() error                                    { return nil }
+func (bareStmt) NumInput() int                                   { return -1 }
+func (bareStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.ResultNoRows, nil }
+func (bareStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("unsupported")
+}
+
+func TestProbeDriver(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	qMock.ExpectPrepare("SELECT 1")
+	r, err := ProbeDriver(context.Background(), mockDB.Driver(), dsn, "")
+	assert.Nil(err)
+	assert.Contains(r.Conn, "driver.QueryerContext")
+	assert.Contains(r.Stmt, "driver.StmtQueryContext")
+	assert.Len(r.Features, 5)
+	// sqlmock converts args itself
+	assert.Equal([]string{"keys"}, featureNames(r.Degraded()))
+
+	r, err = ProbeDriver(context.Background(), bareDriver{}, "bare", "")
+	assert.Nil(err)
+	assert.Empty(r.Driver)
+	assert.Empty(r.Conn)
+	assert.Empty(r.Stmt)
+	assert.Equal([]string{"queries", "transactions", "exec"}, featureNames(r.Degraded()))
+	assert.Contains(r.String(), "transactions: degraded: conns of type sqlcache.bareConn can't be tracked")
+
+	_, err = ProbeDriver(context.Background(), bareDriver{}, "", "")
+	assert.NotNil(err)
+}
+
+func featureNames(features []DriverFeature) []string {
+	var names []string
+	for _, f := range features {
+		names = append(names, f.Name)
+	}
+	return names
+}
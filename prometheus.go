@@ -0,0 +1,193 @@
+package sqlcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusCollector implements prometheus.Collector interface and exports
+// sqlcache stats along with latency histograms of cache backend operations
+// and stats reported by backends implementing cache.StatsReporter, which
+// are fetched at most every 30 seconds.
+type PrometheusCollector struct {
+	i         *Interceptor
+	hits      *prometheus.Desc
+	l1Hits    *prometheus.Desc
+	misses    *prometheus.Desc
+	errors    *prometheus.Desc
+	retries   *prometheus.Desc
+	sets      *prometheus.Desc
+	coalesced *prometheus.Desc
+	shed      *prometheus.Desc
+	panics    *prometheus.Desc
+	shadow    *prometheus.Desc
+	mismatch  *prometheus.Desc
+	memoHits  *prometheus.Desc
+	staleHits *prometheus.Desc
+	ddl       *prometheus.Desc
+	drvMisses *prometheus.Desc
+	skips     *prometheus.Desc
+	saved     *prometheus.Desc
+	entries   *prometheus.Desc
+	bytes     *prometheus.Desc
+	evicted   *prometheus.Desc
+	dryHits   *prometheus.Desc
+	drySets   *prometheus.Desc
+	dryBytes  *prometheus.Desc
+	healthy   *prometheus.Desc
+	trips     *prometheus.Desc
+	latency   *prometheus.HistogramVec
+}
+
+// NewPrometheusCollector creates a new prometheus collector for the
+// interceptor. The collector must be registered with a prometheus registry
+// for the metrics to be exported:
+//
+//	prometheus.MustRegister(sqlcache.NewPrometheusCollector(interceptor))
+func NewPrometheusCollector(i *Interceptor) *PrometheusCollector {
+	pc := &PrometheusCollector{
+		i: i,
+		hits: prometheus.NewDesc("sqlcache_hits_total",
+			"Number of queries served from cache.", nil, nil),
+		l1Hits: prometheus.NewDesc("sqlcache_l1_hits_total",
+			"Number of queries served from the in-process cache, included in sqlcache_hits_total.", nil, nil),
+		misses: prometheus.NewDesc("sqlcache_misses_total",
+			"Number of queries not found in cache.", nil, nil),
+		errors: prometheus.NewDesc("sqlcache_errors_total",
+			"Number of errors returned by the cache backend or hash function.", nil, nil),
+		retries: prometheus.NewDesc("sqlcache_retries_total",
+			"Number of retries of failed cache backend operations.", nil, nil),
+		sets: prometheus.NewDesc("sqlcache_sets_total",
+			"Number of query results written to cache.", nil, nil),
+		coalesced: prometheus.NewDesc("sqlcache_coalesced_total",
+			"Number of cache misses served with the results of a concurrent identical query.", nil, nil),
+		shed: prometheus.NewDesc("sqlcache_shed_total",
+			"Number of queries failed due to too many concurrent misses of the query.", nil, nil),
+		panics: prometheus.NewDesc("sqlcache_panics_total",
+			"Number of panics recovered from user callbacks.", nil, nil),
+		shadow: prometheus.NewDesc("sqlcache_shadow_checks_total",
+			"Number of cache hits verified against the database.", nil, nil),
+		mismatch: prometheus.NewDesc("sqlcache_shadow_mismatches_total",
+			"Number of verified cache hits whose results differed from the database.", nil, nil),
+		memoHits: prometheus.NewDesc("sqlcache_memo_hits_total",
+			"Number of queries served from a request memo.", nil, nil),
+		staleHits: prometheus.NewDesc("sqlcache_stale_hits_total",
+			"Number of cache misses served with results past their TTL.", nil, nil),
+		ddl: prometheus.NewDesc("sqlcache_ddl_invalidations_total",
+			"Number of DDL statements that invalidated cached results.", nil, nil),
+		drvMisses: prometheus.NewDesc("sqlcache_driver_misses_total",
+			"Number of cache misses of queries run through drivers with a policy, by driver.", []string{"driver"}, nil),
+		skips: prometheus.NewDesc("sqlcache_skips_total",
+			"Number of queries whose results weren't cached, by reason.", []string{"reason"}, nil),
+		saved: prometheus.NewDesc("sqlcache_estimated_time_saved_seconds",
+			"Estimated time saved by serving tracked queries from cache.", nil, nil),
+		entries: prometheus.NewDesc("sqlcache_backend_entries",
+			"Number of items in the cache backend.", nil, nil),
+		bytes: prometheus.NewDesc("sqlcache_backend_bytes",
+			"Memory used by the cache backend.", nil, nil),
+		evicted: prometheus.NewDesc("sqlcache_backend_evictions_total",
+			"Number of items evicted by the cache backend.", nil, nil),
+		dryHits: prometheus.NewDesc("sqlcache_dry_run_hits_total",
+			"Number of queries that would have been served from cache in dry-run mode.", nil, nil),
+		drySets: prometheus.NewDesc("sqlcache_dry_run_sets_total",
+			"Number of query results that would have been written to cache in dry-run mode.", nil, nil),
+		dryBytes: prometheus.NewDesc("sqlcache_dry_run_bytes",
+			"Encoded size of the query results that would be in cache in dry-run mode.", nil, nil),
+		healthy: prometheus.NewDesc("sqlcache_backend_healthy",
+			"Whether the cache backend is used (1) or bypassed after repeated failures (0).", nil, nil),
+		trips: prometheus.NewDesc("sqlcache_backend_trips_total",
+			"Number of times the cache backend was bypassed after repeated failures.", nil, nil),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "sqlcache_backend_operation_duration_seconds",
+			Help:    "Latency of cache backend operations.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16), // 100µs to ~3.3s
+		}, []string{"operation", "result"}),
+	}
+
+	i.addOpObserver(func(op string, d time.Duration, err error) {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		pc.latency.WithLabelValues(op, result).Observe(d.Seconds())
+	})
+
+	return pc
+}
+
+// Describe implements prometheus.Collector.
+func (pc *PrometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.hits
+	ch <- pc.l1Hits
+	ch <- pc.misses
+	ch <- pc.errors
+	ch <- pc.retries
+	ch <- pc.sets
+	ch <- pc.coalesced
+	ch <- pc.shed
+	ch <- pc.panics
+	ch <- pc.shadow
+	ch <- pc.mismatch
+	ch <- pc.memoHits
+	ch <- pc.staleHits
+	ch <- pc.ddl
+	ch <- pc.drvMisses
+	ch <- pc.skips
+	ch <- pc.saved
+	ch <- pc.entries
+	ch <- pc.bytes
+	ch <- pc.evicted
+	ch <- pc.dryHits
+	ch <- pc.drySets
+	ch <- pc.dryBytes
+	ch <- pc.healthy
+	ch <- pc.trips
+	pc.latency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (pc *PrometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	s := pc.i.Stats()
+	ch <- prometheus.MustNewConstMetric(pc.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(pc.l1Hits, prometheus.CounterValue, float64(s.L1Hits))
+	ch <- prometheus.MustNewConstMetric(pc.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(pc.errors, prometheus.CounterValue, float64(s.Errors))
+	ch <- prometheus.MustNewConstMetric(pc.retries, prometheus.CounterValue, float64(s.Retries))
+	ch <- prometheus.MustNewConstMetric(pc.sets, prometheus.CounterValue, float64(s.Sets))
+	ch <- prometheus.MustNewConstMetric(pc.coalesced, prometheus.CounterValue, float64(s.Coalesced))
+	ch <- prometheus.MustNewConstMetric(pc.shed, prometheus.CounterValue, float64(s.Shed))
+	ch <- prometheus.MustNewConstMetric(pc.panics, prometheus.CounterValue, float64(s.Panics))
+	ch <- prometheus.MustNewConstMetric(pc.shadow, prometheus.CounterValue, float64(s.ShadowChecks))
+	ch <- prometheus.MustNewConstMetric(pc.mismatch, prometheus.CounterValue, float64(s.ShadowMismatches))
+	ch <- prometheus.MustNewConstMetric(pc.memoHits, prometheus.CounterValue, float64(s.MemoHits))
+	ch <- prometheus.MustNewConstMetric(pc.staleHits, prometheus.CounterValue, float64(s.StaleHits))
+	ch <- prometheus.MustNewConstMetric(pc.ddl, prometheus.CounterValue, float64(s.DDLInvalidations))
+	for reason, count := range s.SkipReasons {
+		ch <- prometheus.MustNewConstMetric(pc.skips, prometheus.CounterValue, float64(count), string(reason))
+	}
+	for name, count := range s.DriverMisses {
+		ch <- prometheus.MustNewConstMetric(pc.drvMisses, prometheus.CounterValue, float64(count), name)
+	}
+	ch <- prometheus.MustNewConstMetric(pc.saved, prometheus.GaugeValue, pc.i.EstimatedTimeSaved().Seconds())
+	if b := pc.i.cachedBackendStats(context.Background()); b != nil {
+		ch <- prometheus.MustNewConstMetric(pc.entries, prometheus.GaugeValue, float64(b.Entries))
+		ch <- prometheus.MustNewConstMetric(pc.bytes, prometheus.GaugeValue, float64(b.Bytes))
+		ch <- prometheus.MustNewConstMetric(pc.evicted, prometheus.CounterValue, float64(b.Evictions))
+	}
+	if d := s.DryRun; d != nil {
+		ch <- prometheus.MustNewConstMetric(pc.dryHits, prometheus.CounterValue, float64(d.Hits))
+		ch <- prometheus.MustNewConstMetric(pc.drySets, prometheus.CounterValue, float64(d.Sets))
+		ch <- prometheus.MustNewConstMetric(pc.dryBytes, prometheus.GaugeValue, float64(d.Bytes))
+	}
+	if h := s.Health; h != nil {
+		var healthy float64
+		if h.Healthy {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(pc.healthy, prometheus.GaugeValue, healthy)
+		ch <- prometheus.MustNewConstMetric(pc.trips, prometheus.CounterValue, float64(h.Trips))
+	}
+	pc.latency.Collect(ch)
+}
@@ -0,0 +1,89 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusCollector(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: mCacher,
+	})
+
+	reg := prometheus.NewPedanticRegistry()
+	assert.Nil(reg.Register(NewPrometheusCollector(ic)))
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	runQuery(t, assert, qMock, db, query, true)
+
+	mfs, err := reg.Gather()
+	assert.Nil(err)
+
+	got := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			switch {
+			case m.GetCounter() != nil:
+				got[mf.GetName()] = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				got[mf.GetName()] = m.GetGauge().GetValue()
+			case m.GetHistogram() != nil:
+				name := mf.GetName()
+				for _, l := range m.GetLabel() {
+					name += ":" + l.GetValue()
+				}
+				got[name] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	assert.Equal(map[string]float64{
+		"sqlcache_hits_total":                                     0,
+		"sqlcache_misses_total":                                   1,
+		"sqlcache_errors_total":                                   0,
+		"sqlcache_sets_total":                                     1,
+		"sqlcache_coalesced_total":                                0,
+		"sqlcache_retries_total":                                  0,
+		"sqlcache_l1_hits_total":                                  0,
+		"sqlcache_shed_total":                                     0,
+		"sqlcache_panics_total":                                   0,
+		"sqlcache_shadow_checks_total":                            0,
+		"sqlcache_shadow_mismatches_total":                        0,
+		"sqlcache_memo_hits_total":                                0,
+		"sqlcache_stale_hits_total":                               0,
+		"sqlcache_ddl_invalidations_total":                        0,
+		"sqlcache_skips_total":                                    0,
+		"sqlcache_estimated_time_saved_seconds":                   0,
+		"sqlcache_backend_operation_duration_seconds:get:success": 1,
+		"sqlcache_backend_operation_duration_seconds:set:success": 1,
+	}, got)
+}
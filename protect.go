@@ -0,0 +1,274 @@
+package sqlcache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// columnAttrRegexp matches the @cache-mask and @cache-encrypt attributes,
+// which list columns protected in addition to Config.ColumnProtection.
+var columnAttrRegexp = regexp.MustCompile(`@cache-(mask|encrypt) ([A-Za-z0-9_$.,]+)`)
+
+// ColumnProtection protects the values of sensitive columns in cached
+// results while other columns are cached as is. Columns are matched by
+// name, case-insensitively, as listed here or in the @cache-mask and
+// @cache-encrypt attributes of queries. NULLs are cached as is.
+type ColumnProtection struct {
+	// Mask lists columns whose values are cached as the zero value of
+	// their type, such as an empty string, so that hits return them
+	// masked. Values of types other than those of driver.Value are
+	// cached as NULL.
+	Mask []string
+	// Encrypt lists columns whose values are cached encrypted with
+	// AES-GCM and decrypted on hits. Hits on values encrypted with
+	// another Key fail to decrypt, so changing it requires a new key
+	// prefix or an empty cache.
+	Encrypt []string
+	// Key is the AES key, of 16, 24 or 32 bytes, used to encrypt values.
+	// It's required to encrypt columns, including by @cache-encrypt.
+	Key []byte
+}
+
+// sealedValue is an encrypted value, as cached.
+type sealedValue []byte
+
+var registerSealedValue sync.Once
+
+// columnProtector protects the columns of results as per ColumnProtection
+// and the attributes of queries.
+type columnProtector struct {
+	mask    map[string]bool
+	encrypt map[string]bool
+	aead    cipher.AEAD // nil without a key
+}
+
+func newColumnProtector(cp *ColumnProtection) (*columnProtector, error) {
+	p := &columnProtector{
+		mask:    make(map[string]bool),
+		encrypt: make(map[string]bool),
+	}
+	if cp == nil {
+		return p, nil
+	}
+
+	for _, col := range cp.Mask {
+		p.mask[strings.ToLower(col)] = true
+	}
+	for _, col := range cp.Encrypt {
+		p.encrypt[strings.ToLower(col)] = true
+	}
+	if cp.Key == nil {
+		if len(p.encrypt) > 0 {
+			return nil, errors.New("ColumnProtection.Key is required to encrypt columns")
+		}
+		return p, nil
+	}
+
+	block, err := aes.NewCipher(cp.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ColumnProtection.Key: %w", err)
+	}
+	if p.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	// encrypted values are serialized by codecs as a registered type,
+	// which only those encrypting pay for
+	registerSealedValue.Do(func() {
+		RegisterValueType("sqlcache.sealed", sealedValue(nil),
+			func(v driver.Value) ([]byte, error) { return v.(sealedValue), nil },
+			func(b []byte) (driver.Value, error) { return sealedValue(b), nil })
+	})
+
+	return p, nil
+}
+
+// getColumnAttrs returns the columns listed by the @cache-mask and
+// @cache-encrypt attributes of the query, lowercase.
+func getColumnAttrs(query string) (mask, encrypt []string) {
+	for _, match := range columnAttrRegexp.FindAllStringSubmatch(query, -1) {
+		for _, col := range strings.Split(match[2], ",") {
+			if col == "" {
+				continue
+			}
+			col = strings.ToLower(col)
+			if match[1] == "mask" {
+				mask = append(mask, col)
+			} else {
+				encrypt = append(encrypt, col)
+			}
+		}
+	}
+
+	return mask, encrypt
+}
+
+// needed reports whether results of the query have columns to protect.
+func (p *columnProtector) needed(attrs *attributes) bool {
+	return len(p.mask) > 0 || len(p.encrypt) > 0 || len(attrs.mask) > 0 || len(attrs.encrypt) > 0
+}
+
+// protect returns a copy of the item with the values of protected columns
+// masked or encrypted, or item itself if none of its columns are
+// protected. Values are encrypted for key, the cache key of the item.
+func (p *columnProtector) protect(item *cache.Item, attrs *attributes, key string) (*cache.Item, error) {
+	const (
+		plain = iota
+		masked
+		encrypted
+	)
+	var (
+		modes     = make([]int, len(item.Cols))
+		protected bool
+	)
+	for c, col := range item.Cols {
+		col = strings.ToLower(col)
+		switch {
+		case p.encrypt[col] || contains(attrs.encrypt, col):
+			modes[c] = encrypted
+		case p.mask[col] || contains(attrs.mask, col):
+			modes[c] = masked
+		default:
+			continue
+		}
+		protected = true
+	}
+	if !protected {
+		return item, nil
+	}
+
+	cpy := *item
+	cpy.Rows = make([][]driver.Value, len(item.Rows))
+	for r, row := range item.Rows {
+		out := make([]driver.Value, len(row))
+		for c, v := range row {
+			if c >= len(modes) || v == nil {
+				out[c] = v
+				continue
+			}
+			switch modes[c] {
+			case masked:
+				out[c] = maskValue(v)
+			case encrypted:
+				sealed, err := p.seal(v, key, item.Cols[c])
+				if err != nil {
+					return nil, err
+				}
+				out[c] = sealed
+			default:
+				out[c] = v
+			}
+		}
+		cpy.Rows[r] = out
+	}
+
+	return &cpy, nil
+}
+
+// seal encrypts the value, bound to the key and column it's cached under.
+func (p *columnProtector) seal(v driver.Value, key, col string) (sealedValue, error) {
+	b, err := MsgpackCodec{}.Marshal(&cache.Item{Rows: [][]driver.Value{{v}}})
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, p.aead.NonceSize(), p.aead.NonceSize()+len(b)+p.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return p.aead.Seal(nonce, nonce, b, sealAAD(key, col)), nil
+}
+
+// open decrypts a value sealed for the key and column.
+func (p *columnProtector) open(s sealedValue, key, col string) (driver.Value, error) {
+	if p.aead == nil {
+		return nil, errors.New("sqlcache: encrypted value cached but ColumnProtection.Key isn't set")
+	}
+	n := p.aead.NonceSize()
+	if len(s) < n {
+		return nil, errors.New("sqlcache: encrypted value too short")
+	}
+	b, err := p.aead.Open(nil, s[:n], s[n:], sealAAD(key, col))
+	if err != nil {
+		return nil, err
+	}
+	var item cache.Item
+	if err := (MsgpackCodec{}).Unmarshal(b, &item); err != nil {
+		return nil, err
+	}
+	if len(item.Rows) != 1 || len(item.Rows[0]) != 1 {
+		return nil, errors.New("sqlcache: malformed encrypted value")
+	}
+
+	return item.Rows[0][0], nil
+}
+
+func sealAAD(key, col string) []byte {
+	return []byte(key + "\x00" + strings.ToLower(col))
+}
+
+// maskValue returns the zero value of the type of v.
+func maskValue(v driver.Value) driver.Value {
+	switch v.(type) {
+	case int64:
+		return int64(0)
+	case float64:
+		return float64(0)
+	case bool:
+		return false
+	case string:
+		return ""
+	case []byte:
+		return []byte{}
+	case time.Time:
+		return time.Time{}
+	default:
+		return nil
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cachedRows returns the rows of an item served from cache for the query.
+func (i *Interceptor) cachedRows(ctx context.Context, q *queryInfo, item *cache.Item, shared bool) *rowsCached {
+	rows := newRowsCached(ctx, item, shared)
+	rows.open = i.opener(ctx, q)
+
+	return rows
+}
+
+// opener returns the function decrypting values of the query's results
+// sealed by ColumnProtection, or nil if values aren't encrypted. Failures
+// are reported.
+func (i *Interceptor) opener(ctx context.Context, q *queryInfo) func(col string, s sealedValue) (driver.Value, error) {
+	if i.columns.aead == nil {
+		return nil
+	}
+
+	return func(col string, s sealedValue) (driver.Value, error) {
+		v, err := i.columns.open(s, q.key, col)
+		if err != nil {
+			err = &Error{Kind: ErrDecode, Op: "ColumnProtection", Key: q.key, Err: err}
+			i.reportErr(ctx, q, err)
+		}
+		return v, err
+	}
+}
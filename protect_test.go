@@ -0,0 +1,162 @@
+package sqlcache
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+// userRows returns rows of users with an id, email and ssn.
+type userRows struct {
+	n, r int
+}
+
+func (u *userRows) Columns() []string { return []string{"id", "Email", "ssn"} }
+func (u *userRows) Close() error      { return nil }
+
+func (u *userRows) Next(dest []driver.Value) error {
+	if u.r == u.n {
+		return io.EOF
+	}
+	dest[0], dest[1], dest[2] = int64(u.r), "user@example.com", []byte("123-45-6789")
+	if u.r == 1 {
+		dest[2] = nil
+	}
+	u.r++
+	return nil
+}
+
+func TestColumnProtection(t *testing.T) {
+	assert := require.New(t)
+
+	key := bytes.Repeat([]byte{1}, 32)
+	for _, cp := range []*ColumnProtection{
+		{Encrypt: []string{"ssn"}},
+		{Key: []byte("short")},
+	} {
+		_, err := NewInterceptor(&Config{Cache: &mapCacher{entries: make(map[string]cache.Entry)}, ColumnProtection: cp})
+		assert.NotNil(err)
+	}
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	var errs []error
+	ic, err := NewInterceptor(&Config{
+		Cache:            mc,
+		ColumnProtection: &ColumnProtection{Encrypt: []string{"SSN"}, Key: key},
+		OnError:          func(err error) { errs = append(errs, err) },
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          -- @cache-mask email
+	          SELECT id, email, ssn FROM users`
+	run := func(ic *Interceptor) ([][]driver.Value, error) {
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &userRows{n: 2}, nil
+		})
+		assert.Nil(err)
+		var got [][]driver.Value
+		for {
+			dest := make([]driver.Value, 3)
+			if err := rows.Next(dest); err != nil {
+				if err != io.EOF {
+					return nil, err
+				}
+				break
+			}
+			got = append(got, dest)
+		}
+		assert.Nil(rows.Close())
+		return got, nil
+	}
+
+	plain := [][]driver.Value{
+		{int64(0), "user@example.com", []byte("123-45-6789")},
+		{int64(1), "user@example.com", nil},
+	}
+	got, err := run(ic)
+	assert.Nil(err)
+	assert.Equal(plain, got)
+
+	// values are masked or encrypted in the cache
+	assert.Len(mc.entries, 1)
+	var cacheKey string
+	var item *cache.Item
+	for k, e := range mc.entries {
+		cacheKey, item = k, e.Item
+	}
+	assert.Equal("", item.Rows[0][1])
+	assert.IsType(sealedValue(nil), item.Rows[0][2])
+	assert.False(bytes.Contains(item.Rows[0][2].(sealedValue), []byte("123-45-6789")))
+	assert.Nil(item.Rows[1][2])
+
+	masked := [][]driver.Value{
+		{int64(0), "", []byte("123-45-6789")},
+		{int64(1), "", nil},
+	}
+	got, err = run(ic)
+	assert.Nil(err)
+	assert.Equal(masked, got)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+
+	// encrypted values survive serialization by codecs
+	for _, codec := range []cache.Codec{MsgpackCodec{}, ColumnarCodec{}} {
+		b, err := codec.Marshal(item)
+		assert.Nil(err)
+		decoded := new(cache.Item)
+		assert.Nil(codec.Unmarshal(b, decoded))
+		v, err := ic.columns.open(decoded.Rows[0][2].(sealedValue), cacheKey, "ssn")
+		assert.Nil(err)
+		assert.Equal([]byte("123-45-6789"), v)
+	}
+
+	// values can't be decrypted with another key, or under another key
+	_, err = ic.columns.open(item.Rows[0][2].(sealedValue), cacheKey+"x", "ssn")
+	assert.NotNil(err)
+	other, err := NewInterceptor(&Config{
+		Cache:            mc,
+		ColumnProtection: &ColumnProtection{Key: bytes.Repeat([]byte{2}, 32)},
+		OnError:          func(err error) { errs = append(errs, err) },
+	})
+	assert.Nil(err)
+	_, err = run(other)
+	assert.NotNil(err)
+	assert.Len(errs, 1)
+	assert.True(errors.Is(errs[0], ErrDecode))
+
+	// @cache-encrypt requires a key
+	noKey, err := NewInterceptor(&Config{Cache: &mapCacher{entries: make(map[string]cache.Entry)}})
+	assert.Nil(err)
+	rows, err := noKey.intercept(context.Background(), noKey.prepare(`-- @cache-ttl 30
+		-- @cache-max-rows 10
+		-- @cache-encrypt ssn,email
+		SELECT id, email, ssn FROM users`), nil, false, nil, func() (driver.Rows, error) {
+		return &userRows{n: 2}, nil
+	})
+	assert.Nil(err)
+	assert.Nil(rows.Close())
+	assert.Equal(uint64(1), noKey.Stats().SkipReasons[SkipInvalidAttributes])
+}
+
+func TestColumnAttrs(t *testing.T) {
+	assert := require.New(t)
+
+	mask, encrypt := getColumnAttrs(`-- @cache-mask Email,phone
+		-- @cache-encrypt ssn
+		-- @cache-mask u.name`)
+	assert.Equal([]string{"email", "phone", "u.name"}, mask)
+	assert.Equal([]string{"ssn"}, encrypt)
+
+	assert.Equal(int64(0), maskValue(int64(5)))
+	assert.Equal(time.Time{}, maskValue(time.Now()))
+	assert.Nil(maskValue(struct{}{}))
+}
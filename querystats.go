@@ -0,0 +1,262 @@
+package sqlcache
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxPendingSets bounds the number of cache entries per query tracked for
+// detecting wasted sets.
+const maxPendingSets = 128
+
+// QueryStats contains statistics of a single query, identified by its
+// fingerprint.
+type QueryStats struct {
+	// Fingerprint identifies the query text.
+	Fingerprint string
+	// Query is the query text as first seen by the interceptor.
+	Query  string
+	Hits   uint64
+	Misses uint64
+	Errors uint64
+	// Sets counts query results written to cache.
+	Sets uint64
+	// WastedSets counts query results written to cache that expired
+	// without being hit. It's approximate as only a bounded number of
+	// entries per query are tracked, and entries evicted by the backend
+	// before expiry aren't detected.
+	WastedSets uint64
+	// AvgHitLatency is the average time taken by the cache lookup on a hit.
+	AvgHitLatency time.Duration
+	// AvgMissLatency is the average time taken by the database to execute
+	// the query on a cache miss. This usually doesn't include the time taken
+	// to transfer all the rows.
+	AvgMissLatency time.Duration
+	// HitLatency is the distribution of cache lookup latencies on hits.
+	HitLatency LatencyHistogram
+	// MissLatency is the distribution of database execution latencies on
+	// misses.
+	MissLatency LatencyHistogram
+	// EstimatedTimeSaved estimates the time saved by serving the query from
+	// cache, computed as Hits × (AvgMissLatency − AvgHitLatency). It's zero
+	// until at least one hit and one miss have been observed.
+	EstimatedTimeSaved time.Duration
+}
+
+type queryStatsEntry struct {
+	QueryStats
+	hitLatency  time.Duration
+	missLatency time.Duration
+	hitCounts   [len(latencyBuckets) + 1]uint64
+	missCounts  [len(latencyBuckets) + 1]uint64
+	// pending holds the expiry time of entries set but not yet hit, by key.
+	pending map[string]time.Time
+}
+
+// sweep counts pending entries which have expired as wasted.
+func (e *queryStatsEntry) sweep(now time.Time) {
+	for key, expiry := range e.pending {
+		if !now.Before(expiry) {
+			e.WastedSets++
+			delete(e.pending, key)
+		}
+	}
+}
+
+// queryStatsTracker tracks per query statistics for a bounded number of
+// queries, evicting the least recently used. A nil tracker tracks nothing.
+type queryStatsTracker struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+func newQueryStatsTracker(max int, now func() time.Time) *queryStatsTracker {
+	if max <= 0 {
+		return nil
+	}
+
+	return &queryStatsTracker{
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		now:     now,
+	}
+}
+
+func (t *queryStatsTracker) record(q *queryInfo, fn func(e *queryStatsEntry)) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	el, ok := t.entries[q.fingerprint]
+	if ok {
+		t.lru.MoveToFront(el)
+	} else {
+		if t.lru.Len() >= t.max {
+			oldest := t.lru.Back()
+			t.lru.Remove(oldest)
+			delete(t.entries, oldest.Value.(*queryStatsEntry).Fingerprint)
+		}
+		el = t.lru.PushFront(&queryStatsEntry{
+			QueryStats: QueryStats{
+				Fingerprint: q.fingerprint,
+				Query:       q.query,
+			},
+		})
+		t.entries[q.fingerprint] = el
+	}
+
+	fn(el.Value.(*queryStatsEntry))
+}
+
+func (t *queryStatsTracker) recordHit(q *queryInfo, d time.Duration) {
+	t.record(q, func(e *queryStatsEntry) {
+		e.Hits++
+		delete(e.pending, q.key)
+		e.hitLatency += d
+		e.hitCounts[latencyBucket(d)]++
+	})
+}
+
+func (t *queryStatsTracker) recordMiss(q *queryInfo, d time.Duration) {
+	t.record(q, func(e *queryStatsEntry) {
+		e.Misses++
+		e.missLatency += d
+		e.missCounts[latencyBucket(d)]++
+	})
+}
+
+func (t *queryStatsTracker) recordSet(q *queryInfo, ttl time.Duration) {
+	t.record(q, func(e *queryStatsEntry) {
+		e.Sets++
+		if ttl <= 0 {
+			return
+		}
+		now := t.now()
+		e.sweep(now)
+		if e.pending == nil {
+			e.pending = make(map[string]time.Time)
+		}
+		if _, ok := e.pending[q.key]; ok || len(e.pending) < maxPendingSets {
+			e.pending[q.key] = now.Add(ttl)
+		}
+	})
+}
+
+func (t *queryStatsTracker) recordErr(q *queryInfo) {
+	t.record(q, func(e *queryStatsEntry) {
+		e.Errors++
+	})
+}
+
+func (t *queryStatsTracker) reset() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lru.Init()
+	t.entries = make(map[string]*list.Element)
+}
+
+// snapshot returns statistics of all tracked queries, most recently used
+// first.
+func (t *queryStatsTracker) snapshot() []QueryStats {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	stats := make([]QueryStats, 0, t.lru.Len())
+	for el := t.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*queryStatsEntry)
+		e.sweep(now)
+		qs := e.QueryStats
+		if e.Hits > 0 {
+			qs.AvgHitLatency = e.hitLatency / time.Duration(e.Hits)
+		}
+		if e.Misses > 0 {
+			qs.AvgMissLatency = e.missLatency / time.Duration(e.Misses)
+		}
+		if e.Hits > 0 && e.Misses > 0 {
+			qs.EstimatedTimeSaved = time.Duration(e.Hits) * (qs.AvgMissLatency - qs.AvgHitLatency)
+		}
+		qs.HitLatency = newLatencyHistogram(e.hitCounts[:])
+		qs.MissLatency = newLatencyHistogram(e.missCounts[:])
+		stats = append(stats, qs)
+	}
+
+	return stats
+}
+
+// EstimatedTimeSaved returns the sum of QueryStats.EstimatedTimeSaved of
+// all queries currently tracked. Returns zero unless Config.MaxTrackedQueries
+// is set.
+func (i *Interceptor) EstimatedTimeSaved() time.Duration {
+	var saved time.Duration
+	for _, qs := range i.queryStats.snapshot() {
+		saved += qs.EstimatedTimeSaved
+	}
+
+	return saved
+}
+
+// QueryStats returns statistics of each query tracked by the interceptor,
+// most recently used first. Returns nil unless Config.MaxTrackedQueries is
+// set.
+func (i *Interceptor) QueryStats() []QueryStats {
+	return i.queryStats.snapshot()
+}
+
+// TopQueries lists the most and least useful cached queries.
+type TopQueries struct {
+	// Hottest are the queries with the most hits, most hits first.
+	Hottest []QueryStats
+	// Coldest are the queries with the most wasted sets, most wasted
+	// first. Queries without any wasted sets aren't included.
+	Coldest []QueryStats
+}
+
+// TopQueries returns up to n of the hottest and coldest queries among
+// those tracked, to help decide which queries deserve caching and which
+// should stop being cached. Returns empty lists unless
+// Config.MaxTrackedQueries is set.
+func (i *Interceptor) TopQueries(n int) *TopQueries {
+	stats := i.queryStats.snapshot()
+	top := &TopQueries{}
+
+	sort.SliceStable(stats, func(a, b int) bool {
+		return stats[a].Hits > stats[b].Hits
+	})
+	for _, qs := range stats {
+		if len(top.Hottest) == n || qs.Hits == 0 {
+			break
+		}
+		top.Hottest = append(top.Hottest, qs)
+	}
+
+	sort.SliceStable(stats, func(a, b int) bool {
+		return stats[a].WastedSets > stats[b].WastedSets
+	})
+	for _, qs := range stats {
+		if len(top.Coldest) == n || qs.WastedSets == 0 {
+			break
+		}
+		top.Coldest = append(top.Coldest, qs)
+	}
+
+	return top
+}
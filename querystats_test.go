@@ -0,0 +1,156 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryStatsTracker(t *testing.T) {
+	assert := require.New(t)
+
+	var nilTracker *queryStatsTracker
+	nilTracker.recordHit(&queryInfo{}, time.Second)
+	assert.Nil(nilTracker.snapshot())
+
+	tr := newQueryStatsTracker(2, time.Now)
+	q1 := &queryInfo{query: "q1", fingerprint: "f1"}
+	q2 := &queryInfo{query: "q2", fingerprint: "f2"}
+	q3 := &queryInfo{query: "q3", fingerprint: "f3"}
+
+	tr.recordHit(q1, 2*time.Millisecond)
+	tr.recordHit(q1, 4*time.Millisecond)
+	tr.recordMiss(q2, 10*time.Millisecond)
+	tr.recordErr(q2)
+
+	stats := tr.snapshot()
+	assert.Len(stats, 2)
+	assert.Equal("f2", stats[0].Fingerprint)
+	assert.Equal(uint64(1), stats[0].Misses)
+	assert.Equal(uint64(1), stats[0].Errors)
+	assert.Equal(10*time.Millisecond, stats[0].AvgMissLatency)
+	assert.Equal("q1", stats[1].Query)
+	assert.Equal(uint64(2), stats[1].Hits)
+	assert.Equal(3*time.Millisecond, stats[1].AvgHitLatency)
+	// 2ms falls in the 2.5ms bucket and 4ms in the 5ms bucket
+	assert.Equal(uint64(1), stats[1].HitLatency.Counts[4])
+	assert.Equal(uint64(1), stats[1].HitLatency.Counts[5])
+	assert.Equal(len(stats[1].HitLatency.Bounds)+1, len(stats[1].HitLatency.Counts))
+	assert.Equal(time.Duration(0), stats[1].EstimatedTimeSaved)
+
+	// time saved = hits × (avg miss latency − avg hit latency)
+	tr.recordMiss(q1, 13*time.Millisecond)
+	stats = tr.snapshot()
+	assert.Equal(20*time.Millisecond, stats[0].EstimatedTimeSaved)
+	assert.Equal(uint64(1), stats[0].MissLatency.Counts[7])
+
+	// q2 is the least recently used and gets evicted
+	tr.recordMiss(q3, time.Millisecond)
+	stats = tr.snapshot()
+	assert.Len(stats, 2)
+	assert.Equal("f3", stats[0].Fingerprint)
+	assert.Equal("f1", stats[1].Fingerprint)
+}
+
+func TestQueryStats(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(errors.New("some error"))
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:             mCacher,
+		MaxTrackedQueries: 10,
+	})
+	assert.Len(ic.QueryStats(), 0)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	runQuery(t, assert, qMock, db, query, true)
+	runQueryPrepared(t, assert, qMock, db, query, true)
+
+	stats := ic.QueryStats()
+	assert.Len(stats, 1)
+	assert.Equal(fingerprint(query), stats[0].Fingerprint)
+	assert.Equal(query, stats[0].Query)
+	assert.Equal(uint64(2), stats[0].Misses)
+	assert.Equal(uint64(2), stats[0].Errors)
+	assert.Equal(uint64(0), stats[0].Hits)
+}
+
+func TestTopQueries(t *testing.T) {
+	assert := require.New(t)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:             new(mocks.Cacher),
+		MaxTrackedQueries: 10,
+	})
+	now := time.Unix(1700000000, 0)
+	ic.queryStats.now = func() time.Time { return now }
+
+	hot := &queryInfo{fingerprint: "hot", key: "k1"}
+	warm := &queryInfo{fingerprint: "warm", key: "k2"}
+	cold := &queryInfo{fingerprint: "cold", key: "k3"}
+
+	ic.queryStats.recordSet(hot, time.Minute)
+	ic.queryStats.recordSet(warm, time.Minute)
+	ic.queryStats.recordSet(cold, time.Minute)
+	for n := 0; n < 3; n++ {
+		ic.queryStats.recordHit(hot, time.Millisecond)
+	}
+	ic.queryStats.recordHit(warm, time.Millisecond)
+
+	top := ic.TopQueries(5)
+	assert.Len(top.Hottest, 2)
+	assert.Equal("hot", top.Hottest[0].Fingerprint)
+	assert.Equal("warm", top.Hottest[1].Fingerprint)
+	assert.Len(top.Coldest, 0) // not expired yet
+
+	// entries that expire without a hit are wasted
+	now = now.Add(time.Minute)
+	ic.queryStats.recordSet(cold, time.Minute)
+	ic.queryStats.recordSet(warm, time.Minute)
+	now = now.Add(time.Minute)
+
+	top = ic.TopQueries(1)
+	assert.Len(top.Hottest, 1)
+	assert.Equal("hot", top.Hottest[0].Fingerprint)
+	assert.Len(top.Coldest, 1)
+	assert.Equal("cold", top.Coldest[0].Fingerprint)
+	assert.Equal(uint64(2), top.Coldest[0].WastedSets)
+	assert.Equal(uint64(2), top.Coldest[0].Sets)
+
+	top = ic.TopQueries(5)
+	assert.Len(top.Coldest, 2)
+	assert.Equal(uint64(1), top.Coldest[1].WastedSets)
+
+	// disabled
+	ic, _ = NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+	top = ic.TopQueries(5)
+	assert.Len(top.Hottest, 0)
+	assert.Len(top.Coldest, 0)
+}
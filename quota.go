@@ -0,0 +1,252 @@
+package sqlcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// quotaSweepInterval is how often expired entries are removed from those
+// counted against quotas, unless a quota is exceeded.
+const quotaSweepInterval = time.Second
+
+// Quota limits the number and size of entries written to the cache
+// backend, overall and per tenant, so that one tenant of a multi-tenant
+// service can't fill the cache and evict the entries of everyone else.
+// Writes beyond a quota are refused and skipped with SkipQuotaExceeded
+// until earlier entries expire.
+//
+// Entries are counted by the interceptor as it writes them, until they
+// expire or it deletes them, such as by InvalidateQuery and
+// InvalidateRows. Quotas thus apply per process: entries written by other
+// processes sharing the backend aren't counted, and entries evicted by the
+// backend or deleted by other processes count until they'd have expired.
+// Entries that don't expire count against quotas for the life of the
+// interceptor unless deleted. Limits of zero aren't enforced.
+type Quota struct {
+	MaxEntries int
+	// MaxBytes limits the encoded size of entries, as measured by
+	// Config.Codec.
+	MaxBytes int64
+	// Tenant returns the tenant a query is run for, such as from a value
+	// of its context. Entries of queries with an empty tenant only count
+	// against MaxEntries and MaxBytes. It's required when TenantMaxEntries
+	// or TenantMaxBytes is set.
+	Tenant           func(ctx context.Context) string
+	TenantMaxEntries int
+	TenantMaxBytes   int64
+}
+
+// QuotaUsage is the number and encoded size of live entries counted
+// against a quota.
+type QuotaUsage struct {
+	Entries int
+	Bytes   int64
+}
+
+func validateQuota(q *Quota) error {
+	if q.MaxEntries < 0 || q.MaxBytes < 0 || q.TenantMaxEntries < 0 || q.TenantMaxBytes < 0 {
+		return fmt.Errorf("Quota limits must not be negative")
+	}
+	if (q.TenantMaxEntries > 0 || q.TenantMaxBytes > 0) && q.Tenant == nil {
+		return fmt.Errorf("Quota.Tenant must be set to limit tenants")
+	}
+
+	return nil
+}
+
+type quotaEntry struct {
+	tenant      string
+	fingerprint string
+	size        int64
+	expiresAt   time.Time // zero if it doesn't expire
+}
+
+// quotaTracker counts the entries written to the backend, without their
+// rows, against the configured quotas.
+type quotaTracker struct {
+	cfg Quota
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]quotaEntry
+	total     QuotaUsage
+	tenants   map[string]*QuotaUsage
+	lastSweep time.Time
+}
+
+func newQuotaTracker(cfg *Quota, now func() time.Time) *quotaTracker {
+	if cfg == nil {
+		return nil
+	}
+
+	return &quotaTracker{
+		cfg:       *cfg,
+		now:       now,
+		entries:   make(map[string]quotaEntry),
+		tenants:   make(map[string]*QuotaUsage),
+		lastSweep: now(),
+	}
+}
+
+// needsSize reports whether entries are limited by size.
+func (t *quotaTracker) needsSize() bool {
+	return t != nil && (t.cfg.MaxBytes > 0 || t.cfg.TenantMaxBytes > 0)
+}
+
+// reserve counts the entry of key, of the query with fingerprint, against
+// the quotas, replacing the entry
+// previously written under key, if any, and reports whether it's within
+// them. Entries not within quotas aren't counted. A negative size, of an
+// entry that wasn't measured, counts as zero.
+func (t *quotaTracker) reserve(key, fingerprint, tenant string, size int64, ttl time.Duration) bool {
+	if size < 0 {
+		size = 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(false)
+	if !t.fits(key, tenant, size) {
+		t.sweep(true)
+		if !t.fits(key, tenant, size) {
+			return false
+		}
+	}
+
+	if old, ok := t.entries[key]; ok {
+		t.remove(key, old)
+	}
+	e := quotaEntry{tenant: tenant, fingerprint: fingerprint, size: size}
+	if ttl > 0 {
+		e.expiresAt = t.now().Add(ttl)
+	}
+	t.entries[key] = e
+	t.total.Entries++
+	t.total.Bytes += size
+	if tenant != "" {
+		u := t.tenants[tenant]
+		if u == nil {
+			u = new(QuotaUsage)
+			t.tenants[tenant] = u
+		}
+		u.Entries++
+		u.Bytes += size
+	}
+
+	return true
+}
+
+// fits reports whether the entry would be within quotas. t.mu must be
+// held.
+func (t *quotaTracker) fits(key, tenant string, size int64) bool {
+	total := t.total
+	var usage QuotaUsage
+	if u := t.tenants[tenant]; u != nil && tenant != "" {
+		usage = *u
+	}
+	if old, ok := t.entries[key]; ok {
+		total.Entries--
+		total.Bytes -= old.size
+		if old.tenant == tenant {
+			usage.Entries--
+			usage.Bytes -= old.size
+		}
+	}
+
+	if exceeds(total, size, t.cfg.MaxEntries, t.cfg.MaxBytes) {
+		return false
+	}
+
+	return tenant == "" || !exceeds(usage, size, t.cfg.TenantMaxEntries, t.cfg.TenantMaxBytes)
+}
+
+func exceeds(u QuotaUsage, size int64, maxEntries int, maxBytes int64) bool {
+	return (maxEntries > 0 && u.Entries+1 > maxEntries) ||
+		(maxBytes > 0 && u.Bytes+size > maxBytes)
+}
+
+// release stops counting the entry of key, such as when writing it failed
+// or once it's deleted.
+func (t *quotaTracker) release(key string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok {
+		t.remove(key, e)
+	}
+}
+
+// releaseFingerprint stops counting the entries of the query with
+// fingerprint.
+func (t *quotaTracker) releaseFingerprint(fingerprint string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, e := range t.entries {
+		if e.fingerprint == fingerprint {
+			t.remove(key, e)
+		}
+	}
+}
+
+// sweep removes expired entries, at most once per quotaSweepInterval
+// unless forced. t.mu must be held.
+func (t *quotaTracker) sweep(force bool) {
+	now := t.now()
+	if !force && now.Sub(t.lastSweep) < quotaSweepInterval {
+		return
+	}
+	t.lastSweep = now
+	for key, e := range t.entries {
+		if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+			t.remove(key, e)
+		}
+	}
+}
+
+func (t *quotaTracker) remove(key string, e quotaEntry) {
+	delete(t.entries, key)
+	t.total.Entries--
+	t.total.Bytes -= e.size
+	if u := t.tenants[e.tenant]; u != nil {
+		u.Entries--
+		u.Bytes -= e.size
+		if u.Entries == 0 {
+			delete(t.tenants, e.tenant)
+		}
+	}
+}
+
+func (t *quotaTracker) usage() (QuotaUsage, map[string]QuotaUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(true)
+	tenants := make(map[string]QuotaUsage, len(t.tenants))
+	for tenant, u := range t.tenants {
+		tenants[tenant] = *u
+	}
+
+	return t.total, tenants
+}
+
+// QuotaUsage returns the usage of Config.Quota overall and by tenant.
+// Returns zero usage unless Config.Quota is set.
+func (i *Interceptor) QuotaUsage() (QuotaUsage, map[string]QuotaUsage) {
+	if i.quota == nil {
+		return QuotaUsage{}, nil
+	}
+
+	return i.quota.usage()
+}
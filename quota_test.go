@@ -0,0 +1,111 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+type quotaTenantKey struct{}
+
+func TestQuota(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := NewInterceptor(&Config{
+		Cache: &mapCacher{entries: make(map[string]cache.Entry)},
+		Quota: &Quota{TenantMaxEntries: 1},
+	})
+	assert.NotNil(err)
+	_, err = NewInterceptor(&Config{
+		Cache: &mapCacher{entries: make(map[string]cache.Entry)},
+		Quota: &Quota{MaxEntries: -1},
+	})
+	assert.NotNil(err)
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{
+		Cache: mc,
+		Clock: clock,
+		Quota: &Quota{
+			MaxEntries: 3,
+			Tenant: func(ctx context.Context) string {
+				tenant, _ := ctx.Value(quotaTenantKey{}).(string)
+				return tenant
+			},
+			TenantMaxEntries: 2,
+		},
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+              -- @cache-max-rows 10
+              SELECT name FROM users WHERE id = ?`
+	run := func(tenant string, id int64) {
+		ctx := context.WithValue(context.Background(), quotaTenantKey{}, tenant)
+		args := []driver.NamedValue{{Ordinal: 1, Value: id}}
+		rows, err := ic.intercept(ctx, ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+
+	run("a", 1)
+	run("a", 2)
+	run("a", 3) // over the tenant's quota
+	assert.Len(mc.entries, 2)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipQuotaExceeded])
+
+	run("b", 4)
+	run("", 5) // over the overall quota
+	assert.Len(mc.entries, 3)
+	assert.Equal(uint64(2), ic.Stats().SkipReasons[SkipQuotaExceeded])
+
+	total, tenants := ic.QuotaUsage()
+	assert.Equal(QuotaUsage{Entries: 3}, total)
+	assert.Equal(map[string]QuotaUsage{"a": {Entries: 2}, "b": {Entries: 1}}, tenants)
+
+	// quota is freed as entries expire
+	clock.Advance(31 * time.Second)
+	run("a", 3)
+	assert.Len(mc.entries, 4)
+	total, tenants = ic.QuotaUsage()
+	assert.Equal(QuotaUsage{Entries: 1}, total)
+	assert.Equal(map[string]QuotaUsage{"a": {Entries: 1}}, tenants)
+}
+
+func TestQuotaBytes(t *testing.T) {
+	assert := require.New(t)
+
+	qt := newQuotaTracker(&Quota{MaxBytes: 100, TenantMaxBytes: 60}, time.Now)
+
+	assert.True(qt.reserve("k1", "f", "a", 50, time.Minute))
+	assert.False(qt.reserve("k2", "f", "a", 20, time.Minute))
+	// replacing an entry only counts the difference
+	assert.True(qt.reserve("k1", "f", "a", 60, time.Minute))
+	assert.True(qt.reserve("k2", "f", "b", 40, time.Minute))
+	assert.False(qt.reserve("k3", "f", "", 1, time.Minute))
+	// entries that don't expire count until released
+	qt.release("k2")
+	assert.True(qt.reserve("k3", "f", "", 40, 0))
+
+	total, tenants := qt.usage()
+	assert.Equal(QuotaUsage{Entries: 2, Bytes: 100}, total)
+	assert.Equal(map[string]QuotaUsage{"a": {Entries: 1, Bytes: 60}}, tenants)
+
+	// as are the entries of invalidated queries
+	assert.True(qt.reserve("k4", "g", "b", 0, time.Minute))
+	qt.releaseFingerprint("f")
+	total, tenants = qt.usage()
+	assert.Equal(QuotaUsage{Entries: 1}, total)
+	assert.Equal(map[string]QuotaUsage{"b": {Entries: 1}}, tenants)
+}
@@ -0,0 +1,133 @@
+package sqlcache
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxQueryBuckets bounds the number of fingerprints whose writes are rate
+// limited individually. Writes of further fingerprints are only subject to
+// the global limit.
+const maxQueryBuckets = 10000
+
+// RateLimit is a token bucket rate limit. The zero value means no limit.
+type RateLimit struct {
+	// Rate is the number of events allowed per second on average.
+	Rate float64
+	// Burst is the number of events allowed at once. Defaults to Rate,
+	// rounded up.
+	Burst int
+}
+
+func (l RateLimit) withDefaults() RateLimit {
+	if l.Rate > 0 && l.Burst <= 0 {
+		l.Burst = int(math.Ceil(l.Rate))
+	}
+	return l
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the bucket was last used.
+func (b *tokenBucket) refill(now time.Time, l RateLimit) {
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+}
+
+// setLimiter limits the rate of writes to the cache backend, overall and
+// per query fingerprint.
+type setLimiter struct {
+	global   RateLimit
+	perQuery RateLimit
+	now      func() time.Time
+
+	mu      sync.Mutex
+	bucket  *tokenBucket
+	buckets map[string]*tokenBucket
+}
+
+func newSetLimiter(global, perQuery RateLimit, now func() time.Time) *setLimiter {
+	global, perQuery = global.withDefaults(), perQuery.withDefaults()
+	if global.Rate <= 0 && perQuery.Rate <= 0 {
+		return nil
+	}
+
+	l := &setLimiter{
+		global:   global,
+		perQuery: perQuery,
+		now:      now,
+	}
+	start := now()
+	if global.Rate > 0 {
+		l.bucket = &tokenBucket{float64(global.Burst), start}
+	}
+	if perQuery.Rate > 0 {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+
+	return l
+}
+
+// allow reports whether a write of results of the query fingerprint may
+// proceed, taking a token from each applicable bucket if so.
+func (l *setLimiter) allow(fp string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.bucket != nil {
+		l.bucket.refill(now, l.global)
+		if l.bucket.tokens < 1 {
+			return false
+		}
+	}
+
+	var qb *tokenBucket
+	if l.buckets != nil {
+		qb = l.queryBucket(fp, now)
+		if qb != nil {
+			qb.refill(now, l.perQuery)
+			if qb.tokens < 1 {
+				return false
+			}
+		}
+	}
+
+	if l.bucket != nil {
+		l.bucket.tokens--
+	}
+	if qb != nil {
+		qb.tokens--
+	}
+
+	return true
+}
+
+// queryBucket returns the bucket of the fingerprint, creating it when
+// there's room. It must be called with the lock held.
+func (l *setLimiter) queryBucket(fp string, now time.Time) *tokenBucket {
+	if b, ok := l.buckets[fp]; ok {
+		return b
+	}
+
+	if len(l.buckets) >= maxQueryBuckets {
+		// buckets which have refilled are equivalent to new ones
+		for key, b := range l.buckets {
+			if b.refill(now, l.perQuery); b.tokens >= float64(l.perQuery.Burst) {
+				delete(l.buckets, key)
+			}
+		}
+		if len(l.buckets) >= maxQueryBuckets {
+			return nil
+		}
+	}
+
+	b := &tokenBucket{float64(l.perQuery.Burst), now}
+	l.buckets[fp] = b
+
+	return b
+}
@@ -0,0 +1,57 @@
+package sqlcache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLimiter(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Nil(newSetLimiter(RateLimit{}, RateLimit{}, time.Now))
+
+	now := time.Now()
+	l := newSetLimiter(RateLimit{Rate: 10, Burst: 3}, RateLimit{Rate: 1}, time.Now)
+	l.now = func() time.Time { return now }
+	l.bucket.last = now
+
+	// per query burst defaults to the rate
+	assert.True(l.allow("a"))
+	assert.False(l.allow("a"))
+	assert.True(l.allow("b"))
+	assert.True(l.allow("c"))
+	// global burst exhausted
+	assert.False(l.allow("d"))
+
+	now = now.Add(100 * time.Millisecond)
+	assert.True(l.allow("d"))
+	assert.False(l.allow("e"))
+
+	now = now.Add(time.Second)
+	assert.True(l.allow("a"))
+}
+
+func TestSetLimiterBounded(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Now()
+	l := newSetLimiter(RateLimit{}, RateLimit{Rate: 1}, time.Now)
+	l.now = func() time.Time { return now }
+
+	for n := 0; n < maxQueryBuckets; n++ {
+		assert.True(l.allow(strconv.Itoa(n)))
+	}
+	// no room for more buckets; writes aren't limited per query
+	assert.True(l.allow("x"))
+	assert.True(l.allow("x"))
+	assert.Len(l.buckets, maxQueryBuckets)
+
+	// refilled buckets are dropped to make room
+	now = now.Add(time.Second)
+	assert.True(l.allow("x"))
+	assert.False(l.allow("x"))
+	assert.Len(l.buckets, 1)
+}
@@ -1,16 +1,35 @@
 package sqlcache
 
 import (
+	"context"
 	"database/sql/driver"
 	"io"
+	"reflect"
+	"time"
 
 	"github.com/prashanthpai/sqlcache/cache"
 )
 
-func newRowsRecorder(setter func(item *cache.Item), rows driver.Rows, maxRows int) *rowsRecorder {
+const defaultDrainMaxDuration = 100 * time.Millisecond
+
+// DrainBudget bounds the work done to read rows left unread on Close when
+// Config.DrainOnClose is set. Results are cached only if all rows are read
+// within the budget.
+type DrainBudget struct {
+	// MaxRows, when set, is the most rows read on Close.
+	MaxRows int
+	// MaxDuration is the most time spent reading rows on Close. Defaults
+	// to 100ms; a negative value means no limit.
+	MaxDuration time.Duration
+}
+
+func newRowsRecorder(ctx context.Context, setter func(item *cache.Item), skipper func(reason SkipReason), rows driver.Rows, maxRows int) *rowsRecorder {
 	return &rowsRecorder{
+		ctx:     ctx,
+		done:    ctx.Done(),
 		item:    new(cache.Item),
 		setter:  setter,
+		skipper: skipper,
 		maxRows: maxRows,
 		dr:      rows,
 	}
@@ -19,11 +38,30 @@ func newRowsRecorder(setter func(item *cache.Item), rows driver.Rows, maxRows in
 type rowsRecorder struct {
 	item       *cache.Item
 	setter     func(item *cache.Item)
+	skipper    func(reason SkipReason)
 	gotErr     bool
 	gotEOF     bool
 	maxRowsHit bool
+	canceled   bool
 	maxRows    int
-	dr         driver.Rows
+	// maxBytes, when positive, bounds size, the memory footprint of the
+	// recorded rows as estimated by rowSize; maxBytesHit is set once it's
+	// exceeded, after which rows are passed through without being copied.
+	maxBytes    int64
+	size        int64
+	maxBytesHit bool
+	dr          driver.Rows
+	ctx         context.Context
+	done        <-chan struct{}
+	// drain, when set, is the budget for reading rows left unread on Close.
+	drain *DrainBudget
+	// utcTimes converts times to UTC, both in dest and the recorded rows.
+	utcTimes bool
+
+	// rows are carved out of slabs to avoid an allocation per row
+	slab     []driver.Value
+	slabRows int
+	slabs    [][]driver.Value
 }
 
 func (r *rowsRecorder) Columns() []string {
@@ -31,15 +69,72 @@ func (r *rowsRecorder) Columns() []string {
 	return r.item.Cols
 }
 
+// The optional column type interfaces of the driver's rows are forwarded,
+// so that they're seen by callers and middlewares stacked above the
+// interceptor on misses. Rows not implementing them report what
+// database/sql defaults to.
+
+func (r *rowsRecorder) ColumnTypeScanType(index int) reflect.Type {
+	if ct, ok := r.dr.(driver.RowsColumnTypeScanType); ok {
+		return ct.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *rowsRecorder) ColumnTypeDatabaseTypeName(index int) string {
+	if ct, ok := r.dr.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return ct.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *rowsRecorder) ColumnTypeLength(index int) (int64, bool) {
+	if ct, ok := r.dr.(driver.RowsColumnTypeLength); ok {
+		return ct.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *rowsRecorder) ColumnTypeNullable(index int) (bool, bool) {
+	if ct, ok := r.dr.(driver.RowsColumnTypeNullable); ok {
+		return ct.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *rowsRecorder) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if ct, ok := r.dr.(driver.RowsColumnTypePrecisionScale); ok {
+		return ct.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
 func (r *rowsRecorder) Close() error {
+	if r.drain != nil && !(r.gotEOF || r.gotErr || r.maxRowsHit || r.maxBytesHit || r.canceled) {
+		r.drainRest()
+	}
+
 	if err := r.dr.Close(); err != nil {
 		r.gotErr = true
+		r.skipper(SkipIncomplete)
 		return err
 	}
 
 	// cache only if we've reached EOF without any errors
 	// and without hitting max rows limit
-	if r.gotEOF && !r.gotErr && !r.maxRowsHit {
+	switch {
+	case r.canceled || (!r.gotEOF && ctxErr(r.ctx, r.done) != nil):
+		r.release()
+		r.skipper(SkipCanceled)
+	case r.maxRowsHit:
+		r.release()
+		r.skipper(SkipMaxRows)
+	case r.maxBytesHit:
+		r.skipper(SkipMaxRecordBytes)
+	case !r.gotEOF || r.gotErr:
+		r.release()
+		r.skipper(SkipIncomplete)
+	default:
 		r.setter(r.item)
 	}
 
@@ -51,23 +146,109 @@ func (r *rowsRecorder) Next(dest []driver.Value) error {
 	if err != nil {
 		if err == io.EOF {
 			r.gotEOF = true
+			// results are served with the recorded columns even when
+			// the caller never asked for them, as for empty results
+			if r.item.Cols == nil {
+				r.item.Cols = r.dr.Columns()
+			}
 		} else {
 			r.gotErr = true
 		}
 	}
 
-	if r.gotEOF || r.gotErr || r.maxRowsHit {
+	if r.gotEOF || r.gotErr || r.maxRowsHit || r.maxBytesHit || r.canceled {
+		return err
+	}
+
+	// rows read after the query's context is done may be incomplete
+	if ctxErr(r.ctx, r.done) != nil {
+		r.canceled = true
+		r.release()
 		return err
 	}
 
-	if len(r.item.Rows) == r.maxRows {
+	if r.maxRows > 0 && len(r.item.Rows) == r.maxRows {
 		r.maxRowsHit = true
 		return err
 	}
 
-	cpy := make([]driver.Value, len(dest))
-	copy(cpy, dest)
+	if r.maxBytes > 0 {
+		if r.size += rowSize(dest); r.size > r.maxBytes {
+			r.maxBytesHit = true
+			r.release()
+			return err
+		}
+	}
+
+	cpy := r.newRow(len(dest))
+	for n, v := range dest {
+		if t, ok := v.(time.Time); ok && r.utcTimes {
+			v = t.UTC()
+			dest[n] = v
+		}
+		// drivers such as go-sql-driver/mysql and pgx may reuse byte
+		// buffers between rows as allowed by driver.Rows; other reference
+		// types returned by drivers aren't expected to be mutated.
+		if b, ok := v.([]byte); ok && b != nil {
+			v = append(make([]byte, 0, len(b)), b...)
+		}
+		cpy[n] = v
+	}
 	r.item.Rows = append(r.item.Rows, cpy)
 
 	return err
 }
+
+// drainRest reads the rows the caller left unread, within the drain
+// budget, so that they're recorded.
+func (r *rowsRecorder) drainRest() {
+	dest := make([]driver.Value, len(r.Columns()))
+	var deadline time.Time
+	if r.drain.MaxDuration > 0 {
+		deadline = time.Now().Add(r.drain.MaxDuration)
+	}
+	// one read past MaxRows rows is needed to find the end
+	for n := 0; r.drain.MaxRows <= 0 || n <= r.drain.MaxRows; n++ {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return
+		}
+		if r.Next(dest) != nil || r.maxRowsHit || r.maxBytesHit || r.canceled {
+			return
+		}
+	}
+}
+
+// newRow returns a row of n values carved out of the current slab.
+func (r *rowsRecorder) newRow(n int) []driver.Value {
+	if n == 0 {
+		return []driver.Value{}
+	}
+
+	if len(r.slab) < n {
+		if r.slabRows = r.slabRows*2 + 1; r.slabRows > maxSlabRows {
+			r.slabRows = maxSlabRows
+		}
+		rows := r.slabRows
+		if left := r.maxRows - len(r.item.Rows); r.maxRows > 0 && left < rows {
+			rows = left
+		}
+		r.slab = getSlab(rows * n)
+		r.slabs = append(r.slabs, r.slab)
+	}
+
+	row := r.slab[:n:n]
+	r.slab = r.slab[n:]
+
+	return row
+}
+
+// release returns slabs to the pool when the recorded rows won't be
+// cached.
+func (r *rowsRecorder) release() {
+	r.item.Rows = nil
+	r.slab = nil
+	for _, s := range r.slabs {
+		putSlab(s)
+	}
+	r.slabs = nil
+}
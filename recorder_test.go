@@ -0,0 +1,347 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+// seqRows is a driver.Rows returning n rows whose values are the row and
+// column numbers.
+type seqRows struct {
+	n, cols, r int
+}
+
+func (s *seqRows) Columns() []string { return make([]string, s.cols) }
+func (s *seqRows) Close() error      { return nil }
+
+func (s *seqRows) Next(dest []driver.Value) error {
+	if s.r == s.n {
+		return io.EOF
+	}
+	for c := range dest {
+		dest[c] = int64(s.r*s.cols + c)
+	}
+	s.r++
+	return nil
+}
+
+func TestRowsRecorderSlabs(t *testing.T) {
+	assert := require.New(t)
+
+	var got *cache.Item
+	rr := newRowsRecorder(context.Background(), func(item *cache.Item) { got = item }, nil, &seqRows{n: 100, cols: 3}, 100)
+	dest := make([]driver.Value, len(rr.Columns()))
+	for rr.Next(dest) == nil {
+		dest[0] = "overwritten by caller"
+	}
+	assert.Nil(rr.Close())
+
+	assert.Len(got.Rows, 100)
+	for r, row := range got.Rows {
+		assert.Len(row, 3)
+		assert.Equal(3, cap(row))
+		for c, v := range row {
+			assert.Equal(int64(r*3+c), v)
+		}
+	}
+
+	// rows are released when results aren't cached
+	var reason SkipReason
+	rr = newRowsRecorder(context.Background(), nil, func(r SkipReason) { reason = r }, &seqRows{n: 100, cols: 3}, 10)
+	rr.Columns()
+	for rr.Next(dest) == nil {
+	}
+	assert.Nil(rr.Close())
+	assert.Equal(SkipMaxRows, reason)
+	assert.Nil(rr.item.Rows)
+	assert.Nil(rr.slabs)
+}
+
+func TestRowsRecorderMaxBytes(t *testing.T) {
+	assert := require.New(t)
+
+	// rows of 3 int64 take 96 bytes
+	var got *cache.Item
+	rr := newRowsRecorder(context.Background(), func(item *cache.Item) { got = item }, nil, &seqRows{n: 10, cols: 3}, 0)
+	rr.maxBytes = 960
+	dest := make([]driver.Value, len(rr.Columns()))
+	for rr.Next(dest) == nil {
+	}
+	assert.Nil(rr.Close())
+	assert.Len(got.Rows, 10)
+
+	// rows of results that can't be cached stream through without being
+	// recorded, however many there are
+	var reason SkipReason
+	rr = newRowsRecorder(context.Background(), nil, func(r SkipReason) { reason = r }, &seqRows{n: 1e5, cols: 3}, 0)
+	rr.maxBytes = 960
+	rr.Columns()
+	n := 0
+	for ; rr.Next(dest) == nil; n++ {
+		assert.Equal(int64(n*3), dest[0])
+	}
+	assert.Equal(int(1e5), n)
+	assert.Nil(rr.Close())
+	assert.Equal(SkipMaxRecordBytes, reason)
+	assert.Nil(rr.item.Rows)
+	assert.Nil(rr.slabs)
+}
+
+// blockRows is a driver.Rows returning n rows decoded a block at a time,
+// as drivers of columnar databases such as clickhouse-go do, whose values
+// are the row number and its text padded to width, the latter in a buffer
+// reused by every block.
+type blockRows struct {
+	n, block, width, r int
+	buf                []byte
+	offs               []int
+}
+
+func (b *blockRows) Columns() []string { return []string{"number", "text"} }
+func (b *blockRows) Close() error      { return nil }
+
+func (b *blockRows) Next(dest []driver.Value) error {
+	if b.r == b.n {
+		return io.EOF
+	}
+	i := b.r % b.block
+	if i == 0 {
+		b.buf, b.offs = b.buf[:0], b.offs[:0]
+		for r := b.r; r < b.r+b.block && r < b.n; r++ {
+			b.offs = append(b.offs, len(b.buf))
+			b.buf = strconv.AppendInt(b.buf, int64(r), 10)
+			for len(b.buf)-b.offs[len(b.offs)-1] < b.width {
+				b.buf = append(b.buf, ' ')
+			}
+		}
+	}
+	dest[0] = int64(b.r)
+	dest[1] = b.buf[b.offs[i] : b.offs[i]+b.width]
+	b.r++
+	return nil
+}
+
+func TestBlockRows(t *testing.T) {
+	assert := require.New(t)
+
+	mc := &mapCacher{entries: make(map[string]cache.Entry)}
+	var skipped []SkipReason
+	ic, err := NewInterceptor(&Config{
+		Cache:          mc,
+		MaxRecordBytes: 1 << 20,
+		OnSkip:         func(key string, reason SkipReason) { skipped = append(skipped, reason) },
+	})
+	assert.Nil(err)
+
+	run := func(query string, n, width int) {
+		rows, err := ic.intercept(context.Background(), ic.prepare(query), nil, false, nil, func() (driver.Rows, error) {
+			return &blockRows{n: n, block: 1000, width: width}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 2)
+		var got int
+		for ; rows.Next(dest) == nil; got++ {
+			assert.Equal(int64(got), dest[0])
+			assert.Equal(strconv.Itoa(got), strings.TrimRight(string(dest[1].([]byte)), " "))
+		}
+		assert.Nil(rows.Close())
+		assert.Equal(n, got)
+	}
+
+	// rows are copied out of the blocks they're decoded into
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 5000
+	          SELECT number, toString(number) FROM numbers(2000)`
+	run(query, 2000, 8)
+	run(query, 2000, 8)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+
+	// results larger than @cache-max-rows or MaxRecordBytes stream through
+	// in full without being cached
+	query = `-- @cache-ttl 30
+	         -- @cache-max-rows 5000
+	         SELECT number, toString(number) FROM numbers(10000)`
+	run(query, 10000, 8)
+	run(query, 10000, 8)
+	query = `-- @cache-ttl 30
+	         -- @cache-max-rows 1000000
+	         SELECT number, rightPad(toString(number), 100) FROM numbers(100000)`
+	run(query, 100000, 100)
+	assert.Equal(uint64(1), ic.Stats().Hits)
+	assert.Equal([]SkipReason{SkipMaxRows, SkipMaxRows, SkipMaxRecordBytes}, skipped)
+	assert.Len(mc.entries, 1)
+}
+
+func TestRowsCanceled(t *testing.T) {
+	assert := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reason SkipReason
+	rr := newRowsRecorder(ctx, nil, func(r SkipReason) { reason = r }, &seqRows{n: 10, cols: 1}, 100)
+	dest := make([]driver.Value, len(rr.Columns()))
+	assert.Nil(rr.Next(dest))
+	cancel()
+	// rows of the driver are passed through but no longer recorded
+	n := 1
+	for rr.Next(dest) == nil {
+		n++
+	}
+	assert.Equal(10, n)
+	assert.Nil(rr.Close())
+	assert.Equal(SkipCanceled, reason)
+	assert.Nil(rr.item.Rows)
+
+	// replay of cached rows
+	ctx, cancel = context.WithCancel(context.Background())
+	item := &cache.Item{Cols: []string{"n"}, Rows: [][]driver.Value{{int64(1)}, {int64(2)}}}
+	rc := newRowsCached(ctx, item, true)
+	assert.Nil(rc.Next(dest))
+	cancel()
+	assert.ErrorIs(rc.Next(dest), context.Canceled)
+
+	// and of streamed rows
+	rs := &rowsStreamed{r: &itemReader{item: item}, ctx: ctx, done: ctx.Done()}
+	assert.ErrorIs(rs.Next(dest), context.Canceled)
+}
+
+// constRows is a driver.Rows returning n rows of small integers, which
+// don't allocate when boxed.
+type constRows struct {
+	n int
+}
+
+func (r *constRows) Columns() []string { return make([]string, 5) }
+func (r *constRows) Close() error      { return nil }
+
+func (r *constRows) Next(dest []driver.Value) error {
+	if r.n == 0 {
+		return io.EOF
+	}
+	for c := range dest {
+		dest[c] = int64(c)
+	}
+	r.n--
+	return nil
+}
+
+func BenchmarkRowsRecorder(b *testing.B) {
+	for _, tc := range []struct {
+		name    string
+		maxRows int
+	}{
+		{"cached", 1000},
+		{"max-rows-exceeded", 500},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			dest := make([]driver.Value, 5)
+			for n := 0; n < b.N; n++ {
+				rr := newRowsRecorder(context.Background(), func(*cache.Item) {}, func(SkipReason) {}, &constRows{n: 1000}, tc.maxRows)
+				rr.Columns()
+				for rr.Next(dest) == nil {
+				}
+				_ = rr.Close()
+			}
+		})
+	}
+}
+
+// reusedBufRows is a driver.Rows which, like go-sql-driver/mysql and pgx,
+// returns values in a byte buffer that's overwritten by the next row.
+type reusedBufRows struct {
+	n, r int
+	buf  []byte
+}
+
+func (s *reusedBufRows) Columns() []string { return []string{"b"} }
+func (s *reusedBufRows) Close() error      { return nil }
+
+func (s *reusedBufRows) Next(dest []driver.Value) error {
+	if s.r == s.n {
+		return io.EOF
+	}
+	s.buf = append(s.buf[:0], byte('a'+s.r))
+	dest[0] = s.buf
+	s.r++
+	return nil
+}
+
+func TestRowsRecorderCopiesBytes(t *testing.T) {
+	assert := require.New(t)
+
+	var got *cache.Item
+	rr := newRowsRecorder(context.Background(), func(item *cache.Item) { got = item }, nil, &reusedBufRows{n: 3}, 10)
+	dest := make([]driver.Value, len(rr.Columns()))
+	for rr.Next(dest) == nil {
+	}
+	assert.Nil(rr.Close())
+
+	assert.NotNil(got)
+	assert.Equal([][]driver.Value{{[]byte("a")}, {[]byte("b")}, {[]byte("c")}}, got.Rows)
+}
+
+func TestRowsRecorderDrain(t *testing.T) {
+	assert := require.New(t)
+
+	tests := map[string]struct {
+		budget  *DrainBudget
+		maxRows int
+		rows    int
+	}{
+		"not draining":      {nil, 100, 0},
+		"drained":           {&DrainBudget{MaxDuration: time.Second}, 100, 50},
+		"row budget":        {&DrainBudget{MaxRows: 10}, 100, 0},
+		"row budget enough": {&DrainBudget{MaxRows: 49}, 100, 50},
+		"max rows":          {&DrainBudget{}, 20, 0},
+	}
+	for name, test := range tests {
+		var (
+			got    *cache.Item
+			reason SkipReason
+		)
+		rr := newRowsRecorder(context.Background(), func(item *cache.Item) { got = item },
+			func(r SkipReason) { reason = r }, &seqRows{n: 50, cols: 2}, test.maxRows)
+		rr.drain = test.budget
+		// as by QueryRow
+		assert.Nil(rr.Next(make([]driver.Value, 2)), name)
+		assert.Nil(rr.Close(), name)
+
+		if test.rows == 0 {
+			assert.Nil(got, name)
+			assert.NotEmpty(reason, name)
+			continue
+		}
+		assert.Len(got.Rows, test.rows, name)
+		assert.Len(got.Cols, 2, name)
+		assert.Equal(int64(99), got.Rows[49][1], name)
+	}
+
+	// the time budget stops draining
+	var got *cache.Item
+	rr := newRowsRecorder(context.Background(), func(item *cache.Item) { got = item },
+		func(SkipReason) {}, &slowRows{seqRows{n: 50, cols: 2}}, 100)
+	rr.drain = &DrainBudget{MaxDuration: 5 * time.Millisecond}
+	assert.Nil(rr.Close())
+	assert.Nil(got)
+	assert.Less(len(rr.item.Rows), 50)
+}
+
+type slowRows struct {
+	seqRows
+}
+
+func (s *slowRows) Next(dest []driver.Value) error {
+	time.Sleep(time.Millisecond)
+	return s.seqRows.Next(dest)
+}
@@ -0,0 +1,94 @@
+package sqlcache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const defaultRetryBackoff = 10 * time.Millisecond
+
+// RetryPolicy configures retries of failed cache backend lookups and
+// writes. Retries are attempted only while the query's context isn't done,
+// so errors such as its deadline being exceeded are never retried, unlike
+// timeouts of Config.GetTimeout and Config.SetTimeout.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt fails.
+	MaxRetries int
+	// Backoff is the wait before the first retry, doubled for every
+	// subsequent retry. Defaults to 10ms.
+	Backoff time.Duration
+	// MaxBackoff, when set, caps the wait between retries.
+	MaxBackoff time.Duration
+	// Jitter, between 0 and 1, is the fraction of each wait that's
+	// randomized to spread out retries of concurrent queries.
+	Jitter float64
+	// Retryable reports whether an error is transient and worth retrying.
+	// By default all errors other than context.Canceled and those of
+	// items found but not decoded, which match ErrDecode, are retried.
+	Retryable func(error) bool
+}
+
+func defaultRetryable(err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, ErrDecode)
+}
+
+// undecodable wraps the error of a lookup that found an item it couldn't
+// decode, which fails again if retried, so that it matches ErrDecode.
+type undecodable struct {
+	error
+}
+
+func (e undecodable) Unwrap() error {
+	return e.error
+}
+
+func (e undecodable) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// lookupErr returns the error of a lookup, which found the item if found
+// is set, as undecodable when it did.
+func lookupErr(err error, found bool) error {
+	if err == nil || !found {
+		return err
+	}
+
+	return undecodable{err}
+}
+
+// backoff returns the wait before the retry numbered attempt, from zero.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff << uint(attempt)
+	if d <= 0 || (p.MaxBackoff > 0 && d > p.MaxBackoff) {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+
+	return d
+}
+
+// withRetries calls fn, retrying it as per the retry policy when it fails.
+// The last error is returned.
+func (i *Interceptor) withRetries(ctx context.Context, fn func() error) error {
+	p := i.retry
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || p == nil || attempt >= p.MaxRetries || ctx.Err() != nil || !p.Retryable(err) {
+			return err
+		}
+
+		wait, stop := i.clock.NewTimer(p.backoff(attempt))
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			stop()
+			return err
+		}
+		atomic.AddUint64(&i.stats.Retries, 1)
+	}
+}
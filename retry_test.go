@@ -0,0 +1,139 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	assert := require.New(t)
+
+	p := &RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	assert.Equal(10*time.Millisecond, p.backoff(0))
+	assert.Equal(20*time.Millisecond, p.backoff(1))
+	assert.Equal(40*time.Millisecond, p.backoff(2))
+	assert.Equal(50*time.Millisecond, p.backoff(3))
+	assert.Equal(50*time.Millisecond, p.backoff(100))
+
+	p.Jitter = 0.5
+	for n := 0; n < 10; n++ {
+		d := p.backoff(0)
+		assert.True(d > 5*time.Millisecond && d <= 10*time.Millisecond)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	errTransient := errors.New("connection reset")
+	errFatal := errors.New("fatal")
+	item := &cache.Item{
+		Cols: []string{"name"},
+		Rows: [][]driver.Value{{"John"}, {"Lisa"}},
+	}
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, errTransient).Twice()
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(item, true, nil).Once()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: mCacher,
+		Retry: &RetryPolicy{
+			MaxRetries: 2,
+			Backoff:    time.Millisecond,
+			Retryable: func(err error) bool {
+				return err != errFatal
+			},
+		},
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+
+	runQuery(t, assert, qMock, db, query, false)
+	assert.True(mCacher.AssertExpectations(t))
+
+	s := ic.Stats()
+	assert.Equal(uint64(1), s.Hits)
+	assert.Equal(uint64(2), s.Retries)
+	assert.Equal(uint64(0), s.Errors)
+
+	// errors that aren't retryable
+	mCacher = new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, errFatal).Once()
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Once()
+	assert.Nil(ic.SetCache(mCacher))
+
+	runQuery(t, assert, qMock, db, query, true)
+	assert.True(mCacher.AssertExpectations(t))
+
+	s = ic.Stats()
+	assert.Equal(uint64(2), s.Retries)
+	assert.Equal(uint64(1), s.Errors)
+
+	// nor are items found but not decoded by default
+	ic.retry.Retryable = defaultRetryable
+	var reported error
+	ic.SetOnError(func(err error) { reported = err })
+	mCacher = new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, true, errors.New("msgpack: invalid code")).Once()
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Once()
+	assert.Nil(ic.SetCache(mCacher))
+
+	runQuery(t, assert, qMock, db, query, true)
+	assert.True(mCacher.AssertExpectations(t))
+	assert.True(errors.Is(reported, ErrCacheGet))
+	assert.True(errors.Is(reported, ErrDecode))
+	s = ic.Stats()
+	assert.Equal(uint64(2), s.Retries)
+	assert.Equal(uint64(2), s.Errors)
+
+	// retries stop once the context is done, whether its deadline was
+	// exceeded or it was canceled
+	ic.retry.Retryable = func(error) bool { return true }
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	calls := 0
+	err = ic.withRetries(ctx, func() error {
+		calls++
+		return context.DeadlineExceeded
+	})
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(1, calls)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	err = ic.withRetries(ctx, func() error {
+		calls++
+		return errTransient
+	})
+	assert.Equal(errTransient, err)
+	assert.Equal(1, calls)
+}
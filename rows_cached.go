@@ -1,6 +1,7 @@
 package sqlcache
 
 import (
+	"context"
 	"database/sql/driver"
 	"io"
 
@@ -11,6 +12,33 @@ import (
 type rowsCached struct {
 	*cache.Item
 	ptr int
+	// done is the Done channel of the query's context, if it can be
+	// cancelled.
+	done <-chan struct{}
+	ctx  context.Context
+	// copyBytes is set when the item is shared, so that callers modifying
+	// byte slices they're handed can't corrupt it.
+	copyBytes bool
+	// open, when set, decrypts values of the column sealed by
+	// ColumnProtection.
+	open func(col string, s sealedValue) (driver.Value, error)
+}
+
+func newRowsCached(ctx context.Context, item *cache.Item, shared bool) *rowsCached {
+	return &rowsCached{Item: item, done: ctx.Done(), ctx: ctx, copyBytes: shared}
+}
+
+// ctxErr returns the error of the context if it's done.
+func ctxErr(ctx context.Context, done <-chan struct{}) error {
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return ctx.Err()
+	default:
+		return nil
+	}
 }
 
 func (r *rowsCached) Columns() []string {
@@ -18,12 +46,29 @@ func (r *rowsCached) Columns() []string {
 }
 
 func (r *rowsCached) Next(dest []driver.Value) error {
+	if err := ctxErr(r.ctx, r.done); err != nil {
+		return err
+	}
 	if r.ptr >= len(r.Item.Rows) {
 		return io.EOF
 	}
 
 	for i := range dest {
-		dest[i] = r.Item.Rows[r.ptr][i]
+		v := r.Item.Rows[r.ptr][i]
+		switch tv := v.(type) {
+		case []byte:
+			if tv != nil && r.copyBytes {
+				v = append(make([]byte, 0, len(tv)), tv...)
+			}
+		case sealedValue:
+			if r.open != nil {
+				var err error
+				if v, err = r.open(r.Item.Cols[i], tv); err != nil {
+					return err
+				}
+			}
+		}
+		dest[i] = v
 	}
 	r.ptr++
 
@@ -33,3 +78,65 @@ func (r *rowsCached) Next(dest []driver.Value) error {
 func (r *rowsCached) Close() error {
 	return nil
 }
+
+// itemReader implements cache.RowsReader over a fully decoded item.
+type itemReader struct {
+	item *cache.Item
+	ptr  int
+}
+
+func (r *itemReader) Header() *cache.Item {
+	return r.item
+}
+
+func (r *itemReader) Next(dest []driver.Value) error {
+	if r.ptr >= len(r.item.Rows) {
+		return io.EOF
+	}
+
+	copy(dest, r.item.Rows[r.ptr])
+	r.ptr++
+
+	return nil
+}
+
+// rowsStreamed implements driver.Rows interface over a cache.RowsReader
+type rowsStreamed struct {
+	r     cache.RowsReader
+	onErr func(error)
+	ctx   context.Context
+	done  <-chan struct{}
+	// open, when set, decrypts values of the column sealed by
+	// ColumnProtection.
+	open func(col string, s sealedValue) (driver.Value, error)
+}
+
+func (r *rowsStreamed) Columns() []string {
+	return r.r.Header().Cols
+}
+
+func (r *rowsStreamed) Next(dest []driver.Value) error {
+	if err := ctxErr(r.ctx, r.done); err != nil {
+		return err
+	}
+	err := r.r.Next(dest)
+	if err != nil && err != io.EOF && r.onErr != nil {
+		r.onErr(err)
+	}
+	if err != nil || r.open == nil {
+		return err
+	}
+	for i, v := range dest {
+		if s, ok := v.(sealedValue); ok {
+			if dest[i], err = r.open(r.r.Header().Cols[i], s); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *rowsStreamed) Close() error {
+	return nil
+}
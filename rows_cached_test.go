@@ -0,0 +1,53 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/require"
+)
+
+// freshCacher is a cache.FreshGetter backed by a mocked cache.Cacher
+type freshCacher struct {
+	*mocks.Cacher
+}
+
+func (freshCacher) FreshItems() bool { return true }
+
+func TestRowsCachedCopiesBytes(t *testing.T) {
+	assert := require.New(t)
+
+	item := &cache.Item{Cols: []string{"b"}, Rows: [][]driver.Value{{[]byte("abc")}}}
+	dest := make([]driver.Value, 1)
+
+	rc := newRowsCached(context.Background(), item, true)
+	assert.Nil(rc.Next(dest))
+	dest[0].([]byte)[0] = 'x'
+	assert.Equal([]byte("abc"), item.Rows[0][0])
+
+	rc = newRowsCached(context.Background(), item, false)
+	assert.Nil(rc.Next(dest))
+	dest[0].([]byte)[0] = 'x'
+	assert.Equal([]byte("xbc"), item.Rows[0][0])
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+	assert.True(ic.itemsShared())
+
+	ic, _ = NewInterceptor(&Config{
+		Cache: freshCacher{new(mocks.Cacher)},
+	})
+	assert.False(ic.itemsShared())
+
+	// items from the backend are kept by the in-process cache
+	ic, _ = NewInterceptor(&Config{
+		Cache:  freshCacher{new(mocks.Cacher)},
+		L1Size: 10,
+	})
+	assert.True(ic.itemsShared())
+}
@@ -0,0 +1,22 @@
+package sqlcache
+
+import (
+	"math"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// sampled reports whether the query is among the fraction of queries
+// cached as per rate, or its @cache-sample-rate attribute. Queries are
+// sampled by key, so that the same queries are consistently cached or not,
+// in every process.
+func (i *Interceptor) sampled(q *queryInfo, rate float64) bool {
+	if q.attrs.sampleRate != nil {
+		rate = *q.attrs.sampleRate
+	}
+	if rate >= 1 {
+		return true
+	}
+
+	return float64(xxhash.Sum64String(q.key)) < rate*math.MaxUint64
+}
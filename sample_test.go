@@ -0,0 +1,73 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleRate(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := NewInterceptor(&Config{Cache: &mapCacher{}, SampleRate: 1.5})
+	assert.NotNil(err)
+
+	queryFn := func() (driver.Rows, error) {
+		return &seqRows{n: 1, cols: 1}, nil
+	}
+	drain := func(rows driver.Rows) {
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+	run := func(ic *Interceptor, query string) {
+		for n := 0; n < 1000; n++ {
+			args := []driver.NamedValue{{Ordinal: 1, Value: int64(n)}}
+			rows, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, queryFn)
+			assert.Nil(err)
+			drain(rows)
+		}
+	}
+
+	query := `-- @cache-ttl 30
+              -- @cache-max-rows 10
+              SELECT name FROM users WHERE id = ?`
+	backend := &mapCacher{entries: make(map[string]cache.Entry)}
+	ic, err := NewInterceptor(&Config{Cache: backend, SampleRate: 0.25})
+	assert.Nil(err)
+	run(ic, query)
+	skipped := ic.Stats().SkipReasons[SkipNotSampled]
+	assert.InDelta(750, skipped, 75)
+	assert.Len(backend.entries, 1000-int(skipped))
+
+	// the same queries are sampled again
+	run(ic, query)
+	s := ic.Stats()
+	assert.Equal(2*skipped, s.SkipReasons[SkipNotSampled])
+	assert.Equal(1000-skipped, s.Hits)
+
+	// and by other interceptors
+	ic, _ = NewInterceptor(&Config{Cache: backend, SampleRate: 0.25})
+	run(ic, query)
+	assert.Equal(1000-skipped, ic.Stats().Hits)
+
+	// attributes override the rate
+	ic, _ = NewInterceptor(&Config{Cache: backend, SampleRate: 0.25})
+	run(ic, `-- @cache-ttl 30
+             -- @cache-max-rows 10
+             -- @cache-sample-rate 0
+             SELECT name FROM users WHERE id = ?`)
+	assert.Equal(uint64(1000), ic.Stats().SkipReasons[SkipNotSampled])
+
+	ic, _ = NewInterceptor(&Config{Cache: backend, SampleRate: 0.25})
+	run(ic, `-- @cache-ttl 30
+             -- @cache-max-rows 10
+             -- @cache-sample-rate 1
+             SELECT name FROM users WHERE id = ?`)
+	assert.Zero(ic.Stats().SkipReasons[SkipNotSampled])
+}
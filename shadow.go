@@ -0,0 +1,249 @@
+package sqlcache
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const (
+	defaultShadowMaxInFlight = 4
+	defaultShadowTimeout     = 5 * time.Second
+)
+
+// Shadow configures verification of a sample of cache hits: the query is
+// also run against the database in the background and its results are
+// compared with those served from cache. Divergences are counted in
+// Stats().ShadowMismatches, logged and reported to OnMismatch, which helps
+// validate TTLs and invalidation before relying on the cache.
+type Shadow struct {
+	// DB runs the queries. It must be opened with the driver not wrapped
+	// by the interceptor. It's required.
+	DB *sql.DB
+	// SampleRate is the fraction of hits verified, from 0 to 1.
+	SampleRate float64
+	// MaxInFlight bounds the number of queries run for verification at
+	// once; sampled hits beyond it aren't verified. Defaults to 4.
+	MaxInFlight int
+	// Timeout bounds each query run for verification. Defaults to 5s.
+	Timeout time.Duration
+	// OnMismatch, when set, is called with every divergence found.
+	OnMismatch func(ShadowMismatch)
+}
+
+// ShadowMismatch describes the first difference found between results
+// served from cache and those of the database.
+type ShadowMismatch struct {
+	Fingerprint string
+	Key         string
+	// Age is how long before verification the cached results were
+	// recorded.
+	Age time.Duration
+	// Reason is "columns", "rows" or "value".
+	Reason string
+	// Row and Col locate the differing value when Reason is "value".
+	// Rows are compared in order, so results of queries without an ORDER
+	// BY clause may differ by order alone.
+	Row, Col int
+	// Cached and Fresh are the differing values when Reason is "value",
+	// or the column names or number of rows otherwise.
+	Cached, Fresh interface{}
+}
+
+// shadower runs verification queries in the background.
+type shadower struct {
+	*Shadow
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+func newShadower(s *Shadow) *shadower {
+	return &shadower{Shadow: s, sem: make(chan struct{}, s.MaxInFlight)}
+}
+
+// maybeShadow verifies the cached rows served for the query if the hit is
+// sampled.
+func (i *Interceptor) maybeShadow(q *queryInfo, rows driver.Rows, args []driver.NamedValue) {
+	s := i.shadow
+	if s == nil || s.SampleRate <= 0 || (s.SampleRate < 1 && rand.Float64() >= s.SampleRate) {
+		return
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+	default:
+		return
+	}
+
+	// streamed items are read again from the backend
+	var item *cache.Item
+	if rc, ok := rows.(*rowsCached); ok {
+		item = rc.Item
+	}
+	cpy := queryArgs(args)
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			<-s.sem
+			s.wg.Done()
+		}()
+		i.verifyShadow(q, item, cpy)
+	}()
+}
+
+func (i *Interceptor) verifyShadow(q *queryInfo, item *cache.Item, args []interface{}) {
+	s := i.shadow
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	if item == nil {
+		var (
+			ok  bool
+			err error
+		)
+		item, ok, err = i.cacher().Get(ctx, q.key)
+		if err != nil || !ok {
+			return
+		}
+	}
+
+	cols, rows, err := queryAll(ctx, s.DB, q.query, args)
+	if err != nil {
+		i.log(ctx, LevelWarn, "sqlcache: shadow query failed",
+			"fingerprint", q.fingerprint, "key", q.key, "error", err)
+		return
+	}
+	atomic.AddUint64(&i.stats.ShadowChecks, 1)
+
+	m := compareResults(item, cols, rows)
+	if m == nil {
+		return
+	}
+	m.Fingerprint = q.fingerprint
+	m.Key = q.key
+	m.Age = i.clock.Now().Sub(item.CreatedAt)
+	atomic.AddUint64(&i.stats.ShadowMismatches, 1)
+	i.log(ctx, LevelWarn, "sqlcache: cached results differ from database",
+		"fingerprint", q.fingerprint, "key", q.key, "reason", m.Reason, "age", m.Age)
+
+	if s.OnMismatch != nil {
+		defer func() {
+			if v := recover(); v != nil {
+				i.reportErr(ctx, q, &Error{Kind: ErrPanic, Op: "OnMismatch", Key: q.key, Err: i.panicked(v)})
+			}
+		}()
+		s.OnMismatch(*m)
+	}
+}
+
+// queryArgs returns args as arguments of queries run on a sql.DB in the
+// background. The caller may reuse byte slices of args once the query it
+// intercepted returns, so they're copied.
+func queryArgs(args []driver.NamedValue) []interface{} {
+	cpy := make([]interface{}, len(args))
+	for n, arg := range args {
+		v := arg.Value
+		if b, ok := v.([]byte); ok {
+			v = append([]byte(nil), b...)
+		}
+		if arg.Name != "" {
+			v = sql.Named(arg.Name, v)
+		}
+		cpy[n] = v
+	}
+
+	return cpy
+}
+
+// queryAll returns the columns and all rows of the query.
+func queryAll(ctx context.Context, db *sql.DB, query string, args []interface{}) ([]string, [][]interface{}, error) {
+	rs, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rs.Close()
+
+	cols, err := rs.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows [][]interface{}
+	for rs.Next() {
+		row := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for c := range row {
+			ptrs[c] = &row[c]
+		}
+		if err := rs.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	return cols, rows, rs.Err()
+}
+
+// compareResults returns the first difference between the cached item and
+// the fresh results, or nil if there's none. Values are compared after
+// normalization as by the default hash function, and times by instant.
+func compareResults(item *cache.Item, cols []string, rows [][]interface{}) *ShadowMismatch {
+	if !reflect.DeepEqual(item.Cols, cols) && (len(item.Cols) != 0 || len(cols) != 0) {
+		return &ShadowMismatch{Reason: "columns", Cached: item.Cols, Fresh: cols}
+	}
+	if len(item.Rows) != len(rows) {
+		return &ShadowMismatch{Reason: "rows", Cached: len(item.Rows), Fresh: len(rows)}
+	}
+
+	for r, row := range rows {
+		for c, fresh := range row {
+			var cached driver.Value
+			if c < len(item.Rows[r]) {
+				cached = item.Rows[r][c]
+			}
+			if !valuesEqual(cached, fresh) {
+				return &ShadowMismatch{Reason: "value", Row: r, Col: c, Cached: cached, Fresh: fresh}
+			}
+		}
+	}
+
+	return nil
+}
+
+func valuesEqual(a, b interface{}) bool {
+	a, b = normalizeValue(a), normalizeValue(b)
+	switch a := a.(type) {
+	case time.Time:
+		b, ok := b.(time.Time)
+		return ok && a.Equal(b)
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// validateShadow returns a copy of s with defaults applied.
+func validateShadow(s *Shadow) (*Shadow, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("Shadow.DB must be set")
+	}
+	cpy := *s
+	if cpy.MaxInFlight <= 0 {
+		cpy.MaxInFlight = defaultShadowMaxInFlight
+	}
+	if cpy.Timeout <= 0 {
+		cpy.Timeout = defaultShadowTimeout
+	}
+
+	return &cpy, nil
+}
@@ -0,0 +1,127 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadow(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := NewInterceptor(&Config{Cache: &mapCacher{}, Shadow: &Shadow{SampleRate: 1}})
+	assert.NotNil(err)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	var mismatches []ShadowMismatch
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	ic, err := NewInterceptor(&Config{
+		Cache: &mapCacher{entries: make(map[string]cache.Entry)},
+		Clock: clock,
+		Shadow: &Shadow{
+			DB:         mockDB,
+			SampleRate: 1,
+			OnMismatch: func(m ShadowMismatch) { mismatches = append(mismatches, m) },
+		},
+	})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	run := func() []string {
+		rows, err := db.Query(query, 18)
+		assert.Nil(err)
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			assert.Nil(rows.Scan(&name))
+			names = append(names, name)
+		}
+		assert.Nil(rows.Err())
+		// wait for verification
+		assert.Nil(ic.Shutdown(context.Background()))
+		return names
+	}
+	users := func(names ...string) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"name"})
+		for _, name := range names {
+			rows.AddRow(name)
+		}
+		return rows
+	}
+
+	// misses aren't verified
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnRows(users("John"))
+	assert.Equal([]string{"John"}, run())
+
+	// hits are, with the results served from cache
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnRows(users("John"))
+	assert.Equal([]string{"John"}, run())
+	assert.Empty(mismatches)
+
+	clock.Advance(10 * time.Second)
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnRows(users("Lisa"))
+	assert.Equal([]string{"John"}, run())
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnRows(users("John", "Lisa"))
+	assert.Equal([]string{"John"}, run())
+	assert.Nil(qMock.ExpectationsWereMet())
+
+	assert.Len(mismatches, 2)
+	assert.Equal(ShadowMismatch{
+		Fingerprint: fingerprint(query),
+		Key:         mismatches[0].Key,
+		Age:         10 * time.Second,
+		Reason:      "value",
+		Cached:      "John",
+		Fresh:       "Lisa",
+	}, mismatches[0])
+	assert.NotEmpty(mismatches[0].Key)
+	assert.Equal("rows", mismatches[1].Reason)
+	assert.Equal(1, mismatches[1].Cached)
+	assert.Equal(2, mismatches[1].Fresh)
+
+	s := ic.Stats()
+	assert.Equal(uint64(3), s.ShadowChecks)
+	assert.Equal(uint64(2), s.ShadowMismatches)
+}
+
+func TestCompareResults(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Now()
+	item := &cache.Item{
+		Cols: []string{"a", "b", "c"},
+		Rows: [][]driver.Value{{int64(1), []byte("x"), now}},
+	}
+	assert.Nil(compareResults(item, []string{"a", "b", "c"}, [][]interface{}{{int32(1), []byte("x"), now.UTC()}}))
+
+	m := compareResults(item, []string{"a", "b"}, nil)
+	assert.Equal("columns", m.Reason)
+
+	m = compareResults(item, []string{"a", "b", "c"}, [][]interface{}{{int64(1), []byte("y"), now}})
+	assert.Equal("value", m.Reason)
+	assert.Equal(1, m.Col)
+
+	// empty results
+	assert.Nil(compareResults(&cache.Item{Cols: []string{"a"}}, []string{"a"}, nil))
+}
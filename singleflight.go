@@ -0,0 +1,81 @@
+package sqlcache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const defaultCoalesceTimeout = time.Second
+
+// flight is a query being run on behalf of all concurrent callers that
+// missed the cache on the same key.
+type flight struct {
+	done chan struct{}
+	// item holds the complete results of the query once done is closed,
+	// or nil when the results couldn't be recorded.
+	item *cache.Item
+}
+
+// flightGroup coalesces concurrent cache misses on the same key so that
+// only one of them runs the query.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// join returns the flight for key and whether the caller is its leader.
+// The leader must call land once the query results have been recorded.
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+
+	return f, true
+}
+
+// land shares item with the callers waiting on the flight.
+func (g *flightGroup) land(key string, f *flight, item *cache.Item) {
+	g.abandon(key, f)
+
+	f.item = item
+	close(f.done)
+}
+
+// abandon removes the flight for key, unless it has been already, so that
+// later callers don't join it.
+func (g *flightGroup) abandon(key string, f *flight) {
+	g.mu.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+}
+
+// awaitFlight waits for up to Config.CoalesceTimeout for the flight for key
+// to land, reporting whether it did. A flight that doesn't land in time,
+// such as one whose leader never closes its rows, is abandoned.
+func (i *Interceptor) awaitFlight(ctx context.Context, key string, f *flight) (bool, error) {
+	timeout, stop := i.clock.NewTimer(i.coalesceTimeout)
+	defer stop()
+
+	select {
+	case <-f.done:
+		return true, nil
+	case <-timeout:
+		i.flights.abandon(key, f)
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
@@ -0,0 +1,132 @@
+package sqlcache
+
+// SkipReason describes why the results of a query weren't cached.
+type SkipReason string
+
+const (
+	// SkipNoAttributes indicates that the query has no cache attributes.
+	SkipNoAttributes SkipReason = "no-attributes"
+	// SkipDisabled indicates that the interceptor is disabled or closed.
+	SkipDisabled SkipReason = "disabled"
+	// SkipHashError indicates that HashFunc returned an error.
+	SkipHashError SkipReason = "hash-error"
+	// SkipInTx indicates that the query was run within a transaction and
+	// Config.DisableTxCaching is set.
+	SkipInTx SkipReason = "in-transaction"
+	// SkipMaxRows indicates that the number of rows exceeded the
+	// @cache-max-rows attribute.
+	SkipMaxRows SkipReason = "max-rows-exceeded"
+	// SkipMaxBytes indicates that the encoded size of the results exceeded
+	// Config.MaxItemBytes or AutoCache.MaxItemBytes.
+	SkipMaxBytes SkipReason = "max-bytes-exceeded"
+	// SkipMaxRecordBytes indicates that the size in memory of the rows
+	// read exceeded Config.MaxRecordBytes.
+	SkipMaxRecordBytes SkipReason = "max-record-bytes-exceeded"
+	// SkipIncomplete indicates that the rows weren't read till the end or
+	// reading them failed.
+	SkipIncomplete SkipReason = "incomplete"
+	// SkipBackendError indicates that writing the results to the cache
+	// backend failed.
+	SkipBackendError SkipReason = "backend-error"
+	// SkipSetQueueFull indicates that the queue of asynchronous writes to
+	// the cache backend was full.
+	SkipSetQueueFull SkipReason = "set-queue-full"
+	// SkipDeadline indicates that the time left before the query's
+	// deadline was less than Config.MinLookupBudget.
+	SkipDeadline SkipReason = "deadline-too-short"
+	// SkipFastQuery indicates that the average execution time of the
+	// query was below Config.MinQueryLatency.
+	SkipFastQuery SkipReason = "fast-query"
+	// SkipRateLimited indicates that writes to the cache backend exceeded
+	// Config.SetRateLimit or Config.QuerySetRateLimit.
+	SkipRateLimited SkipReason = "rate-limited"
+	// SkipCanceled indicates that the query's context was done before
+	// all rows were read.
+	SkipCanceled SkipReason = "canceled"
+	// SkipNonDeterministic indicates that the query calls a
+	// non-deterministic function and Config.NonDeterministic is
+	// NonDeterministicSkip.
+	SkipNonDeterministic SkipReason = "non-deterministic"
+	// SkipNotRead indicates that the query isn't a read, such as an INSERT
+	// with a RETURNING clause, and Config.CacheWrites isn't set.
+	SkipNotRead SkipReason = "not-a-read"
+	// SkipEmpty indicates that the query returned no rows and
+	// Config.DisableNegativeCaching is set.
+	SkipEmpty SkipReason = "empty"
+	// SkipZeroTTL indicates that the query has a @cache-ttl of zero and
+	// Config.ZeroTTL is ZeroTTLSkip, or a TTL override of zero.
+	SkipZeroTTL SkipReason = "zero-ttl"
+	// SkipInvalidAttributes indicates that the query's cache attributes
+	// are repeated, out of range or can't be combined.
+	SkipInvalidAttributes SkipReason = "invalid-attributes"
+	// SkipNotSampled indicates that the query isn't among the fraction of
+	// queries cached as per Config.SampleRate or @cache-sample-rate.
+	SkipNotSampled SkipReason = "not-sampled"
+	// SkipDenied indicates that the query's fingerprint isn't in
+	// Config.AllowFingerprints or is in Config.DenyFingerprints.
+	SkipDenied SkipReason = "denied"
+	// SkipNotEnabled indicates that Config.Enabler returned false for the
+	// query or panicked.
+	SkipNotEnabled SkipReason = "not-enabled"
+	// SkipQuotaExceeded indicates that writing the results would exceed
+	// Config.Quota overall or for the query's tenant.
+	SkipQuotaExceeded SkipReason = "quota-exceeded"
+	// SkipDriverNoCache indicates that the query was run through a driver
+	// whose DriverPolicy has NoCache set.
+	SkipDriverNoCache SkipReason = "driver-no-cache"
+	// SkipTransformVeto indicates that Config.TransformItem vetoed
+	// caching of the results or panicked.
+	SkipTransformVeto SkipReason = "transform-veto"
+	// SkipNoPrincipal indicates that the query has the
+	// @cache-per-principal attribute but Config.Principal returned no
+	// principal for it, or panicked.
+	SkipNoPrincipal SkipReason = "no-principal"
+	// SkipUnhealthy indicates that the cache backend is bypassed after
+	// repeated failures; see Config.HealthCheck.
+	SkipUnhealthy SkipReason = "unhealthy"
+	// SkipFragmentMismatch indicates that the results of a query with
+	// @cache-fragment-by couldn't be told apart by the values of its IN
+	// list, as the column is missing or holds values not in the list.
+	SkipFragmentMismatch SkipReason = "fragment-mismatch"
+)
+
+// skipReasons lists all skip reasons; the index of a reason is used to
+// account for it in Interceptor.skips.
+var skipReasons = [...]SkipReason{
+	SkipNoAttributes,
+	SkipDisabled,
+	SkipHashError,
+	SkipInTx,
+	SkipMaxRows,
+	SkipMaxBytes,
+	SkipIncomplete,
+	SkipBackendError,
+	SkipSetQueueFull,
+	SkipDeadline,
+	SkipFastQuery,
+	SkipRateLimited,
+	SkipCanceled,
+	SkipNonDeterministic,
+	SkipNotRead,
+	SkipEmpty,
+	SkipZeroTTL,
+	SkipInvalidAttributes,
+	SkipNotSampled,
+	SkipDenied,
+	SkipNotEnabled,
+	SkipQuotaExceeded,
+	SkipDriverNoCache,
+	SkipTransformVeto,
+	SkipNoPrincipal,
+	SkipUnhealthy,
+	SkipFragmentMismatch,
+	SkipMaxRecordBytes,
+}
+
+var skipReasonIndex = func() map[SkipReason]int {
+	m := make(map[SkipReason]int, len(skipReasons))
+	for n, r := range skipReasons {
+		m[r] = n
+	}
+	return m
+}()
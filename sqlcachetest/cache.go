@@ -0,0 +1,403 @@
+// Package sqlcachetest provides an in-memory cache.Cacher for testing code
+// that uses sqlcache without redis or generated mocks.
+package sqlcachetest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// ErrInjected is returned by operations failed by a Fault without an Err.
+var ErrInjected = errors.New("sqlcachetest: injected fault")
+
+// Op names an operation of Cache.
+type Op string
+
+// Operations of Cache, as recorded in calls and matched by faults.
+const (
+	OpGet      Op = "Get"
+	OpSet      Op = "Set"
+	OpDelete   Op = "Delete"
+	OpGetMulti Op = "GetMulti"
+	OpSetMulti Op = "SetMulti"
+	OpRange    Op = "Range"
+	OpTTL      Op = "TTL"
+	// OpDeleteFingerprint calls are recorded with the fingerprint as
+	// their key.
+	OpDeleteFingerprint Op = "DeleteFingerprint"
+)
+
+// Fault makes matching operations of a Cache fail.
+type Fault struct {
+	// Op is the operation to fail; empty matches all operations.
+	Op Op
+	// Key is the key to fail operations on; empty matches all keys.
+	// Batch operations fail when any of their keys match.
+	Key string
+	// Err is returned by failed operations; ErrInjected if nil.
+	Err error
+	// Times is the number of operations to fail, after which the fault
+	// is removed; zero fails operations until Cache.Reset.
+	Times int
+}
+
+// Call is an operation made on a Cache.
+type Call struct {
+	Op   Op
+	Keys []string
+	// TTL is the TTL of Set, or of the last entry of SetMulti.
+	TTL time.Duration
+	Err error
+}
+
+// Clock tells the time for Cache; sqlcache.Clock and *sqlcache.FakeClock
+// implement it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type entry struct {
+	item      *cache.Item
+	expiresAt time.Time // zero if it doesn't expire
+}
+
+// Cache is an in-memory cache.Cacher whose items expire by a Clock, whose
+// contents and calls can be inspected and whose operations can be made to
+// fail. Items are copied when set and got, so that code under test can't
+// modify them in the cache, as is the case for backends that serialize
+// items. Cache also implements cache.Deleter, cache.BatchCacher,
+// cache.Ranger, cache.Indexer and cache.TTLReporter. It's safe for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries map[string]entry
+	faults  []*Fault
+	calls   []Call
+}
+
+// NewCache returns an empty Cache. A *sqlcache.FakeClock, shared with
+// sqlcache.Config.Clock, expires items deterministically; a nil clock uses
+// the system clock.
+func NewCache(clock Clock) *Cache {
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return &Cache{
+		clock:   clock,
+		entries: make(map[string]entry),
+	}
+}
+
+// Inject adds a fault. Faults are matched in the order they were added and
+// only the first match applies.
+func (c *Cache) Inject(f Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.faults = append(c.faults, &f)
+}
+
+// Reset removes all items, faults and recorded calls.
+func (c *Cache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]entry)
+	c.faults = nil
+	c.calls = nil
+}
+
+// Calls returns the operations made on the cache so far, in order.
+func (c *Cache) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Call(nil), c.calls...)
+}
+
+// Keys returns the sorted keys of items that haven't expired.
+func (c *Cache) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	keys := make([]string, 0, len(c.entries))
+	for key, e := range c.entries {
+		if e.live(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Peek returns a copy of the item of key and the time left before it
+// expires, which is zero if it doesn't, without recording a call or
+// applying faults.
+func (c *Cache) Peek(key string) (cache.Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	e, ok := c.entries[key]
+	if !ok || !e.live(now) {
+		return cache.Entry{}, false
+	}
+
+	return e.entry(key, now), true
+}
+
+// Get implements cache.Cacher.
+func (c *Cache) Get(ctx context.Context, key string) (*cache.Item, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call(Call{Op: OpGet, Keys: []string{key}}); err != nil {
+		return nil, false, err
+	}
+	item := c.get(key)
+
+	return item, item != nil, nil
+}
+
+// Set implements cache.Cacher.
+func (c *Cache) Set(ctx context.Context, key string, item *cache.Item, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call(Call{Op: OpSet, Keys: []string{key}, TTL: ttl}); err != nil {
+		return err
+	}
+	c.set(key, item, ttl)
+
+	return nil
+}
+
+// Delete implements cache.Deleter.
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call(Call{Op: OpDelete, Keys: []string{key}}); err != nil {
+		return err
+	}
+	delete(c.entries, key)
+
+	return nil
+}
+
+// DeleteFingerprint implements cache.Indexer.
+func (c *Cache) DeleteFingerprint(ctx context.Context, fingerprint string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call(Call{Op: OpDeleteFingerprint, Keys: []string{fingerprint}}); err != nil {
+		return 0, err
+	}
+	now := c.clock.Now()
+	var deleted int
+	for key, e := range c.entries {
+		if e.item.Fingerprint != fingerprint {
+			continue
+		}
+		if e.live(now) {
+			deleted++
+		}
+		delete(c.entries, key)
+	}
+
+	return deleted, nil
+}
+
+// TTL implements cache.TTLReporter.
+func (c *Cache) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call(Call{Op: OpTTL, Keys: []string{key}}); err != nil {
+		return 0, false, err
+	}
+	now := c.clock.Now()
+	e, ok := c.entries[key]
+	if !ok || !e.live(now) {
+		return 0, false, nil
+	}
+
+	return e.entry(key, now).TTL, true, nil
+}
+
+// GetMulti implements cache.BatchCacher.
+func (c *Cache) GetMulti(ctx context.Context, keys []string) ([]*cache.Item, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call(Call{Op: OpGetMulti, Keys: append([]string(nil), keys...)}); err != nil {
+		return nil, err
+	}
+	items := make([]*cache.Item, len(keys))
+	for n, key := range keys {
+		items[n] = c.get(key)
+	}
+
+	return items, nil
+}
+
+// SetMulti implements cache.BatchCacher.
+func (c *Cache) SetMulti(ctx context.Context, entries []cache.Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call := Call{Op: OpSetMulti, Keys: make([]string, len(entries))}
+	for n, e := range entries {
+		call.Keys[n] = e.Key
+		call.TTL = e.TTL
+	}
+	if err := c.call(call); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		c.set(e.Key, e.Item, e.TTL)
+	}
+
+	return nil
+}
+
+// Range implements cache.Ranger, calling fn in the order of keys. fn may
+// call other methods of the cache.
+func (c *Cache) Range(ctx context.Context, fn func(e cache.Entry) error) error {
+	c.mu.Lock()
+	if err := c.call(Call{Op: OpRange}); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	now := c.clock.Now()
+	entries := make([]cache.Entry, 0, len(c.entries))
+	for key, e := range c.entries {
+		if e.live(now) {
+			entries = append(entries, e.entry(key, now))
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Key < entries[b].Key })
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FreshItems implements cache.FreshGetter.
+func (c *Cache) FreshItems() bool {
+	return true
+}
+
+// call records the call and returns the error of the first fault matching
+// it. c.mu must be held.
+func (c *Cache) call(call Call) error {
+	for n, f := range c.faults {
+		if !f.matches(call) {
+			continue
+		}
+		call.Err = f.Err
+		if call.Err == nil {
+			call.Err = ErrInjected
+		}
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				c.faults = append(c.faults[:n], c.faults[n+1:]...)
+			}
+		}
+		break
+	}
+	c.calls = append(c.calls, call)
+
+	return call.Err
+}
+
+func (c *Cache) get(key string) *cache.Item {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if !e.live(c.clock.Now()) {
+		delete(c.entries, key)
+		return nil
+	}
+
+	return copyItem(e.item)
+}
+
+func (c *Cache) set(key string, item *cache.Item, ttl time.Duration) {
+	e := entry{item: copyItem(item)}
+	if ttl > 0 {
+		e.expiresAt = c.clock.Now().Add(ttl)
+	}
+	c.entries[key] = e
+}
+
+func (f *Fault) matches(call Call) bool {
+	if f.Op != "" && f.Op != call.Op {
+		return false
+	}
+	if f.Key == "" {
+		return true
+	}
+	for _, key := range call.Keys {
+		if key == f.Key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e entry) live(now time.Time) bool {
+	return e.expiresAt.IsZero() || now.Before(e.expiresAt)
+}
+
+func (e entry) entry(key string, now time.Time) cache.Entry {
+	var ttl time.Duration
+	if !e.expiresAt.IsZero() {
+		ttl = e.expiresAt.Sub(now)
+	}
+
+	return cache.Entry{Key: key, Item: copyItem(e.item), TTL: ttl}
+}
+
+// copyItem returns a deep copy of item, but for Hits.
+func copyItem(item *cache.Item) *cache.Item {
+	cp := &cache.Item{
+		Cols:        append([]string(nil), item.Cols...),
+		CreatedAt:   item.CreatedAt,
+		Fingerprint: item.Fingerprint,
+		Digest:      append([]byte(nil), item.Digest...),
+		StaleAt:     item.StaleAt,
+		Rows:        make([][]driver.Value, len(item.Rows)),
+	}
+	for r, row := range item.Rows {
+		cp.Rows[r] = make([]driver.Value, len(row))
+		for c, v := range row {
+			if b, ok := v.([]byte); ok {
+				v = append([]byte(nil), b...)
+			}
+			cp.Rows[r][c] = v
+		}
+	}
+
+	return cp
+}
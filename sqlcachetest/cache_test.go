@@ -0,0 +1,174 @@
+package sqlcachetest_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache"
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/cache/cachetest"
+	"github.com/prashanthpai/sqlcache/sqlcachetest"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cacher {
+		return sqlcachetest.NewCache(nil)
+	})
+}
+
+func TestCacheExpiry(t *testing.T) {
+	assert := require.New(t)
+
+	clock := sqlcache.NewFakeClock(time.Unix(1700000000, 0))
+	c := sqlcachetest.NewCache(clock)
+	item := &cache.Item{Cols: []string{"n"}, Rows: [][]driver.Value{{int64(1)}}}
+	assert.Nil(c.Set(context.Background(), "a", item, time.Minute))
+	assert.Nil(c.Set(context.Background(), "b", item, 0))
+
+	clock.Advance(59 * time.Second)
+	e, ok := c.Peek("a")
+	assert.True(ok)
+	assert.Equal(time.Second, e.TTL)
+	assert.Equal(item, e.Item)
+	assert.Equal([]string{"a", "b"}, c.Keys())
+
+	clock.Advance(time.Second)
+	_, ok = c.Peek("a")
+	assert.False(ok)
+	_, ok, err := c.Get(context.Background(), "a")
+	assert.Nil(err)
+	assert.False(ok)
+	assert.Equal([]string{"b"}, c.Keys())
+	e, ok = c.Peek("b")
+	assert.True(ok)
+	assert.Zero(e.TTL)
+
+	// items are copied
+	got, _, _ := c.Get(context.Background(), "b")
+	got.Rows[0][0] = int64(2)
+	item.Rows[0][0] = int64(3)
+	e, _ = c.Peek("b")
+	assert.Equal(int64(1), e.Item.Rows[0][0])
+}
+
+func TestCacheFaults(t *testing.T) {
+	assert := require.New(t)
+
+	c := sqlcachetest.NewCache(nil)
+	item := &cache.Item{Cols: []string{"n"}}
+	boom := errors.New("boom")
+	c.Inject(sqlcachetest.Fault{Op: sqlcachetest.OpSet, Key: "a", Err: boom, Times: 1})
+	c.Inject(sqlcachetest.Fault{Op: sqlcachetest.OpGet})
+
+	assert.ErrorIs(c.Set(context.Background(), "a", item, time.Minute), boom)
+	assert.Nil(c.Set(context.Background(), "a", item, time.Minute))
+	_, _, err := c.Get(context.Background(), "a")
+	assert.ErrorIs(err, sqlcachetest.ErrInjected)
+	_, _, err = c.Get(context.Background(), "a")
+	assert.ErrorIs(err, sqlcachetest.ErrInjected)
+	// batch operations fail when any key matches
+	c.Inject(sqlcachetest.Fault{Key: "b"})
+	assert.NotNil(c.SetMulti(context.Background(), []cache.Entry{{Key: "c", Item: item}, {Key: "b", Item: item}}))
+	_, ok := c.Peek("c")
+	assert.False(ok)
+
+	assert.Equal([]sqlcachetest.Call{
+		{Op: sqlcachetest.OpSet, Keys: []string{"a"}, TTL: time.Minute, Err: boom},
+		{Op: sqlcachetest.OpSet, Keys: []string{"a"}, TTL: time.Minute},
+		{Op: sqlcachetest.OpGet, Keys: []string{"a"}, Err: sqlcachetest.ErrInjected},
+		{Op: sqlcachetest.OpGet, Keys: []string{"a"}, Err: sqlcachetest.ErrInjected},
+		{Op: sqlcachetest.OpSetMulti, Keys: []string{"c", "b"}, Err: sqlcachetest.ErrInjected},
+	}, c.Calls())
+
+	c.Reset()
+	_, ok, err = c.Get(context.Background(), "a")
+	assert.Nil(err)
+	assert.False(ok)
+	assert.Len(c.Calls(), 1)
+}
+
+func TestCacheInterceptor(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	clock := sqlcache.NewFakeClock(time.Unix(1700000000, 0))
+	c := sqlcachetest.NewCache(clock)
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	ic, err := sqlcache.NewInterceptor(&sqlcache.Config{
+		Cache: c,
+		Clock: clock,
+		OnError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		},
+	})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	run := func() string {
+		var name string
+		rows, err := db.Query(query, 18)
+		assert.Nil(err)
+		defer rows.Close()
+		for rows.Next() {
+			assert.Nil(rows.Scan(&name))
+		}
+		assert.Nil(rows.Err())
+		return name
+	}
+
+	// miss, then hit
+	qMock.ExpectQuery("SELECT name").WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	assert.Equal("John", run())
+	assert.Len(c.Keys(), 1)
+	assert.Equal("John", run())
+
+	clock.Advance(10 * time.Second)
+	info, ok, err := ic.Inspect(context.Background(), query, 18)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(20*time.Second, info.TTL)
+
+	// expired
+	clock.Advance(20 * time.Second)
+	assert.Empty(c.Keys())
+
+	// the backend failing lookups falls back to the database
+	c.Inject(sqlcachetest.Fault{Op: sqlcachetest.OpGet, Times: 1})
+	qMock.ExpectQuery("SELECT name").WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Lisa"))
+	assert.Equal("Lisa", run())
+	assert.Nil(qMock.ExpectationsWereMet())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(errs, 1)
+	assert.ErrorIs(errs[0], sqlcache.ErrCacheGet)
+	assert.ErrorIs(errs[0], sqlcachetest.ErrInjected)
+}
@@ -0,0 +1,279 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+const defaultStaleTimeout = 30 * time.Second
+
+// StaleBudget configures serving the results of queries past their TTL
+// when the database is slow. Results are cached with their TTL as soft TTL
+// (see cache.Item.StaleAt) and expire from the backend Window later. On a
+// miss with such stale results, the query is run on DB and raced against
+// Deadline: if it completes in time its results are served and cached as
+// usual, and otherwise the stale results are served right away while the
+// query completes in the background to refresh the cache. Misses served
+// stale results are counted in Stats().StaleHits. Results cached without
+// expiry are never stale.
+//
+// Processes predating cache.Item.StaleAt ignore it and serve results as
+// fresh until the backend expires them, Window past their TTL, so set
+// StaleBudget once all processes sharing the backend are upgraded.
+type StaleBudget struct {
+	// DB runs the queries. It must be opened with the driver not wrapped
+	// by the interceptor. It's required. Queries are run on a connection
+	// of DB rather than on the caller's, so they don't see its session
+	// state, such as its transaction, temporary tables or variables set
+	// with SET; don't use attributes with queries depending on it while
+	// StaleBudget is set.
+	DB *sql.DB
+	// Deadline is how long a query may take before stale results are
+	// served instead. Zero serves them at once while the query refreshes
+	// the cache, as stale-while-revalidate.
+	Deadline time.Duration
+	// OnError serves stale results when the query fails within Deadline,
+	// rather than running it again on the caller's connection.
+	OnError bool
+	// RefreshAhead is how long before their soft TTL the results of hits
+	// are refreshed, by running the query in the background as for a miss
+	// served stale results, so that frequently read results don't go
+	// stale. Zero disables it.
+	RefreshAhead time.Duration
+	// Window is how long results are kept past their TTL to be served
+	// stale. It's required.
+	Window time.Duration
+	// Timeout bounds each query, including its completion in the
+	// background once stale results were served. Defaults to 30s.
+	Timeout time.Duration
+}
+
+func validateStaleBudget(s *StaleBudget) (*StaleBudget, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("StaleBudget.DB must be set")
+	}
+	if s.Deadline < 0 {
+		return nil, fmt.Errorf("StaleBudget.Deadline must not be negative")
+	}
+	if s.RefreshAhead < 0 {
+		return nil, fmt.Errorf("StaleBudget.RefreshAhead must not be negative")
+	}
+	if s.Window <= 0 {
+		return nil, fmt.Errorf("StaleBudget.Window must be positive")
+	}
+	cpy := *s
+	if cpy.Timeout <= 0 {
+		cpy.Timeout = defaultStaleTimeout
+	}
+
+	return &cpy, nil
+}
+
+// staler runs queries with stale results available in the background.
+type staler struct {
+	*StaleBudget
+	wg sync.WaitGroup
+}
+
+// fresh reports whether the item is within its soft TTL.
+func (i *Interceptor) fresh(item *cache.Item) bool {
+	return item.StaleAt.IsZero() || i.clock.Now().Before(item.StaleAt)
+}
+
+// refreshAhead reports whether the fresh item is due to be refreshed, as
+// by StaleBudget.RefreshAhead.
+func (i *Interceptor) refreshAhead(item *cache.Item) bool {
+	if i.stale == nil || i.stale.RefreshAhead <= 0 || i.dryRun != nil || item.StaleAt.IsZero() {
+		return false
+	}
+
+	return !i.clock.Now().Before(item.StaleAt.Add(-i.stale.RefreshAhead))
+}
+
+// softTTL returns the item to write for results cached for ttl, and the
+// TTL the backend expires it at. Items that may be served stale are copied
+// with ttl as soft TTL and kept for Window longer.
+func (i *Interceptor) softTTL(item *cache.Item, ttl time.Duration) (*cache.Item, time.Duration) {
+	if i.stale == nil || ttl <= 0 {
+		return item, ttl
+	}
+
+	created := item.CreatedAt
+	if created.IsZero() {
+		created = i.clock.Now()
+	}
+	cpy := &cache.Item{
+		Cols:        item.Cols,
+		CreatedAt:   item.CreatedAt,
+		Fingerprint: item.Fingerprint,
+		Digest:      item.Digest,
+		StaleAt:     created.Add(ttl),
+		Rows:        item.Rows,
+	}
+
+	return cpy, ttl + i.stale.Window
+}
+
+// serveStale serves the query from its stale results if it doesn't
+// complete within the deadline, in which case ok is set. The results of
+// the query are written using set, or done is called if there are none.
+// If the query fails within the deadline, ok isn't set and neither land
+// nor done are called, so that the caller runs the query as usual while
+// still holding the flight and locks of the miss, unless StaleBudget.OnError
+// is set. Results due to be refreshed ahead of their soft TTL are served at
+// once, as they're still fresh.
+func (i *Interceptor) serveStale(ctx context.Context, q *queryInfo, args []driver.NamedValue, set func(context.Context, *cache.Item), land func(*cache.Item), done func()) (rows driver.Rows, ok bool, err error) {
+	stale := q.stale
+	if stale == nil {
+		return nil, false, nil
+	}
+
+	s := i.stale
+	res := make(chan *cache.Item, 1)
+	qArgs := queryArgs(args)
+	bctx := detachedContext{ctx}
+	var (
+		mu      sync.Mutex
+		waiting = true // the caller waits for the results
+	)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		item := i.refresh(bctx, q, qArgs)
+		mu.Lock()
+		handedOff := waiting
+		if handedOff {
+			res <- item
+		}
+		mu.Unlock()
+		switch {
+		case item == nil && handedOff:
+			// the caller runs the query itself or serves the stale
+			// results
+		case item == nil:
+			land(nil)
+			done()
+		case q.attrs.maxRows > 0 && len(item.Rows) > q.attrs.maxRows:
+			land(nil)
+			i.skip(bctx, q, SkipMaxRows)
+			done()
+		default:
+			set(bctx, item)
+		}
+	}()
+
+	ahead := i.fresh(stale)
+	var (
+		item  *cache.Item
+		ready bool
+	)
+	if s.Deadline > 0 && !ahead {
+		timer, stop := i.clock.NewTimer(s.Deadline)
+		defer stop()
+		select {
+		case item = <-res:
+			ready = true
+		case <-ctx.Done():
+		case <-timer:
+		}
+	}
+	if !ready {
+		// stop waiting, unless the results were handed off meanwhile
+		mu.Lock()
+		waiting = false
+		mu.Unlock()
+		select {
+		case item = <-res:
+			ready = true
+		default:
+		}
+	}
+	switch {
+	case ready && item != nil:
+		return i.cachedRows(ctx, q, &cache.Item{Cols: item.Cols, Rows: item.Rows}, false), true, nil
+	case ready && !s.OnError && !ahead:
+		// the query failed; run it as usual for the caller to see why
+		return nil, false, nil
+	case ready:
+		// the query failed; serve the stale results instead, freeing the
+		// flight and locks left to the caller once they're landed
+		defer done()
+	case ctx.Err() != nil:
+		return nil, true, ctx.Err()
+	}
+
+	land(stale)
+
+	return i.staleRows(ctx, q, stale), true, nil
+}
+
+// staleRows returns the rows of the stale results of the query, counting
+// them as a stale hit unless they're being refreshed ahead of their TTL.
+func (i *Interceptor) staleRows(ctx context.Context, q *queryInfo, stale *cache.Item) driver.Rows {
+	if !i.fresh(stale) {
+		atomic.AddUint64(&i.stats.StaleHits, 1)
+		recordHitInfo(ctx, func(h *HitInfo) {
+			h.Stale = true
+			h.Age = i.clock.Now().Sub(stale.CreatedAt)
+		})
+	}
+
+	return i.cachedRows(ctx, q, stale, i.itemsShared())
+}
+
+// readStale reads the rows of the stale results read by rr, returning nil
+// if they can't be decoded, which is reported.
+func (i *Interceptor) readStale(ctx context.Context, q *queryInfo, rr cache.RowsReader) *cache.Item {
+	hdr := rr.Header()
+	item := &cache.Item{
+		Cols:        hdr.Cols,
+		CreatedAt:   hdr.CreatedAt,
+		Fingerprint: hdr.Fingerprint,
+		Digest:      hdr.Digest,
+		StaleAt:     hdr.StaleAt,
+	}
+	for {
+		row := make([]driver.Value, len(hdr.Cols))
+		err := rr.Next(row)
+		if err == io.EOF {
+			return item
+		}
+		if err != nil {
+			i.reportErr(ctx, q, &Error{Kind: ErrDecode, Op: "RowsReader.Next", Key: q.key, Err: err})
+			return nil
+		}
+		item.Rows = append(item.Rows, row)
+	}
+}
+
+// refresh runs the query on StaleBudget.DB and returns its results, or
+// nil if it failed.
+func (i *Interceptor) refresh(ctx context.Context, q *queryInfo, args []interface{}) *cache.Item {
+	ctx, cancel := context.WithTimeout(ctx, i.stale.Timeout)
+	defer cancel()
+
+	cols, rows, err := queryAll(ctx, i.stale.DB, q.query, args)
+	if err != nil {
+		i.log(ctx, LevelWarn, "sqlcache: stale budget query failed",
+			"fingerprint", q.fingerprint, "key", q.key, "error", err)
+		return nil
+	}
+
+	item := &cache.Item{Cols: cols, Rows: make([][]driver.Value, len(rows))}
+	for n, row := range rows {
+		item.Rows[n] = make([]driver.Value, len(row))
+		for c, v := range row {
+			item.Rows[n][c] = v
+		}
+	}
+
+	return item
+}
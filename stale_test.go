@@ -0,0 +1,271 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleBudget(t *testing.T) {
+	assert := require.New(t)
+
+	mockDB, qMock, err := sqlmock.NewWithDSN(fmt.Sprintf("fakeDSN:%s", t.Name()))
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	for _, s := range []*StaleBudget{{Deadline: time.Second, Window: time.Minute}, {DB: mockDB, Deadline: -1, Window: time.Minute}, {DB: mockDB, Deadline: time.Second}, {DB: mockDB, Window: time.Minute, RefreshAhead: -1}} {
+		_, err := NewInterceptor(&Config{Cache: &mapCacher{entries: make(map[string]cache.Entry)}, StaleBudget: s})
+		assert.NotNil(err)
+	}
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	c := &flakyCacher{mapCacher: &mapCacher{entries: make(map[string]cache.Entry)}}
+	ic, err := NewInterceptor(&Config{
+		Cache:       c,
+		Clock:       clock,
+		StaleBudget: &StaleBudget{DB: mockDB, Deadline: 100 * time.Millisecond, Window: time.Minute},
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users WHERE age > ?`
+	args := []driver.NamedValue{{Ordinal: 1, Value: int64(18)}}
+	key, err := ic.Key(query, int64(18))
+	assert.Nil(err)
+	run := func(ctx context.Context, queryFn func() (driver.Rows, error)) []driver.Value {
+		rows, err := ic.intercept(ctx, ic.prepare(query), args, false, nil, queryFn)
+		assert.Nil(err)
+		var got []driver.Value
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+			got = append(got, dest[0])
+		}
+		assert.Nil(rows.Close())
+		return got
+	}
+	notRun := func() (driver.Rows, error) {
+		return nil, errors.New("query run on the caller's conn")
+	}
+	cached := func() *cache.Item {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.entries[key].Item
+	}
+
+	// results are cached past their TTL, which is their soft TTL
+	run(context.Background(), func() (driver.Rows, error) {
+		return &seqRows{n: 1, cols: 1}, nil
+	})
+	c.mu.Lock()
+	assert.Equal(30*time.Second+time.Minute, c.entries[key].TTL)
+	c.mu.Unlock()
+	assert.Equal(clock.Now().Add(30*time.Second), cached().StaleAt)
+
+	// queries completing within the deadline are served as usual
+	clock.Advance(31 * time.Second)
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	assert.Equal([]driver.Value{"John"}, run(context.Background(), notRun))
+	assert.Nil(ic.Shutdown(context.Background()))
+	assert.Equal([]driver.Value{"John"}, cached().Rows[0])
+	assert.Equal(uint64(0), ic.Stats().StaleHits)
+	assert.Equal(uint64(2), ic.Stats().Misses)
+
+	// stale results are served to slower ones, which refresh the cache in
+	// the background
+	clock.Advance(time.Minute)
+	proceed := make(chan struct{})
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillDelayFor(100 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Lisa"))
+	go func() {
+		for clock.Timers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(100 * time.Millisecond)
+		close(proceed)
+	}()
+	ctx, hitInfo := WithHitInfo(context.Background())
+	assert.Equal([]driver.Value{"John"}, run(ctx, notRun))
+	<-proceed
+	assert.True(hitInfo().Stale)
+	assert.Equal(time.Minute+100*time.Millisecond, hitInfo().Age)
+	assert.Equal(uint64(1), ic.Stats().StaleHits)
+	assert.Nil(ic.Shutdown(context.Background()))
+	assert.Equal([]driver.Value{"Lisa"}, cached().Rows[0])
+
+	// queries failing within the deadline are run on the caller's conn,
+	// still coalescing concurrent misses
+	clock.Advance(31 * time.Second)
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnError(errors.New("failed"))
+	assert.Equal([]driver.Value{int64(0)}, run(context.Background(), func() (driver.Rows, error) {
+		ic.flights.mu.Lock()
+		_, inFlight := ic.flights.flights[key]
+		ic.flights.mu.Unlock()
+		assert.True(inFlight)
+		return &seqRows{n: 1, cols: 1}, nil
+	}))
+	assert.Nil(ic.Shutdown(context.Background()))
+	assert.Equal([]driver.Value{int64(0)}, cached().Rows[0])
+	assert.Equal(uint64(1), ic.Stats().StaleHits)
+	assert.Nil(qMock.ExpectationsWereMet())
+}
+
+func TestStaleOnErrorAndRefreshAhead(t *testing.T) {
+	assert := require.New(t)
+
+	mockDB, qMock, err := sqlmock.NewWithDSN(fmt.Sprintf("fakeDSN:%s", t.Name()))
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	c := &flakyCacher{mapCacher: &mapCacher{entries: make(map[string]cache.Entry)}}
+	ic, err := NewInterceptor(&Config{
+		Cache: c,
+		Clock: clock,
+		StaleBudget: &StaleBudget{
+			DB:           mockDB,
+			Window:       time.Minute,
+			OnError:      true,
+			RefreshAhead: 10 * time.Second,
+		},
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users WHERE age > ?`
+	args := []driver.NamedValue{{Ordinal: 1, Value: int64(18)}}
+	key, err := ic.Key(query, int64(18))
+	assert.Nil(err)
+	run := func(ctx context.Context, queryFn func() (driver.Rows, error)) []driver.Value {
+		rows, err := ic.intercept(ctx, ic.prepare(query), args, false, nil, queryFn)
+		assert.Nil(err)
+		var got []driver.Value
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+			got = append(got, dest[0])
+		}
+		assert.Nil(rows.Close())
+		return got
+	}
+	notRun := func() (driver.Rows, error) {
+		return nil, errors.New("query run on the caller's conn")
+	}
+	cached := func() *cache.Item {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.entries[key].Item
+	}
+	inFlight := func() bool {
+		ic.flights.mu.Lock()
+		defer ic.flights.mu.Unlock()
+		_, ok := ic.flights.flights[key]
+		return ok
+	}
+
+	run(context.Background(), func() (driver.Rows, error) {
+		return &seqRows{n: 1, cols: 1}, nil
+	})
+
+	// hits about to go stale are served while the query refreshes them
+	clock.Advance(25 * time.Second)
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	ctx, hitInfo := WithHitInfo(context.Background())
+	assert.Equal([]driver.Value{int64(0)}, run(ctx, notRun))
+	assert.True(hitInfo().Hit)
+	assert.False(hitInfo().Stale)
+	assert.Nil(ic.Shutdown(context.Background()))
+	assert.Equal([]driver.Value{"John"}, cached().Rows[0])
+	assert.Equal(uint64(1), ic.Stats().Hits)
+	assert.Equal(uint64(0), ic.Stats().StaleHits)
+
+	// stale results are served when the query fails
+	clock.Advance(31 * time.Second)
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillReturnError(errors.New("failed"))
+	assert.Equal([]driver.Value{"John"}, run(context.Background(), notRun))
+	assert.Nil(ic.Shutdown(context.Background()))
+	assert.False(inFlight())
+	assert.Equal(uint64(1), ic.Stats().StaleHits)
+
+	// and at once without a deadline, while the query refreshes them
+	qMock.ExpectQuery("SELECT name").WithArgs(18).WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Lisa"))
+	assert.Equal([]driver.Value{"John"}, run(context.Background(), notRun))
+	assert.Nil(ic.Shutdown(context.Background()))
+	assert.False(inFlight())
+	assert.Equal([]driver.Value{"Lisa"}, cached().Rows[0])
+	assert.Equal(uint64(2), ic.Stats().StaleHits)
+	assert.Nil(qMock.ExpectationsWereMet())
+}
+
+func TestMissLimitStale(t *testing.T) {
+	assert := require.New(t)
+
+	mockDB, qMock, err := sqlmock.NewWithDSN(fmt.Sprintf("fakeDSN:%s", t.Name()))
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	ic, err := NewInterceptor(&Config{
+		Cache:               &mapCacher{entries: make(map[string]cache.Entry)},
+		Clock:               clock,
+		StaleBudget:         &StaleBudget{DB: mockDB, Window: time.Minute},
+		MaxConcurrentMisses: 1,
+		MissLimit:           MissLimitStale,
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users WHERE age > ?`
+	run := func(ctx context.Context, age int64) ([]driver.Value, error) {
+		args := []driver.NamedValue{{Ordinal: 1, Value: age}}
+		rows, err := ic.intercept(ctx, ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		var got []driver.Value
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+			got = append(got, dest[0])
+		}
+		return got, rows.Close()
+	}
+
+	_, err = run(context.Background(), 18)
+	assert.Nil(err)
+	clock.Advance(31 * time.Second)
+
+	// callers beyond the limit are served the stale results without
+	// running the query
+	release, err := ic.missLimiter.acquire(context.Background(), ic.prepare(query).fingerprint, 1, false)
+	assert.Nil(err)
+	ctx, hitInfo := WithHitInfo(context.Background())
+	got, err := run(ctx, 18)
+	assert.Nil(err)
+	assert.Equal([]driver.Value{int64(0)}, got)
+	assert.True(hitInfo().Stale)
+	assert.Equal(uint64(1), ic.Stats().StaleHits)
+	assert.Equal(uint64(0), ic.Stats().Shed)
+
+	// and wait for a slot when there are none
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = run(ctx, 21)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	release()
+
+	assert.Nil(ic.Shutdown(context.Background()))
+	assert.Nil(qMock.ExpectationsWereMet())
+}
@@ -0,0 +1,89 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// Interceptor methods are safe for concurrent use. The runtime state that
+// may be changed after creation (whether the interceptor is enabled, the
+// cache backend, the OnError, OnSkip, OnSet and Enabler hooks and the
+// Options) is held in atomics and changes take effect for queries started
+// after them. A query in flight during a change may observe either value at
+// each step; for example, a miss looked up in the previous backend may be
+// written to the new one. All other configuration is fixed at creation.
+
+// cacheBox boxes the backend so that values of different concrete types
+// can be stored in an atomic.Value.
+type cacheBox struct {
+	cache.Cacher
+}
+
+// hooks holds the callbacks which can be swapped at runtime.
+type hooks struct {
+	onErr   func(error)
+	onSkip  func(key string, reason SkipReason)
+	onSet   func(query string, args []driver.NamedValue, key string, item *cache.Item)
+	enabler func(ctx context.Context, query string) bool
+}
+
+func (i *Interceptor) cacher() cache.Cacher {
+	return i.cache.Load().(cacheBox).Cacher
+}
+
+func (i *Interceptor) hooks() *hooks {
+	return i.hookFns.Load().(*hooks)
+}
+
+// SetCache replaces the cache backend. Config.Codec, if defaulted from the
+// previous backend, isn't changed.
+func (i *Interceptor) SetCache(c cache.Cacher) error {
+	if c == nil {
+		return fmt.Errorf("cache can't be nil")
+	}
+	i.cache.Store(cacheBox{c})
+
+	return nil
+}
+
+// SetOnError replaces the Config.OnError hook. A nil fn removes it.
+func (i *Interceptor) SetOnError(fn func(error)) {
+	i.updateHooks(func(h *hooks) {
+		h.onErr = fn
+	})
+}
+
+// SetOnSkip replaces the Config.OnSkip hook. A nil fn removes it.
+func (i *Interceptor) SetOnSkip(fn func(key string, reason SkipReason)) {
+	i.updateHooks(func(h *hooks) {
+		h.onSkip = fn
+	})
+}
+
+// SetOnSet replaces the Config.OnSet hook. A nil fn removes it.
+func (i *Interceptor) SetOnSet(fn func(query string, args []driver.NamedValue, key string, item *cache.Item)) {
+	i.updateHooks(func(h *hooks) {
+		h.onSet = fn
+	})
+}
+
+// SetEnabler replaces the Config.Enabler hook. A nil fn removes it.
+func (i *Interceptor) SetEnabler(fn func(ctx context.Context, query string) bool) {
+	i.updateHooks(func(h *hooks) {
+		h.enabler = fn
+	})
+}
+
+// updateHooks replaces the hooks with a copy changed by fn. Changes are
+// serialized so that concurrent ones aren't lost.
+func (i *Interceptor) updateHooks(fn func(h *hooks)) {
+	i.hooksMu.Lock()
+	defer i.hooksMu.Unlock()
+
+	h := *i.hooks()
+	fn(&h)
+	i.hookFns.Store(&h)
+}
@@ -0,0 +1,153 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRuntimeStateRace changes the runtime state while queries run and is
+// meant to be run with the race detector.
+func TestRuntimeStateRace(t *testing.T) {
+	assert := require.New(t)
+
+	newCacher := func() *mocks.Cacher {
+		c := new(mocks.Cacher)
+		c.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+		c.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		return c
+	}
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: newCacher(),
+	})
+	assert.NotNil(ic.SetCache(nil))
+
+	p := ic.prepare(`-- @cache-max-rows 10
+                     -- @cache-ttl 30
+                     SELECT name FROM users WHERE age > ?`)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for m := 0; m < 100; m++ {
+				args := []driver.NamedValue{{Ordinal: 1, Value: int64(n*100 + m)}}
+				rows, err := ic.intercept(context.Background(), p, args, false, nil, func() (driver.Rows, error) {
+					return &seqRows{n: 2, cols: 1}, nil
+				})
+				if err != nil {
+					continue
+				}
+				dest := make([]driver.Value, 1)
+				for rows.Next(dest) == nil {
+				}
+				rows.Close()
+			}
+		}(n)
+	}
+
+	for m := 0; m < 100; m++ {
+		if m%2 == 0 {
+			ic.Disable()
+		} else {
+			ic.Enable()
+		}
+		assert.Nil(ic.SetCache(newCacher()))
+		ic.SetOnError(func(error) {})
+		ic.SetOnSkip(func(string, SkipReason) {})
+	}
+	wg.Wait()
+
+	ic.SetOnSkip(nil)
+	assert.Nil(ic.hooks().onSkip)
+	assert.NotNil(ic.hooks().onErr)
+}
+
+func TestConcurrentHookChanges(t *testing.T) {
+	assert := require.New(t)
+
+	for n := 0; n < 100; n++ {
+		ic, err := NewInterceptor(&Config{
+			Cache: &mapCacher{entries: make(map[string]cache.Entry)},
+		})
+		assert.Nil(err)
+
+		var wg sync.WaitGroup
+		wg.Add(4)
+		go func() { defer wg.Done(); ic.SetOnError(func(error) {}) }()
+		go func() { defer wg.Done(); ic.SetOnSkip(func(string, SkipReason) {}) }()
+		go func() { defer wg.Done(); ic.SetOnSet(func(string, []driver.NamedValue, string, *cache.Item) {}) }()
+		go func() { defer wg.Done(); ic.SetEnabler(func(context.Context, string) bool { return true }) }()
+		wg.Wait()
+
+		h := ic.hooks()
+		assert.NotNil(h.onErr)
+		assert.NotNil(h.onSkip)
+		assert.NotNil(h.onSet)
+		assert.NotNil(h.enabler)
+	}
+}
+
+type tenantKey struct{}
+
+func TestEnabler(t *testing.T) {
+	assert := require.New(t)
+
+	var queries []string
+	ic, err := NewInterceptor(&Config{
+		Cache: &mapCacher{entries: make(map[string]cache.Entry)},
+		Enabler: func(ctx context.Context, query string) bool {
+			queries = append(queries, query)
+			return ctx.Value(tenantKey{}) == "beta"
+		},
+	})
+	assert.Nil(err)
+
+	query := `-- @cache-ttl 30
+              -- @cache-max-rows 10
+              SELECT name FROM users WHERE id = ?`
+	run := func(ctx context.Context) {
+		args := []driver.NamedValue{{Ordinal: 1, Value: int64(1)}}
+		rows, err := ic.intercept(ctx, ic.prepare(query), args, false, nil, func() (driver.Rows, error) {
+			return &seqRows{n: 1, cols: 1}, nil
+		})
+		assert.Nil(err)
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		assert.Nil(rows.Close())
+	}
+	beta := context.WithValue(context.Background(), tenantKey{}, "beta")
+
+	run(context.Background())
+	run(beta)
+	run(beta)
+	run(context.Background())
+	s := ic.Stats()
+	assert.Equal(uint64(2), s.SkipReasons[SkipNotEnabled])
+	assert.Equal(uint64(1), s.Hits)
+	assert.Equal([]string{query, query, query, query}, queries)
+
+	// a panicking enabler turns caching off
+	ic.SetEnabler(func(ctx context.Context, query string) bool {
+		panic("flags")
+	})
+	run(beta)
+	s = ic.Stats()
+	assert.Equal(uint64(3), s.SkipReasons[SkipNotEnabled])
+	assert.Equal(uint64(1), s.Panics)
+	assert.Equal(uint64(1), s.Hits)
+
+	ic.SetEnabler(nil)
+	run(context.Background())
+	assert.Equal(uint64(2), ic.Stats().Hits)
+}
@@ -0,0 +1,56 @@
+package sqlcache
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	leadingKeywordRegexp = regexp.MustCompile(`^[\s(]*([A-Za-z]+)`)
+	// dmlRegexp matches data-modifying statements nested in a WITH query.
+	dmlRegexp = regexp.MustCompile(`(?i)\b(?:insert|update|delete|merge)\b`)
+	// intoRegexp matches the INTO clause of SELECT ... INTO, which writes
+	// the results to a table, file or variables.
+	intoRegexp = regexp.MustCompile(`(?i)\binto\b`)
+)
+
+// readKeywords are the keywords read-only statements start with.
+var readKeywords = map[string]bool{
+	"SELECT":   true,
+	"WITH":     true,
+	"VALUES":   true,
+	"TABLE":    true,
+	"SHOW":     true,
+	"DESCRIBE": true,
+	"DESC":     true,
+}
+
+// isRead reports whether the query, ignoring comments, is a read-only
+// statement such as SELECT, as opposed to INSERT, UPDATE, DELETE, DDL or
+// calls of stored procedures, such as CALL or EXEC. WITH queries containing
+// data-modifying statements, SELECT ... INTO and locking reads, such as
+// SELECT ... FOR UPDATE, aren't reads. Words within strings and quoted
+// identifiers are ignored.
+func isRead(query string) bool {
+	query = stripLiterals(query)
+	return isReadStatement(query) && !lockingReadRegexp.MatchString(query)
+}
+
+// isReadStatement reports whether the query, stripped by stripLiterals, is
+// a read-only statement as by isRead, locking or not.
+func isReadStatement(query string) bool {
+	m := leadingKeywordRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return false
+	}
+
+	keyword := strings.ToUpper(m[1])
+	switch {
+	case keyword == "WITH" && dmlRegexp.MatchString(query):
+		return false
+	case (keyword == "SELECT" || keyword == "WITH") && intoRegexp.MatchString(query):
+		return false
+	}
+
+	return readKeywords[keyword]
+}
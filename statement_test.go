@@ -0,0 +1,73 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRead(t *testing.T) {
+	assert := require.New(t)
+
+	tests := map[string]bool{
+		"SELECT name FROM users":                                       true,
+		"  select name FROM users":                                     true,
+		"(SELECT 1) UNION (SELECT 2)":                                  true,
+		"-- @cache-ttl 30\n/* comment */ SELECT 1":                     true,
+		"WITH t AS (SELECT 1) SELECT * FROM t":                         true,
+		"VALUES (1), (2)":                                              true,
+		"INSERT INTO users (name) VALUES ($1) RETURNING id":            false,
+		"update users SET name = $1 RETURNING id":                      false,
+		"DELETE FROM users RETURNING id":                               false,
+		"WITH d AS (DELETE FROM users RETURNING id) SELECT * FROM d":   false,
+		"CREATE TABLE t (id int)":                                      false,
+		"-- SELECT\nINSERT INTO users (name) VALUES ($1) RETURNING id": false,
+		"SELECT * INTO t2 FROM t":                                      false,
+		"SELECT id INTO @id FROM t LIMIT 1":                            false,
+		"SELECT id FROM t WHERE id = 1 FOR UPDATE":                     false,
+		"select id from t for share":                                   false,
+		"SELECT 'update', \"into\" FROM t":                             true,
+		"WITH t AS (SELECT 'delete') SELECT * FROM t":                  true,
+		"CALL p()": false,
+		"EXEC p":   false,
+		"":         false,
+	}
+	for query, want := range tests {
+		assert.Equal(want, isRead(query), query)
+	}
+}
+
+func TestCacheWrites(t *testing.T) {
+	assert := require.New(t)
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              INSERT INTO users (name) VALUES (?) RETURNING id`
+	args := []driver.NamedValue{{Ordinal: 1, Value: "John"}}
+	queryFn := func() (driver.Rows, error) {
+		return &seqRows{n: 1, cols: 1}, nil
+	}
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+	_, err := ic.intercept(context.Background(), ic.prepare(query), args, false, nil, queryFn)
+	assert.Nil(err)
+	assert.Equal(uint64(1), ic.Stats().SkipReasons[SkipNotRead])
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil).Once()
+	ic, _ = NewInterceptor(&Config{
+		Cache:       mCacher,
+		CacheWrites: true,
+	})
+	_, err = ic.intercept(context.Background(), ic.prepare(query), args, false, nil, queryFn)
+	assert.Nil(err)
+	assert.True(mCacher.AssertExpectations(t))
+	assert.Equal(uint64(0), ic.Stats().SkipReasons[SkipNotRead])
+}
@@ -0,0 +1,272 @@
+package sqlcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+)
+
+// Stats contains sqlcache statistics.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	Errors uint64
+	// L1Hits counts the hits, included in Hits, served from the
+	// in-process cache enabled by Config.L1Size.
+	L1Hits uint64
+	// Retries counts retries of failed cache backend operations as per
+	// Config.Retry.
+	Retries uint64
+	// Sets counts query results successfully written to the cache.
+	Sets uint64
+	// Coalesced counts cache misses served with the results of a
+	// concurrent identical query instead of running the query again.
+	Coalesced uint64
+	// Shed counts queries failed with ErrTooManyMisses.
+	Shed uint64
+	// Panics counts panics recovered from user callbacks; see ErrPanic.
+	Panics uint64
+	// ShadowChecks counts cache hits verified against the database as per
+	// Config.Shadow, and ShadowMismatches those whose results differed.
+	ShadowChecks     uint64
+	ShadowMismatches uint64
+	// MemoHits counts queries served from a request memo; see
+	// WithRequestMemo. They aren't counted as Hits.
+	MemoHits uint64
+	// StaleHits counts misses served with results past their TTL; see
+	// Config.StaleBudget. They aren't counted as Hits.
+	StaleHits uint64
+	// DDLInvalidations counts DDL statements that invalidated cached
+	// results; see Config.InvalidateOnDDL.
+	DDLInvalidations uint64
+	// DriverMisses counts the Misses of queries run through drivers with a
+	// DriverPolicy, by name. It's nil unless there are such drivers.
+	DriverMisses map[string]uint64
+	// Skips counts queries whose results weren't cached.
+	Skips uint64
+	// SkipReasons breaks down Skips by the reason results weren't cached.
+	SkipReasons map[SkipReason]uint64
+	// DryRun contains stats of dry-run mode. It's nil unless Config.DryRun
+	// is set.
+	DryRun *DryRunStats
+	// Health contains stats of health checking. It's nil unless
+	// Config.HealthCheck is set.
+	Health *HealthStats
+}
+
+// Stats returns sqlcache stats. They're read from in-process counters
+// only; see BackendStats for the stats of the cache backend.
+func (i *Interceptor) Stats() *Stats {
+	return i.loadStats(atomic.LoadUint64)
+}
+
+const (
+	// backendStatsTimeout bounds fetching backend stats when the context
+	// has no deadline.
+	backendStatsTimeout = 5 * time.Second
+	// backendStatsMaxAge is how long exporters reuse fetched backend
+	// stats, as fetching them may scan the whole backend.
+	backendStatsMaxAge = 30 * time.Second
+)
+
+// BackendStats returns the stats reported by the cache backend, or nil
+// when it doesn't implement cache.StatsReporter. Fetching them may be
+// slow, such as Redis scanning its keys, so it's bounded by 5 seconds
+// unless ctx has an earlier deadline.
+func (i *Interceptor) BackendStats(ctx context.Context) (*cache.BackendStats, error) {
+	sr, ok := cache.As[cache.StatsReporter](i.cacher())
+	if !ok {
+		return nil, nil
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, backendStatsTimeout)
+		defer cancel()
+	}
+	bs, err := sr.BackendStats(ctx)
+	if err != nil {
+		return nil, &Error{Kind: ErrBackendStats, Op: "Cache.BackendStats", Err: err}
+	}
+
+	return bs, nil
+}
+
+// backendStatsCache holds the backend stats last fetched for exporters.
+type backendStatsCache struct {
+	mu      sync.Mutex
+	fetched time.Time
+	stats   *cache.BackendStats
+}
+
+// cachedBackendStats returns the backend stats for exporters, fetching
+// them at most once every backendStatsMaxAge. Failures to fetch them are
+// reported to Config.OnError and Logger, and the stats fetched last are
+// returned instead.
+func (i *Interceptor) cachedBackendStats(ctx context.Context) *cache.BackendStats {
+	c := &i.backendStats
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := i.clock.Now()
+	if !c.fetched.IsZero() && now.Sub(c.fetched) < backendStatsMaxAge {
+		return c.stats
+	}
+	c.fetched = now
+
+	bs, err := i.BackendStats(ctx)
+	if err != nil {
+		i.notifyErr(ctx, err)
+		i.log(ctx, LevelError, "sqlcache: fetching backend stats failed", "error", err)
+		return c.stats
+	}
+	c.stats = bs
+
+	return bs
+}
+
+// ResetStats resets all stats, including per query stats, to zero and
+// returns the values prior to the reset. Every counter is reset atomically
+// so no increments are lost, but the counters aren't reset all at once.
+// Note that resetting stats appears as a counter reset to monitoring
+// systems such as prometheus; prefer Stats.Delta for per-interval rates.
+func (i *Interceptor) ResetStats() *Stats {
+	s := i.loadStats(func(addr *uint64) uint64 {
+		return atomic.SwapUint64(addr, 0)
+	})
+	i.queryStats.reset()
+
+	return s
+}
+
+func (i *Interceptor) loadStats(load func(addr *uint64) uint64) *Stats {
+	s := &Stats{
+		Hits:             load(&i.stats.Hits),
+		Misses:           load(&i.stats.Misses),
+		Errors:           load(&i.stats.Errors),
+		L1Hits:           load(&i.stats.L1Hits),
+		Retries:          load(&i.stats.Retries),
+		Sets:             load(&i.stats.Sets),
+		Coalesced:        load(&i.stats.Coalesced),
+		Shed:             load(&i.stats.Shed),
+		Panics:           load(&i.stats.Panics),
+		ShadowChecks:     load(&i.stats.ShadowChecks),
+		ShadowMismatches: load(&i.stats.ShadowMismatches),
+		MemoHits:         load(&i.stats.MemoHits),
+		StaleHits:        load(&i.stats.StaleHits),
+		DDLInvalidations: load(&i.stats.DDLInvalidations),
+		SkipReasons:      make(map[SkipReason]uint64, len(skipReasons)),
+		DriverMisses:     i.driverMisses(load),
+	}
+
+	for n, reason := range skipReasons {
+		count := load(&i.skips[n])
+		s.SkipReasons[reason] = count
+		s.Skips += count
+	}
+	s.Health = i.health.stats()
+	if d := i.dryRun; d != nil {
+		s.DryRun = &DryRunStats{
+			Hits:  load(&d.hits),
+			Sets:  load(&d.sets),
+			Bytes: d.liveBytes(),
+		}
+	}
+
+	return s
+}
+
+// Snapshot returns a deep copy of the stats.
+func (s *Stats) Snapshot() *Stats {
+	cpy := *s
+	cpy.SkipReasons = make(map[SkipReason]uint64, len(s.SkipReasons))
+	for reason, count := range s.SkipReasons {
+		cpy.SkipReasons[reason] = count
+	}
+	if s.DriverMisses != nil {
+		cpy.DriverMisses = make(map[string]uint64, len(s.DriverMisses))
+		for name, count := range s.DriverMisses {
+			cpy.DriverMisses[name] = count
+		}
+	}
+	if s.DryRun != nil {
+		d := *s.DryRun
+		cpy.DryRun = &d
+	}
+	if s.Health != nil {
+		h := *s.Health
+		cpy.Health = &h
+	}
+
+	return &cpy
+}
+
+// Delta returns the change in stats since prev, which must be an earlier
+// snapshot from the same interceptor. Periodic reporters can use this to
+// emit per-interval rates instead of monotonically increasing totals. A nil
+// prev returns a copy of s. DryRun.Bytes and Health.Healthy aren't
+// subtracted as they're gauges; the current values are returned as is.
+func (s *Stats) Delta(prev *Stats) *Stats {
+	if prev == nil {
+		return s.Snapshot()
+	}
+
+	d := &Stats{
+		Hits:             sub(s.Hits, prev.Hits),
+		Misses:           sub(s.Misses, prev.Misses),
+		Errors:           sub(s.Errors, prev.Errors),
+		L1Hits:           sub(s.L1Hits, prev.L1Hits),
+		Retries:          sub(s.Retries, prev.Retries),
+		Sets:             sub(s.Sets, prev.Sets),
+		Coalesced:        sub(s.Coalesced, prev.Coalesced),
+		Shed:             sub(s.Shed, prev.Shed),
+		Panics:           sub(s.Panics, prev.Panics),
+		ShadowChecks:     sub(s.ShadowChecks, prev.ShadowChecks),
+		ShadowMismatches: sub(s.ShadowMismatches, prev.ShadowMismatches),
+		MemoHits:         sub(s.MemoHits, prev.MemoHits),
+		StaleHits:        sub(s.StaleHits, prev.StaleHits),
+		DDLInvalidations: sub(s.DDLInvalidations, prev.DDLInvalidations),
+		Skips:            sub(s.Skips, prev.Skips),
+		SkipReasons:      make(map[SkipReason]uint64, len(s.SkipReasons)),
+	}
+	for reason, count := range s.SkipReasons {
+		d.SkipReasons[reason] = sub(count, prev.SkipReasons[reason])
+	}
+	if s.DriverMisses != nil {
+		d.DriverMisses = make(map[string]uint64, len(s.DriverMisses))
+		for name, count := range s.DriverMisses {
+			d.DriverMisses[name] = sub(count, prev.DriverMisses[name])
+		}
+	}
+	if s.DryRun != nil {
+		dr := *s.DryRun
+		if prev.DryRun != nil {
+			dr.Hits = sub(dr.Hits, prev.DryRun.Hits)
+			dr.Sets = sub(dr.Sets, prev.DryRun.Sets)
+		}
+		d.DryRun = &dr
+	}
+	if s.Health != nil {
+		h := *s.Health
+		if prev.Health != nil {
+			h.Trips = sub(h.Trips, prev.Health.Trips)
+			h.FailedProbes = sub(h.FailedProbes, prev.Health.FailedProbes)
+		}
+		d.Health = &h
+	}
+
+	return d
+}
+
+// sub returns a-b, or a when the counter appears to have been reset since b
+// was read.
+func sub(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return a - b
+}
@@ -0,0 +1,104 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/cache"
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsDeltaAndReset(t *testing.T) {
+	assert := require.New(t)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache:             new(mocks.Cacher),
+		MaxTrackedQueries: 10,
+	})
+
+	atomic.AddUint64(&ic.stats.Hits, 3)
+	atomic.AddUint64(&ic.skips[skipReasonIndex[SkipMaxRows]], 2)
+	ic.queryStats.recordErr(&queryInfo{fingerprint: "f"})
+
+	prev := ic.Stats()
+	snap := prev.Snapshot()
+	snap.SkipReasons[SkipMaxRows] = 100
+	assert.Equal(uint64(2), prev.SkipReasons[SkipMaxRows]) // deep copy
+
+	atomic.AddUint64(&ic.stats.Hits, 2)
+	atomic.AddUint64(&ic.stats.Sets, 1)
+	atomic.AddUint64(&ic.skips[skipReasonIndex[SkipMaxRows]], 1)
+
+	cur := ic.Stats()
+	d := cur.Delta(prev)
+	assert.Equal(uint64(2), d.Hits)
+	assert.Equal(uint64(1), d.Sets)
+	assert.Equal(uint64(1), d.Skips)
+	assert.Equal(uint64(1), d.SkipReasons[SkipMaxRows])
+	assert.Equal(cur, cur.Delta(nil))
+
+	final := ic.ResetStats()
+	assert.Equal(cur, final)
+	assert.Len(ic.QueryStats(), 0)
+
+	after := ic.Stats()
+	assert.Equal(uint64(0), after.Hits)
+	assert.Equal(uint64(0), after.Skips)
+
+	// delta across a reset returns the current values
+	atomic.AddUint64(&ic.stats.Hits, 1)
+	assert.Equal(uint64(1), ic.Stats().Delta(cur).Hits)
+}
+
+func TestBackendStats(t *testing.T) {
+	assert := require.New(t)
+
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        100,
+		MaxCost:            10,
+		BufferItems:        64,
+		Metrics:            true,
+		IgnoreInternalCost: true,
+	})
+	assert.Nil(err)
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	ic, _ := NewInterceptor(&Config{
+		Cache: NewRistretto(rc),
+		Clock: clock,
+	})
+
+	item := &cache.Item{Rows: [][]driver.Value{{int64(1)}}}
+	assert.Nil(ic.cacher().Set(context.Background(), "a", item, time.Minute))
+	assert.Nil(ic.cacher().Set(context.Background(), "b", item, time.Minute))
+	rc.Wait()
+
+	s, err := ic.BackendStats(context.Background())
+	assert.Nil(err)
+	assert.NotNil(s)
+	assert.Equal(uint64(2), s.Entries)
+	assert.Equal(uint64(0), s.Evictions)
+
+	// exporters reuse fetched stats for a while
+	assert.Equal(uint64(2), ic.cachedBackendStats(context.Background()).Entries)
+	assert.Nil(ic.cacher().Set(context.Background(), "c", item, time.Minute))
+	rc.Wait()
+	assert.Equal(uint64(2), ic.cachedBackendStats(context.Background()).Entries)
+	clock.Advance(backendStatsMaxAge)
+	assert.Equal(uint64(3), ic.cachedBackendStats(context.Background()).Entries)
+
+	// backends not implementing cache.StatsReporter
+	ic, _ = NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+	s, err = ic.BackendStats(context.Background())
+	assert.Nil(err)
+	assert.Nil(s)
+	assert.Nil(ic.cachedBackendStats(context.Background()))
+}
@@ -0,0 +1,239 @@
+package sqlcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStatsdNamespace = "sqlcache."
+	defaultStatsdInterval  = 10 * time.Second
+	// maxStatsdPacketSize keeps packets within a typical ethernet MTU.
+	maxStatsdPacketSize = 1432
+)
+
+// StatsdExporter periodically flushes interceptor stats to a statsd server
+// over UDP. Counters are sent as the change since the previous flush. Tags
+// are sent using the DogStatsD format which is also understood by the
+// Datadog agent and Telegraf.
+type StatsdExporter struct {
+	i         *Interceptor
+	conn      net.Conn
+	namespace string
+	tags      []string
+	interval  time.Duration
+
+	mu   sync.Mutex
+	prev *Stats
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// StatsdOption configures optional behaviour of the statsd exporter.
+type StatsdOption func(e *StatsdExporter)
+
+// WithStatsdNamespace sets the prefix prepended to metric names. Defaults
+// to "sqlcache.".
+func WithStatsdNamespace(namespace string) StatsdOption {
+	return func(e *StatsdExporter) {
+		e.namespace = namespace
+	}
+}
+
+// WithStatsdTags sets tags, such as "env:prod", sent with every metric.
+func WithStatsdTags(tags ...string) StatsdOption {
+	return func(e *StatsdExporter) {
+		e.tags = tags
+	}
+}
+
+// WithStatsdInterval sets how often stats are flushed. Defaults to 10s.
+func WithStatsdInterval(d time.Duration) StatsdOption {
+	return func(e *StatsdExporter) {
+		e.interval = d
+	}
+}
+
+// NewStatsdExporter creates a statsd exporter which sends stats of the
+// interceptor to the statsd server listening at addr (host:port) until
+// Close is called.
+func NewStatsdExporter(i *Interceptor, addr string, opts ...StatsdOption) (*StatsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &StatsdExporter{
+		i:         i,
+		conn:      conn,
+		namespace: defaultStatsdNamespace,
+		interval:  defaultStatsdInterval,
+		prev:      i.Stats(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.interval <= 0 {
+		conn.Close()
+		return nil, fmt.Errorf("statsd flush interval must be positive")
+	}
+
+	go e.run()
+
+	return e, nil
+}
+
+func (e *StatsdExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.Flush(); err != nil {
+				e.i.notifyErr(context.Background(), &Error{Kind: ErrExport, Op: "statsd flush", Err: err})
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Flush sends the change in stats since the previous flush to the statsd
+// server. It's called periodically and needn't be called directly.
+func (e *StatsdExporter) Flush() error {
+	e.mu.Lock()
+	cur := e.i.Stats()
+	d := cur.Delta(e.prev)
+	e.prev = cur
+	e.mu.Unlock()
+
+	lines := []string{
+		e.metric("hits", d.Hits, "c", nil),
+		e.metric("l1_hits", d.L1Hits, "c", nil),
+		e.metric("misses", d.Misses, "c", nil),
+		e.metric("errors", d.Errors, "c", nil),
+		e.metric("retries", d.Retries, "c", nil),
+		e.metric("sets", d.Sets, "c", nil),
+		e.metric("coalesced", d.Coalesced, "c", nil),
+		e.metric("shed", d.Shed, "c", nil),
+		e.metric("panics", d.Panics, "c", nil),
+		e.metric("shadow_checks", d.ShadowChecks, "c", nil),
+		e.metric("shadow_mismatches", d.ShadowMismatches, "c", nil),
+		e.metric("memo_hits", d.MemoHits, "c", nil),
+		e.metric("stale_hits", d.StaleHits, "c", nil),
+		e.metric("ddl_invalidations", d.DDLInvalidations, "c", nil),
+	}
+
+	reasons := make([]string, 0, len(d.SkipReasons))
+	for reason := range d.SkipReasons {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		count := d.SkipReasons[SkipReason(reason)]
+		if count == 0 {
+			continue
+		}
+		lines = append(lines, e.metric("skips", count, "c", []string{"reason:" + reason}))
+	}
+	names := make([]string, 0, len(d.DriverMisses))
+	for name := range d.DriverMisses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if count := d.DriverMisses[name]; count > 0 {
+			lines = append(lines, e.metric("driver_misses", count, "c", []string{"driver:" + name}))
+		}
+	}
+
+	lines = append(lines, e.metric("estimated_time_saved_ms",
+		uint64(e.i.EstimatedTimeSaved().Milliseconds()), "g", nil))
+	if b := e.i.cachedBackendStats(context.Background()); b != nil {
+		lines = append(lines,
+			e.metric("backend.entries", b.Entries, "g", nil),
+			e.metric("backend.bytes", b.Bytes, "g", nil),
+			e.metric("backend.evictions", b.Evictions, "g", nil))
+	}
+	if dr := d.DryRun; dr != nil {
+		lines = append(lines,
+			e.metric("dry_run.hits", dr.Hits, "c", nil),
+			e.metric("dry_run.sets", dr.Sets, "c", nil),
+			e.metric("dry_run.bytes", dr.Bytes, "g", nil))
+	}
+	if h := d.Health; h != nil {
+		var healthy uint64
+		if h.Healthy {
+			healthy = 1
+		}
+		lines = append(lines,
+			e.metric("backend.healthy", healthy, "g", nil),
+			e.metric("backend.trips", h.Trips, "c", nil))
+	}
+
+	return e.send(lines)
+}
+
+func (e *StatsdExporter) metric(name string, value uint64, typ string, tags []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s:%d|%s", e.namespace, name, value, typ)
+	if len(e.tags)+len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(append(append([]string(nil), e.tags...), tags...), ","))
+	}
+
+	return b.String()
+}
+
+// send writes lines to the server, batching as many lines as fit in a
+// single packet.
+func (e *StatsdExporter) send(lines []string) error {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > maxStatsdPacketSize {
+			if _, err := e.conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+	_, err := e.conn.Write(buf.Bytes())
+
+	return err
+}
+
+// Close stops the exporter after flushing stats one last time.
+func (e *StatsdExporter) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+		err = e.Flush()
+		if cerr := e.conn.Close(); err == nil {
+			err = cerr
+		}
+	})
+
+	return err
+}
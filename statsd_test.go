@@ -0,0 +1,60 @@
+package sqlcache
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsdExporter(t *testing.T) {
+	assert := require.New(t)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer pc.Close()
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+	atomic.AddUint64(&ic.stats.Hits, 5) // before creation; not exported
+
+	e, err := NewStatsdExporter(ic, pc.LocalAddr().String(),
+		WithStatsdNamespace("app.cache."),
+		WithStatsdTags("env:test"),
+		WithStatsdInterval(time.Hour))
+	assert.Nil(err)
+
+	atomic.AddUint64(&ic.stats.Hits, 3)
+	atomic.AddUint64(&ic.skips[skipReasonIndex[SkipMaxRows]], 2)
+
+	read := func() []string {
+		buf := make([]byte, maxStatsdPacketSize)
+		assert.Nil(pc.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := pc.ReadFrom(buf)
+		assert.Nil(err)
+		return strings.Split(string(buf[:n]), "\n")
+	}
+
+	assert.Nil(e.Flush())
+	lines := read()
+	assert.Contains(lines, "app.cache.hits:3|c|#env:test")
+	assert.Contains(lines, "app.cache.misses:0|c|#env:test")
+	assert.Contains(lines, "app.cache.skips:2|c|#env:test,reason:max-rows-exceeded")
+	assert.Contains(lines, "app.cache.estimated_time_saved_ms:0|g|#env:test")
+
+	// counters are reported as the change since the previous flush
+	atomic.AddUint64(&ic.stats.Hits, 1)
+	assert.Nil(e.Close())
+	lines = read()
+	assert.Contains(lines, "app.cache.hits:1|c|#env:test")
+	assert.Nil(e.Close())
+
+	_, err = NewStatsdExporter(ic, pc.LocalAddr().String(), WithStatsdInterval(0))
+	assert.NotNil(err)
+}
@@ -0,0 +1,128 @@
+package sqlcache
+
+import (
+	"database/sql/driver"
+	"sync"
+)
+
+// tableChanges tracks the tables changed by each conn, as reported by
+// Interceptor.TablesChanged, until the changes are committed or rolled
+// back.
+type tableChanges struct {
+	mu      sync.Mutex
+	pending map[interface{}]map[string]struct{} // conn -> tables
+}
+
+// add records the tables as changed by conn, returning those that weren't
+// already.
+func (t *tableChanges) add(conn interface{}, tables []string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending == nil {
+		t.pending = make(map[interface{}]map[string]struct{})
+	}
+	changed, ok := t.pending[conn]
+	if !ok {
+		changed = make(map[string]struct{}, len(tables))
+		t.pending[conn] = changed
+	}
+	var added []string
+	for _, table := range tables {
+		if _, ok := changed[table]; !ok {
+			changed[table] = struct{}{}
+			added = append(added, table)
+		}
+	}
+
+	return added
+}
+
+// take returns the tables changed by conn and forgets them.
+func (t *tableChanges) take(conn interface{}) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changed, ok := t.pending[conn]
+	if !ok {
+		return nil
+	}
+	delete(t.pending, conn)
+	tables := make([]string, 0, len(changed))
+	for table := range changed {
+		tables = append(tables, table)
+	}
+
+	return tables
+}
+
+// TablesChanged stops serving the cached results of queries reading the
+// tables, which conn, a connection of the driver wrapped by the
+// interceptor, has changed, when Config.TrackTableChanges is set. It's
+// meant to be called from hooks of drivers reporting changes, such as
+// SQLite's update hook; see SQLiteUpdateHook.
+//
+// Results are invalidated at once, and again once the changes are
+// committed, as by the end of a statement run outside of a transaction
+// or of the transaction, so that results of queries run in the meantime,
+// which don't observe the changes yet, aren't served afterwards. Changes
+// reported with a nil conn are only invalidated at once.
+func (i *Interceptor) TablesChanged(conn driver.Conn, tables ...string) {
+	if i.changes == nil {
+		return
+	}
+
+	names := make([]string, len(tables))
+	for n, table := range tables {
+		names[n] = unqualifiedTableName(normalizeTableName(table))
+	}
+	if conn != nil && isComparable(conn) {
+		names = i.changes.add(conn, names)
+	}
+	if len(names) > 0 {
+		i.ddl.bump(names)
+	}
+}
+
+// SQLiteUpdateHook returns a function to be registered as the update hook
+// of conn, a connection of a SQLite driver such as mattn/go-sqlite3, which
+// calls it for every row changed, so that queries reading the changed
+// tables are invalidated as by TablesChanged:
+//
+//	sql.Register("sqlite3-cached", ic.Driver(&sqlite3.SQLiteDriver{
+//		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+//			conn.RegisterUpdateHook(ic.SQLiteUpdateHook(conn))
+//			return nil
+//		},
+//	}))
+//
+// Drivers whose hooks take other arguments, such as modernc.org/sqlite,
+// can call TablesChanged from them instead.
+func (i *Interceptor) SQLiteUpdateHook(conn driver.Conn) func(op int, db, table string, rowid int64) {
+	return func(_ int, _, table string, _ int64) {
+		i.TablesChanged(conn, table)
+	}
+}
+
+// commitChanges invalidates the results of queries reading the tables
+// changed by the conn of key, as by unwrapParent, once its changes are
+// committed.
+func (i *Interceptor) commitChanges(key interface{}) {
+	if i.changes == nil || !isComparable(key) {
+		return
+	}
+
+	if tables := i.changes.take(key); len(tables) > 0 {
+		i.ddl.bump(tables)
+	}
+}
+
+// discardChanges forgets the tables changed by the conn of key, whose
+// changes were rolled back.
+func (i *Interceptor) discardChanges(key interface{}) {
+	if i.changes == nil || !isComparable(key) {
+		return
+	}
+
+	i.changes.take(key)
+}
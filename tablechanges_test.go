@@ -0,0 +1,167 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTablesChanged(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	ic, err := NewInterceptor(&Config{
+		Cache:             &mapCacher{entries: make(map[string]cache.Entry)},
+		TrackTableChanges: true,
+	})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	// sqlmock hands out the same conn for every connection to dsn, as
+	// passed to the update hook of SQLite drivers
+	hook := ic.SQLiteUpdateHook(qMock.(driver.Conn))
+	const sqliteUpdate = 23
+
+	users := `-- @cache-ttl 30
+	          -- @cache-max-rows 10
+	          SELECT name FROM users WHERE id = ?`
+	accounts := `-- @cache-ttl 30
+	             -- @cache-max-rows 10
+	             SELECT name FROM accounts WHERE id = ?`
+	lookup := func(query string, miss bool) {
+		if miss {
+			qMock.ExpectQuery("SELECT name").WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+		}
+		rows, err := db.QueryContext(context.Background(), query, 1)
+		assert.Nil(err)
+		for rows.Next() {
+		}
+		assert.Nil(rows.Close())
+		assert.Nil(qMock.ExpectationsWereMet())
+	}
+	generation := func() uint64 {
+		return ic.ddl.generation([]string{"users"})
+	}
+
+	lookup(users, true)
+	lookup(users, false)
+	lookup(accounts, true)
+	lookup(accounts, false)
+
+	// statements outside of transactions commit their changes once they
+	// return; the hook is called for each row changed as they run
+	hook(sqliteUpdate, "main", "users", 1)
+	hook(sqliteUpdate, "main", "Users", 2)
+	assert.Equal(uint64(1), generation())
+	qMock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 2))
+	_, err = db.ExecContext(context.Background(), "UPDATE users SET name = 'x'")
+	assert.Nil(err)
+	assert.Equal(uint64(2), generation())
+	lookup(users, true)
+	lookup(users, false)
+	lookup(accounts, false)
+
+	// and transactions once committed, as queries run before may have
+	// been cached under the new generation already
+	qMock.ExpectBegin()
+	tx, err := db.Begin()
+	assert.Nil(err)
+	hook(sqliteUpdate, "main", "users", 1)
+	assert.Equal(uint64(3), generation())
+	qMock.ExpectCommit()
+	assert.Nil(tx.Commit())
+	assert.Equal(uint64(4), generation())
+	lookup(users, true)
+	lookup(users, false)
+
+	// rolled back changes are forgotten
+	qMock.ExpectBegin()
+	tx, err = db.Begin()
+	assert.Nil(err)
+	hook(sqliteUpdate, "main", "users", 1)
+	qMock.ExpectRollback()
+	assert.Nil(tx.Rollback())
+	assert.Equal(uint64(5), generation())
+	qMock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err = db.ExecContext(context.Background(), "UPDATE accounts SET name = 'x'")
+	assert.Nil(err)
+	assert.Equal(uint64(5), generation())
+	lookup(users, true)
+	lookup(accounts, false)
+
+	// as are prepared statements
+	qMock.ExpectPrepare("DELETE FROM users").ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	stmt, err := db.Prepare("DELETE FROM users WHERE id = ?")
+	assert.Nil(err)
+	defer stmt.Close()
+	hook(sqliteUpdate, "main", "users", 1)
+	_, err = stmt.Exec(1)
+	assert.Nil(err)
+	assert.Equal(uint64(7), generation())
+
+	// changes of unknown conns are invalidated at once
+	ic.TablesChanged(nil, "users")
+	assert.Equal(uint64(8), generation())
+	lookup(users, true)
+}
+
+func TestTablesChangedTxCaching(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	ic, err := NewInterceptor(&Config{
+		Cache:             &mapCacher{entries: make(map[string]cache.Entry)},
+		TrackTableChanges: true,
+	})
+	assert.Nil(err)
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	hook := ic.SQLiteUpdateHook(qMock.(driver.Conn))
+	generation := func() uint64 {
+		return ic.ddl.generation([]string{"users"})
+	}
+
+	// transactions are tracked even though their queries are cached, so
+	// that their changes are committed along with them rather than by
+	// their first statement
+	qMock.ExpectBegin()
+	tx, err := db.Begin()
+	assert.Nil(err)
+	hook(23, "main", "users", 1)
+	qMock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err = tx.Exec("UPDATE users SET name = 'x'")
+	assert.Nil(err)
+	assert.Equal(uint64(1), generation())
+	hook(23, "main", "users", 2)
+	assert.Equal(uint64(1), generation())
+	qMock.ExpectCommit()
+	assert.Nil(tx.Commit())
+	assert.Equal(uint64(2), generation())
+	assert.Nil(qMock.ExpectationsWereMet())
+}
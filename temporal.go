@@ -0,0 +1,98 @@
+package sqlcache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// msgpack extension type id used to tag times along with their location
+const zonedTimeExtID = 43
+
+func init() {
+	msgpack.RegisterExt(zonedTimeExtID, (*zonedTime)(nil))
+}
+
+// zonedTime is the serialized form of a time.Time. Unlike msgpack's own
+// encoding of times, which decode in the local time zone, it keeps the
+// location of the time so that values served from cache are in the same
+// location as those returned by the driver.
+type zonedTime struct {
+	_msgpack struct{} `msgpack:",asArray"`
+	Sec      int64
+	Nsec     int
+	// Loc is the name of the location, such as "UTC", "Local" or
+	// "Europe/Berlin".
+	Loc string
+	// Zone and Offset are the zone abbreviation and offset in seconds
+	// east of UTC in effect at the time, used when Loc can't be loaded.
+	Zone   string
+	Offset int
+}
+
+// zonedTimeFields has the same layout as zonedTime but none of its
+// methods, which lets it be marshaled without recursion.
+type zonedTimeFields zonedTime
+
+func (zt *zonedTime) MarshalMsgpack() ([]byte, error) {
+	return msgpack.Marshal((*zonedTimeFields)(zt))
+}
+
+func (zt *zonedTime) UnmarshalMsgpack(b []byte) error {
+	return msgpack.Unmarshal(b, (*zonedTimeFields)(zt))
+}
+
+func newZonedTime(t time.Time) zonedTime {
+	zone, offset := t.Zone()
+	return zonedTime{
+		Sec:    t.Unix(),
+		Nsec:   t.Nanosecond(),
+		Loc:    t.Location().String(),
+		Zone:   zone,
+		Offset: offset,
+	}
+}
+
+// time returns the time in its original location when it's available in
+// this process with the same offset, and in a fixed zone of the recorded
+// offset otherwise, so that the wall clock reading is preserved either way.
+func (zt *zonedTime) time() time.Time {
+	t := time.Unix(zt.Sec, int64(zt.Nsec))
+	if loc := loadLocation(zt.Loc); loc != nil {
+		if t := t.In(loc); sameZone(t, zt) {
+			return t
+		}
+	}
+
+	return t.In(time.FixedZone(zt.Zone, zt.Offset))
+}
+
+func sameZone(t time.Time, zt *zonedTime) bool {
+	zone, offset := t.Zone()
+	return zone == zt.Zone && offset == zt.Offset
+}
+
+// locations caches locations by name, including those that failed to
+// load as nil.
+var locations sync.Map // string -> *time.Location
+
+func loadLocation(name string) *time.Location {
+	switch name {
+	case "UTC":
+		return time.UTC
+	case "Local":
+		return time.Local
+	}
+
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = nil
+	}
+	locations.Store(name, loc)
+
+	return loc
+}
@@ -0,0 +1,224 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/prashanthpai/sqlcache/cache"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestCodecsKeepTimeLocation(t *testing.T) {
+	assert := require.New(t)
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.Nil(err)
+	ts := time.Unix(1700000000, 123456789)
+	times := []time.Time{
+		ts.UTC(),
+		ts.Local(),
+		ts.In(time.FixedZone("XYZ", -9000)),
+		ts.In(berlin),
+		ts.AddDate(0, 6, 0).In(berlin), // DST
+	}
+
+	item := &cache.Item{Cols: []string{"t", "mixed"}}
+	for n, tm := range times {
+		var mixed driver.Value = tm
+		if n%2 == 0 {
+			mixed = int64(n)
+		}
+		item.Rows = append(item.Rows, []driver.Value{tm, mixed})
+	}
+
+	check := func(name string, rows [][]driver.Value) {
+		assert.Len(rows, len(times), name)
+		for r, want := range times {
+			for c := 0; c < 2; c++ {
+				if c == 1 && r%2 == 0 {
+					continue
+				}
+				got, ok := rows[r][c].(time.Time)
+				assert.True(ok, name)
+				assert.True(want.Equal(got), name)
+				assert.Equal(want.Location().String(), got.Location().String(), name)
+				assert.Equal(want.Format(time.RFC3339Nano), got.Format(time.RFC3339Nano), name)
+			}
+		}
+	}
+
+	for name, codec := range map[string]cache.Codec{
+		"msgpack":  MsgpackCodec{ZonedTimes: true},
+		"columnar": ColumnarCodec{ZonedTimes: true},
+	} {
+		b, err := codec.Marshal(item)
+		assert.Nil(err)
+		var got cache.Item
+		assert.Nil(codec.Unmarshal(b, &got), name)
+		check(name, got.Rows)
+	}
+
+	b, err := MsgpackCodec{ZonedTimes: true}.Marshal(item)
+	assert.Nil(err)
+	rr, err := MsgpackCodec{}.NewRowsReader(b)
+	assert.Nil(err)
+	var rows [][]driver.Value
+	for {
+		dest := make([]driver.Value, 2)
+		if err := rr.Next(dest); err == io.EOF {
+			break
+		}
+		rows = append(rows, dest)
+	}
+	check("stream", rows)
+
+	// the encoded item isn't modified
+	assert.IsType(time.Time{}, item.Rows[0][0])
+}
+
+func TestCodecsDefaultTimes(t *testing.T) {
+	assert := require.New(t)
+
+	ts := time.Unix(1700000000, 123456789).In(time.FixedZone("XYZ", 3600))
+	item := &cache.Item{Cols: []string{"t", "mixed"}, Rows: [][]driver.Value{{ts, ts}, {ts, int64(1)}}}
+
+	// times are encoded as by earlier releases unless ZonedTimes is set,
+	// so that they can still decode items written by upgraded processes
+	b, err := MsgpackCodec{}.Marshal(item)
+	assert.Nil(err)
+	want, err := marshalMsgpack(item)
+	assert.Nil(err)
+	assert.Equal(want, b)
+
+	b, err = ColumnarCodec{}.Marshal(item)
+	assert.Nil(err)
+	var old struct {
+		_msgpack struct{} `msgpack:",asArray"`
+		Cols     []string
+		NumRows  int
+		Columns  []oldColumn
+	}
+	assert.Nil(msgpack.Unmarshal(b, &old))
+	assert.Equal(kindTime, old.Columns[0].Kind)
+	assert.True(ts.Equal(old.Columns[0].Times[1]))
+	assert.IsType(&time.Time{}, old.Columns[1].Values[0])
+
+	for _, codec := range []cache.Codec{MsgpackCodec{}, ColumnarCodec{}} {
+		b, err := codec.Marshal(item)
+		assert.Nil(err)
+		var got cache.Item
+		assert.Nil(codec.Unmarshal(b, &got))
+		assert.True(ts.Equal(got.Rows[0][0].(time.Time)))
+		assert.True(ts.Equal(got.Rows[0][1].(time.Time)))
+	}
+}
+
+func TestEncodeZonedTimesAllocs(t *testing.T) {
+	assert := require.New(t)
+
+	rows := make([][]driver.Value, 1000)
+	for r := range rows {
+		rows[r] = []driver.Value{int64(r), time.Unix(int64(r), 0).UTC()}
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, err := encodeCustomValues(rows, true); err != nil {
+			panic(err)
+		}
+	})
+	// the rows, their copies and the zoned times are each allocated once
+	assert.LessOrEqual(allocs, float64(3))
+}
+
+func TestZonedTimeUnknownLocation(t *testing.T) {
+	assert := require.New(t)
+
+	zt := newZonedTime(time.Unix(1700000000, 0).In(time.FixedZone("XYZ", 3600)))
+	zt.Loc = "Nowhere/Unknown"
+	got := zt.time()
+	zone, offset := got.Zone()
+	assert.Equal("XYZ", zone)
+	assert.Equal(3600, offset)
+	assert.Equal(int64(1700000000), got.Unix())
+}
+
+// oldColumn is the layout of column before zoned times were added.
+type oldColumn struct {
+	_msgpack struct{} `msgpack:",asArray"`
+	Kind     columnKind
+	Nulls    []bool
+	Int64s   []int64
+	Float64s []float64
+	Bools    []bool
+	Strings  []string
+	Bytes    [][]byte
+	Times    []time.Time
+	Values   []interface{}
+}
+
+func TestColumnarDecodesOldTimes(t *testing.T) {
+	assert := require.New(t)
+
+	ts := time.Unix(1700000000, 0)
+	b, err := msgpack.Marshal(&struct {
+		_msgpack struct{} `msgpack:",asArray"`
+		Cols     []string
+		NumRows  int
+		Columns  []oldColumn
+	}{
+		Cols:    []string{"t"},
+		NumRows: 1,
+		Columns: []oldColumn{{Kind: kindTime, Times: []time.Time{ts}}},
+	})
+	assert.Nil(err)
+
+	var item cache.Item
+	assert.Nil(ColumnarCodec{}.Unmarshal(b, &item))
+	assert.True(ts.Equal(item.Rows[0][0].(time.Time)))
+}
+
+type timeRows struct {
+	times []time.Time
+}
+
+func (r *timeRows) Columns() []string { return []string{"t"} }
+func (r *timeRows) Close() error      { return nil }
+
+func (r *timeRows) Next(dest []driver.Value) error {
+	if len(r.times) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.times[0]
+	r.times = r.times[1:]
+	return nil
+}
+
+func TestUTCTimes(t *testing.T) {
+	assert := require.New(t)
+
+	ts := time.Unix(1700000000, 0).In(time.FixedZone("XYZ", 3600))
+	for _, utc := range []bool{false, true} {
+		var got *cache.Item
+		rr := newRowsRecorder(context.Background(), func(item *cache.Item) { got = item }, nil,
+			&timeRows{[]time.Time{ts}}, 10)
+		rr.utcTimes = utc
+
+		dest := make([]driver.Value, 1)
+		assert.Nil(rr.Next(dest))
+		served := dest[0]
+		assert.Equal(io.EOF, rr.Next(dest))
+		assert.Nil(rr.Close())
+
+		want := ts
+		if utc {
+			want = ts.UTC()
+		}
+		assert.Equal(want, served)
+		assert.Equal(want, got.Rows[0][0])
+	}
+}
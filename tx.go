@@ -0,0 +1,156 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+)
+
+// Transactions are tracked when Config.DisableTxCaching is set so that
+// queries run within them aren't cached: such queries may observe writes
+// that are yet to be committed or may be rolled back. They're also tracked
+// for Config.TrackTableChanges, so that tables changed within them are
+// invalidated once committed. Conns and stmts are tracked by identity;
+// those that can't be, such as the values of a middleware stacked below
+// the interceptor holding uncomparable fields, are assumed to be within
+// one.
+
+// ConnBeginTx intercepts database/sql's DB.BeginTx and Conn.BeginTx calls.
+func (i *Interceptor) ConnBeginTx(ctx context.Context, conn driver.ConnBeginTx, txOpts driver.TxOptions) (context.Context, driver.Tx, error) {
+	tx, err := conn.BeginTx(ctx, txOpts)
+	if err != nil || i.cacheInTx && i.changes == nil {
+		return ctx, tx, err
+	}
+
+	key := unwrapParent(conn, "Conn")
+	if isComparable(key) && isComparable(tx) {
+		i.txConns.Store(key, struct{}{})
+		i.txs.Store(tx, key)
+	}
+
+	return ctx, tx, err
+}
+
+// TxCommit intercepts database/sql's Tx.Commit calls.
+func (i *Interceptor) TxCommit(ctx context.Context, tx driver.Tx) error {
+	key := i.endTx(tx)
+	err := tx.Commit()
+	i.commitChanges(key)
+	return err
+}
+
+// TxRollback intercepts database/sql's Tx.Rollback calls.
+func (i *Interceptor) TxRollback(ctx context.Context, tx driver.Tx) error {
+	key := i.endTx(tx)
+	err := tx.Rollback()
+	i.discardChanges(key)
+	return err
+}
+
+// ConnPrepareContext intercepts database/sql's PrepareContext calls.
+func (i *Interceptor) ConnPrepareContext(ctx context.Context, conn driver.ConnPrepareContext, query string) (context.Context, driver.Stmt, error) {
+	return i.connPrepareContext(ctx, nil, conn, query)
+}
+
+func (i *Interceptor) connPrepareContext(ctx context.Context, dp *driverPolicy, conn driver.ConnPrepareContext, query string) (context.Context, driver.Stmt, error) {
+	stmt, err := conn.PrepareContext(ctx, query)
+	if err == nil && isComparable(stmt) {
+		i.stmts.Store(stmt, &stmtState{
+			preparedQuery: i.prepare(query),
+			driver:        dp,
+			conn:          unwrapParent(conn, "Conn"),
+			inTx:          i.inTx(conn),
+		})
+	}
+
+	return ctx, stmt, err
+}
+
+// StmtClose intercepts database/sql's Stmt.Close calls.
+func (i *Interceptor) StmtClose(ctx context.Context, stmt driver.Stmt) error {
+	if isComparable(stmt) {
+		i.stmts.Delete(stmt)
+	}
+
+	return stmt.Close()
+}
+
+// endTx stops tracking the transaction, returning the key of its conn, if
+// it was tracked.
+func (i *Interceptor) endTx(tx driver.Tx) interface{} {
+	if !isComparable(tx) {
+		return nil
+	}
+	key, ok := i.txs.LoadAndDelete(tx)
+	if ok {
+		i.txConns.Delete(key)
+	}
+
+	return key
+}
+
+// connInTx reports whether queries of conn mustn't be cached for being
+// within a transaction.
+func (i *Interceptor) connInTx(conn interface{}) bool {
+	return !i.cacheInTx && i.inTx(conn)
+}
+
+// inTx reports whether conn is within a transaction.
+func (i *Interceptor) inTx(conn interface{}) bool {
+	key := unwrapParent(conn, "Conn")
+	if !isComparable(key) {
+		return key != nil
+	}
+	_, ok := i.txConns.Load(key)
+
+	return ok
+}
+
+// unwrapParent returns the value of the exported field name of the struct
+// v. ngrok/sqlmw passes the parent driver conns and stmts to interceptors
+// wrapped in such structs.
+func unwrapParent(v interface{}, name string) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Struct {
+		return v
+	}
+
+	f := rv.FieldByName(name)
+	if !f.IsValid() || !f.CanInterface() {
+		return v
+	}
+
+	return f.Interface()
+}
+
+// isComparable reports whether v can be used as a map key. Values of
+// comparable struct types, such as the conns of middlewares stacked below
+// the interceptor, may still hold uncomparable values in interface fields.
+func isComparable(v interface{}) bool {
+	return v != nil && valueComparable(reflect.ValueOf(v))
+}
+
+func valueComparable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil() || valueComparable(v.Elem())
+	case reflect.Struct:
+		for n := 0; n < v.NumField(); n++ {
+			if !valueComparable(v.Field(n)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for n := 0; n < v.Len(); n++ {
+			if !valueComparable(v.Index(n)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	default:
+		return true
+	}
+}
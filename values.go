@@ -0,0 +1,207 @@
+package sqlcache
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// msgpack extension type id used to tag values of registered types
+const customValueExtID = 42
+
+func init() {
+	msgpack.RegisterExt(customValueExtID, (*customValue)(nil))
+}
+
+// ValueEncoder encodes a driver value of a registered type into bytes.
+type ValueEncoder func(v driver.Value) ([]byte, error)
+
+// ValueDecoder decodes bytes produced by the corresponding ValueEncoder back
+// into a driver value.
+type ValueDecoder func(b []byte) (driver.Value, error)
+
+type valueType struct {
+	name   string
+	encode ValueEncoder
+	decode ValueDecoder
+}
+
+var valueTypes = struct {
+	sync.RWMutex
+	byType map[reflect.Type]*valueType
+	byName map[string]*valueType
+}{
+	byType: make(map[reflect.Type]*valueType),
+	byName: make(map[string]*valueType),
+}
+
+// RegisterValueType registers encode and decode functions for values of the
+// same type as sample. Use this for value types emitted by your driver that
+// the codecs can't serialize faithfully (e.g. decimals or UUID arrays). The
+// name identifies the type in serialized items and must be stable across
+// releases of your program. Registering an already registered name or type
+// panics. Like sql.Register, this is expected to be called during init.
+//
+// Registered types only apply to backends that serialize items using a
+// codec (such as redis); in-memory backends store values as is.
+func RegisterValueType(name string, sample driver.Value, encode ValueEncoder, decode ValueDecoder) {
+	if sample == nil || encode == nil || decode == nil {
+		panic("sqlcache: RegisterValueType: sample, encode and decode must be non-nil")
+	}
+
+	typ := reflect.TypeOf(sample)
+
+	valueTypes.Lock()
+	defer valueTypes.Unlock()
+
+	if _, dup := valueTypes.byName[name]; dup {
+		panic("sqlcache: RegisterValueType called twice for name " + name)
+	}
+	if _, dup := valueTypes.byType[typ]; dup {
+		panic("sqlcache: RegisterValueType called twice for type " + typ.String())
+	}
+
+	vt := &valueType{
+		name:   name,
+		encode: encode,
+		decode: decode,
+	}
+	valueTypes.byType[typ] = vt
+	valueTypes.byName[name] = vt
+}
+
+// customValue is the serialized form of a value of a registered type.
+type customValue struct {
+	_msgpack struct{} `msgpack:",asArray"`
+	Name     string
+	Data     []byte
+}
+
+// customValueFields has the same layout as customValue but none of its
+// methods, which lets it be marshaled without recursion.
+type customValueFields customValue
+
+func (cv *customValue) MarshalMsgpack() ([]byte, error) {
+	return msgpack.Marshal((*customValueFields)(cv))
+}
+
+func (cv *customValue) UnmarshalMsgpack(b []byte) error {
+	return msgpack.Unmarshal(b, (*customValueFields)(cv))
+}
+
+// encodeCustomValues returns rows with values of registered types, and
+// times if zoneTimes is set, replaced by their serialized form. The input
+// rows are never modified; they are returned as is when no values need
+// replacing. Copied rows and zoned times are allocated in slabs holding the
+// rest of the rows, so that encoding a column of times doesn't allocate per
+// value.
+func encodeCustomValues(rows [][]driver.Value, zoneTimes bool) ([][]driver.Value, error) {
+	valueTypes.RLock()
+	defer valueTypes.RUnlock()
+
+	hasTypes := len(valueTypes.byType) > 0
+	if !hasTypes && !zoneTimes {
+		return rows, nil
+	}
+
+	var (
+		out    [][]driver.Value
+		values []driver.Value // slab of copied rows
+		zoned  []zonedTime    // slab of zoned times
+	)
+	for r, row := range rows {
+		copied := false
+		for c, v := range row {
+			var enc driver.Value
+			switch tv := v.(type) {
+			case nil:
+				continue
+			case time.Time:
+				if !zoneTimes {
+					continue
+				}
+				if len(zoned) == cap(zoned) {
+					zoned = make([]zonedTime, 0, len(rows)-r)
+				}
+				zoned = append(zoned, newZonedTime(tv))
+				enc = &zoned[len(zoned)-1]
+			default:
+				if !hasTypes {
+					continue
+				}
+				vt, ok := valueTypes.byType[reflect.TypeOf(v)]
+				if !ok {
+					continue
+				}
+
+				b, err := vt.encode(v)
+				if err != nil {
+					return nil, fmt.Errorf("encoding value of type %q failed: %w", vt.name, err)
+				}
+				enc = &customValue{Name: vt.name, Data: b}
+			}
+
+			if out == nil { // copy on first write
+				out = make([][]driver.Value, len(rows))
+				copy(out, rows)
+			}
+			if !copied {
+				if cap(values)-len(values) < len(row) {
+					values = make([]driver.Value, 0, len(row)*(len(rows)-r))
+				}
+				start := len(values)
+				values = append(values, row...)
+				out[r] = values[start:len(values):len(values)]
+				copied = true
+			}
+			out[r][c] = enc
+		}
+	}
+
+	if out == nil {
+		return rows, nil
+	}
+
+	return out, nil
+}
+
+// decodeCustomValues replaces serialized values of registered types and
+// times in rows with their decoded form in place. Times encoded as
+// msgpack's own, which decode as *time.Time, are dereferenced.
+func decodeCustomValues(rows [][]driver.Value) error {
+	for _, row := range rows {
+		for c, v := range row {
+			switch tv := v.(type) {
+			case *zonedTime:
+				row[c] = tv.time()
+				continue
+			case *time.Time:
+				row[c] = *tv
+				continue
+			}
+			cv, ok := v.(*customValue)
+			if !ok {
+				continue
+			}
+
+			valueTypes.RLock()
+			vt, ok := valueTypes.byName[cv.Name]
+			valueTypes.RUnlock()
+			if !ok {
+				return fmt.Errorf("no decoder registered for value type %q", cv.Name)
+			}
+
+			dv, err := vt.decode(cv.Data)
+			if err != nil {
+				return fmt.Errorf("decoding value of type %q failed: %w", cv.Name, err)
+			}
+			row[c] = dv
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package vitessparser implements sqlcache.Parser for MySQL queries with
+// the SQL parser of Vitess. It's a separate module so that users of other
+// databases don't depend on Vitess.
+//
+//	p, err := vitessparser.New("8.0.30")
+//	...
+//	interceptor, err := sqlcache.NewInterceptor(&sqlcache.Config{
+//		Cache:  sqlcache.NewRedis(rc, "sqc"),
+//		Parser: p,
+//	})
+package vitessparser
+
+import (
+	"strings"
+
+	"github.com/prashanthpai/sqlcache"
+
+	"vitess.io/vitess/go/vt/sqlparser"
+)
+
+// nonDeterministicFuncs are the MySQL functions whose results change on
+// every call, by lowercase name.
+var nonDeterministicFuncs = map[string]bool{
+	"now":               true,
+	"sysdate":           true,
+	"curdate":           true,
+	"curtime":           true,
+	"current_date":      true,
+	"current_time":      true,
+	"current_timestamp": true,
+	"localtime":         true,
+	"localtimestamp":    true,
+	"utc_date":          true,
+	"utc_time":          true,
+	"utc_timestamp":     true,
+	"rand":              true,
+	"uuid":              true,
+	"uuid_short":        true,
+	"connection_id":     true,
+	"last_insert_id":    true,
+	"found_rows":        true,
+	"row_count":         true,
+}
+
+// Parser implements sqlcache.Parser for MySQL queries.
+type Parser struct {
+	p *sqlparser.Parser
+}
+
+// New returns a Parser of the syntax of the MySQL version given, such as
+// "8.0.30", or of the Vitess default if it's empty.
+func New(mysqlVersion string) (*Parser, error) {
+	p, err := sqlparser.New(sqlparser.Options{MySQLServerVersion: mysqlVersion})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Parser{p: p}, nil
+}
+
+// Parse implements sqlcache.Parser.
+func (p *Parser) Parse(query string) (*sqlcache.ParsedQuery, error) {
+	stmt, err := p.p.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	pq := new(sqlcache.ParsedQuery)
+	switch stmt.(type) {
+	case sqlparser.SelectStatement:
+		pq.Read = true
+	case *sqlparser.Show, *sqlparser.ExplainTab:
+		pq.Read = true
+	}
+
+	var (
+		tables  []string
+		ctes    = make(map[string]bool)
+		unknown bool // tables read by table functions aren't known
+		into    bool // SELECT ... INTO writes its results
+	)
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *sqlparser.Select:
+			pq.Locking = pq.Locking || n.Lock != sqlparser.NoLock
+			into = into || n.Into != nil
+		case *sqlparser.Union:
+			pq.Locking = pq.Locking || n.Lock != sqlparser.NoLock
+			into = into || n.Into != nil
+		case *sqlparser.CommonTableExpr:
+			ctes[strings.ToLower(n.ID.String())] = true
+		case *sqlparser.AliasedTableExpr:
+			if name, ok := n.Expr.(sqlparser.TableName); ok {
+				tables = append(tables, tableName(name))
+			}
+		case *sqlparser.JSONTableExpr:
+			unknown = true
+		case *sqlparser.FuncExpr:
+			fn := n.Name.Lowered()
+			// UNIX_TIMESTAMP() is only non-deterministic without args
+			if pq.NonDeterministic == "" && (nonDeterministicFuncs[fn] || (fn == "unix_timestamp" && len(n.Exprs) == 0)) {
+				pq.NonDeterministic = strings.ToUpper(n.Name.String())
+			}
+		case *sqlparser.CurTimeFuncExpr:
+			if pq.NonDeterministic == "" {
+				pq.NonDeterministic = strings.ToUpper(n.Name.String())
+			}
+		}
+		return true, nil
+	}, stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	pq.Read = pq.Read && !into
+	if pq.Read && !unknown {
+		for _, t := range tables {
+			if t != "dual" && !ctes[t] {
+				pq.Tables = append(pq.Tables, t)
+			}
+		}
+	}
+
+	return pq, nil
+}
+
+// tableName returns the name of the table, lowercase and unquoted.
+func tableName(name sqlparser.TableName) string {
+	t := strings.ToLower(name.Name.String())
+	if name.Qualifier.IsEmpty() {
+		return t
+	}
+
+	return strings.ToLower(name.Qualifier.String()) + "." + t
+}
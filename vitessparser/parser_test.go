@@ -0,0 +1,57 @@
+package vitessparser
+
+import (
+	"testing"
+
+	"github.com/prashanthpai/sqlcache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	assert := require.New(t)
+
+	p, err := New("")
+	assert.Nil(err)
+
+	for query, want := range map[string]*sqlcache.ParsedQuery{
+		"SELECT name FROM users WHERE id = ?": {
+			Read: true, Tables: []string{"users"},
+		},
+		"SELECT o.id FROM `Shop`.`Orders` o JOIN users u ON u.id = o.uid WHERE o.id IN (SELECT id FROM tags)": {
+			Read: true, Tables: []string{"shop.orders", "users", "tags"},
+		},
+		"WITH u AS (SELECT * FROM users) SELECT * FROM u": {
+			Read: true, Tables: []string{"users"},
+		},
+		"SELECT 'now()' FROM users -- NOW()": {
+			Read: true, Tables: []string{"users"},
+		},
+		"SELECT NOW(), RAND() FROM users FOR UPDATE": {
+			Read: true, Locking: true, Tables: []string{"users"}, NonDeterministic: "NOW",
+		},
+		"SELECT UNIX_TIMESTAMP(created_at) FROM users": {
+			Read: true, Tables: []string{"users"},
+		},
+		"SELECT 1 FROM dual": {
+			Read: true,
+		},
+		"SELECT * FROM JSON_TABLE('[]', '$[*]' COLUMNS (id INT PATH '$')) AS t": {
+			Read: true,
+		},
+		"SHOW TABLES": {
+			Read: true,
+		},
+		"UPDATE users SET seen = NOW() WHERE id = ?": {
+			NonDeterministic: "NOW",
+		},
+		"INSERT INTO users (name) SELECT name FROM accounts": {},
+	} {
+		pq, err := p.Parse(query)
+		assert.Nil(err, query)
+		assert.Equal(want, pq, query)
+	}
+
+	_, err = p.Parse("SELEC 1")
+	assert.NotNil(err)
+}
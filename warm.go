@@ -0,0 +1,139 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TopStatement is a statement as reported by the statistics of a database,
+// such as pg_stat_statements, with constants typically replaced by
+// placeholders.
+type TopStatement struct {
+	Query     string
+	Calls     int64
+	TotalTime time.Duration
+}
+
+// WarmSpec is a query to prime when it's among the top statements passed
+// to Interceptor.Warm.
+type WarmSpec struct {
+	// Query is the query as run by the program, with cache attributes.
+	Query string
+	// Args are representative sets of arguments; the query is primed once
+	// with each set.
+	Args [][]interface{}
+	// Match, when set, reports whether a top statement is Query. By
+	// default statements match when they're the same as Query ignoring
+	// comments, whitespace, case, identifier quotes and the style of
+	// placeholders.
+	Match func(statement string) bool
+}
+
+var placeholderRegexp = regexp.MustCompile(`\$\d+|\?`)
+
+// statementShape returns the query in the form compared by the default
+// WarmSpec.Match.
+func statementShape(query string) string {
+	s := strings.ToLower(normalizeQuery(query))
+	s = placeholderRegexp.ReplaceAllString(s, "?")
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '`', '"':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Warm primes the cache, as Interceptor.Prime does, with the queries of
+// specs that match the statements in top, in the order of top, and returns
+// the number of queries run. top is typically read from the database's
+// statistics with TopStatementsPostgres or TopStatementsMySQL so that only
+// the queries currently hurting the database are primed.
+func (i *Interceptor) Warm(ctx context.Context, db *sql.DB, top []TopStatement, specs []WarmSpec, opts ...PrimeOption) (int, error) {
+	shapes := make([]string, len(specs))
+	for n, spec := range specs {
+		if spec.Match == nil {
+			shapes[n] = statementShape(spec.Query)
+		}
+	}
+
+	var (
+		primes []PrimeSpec
+		used   = make([]bool, len(specs))
+	)
+	for _, stmt := range top {
+		shape := statementShape(stmt.Query)
+		for n, spec := range specs {
+			if used[n] {
+				continue
+			}
+			if (spec.Match != nil && spec.Match(stmt.Query)) || (spec.Match == nil && shapes[n] == shape) {
+				used[n] = true
+				for _, args := range spec.Args {
+					primes = append(primes, PrimeSpec{Query: spec.Query, Args: args})
+				}
+			}
+		}
+	}
+	if len(primes) == 0 {
+		return 0, nil
+	}
+
+	return len(primes), i.Prime(ctx, db, primes, opts...)
+}
+
+// TopStatementsPostgres returns up to limit statements from the
+// pg_stat_statements extension, most time consuming first. db needn't be
+// opened with a driver wrapped by the interceptor.
+func TopStatementsPostgres(ctx context.Context, db *sql.DB, limit int) ([]TopStatement, error) {
+	// total_exec_time replaced total_time in PostgreSQL 13
+	stmts, err := topStatements(ctx, db, `SELECT query, calls, total_exec_time
+		FROM pg_stat_statements ORDER BY total_exec_time DESC LIMIT $1`, limit, time.Millisecond)
+	if err != nil {
+		stmts, err = topStatements(ctx, db, `SELECT query, calls, total_time
+			FROM pg_stat_statements ORDER BY total_time DESC LIMIT $1`, limit, time.Millisecond)
+	}
+
+	return stmts, err
+}
+
+// TopStatementsMySQL returns up to limit statement digests from the
+// performance schema of MySQL, most time consuming first. Digests don't
+// retain comments, so cache attributes must come from WarmSpec.Query.
+func TopStatementsMySQL(ctx context.Context, db *sql.DB, limit int) ([]TopStatement, error) {
+	// timer columns are in picoseconds
+	return topStatements(ctx, db, `SELECT DIGEST_TEXT, COUNT_STAR, SUM_TIMER_WAIT / 1000000
+		FROM performance_schema.events_statements_summary_by_digest
+		WHERE DIGEST_TEXT IS NOT NULL ORDER BY SUM_TIMER_WAIT DESC LIMIT ?`, limit, time.Microsecond)
+}
+
+func topStatements(ctx context.Context, db *sql.DB, query string, limit int, unit time.Duration) ([]TopStatement, error) {
+	rows, err := db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("reading top statements failed: %w", err)
+	}
+	defer rows.Close()
+
+	var stmts []TopStatement
+	for rows.Next() {
+		var (
+			s     TopStatement
+			total float64
+		)
+		if err := rows.Scan(&s.Query, &s.Calls, &total); err != nil {
+			return nil, fmt.Errorf("reading top statements failed: %w", err)
+		}
+		s.TotalTime = time.Duration(total * float64(unit))
+		stmts = append(stmts, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading top statements failed: %w", err)
+	}
+
+	return stmts, nil
+}
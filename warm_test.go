@@ -0,0 +1,123 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatementShape(t *testing.T) {
+	assert := require.New(t)
+
+	query := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ? AND city = ?`
+	assert.Equal(statementShape(query), statementShape("select name from users where age > $1 and city = $2"))
+	assert.Equal(statementShape(query), statementShape("SELECT `name` FROM `users` WHERE `age` > ? AND `city` = ?"))
+	assert.NotEqual(statementShape(query), statementShape("SELECT name FROM users WHERE age < $1 AND city = $2"))
+}
+
+func TestWarm(t *testing.T) {
+	assert := require.New(t)
+
+	dsn := fmt.Sprintf("fakeDSN:%s", t.Name())
+	mockDB, qMock, err := sqlmock.NewWithDSN(dsn)
+	assert.Nil(err)
+	defer mockDB.Close()
+
+	mCacher := new(mocks.Cacher)
+	mCacher.On("Get", mock.Anything, mock.Anything).Return(nil, false, nil)
+	mCacher.On("Set", mock.Anything, mock.Anything, mock.Anything, time.Duration(30*time.Second)).Return(nil).Times(3)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: mCacher,
+	})
+
+	driverName := fmt.Sprintf("mockdriver:%s", t.Name())
+	sql.Register(driverName, ic.Driver(mockDB.Driver()))
+
+	db, err := sql.Open(driverName, dsn)
+	assert.Nil(err)
+	defer db.Close()
+
+	users := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+              SELECT name FROM users WHERE age > ?`
+	orders := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+               SELECT id FROM orders WHERE user_id = ?`
+	cold := `-- @cache-max-rows 10
+              -- @cache-ttl 30
+             SELECT id FROM products`
+
+	top := []TopStatement{
+		{Query: "SELECT id FROM orders WHERE user_id = $1", Calls: 1000},
+		{Query: "SELECT name FROM users WHERE age > $1", Calls: 500},
+		{Query: "SELECT name FROM users WHERE age > $1 ", Calls: 10},
+	}
+	specs := []WarmSpec{
+		{Query: users, Args: [][]interface{}{{18}, {65}}},
+		{Query: orders, Args: [][]interface{}{{1}}, Match: func(s string) bool {
+			return strings.Contains(s, "FROM orders")
+		}},
+		{Query: cold, Args: [][]interface{}{nil}},
+	}
+
+	// queries are primed in the order of top statements, each spec once
+	qMock.ExpectQuery("SELECT id FROM orders").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	qMock.ExpectQuery("SELECT name FROM users").WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John"))
+	qMock.ExpectQuery("SELECT name FROM users").WithArgs(65).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Lisa"))
+
+	n, err := ic.Warm(context.Background(), db, top, specs, WithPrimeConcurrency(1))
+	assert.Nil(err)
+	assert.Equal(3, n)
+	assert.Nil(qMock.ExpectationsWereMet())
+	assert.True(mCacher.AssertExpectations(t))
+
+	n, err = ic.Warm(context.Background(), db, nil, specs)
+	assert.Nil(err)
+	assert.Zero(n)
+}
+
+func TestTopStatements(t *testing.T) {
+	assert := require.New(t)
+
+	db, qMock, err := sqlmock.New()
+	assert.Nil(err)
+	defer db.Close()
+
+	// falls back to total_time before PostgreSQL 13
+	qMock.ExpectQuery("total_exec_time").WithArgs(10).
+		WillReturnError(errors.New(`column "total_exec_time" does not exist`))
+	qMock.ExpectQuery("total_time").WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"query", "calls", "total_time"}).
+			AddRow("SELECT 1", 42, 1.5))
+	stmts, err := TopStatementsPostgres(context.Background(), db, 10)
+	assert.Nil(err)
+	assert.Equal([]TopStatement{{Query: "SELECT 1", Calls: 42, TotalTime: 1500 * time.Microsecond}}, stmts)
+
+	qMock.ExpectQuery("events_statements_summary_by_digest").WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"DIGEST_TEXT", "COUNT_STAR", "SUM_TIMER_WAIT"}).
+			AddRow("SELECT ?", 7, 2000))
+	stmts, err = TopStatementsMySQL(context.Background(), db, 10)
+	assert.Nil(err)
+	assert.Equal([]TopStatement{{Query: "SELECT ?", Calls: 7, TotalTime: 2 * time.Millisecond}}, stmts)
+
+	qMock.ExpectQuery("events_statements_summary_by_digest").WillReturnError(errors.New("access denied"))
+	_, err = TopStatementsMySQL(context.Background(), db, 10)
+	assert.NotNil(err)
+	assert.Nil(qMock.ExpectationsWereMet())
+}
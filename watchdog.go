@@ -0,0 +1,192 @@
+package sqlcache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultWatchdogWindow = 5 * time.Minute
+
+// AlertKind identifies the condition a watchdog alert is about.
+type AlertKind string
+
+// Kinds of watchdog alerts.
+const (
+	// AlertLowHitRatio indicates that the hit ratio fell below
+	// WatchdogConfig.MinHitRatio.
+	AlertLowHitRatio AlertKind = "low-hit-ratio"
+	// AlertHighErrorRate indicates that the error rate rose above
+	// WatchdogConfig.MaxErrorRate.
+	AlertHighErrorRate AlertKind = "high-error-rate"
+)
+
+// Alert is passed to WatchdogConfig.OnAlert when a threshold is crossed.
+type Alert struct {
+	Kind AlertKind
+	// Firing is true when the threshold has been crossed and false when
+	// the value has returned within the threshold.
+	Firing bool
+	// Value is the hit ratio or error rate over Window.
+	Value     float64
+	Threshold float64
+	Window    time.Duration
+}
+
+// WatchdogConfig is the configuration of a Watchdog.
+type WatchdogConfig struct {
+	// Window is the duration over which hit ratio and error rate are
+	// computed. Defaults to 5 minutes.
+	Window time.Duration
+	// Interval is how often the window is evaluated. Defaults to a tenth
+	// of Window.
+	Interval time.Duration
+	// MinHitRatio, when set, alerts when hits / (hits + misses) falls
+	// below this value.
+	MinHitRatio float64
+	// MaxErrorRate, when set, alerts when errors / (hits + misses +
+	// errors) rises above this value.
+	MaxErrorRate float64
+	// MinRequests is the number of cache lookups needed within the window
+	// for it to be evaluated, to avoid alerting on too little traffic.
+	MinRequests uint64
+	// OnAlert is called when a threshold is crossed in either direction.
+	// It's called from the watchdog's goroutine and may, for example,
+	// disable the interceptor. This field is mandatory.
+	OnAlert func(Alert)
+}
+
+type watchdogSample struct {
+	at    time.Time
+	stats *Stats
+}
+
+// Watchdog periodically evaluates the hit ratio and error rate of an
+// interceptor over a sliding window and reports when they cross the
+// configured thresholds.
+type Watchdog struct {
+	i   *Interceptor
+	cfg WatchdogConfig
+
+	mu      sync.Mutex
+	samples []watchdogSample
+	firing  map[AlertKind]bool
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWatchdog creates and starts a watchdog for the interceptor. Call Stop
+// to stop it.
+func NewWatchdog(i *Interceptor, config WatchdogConfig) (*Watchdog, error) {
+	if config.OnAlert == nil {
+		return nil, errors.New("OnAlert cannot be nil")
+	}
+	if config.MinHitRatio <= 0 && config.MaxErrorRate <= 0 {
+		return nil, errors.New("one of MinHitRatio or MaxErrorRate must be set")
+	}
+	if config.Window <= 0 {
+		config.Window = defaultWatchdogWindow
+	}
+	if config.Interval <= 0 {
+		config.Interval = config.Window / 10
+	}
+
+	w := &Watchdog{
+		i:      i,
+		cfg:    config,
+		firing: make(map[AlertKind]bool),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	w.evaluate(i.clock.Now())
+
+	go w.run()
+
+	return w, nil
+}
+
+func (w *Watchdog) run() {
+	defer close(w.done)
+
+	for {
+		tick, stopTick := w.i.clock.NewTimer(w.cfg.Interval)
+		select {
+		case <-tick:
+			w.evaluate(w.i.clock.Now())
+		case <-w.stop:
+			stopTick()
+			return
+		}
+	}
+}
+
+// Stop stops the watchdog.
+func (w *Watchdog) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+}
+
+func (w *Watchdog) evaluate(now time.Time) {
+	// OnAlert is a user callback, so it's called without holding w.mu
+	for _, a := range w.alerts(now) {
+		w.cfg.OnAlert(a)
+	}
+}
+
+// alerts records a sample at now and returns the alerts of the thresholds
+// crossed over the window ending at now.
+func (w *Watchdog) alerts(now time.Time) []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// backend stats aren't needed and may be expensive to fetch
+	w.samples = append(w.samples, watchdogSample{now, w.i.loadStats(atomic.LoadUint64)})
+
+	// keep the newest sample at or before the start of the window as base
+	start := now.Add(-w.cfg.Window)
+	n := 0
+	for n+1 < len(w.samples) && !w.samples[n+1].at.After(start) {
+		n++
+	}
+	w.samples = w.samples[n:]
+
+	d := w.samples[len(w.samples)-1].stats.Delta(w.samples[0].stats)
+	lookups := d.Hits + d.Misses
+	if lookups == 0 || lookups < w.cfg.MinRequests {
+		return nil
+	}
+
+	var alerts []Alert
+	if w.cfg.MinHitRatio > 0 {
+		ratio := float64(d.Hits) / float64(lookups)
+		alerts = w.check(alerts, AlertLowHitRatio, ratio < w.cfg.MinHitRatio, ratio, w.cfg.MinHitRatio)
+	}
+	if w.cfg.MaxErrorRate > 0 {
+		rate := float64(d.Errors) / float64(lookups+d.Errors)
+		alerts = w.check(alerts, AlertHighErrorRate, rate > w.cfg.MaxErrorRate, rate, w.cfg.MaxErrorRate)
+	}
+
+	return alerts
+}
+
+// check appends an alert to alerts if the threshold of kind was crossed in
+// either direction since it was last checked.
+func (w *Watchdog) check(alerts []Alert, kind AlertKind, crossed bool, value, threshold float64) []Alert {
+	if crossed == w.firing[kind] {
+		return alerts
+	}
+	w.firing[kind] = crossed
+
+	return append(alerts, Alert{
+		Kind:      kind,
+		Firing:    crossed,
+		Value:     value,
+		Threshold: threshold,
+		Window:    w.cfg.Window,
+	})
+}
@@ -0,0 +1,114 @@
+package sqlcache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prashanthpai/sqlcache/mocks"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdog(t *testing.T) {
+	assert := require.New(t)
+
+	ic, _ := NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+	})
+
+	_, err := NewWatchdog(ic, WatchdogConfig{MinHitRatio: 0.5})
+	assert.NotNil(err)
+	_, err = NewWatchdog(ic, WatchdogConfig{OnAlert: func(Alert) {}})
+	assert.NotNil(err)
+
+	var (
+		alerts []Alert
+		w      *Watchdog
+	)
+	w, err = NewWatchdog(ic, WatchdogConfig{
+		Window:       time.Minute,
+		Interval:     time.Hour, // evaluated manually below
+		MinHitRatio:  0.5,
+		MaxErrorRate: 0.1,
+		MinRequests:  10,
+		OnAlert: func(a Alert) {
+			// not called holding the watchdog's lock
+			assert.True(w.mu.TryLock())
+			w.mu.Unlock()
+			alerts = append(alerts, a)
+		},
+	})
+	assert.Nil(err)
+	defer w.Stop()
+
+	now := time.Now()
+	tick := func(hits, misses, errs uint64) {
+		atomic.AddUint64(&ic.stats.Hits, hits)
+		atomic.AddUint64(&ic.stats.Misses, misses)
+		atomic.AddUint64(&ic.stats.Errors, errs)
+		now = now.Add(30 * time.Second)
+		w.evaluate(now)
+	}
+
+	// too few requests
+	tick(0, 5, 0)
+	assert.Len(alerts, 0)
+
+	tick(2, 8, 0)
+	assert.Len(alerts, 1)
+	assert.Equal(AlertLowHitRatio, alerts[0].Kind)
+	assert.True(alerts[0].Firing)
+	assert.InDelta(2.0/15, alerts[0].Value, 1e-9)
+	assert.Equal(time.Minute, alerts[0].Window)
+
+	// still firing; not reported again
+	tick(2, 8, 0)
+	assert.Len(alerts, 1)
+
+	// older samples slide out of the window
+	tick(20, 0, 5)
+	tick(40, 0, 0)
+	assert.Len(alerts, 4)
+	assert.Equal(AlertLowHitRatio, alerts[1].Kind)
+	assert.False(alerts[1].Firing)
+	assert.Equal(AlertHighErrorRate, alerts[2].Kind)
+	assert.True(alerts[2].Firing)
+	assert.Equal(AlertHighErrorRate, alerts[3].Kind)
+	assert.False(alerts[3].Firing)
+
+	w.Stop()
+	w.Stop()
+}
+
+func TestWatchdogClock(t *testing.T) {
+	assert := require.New(t)
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	ic, _ := NewInterceptor(&Config{
+		Cache: new(mocks.Cacher),
+		Clock: clock,
+	})
+
+	alerts := make(chan Alert, 1)
+	w, err := NewWatchdog(ic, WatchdogConfig{
+		Window:      time.Minute,
+		Interval:    10 * time.Second,
+		MinHitRatio: 0.5,
+		OnAlert: func(a Alert) {
+			alerts <- a
+		},
+	})
+	assert.Nil(err)
+	defer w.Stop()
+
+	// evaluated as the clock advances by Interval
+	atomic.AddUint64(&ic.stats.Misses, 10)
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(10 * time.Second)
+	a := <-alerts
+	assert.Equal(AlertLowHitRatio, a.Kind)
+	assert.True(a.Firing)
+}